@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joelanford/helm-operator/pkg/chartloader"
+	"github.com/joelanford/helm-operator/pkg/crd"
+	"github.com/joelanford/helm-operator/pkg/watches"
+)
+
+func newGenerateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate manifests for the Helm operator",
+	}
+	cmd.AddCommand(newGenerateCRDCmd())
+	return cmd
+}
+
+func newGenerateCRDCmd() *cobra.Command {
+	var (
+		watchesFile   string
+		outputDir     string
+		chartCacheDir string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "crd",
+		Short: "Generate CustomResourceDefinitions from the charts in watches.yaml",
+		Long: `Generate CustomResourceDefinitions for the custom resources in watches.yaml.
+
+The OpenAPI schema of the spec of each custom resource is derived from the
+values.schema.json file of its chart. Charts without a values schema get a
+schema inferred from their default values.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+	cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		// Charts that reference credential secrets cannot be loaded without a
+		// cluster, so no secret reader is configured.
+		ws, err := watches.Load(watchesFile,
+			watches.WithChartLoader(chartloader.New(chartloader.WithCacheDir(chartCacheDir))),
+		)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", watchesFile, err)
+		}
+
+		versions := make([]crd.Version, 0, len(ws))
+		for _, w := range ws {
+			versions = append(versions, crd.Version{
+				GroupVersionKind:  w.GroupVersionKind,
+				Chart:             w.Chart,
+				ChartVersionField: w.ChartVersionField,
+				ValuesFromField:   w.ValuesFromField,
+				RemediationField:  w.RemediationField,
+			})
+		}
+		crds, err := crd.Generate(versions)
+		if err != nil {
+			return err
+		}
+
+		if outputDir != "" {
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
+				return err
+			}
+		}
+		for _, c := range crds {
+			data, err := crd.Marshal(c)
+			if err != nil {
+				return fmt.Errorf("marshal CRD %s: %w", c.Name, err)
+			}
+			if outputDir == "" {
+				if _, err := cmd.OutOrStdout().Write(data); err != nil {
+					return err
+				}
+				continue
+			}
+			path := filepath.Join(outputDir, fmt.Sprintf("%s_%s.yaml", c.Spec.Group, c.Spec.Names.Plural))
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&watchesFile, "watches-file", "./watches.yaml", "Path to watches.yaml file.")
+	f.StringVar(&outputDir, "output-dir", "", "Directory to write the CRDs to, one file per CRD. If empty, the CRDs are written to stdout.")
+	f.StringVar(&chartCacheDir, "chart-cache-dir", filepath.Join(os.TempDir(), "helm-operator", "charts"), "Directory in which charts downloaded from registries and repositories are cached.")
+	return cmd
+}
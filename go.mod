@@ -3,12 +3,18 @@ module github.com/joelanford/helm-operator
 go 1.13
 
 require (
+	github.com/Masterminds/semver/v3 v3.1.0
+	github.com/Masterminds/sprig/v3 v3.1.0
 	github.com/go-logr/logr v0.1.0
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/mitchellh/copystructure v1.0.0
 	github.com/onsi/ginkgo v1.12.0
 	github.com/onsi/gomega v1.9.0
 	github.com/prometheus/client_golang v1.0.0
+	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.5.1
+	github.com/xeipuuv/gojsonschema v1.1.0
 	go.uber.org/zap v1.13.0
 	gomodules.xyz/jsonpatch/v2 v2.0.1
 	helm.sh/helm/v3 v3.2.0
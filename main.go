@@ -19,17 +19,20 @@ package main
 import (
 	"flag"
 	"os"
+	"path/filepath"
 	"runtime"
 	"time"
 
-	"github.com/spf13/pflag"
+	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"helm.sh/helm/v3/pkg/chart"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
 	zapl "sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/joelanford/helm-operator/pkg/annotation"
+	"github.com/joelanford/helm-operator/pkg/chartloader"
 	"github.com/joelanford/helm-operator/pkg/manager"
 	"github.com/joelanford/helm-operator/pkg/reconciler"
 	"github.com/joelanford/helm-operator/pkg/watches"
@@ -49,132 +52,228 @@ func printVersion() {
 }
 
 func main() {
+	klog.InitFlags(flag.CommandLine)
+
+	// The root command runs the operator, so that existing deployments that
+	// run the binary without a subcommand keep working.
+	root := newRunCmd()
+	root.Use = "helm-operator"
+	root.AddCommand(newRunCmd(), newGenerateCmd())
+	if err := root.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRunCmd() *cobra.Command {
 	var (
 		metricsAddr             string
 		enableLeaderElection    bool
 		leaderElectionID        string
 		leaderElectionNamespace string
+		webhookPort             int
+		webhookCertDir          string
 
 		watchesFile                    string
 		defaultMaxConcurrentReconciles int
 		defaultReconcilePeriod         time.Duration
+		defaultWaitTimeout             time.Duration
+		defaultMaxHistory              int
+		defaultRenderResyncInterval    time.Duration
+		chartCacheDir                  string
 
 		// Deprecated: use defaultMaxConcurrentReconciles
 		defaultMaxWorkers int
 	)
 
-	pflag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
-	pflag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
-		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
-	pflag.StringVar(&leaderElectionID, "leader-election-id", "",
-		"Name of the configmap that is used for holding the leader lock.")
-	pflag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
-		"Namespace in which to create the leader election configmap for holding the leader lock (required if running locally).")
-
-	pflag.StringVar(&watchesFile, "watches-file", "./watches.yaml", "Path to watches.yaml file.")
-	pflag.DurationVar(&defaultReconcilePeriod, "reconcile-period", time.Minute, "Default reconcile period for controllers (use 0 to disable periodic reconciliation)")
-	pflag.IntVar(&defaultMaxConcurrentReconciles, "max-concurrent-reconciles", runtime.NumCPU(), "Default maximum number of concurrent reconciles for controllers.")
-
-	// Deprecated: --max-workers flag does not align well with the name of the option it configures on the controller
-	//   (MaxConcurrentReconciles). Flag `--max-concurrent-reconciles` should be used instead.
-	pflag.IntVar(&defaultMaxWorkers, "max-workers", runtime.NumCPU(), "Default maximum number of concurrent reconciles for controllers.")
-	if err := pflag.CommandLine.MarkHidden("max-workers"); err != nil {
-		setupLog.Error(err, "failed to hide --max-workers flag")
-		os.Exit(1)
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the Helm operator",
+		Args:  cobra.NoArgs,
 	}
+	cmd.Run = func(cmd *cobra.Command, _ []string) {
 
-	klog.InitFlags(flag.CommandLine)
-	pflag.Parse()
-
-	logLvl := zap.NewAtomicLevelAt(zap.InfoLevel)
-	sttLvl := zap.NewAtomicLevelAt(zap.PanicLevel)
-	ctrl.SetLogger(zapl.New(
-		zapl.UseDevMode(false),
-		zapl.Level(&logLvl),
-		zapl.StacktraceLevel(&sttLvl),
-	))
+		logLvl := zap.NewAtomicLevelAt(zap.InfoLevel)
+		sttLvl := zap.NewAtomicLevelAt(zap.PanicLevel)
+		ctrl.SetLogger(zapl.New(
+			zapl.UseDevMode(false),
+			zapl.Level(&logLvl),
+			zapl.StacktraceLevel(&sttLvl),
+		))
 
-	printVersion()
+		printVersion()
 
-	// Deprecated: --max-workers flag does not align well with the name of the option it configures on the controller
-	//   (MaxConcurrentReconciles). Flag `--max-concurrent-reconciles` should be used instead.
-	if pflag.Lookup("max-workers").Changed {
-		setupLog.Info("flag --max-workers has been deprecated, use --max-concurrent-reconciles instead")
-		if pflag.Lookup("max-concurrent-reconciles").Changed {
-			setupLog.Info("ignoring --max-workers since --max-concurrent-reconciles is set")
-		} else {
-			defaultMaxConcurrentReconciles = defaultMaxWorkers
+		// Deprecated: --max-workers flag does not align well with the name of the option it configures on the controller
+		//   (MaxConcurrentReconciles). Flag `--max-concurrent-reconciles` should be used instead.
+		if cmd.Flags().Lookup("max-workers").Changed {
+			setupLog.Info("flag --max-workers has been deprecated, use --max-concurrent-reconciles instead")
+			if cmd.Flags().Lookup("max-concurrent-reconciles").Changed {
+				setupLog.Info("ignoring --max-workers since --max-concurrent-reconciles is set")
+			} else {
+				defaultMaxConcurrentReconciles = defaultMaxWorkers
+			}
 		}
-	}
 
-	// Deprecated: OPERATOR_NAME environment variable is an artifact of the legacy operator-sdk project scaffolding.
-	//   Flag `--leader-election-id` should be used instead.
-	if operatorName, found := os.LookupEnv("OPERATOR_NAME"); found {
-		setupLog.Info("environment variable OPERATOR_NAME has been deprecated, use --leader-election-id instead.")
-		if pflag.Lookup("leader-election-id").Changed {
-			setupLog.Info("ignoring OPERATOR_NAME environment variable since --leader-election-id is set")
-		} else {
-			leaderElectionID = operatorName
+		// Deprecated: OPERATOR_NAME environment variable is an artifact of the legacy operator-sdk project scaffolding.
+		//   Flag `--leader-election-id` should be used instead.
+		if operatorName, found := os.LookupEnv("OPERATOR_NAME"); found {
+			setupLog.Info("environment variable OPERATOR_NAME has been deprecated, use --leader-election-id instead.")
+			if cmd.Flags().Lookup("leader-election-id").Changed {
+				setupLog.Info("ignoring OPERATOR_NAME environment variable since --leader-election-id is set")
+			} else {
+				leaderElectionID = operatorName
+			}
 		}
-	}
-
-	options := ctrl.Options{
-		MetricsBindAddress:      metricsAddr,
-		LeaderElection:          enableLeaderElection,
-		LeaderElectionID:        leaderElectionID,
-		LeaderElectionNamespace: leaderElectionNamespace,
-		NewClient:               manager.NewDelegatingClientFunc(),
-	}
-	manager.ConfigureWatchNamespaces(&options, setupLog)
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
-	if err != nil {
-		setupLog.Error(err, "unable to start manager")
-		os.Exit(1)
-	}
 
-	ws, err := watches.Load(watchesFile)
-	if err != nil {
-		setupLog.Error(err, "unable to load watches.yaml", "path", watchesFile)
-		os.Exit(1)
-	}
-
-	for _, w := range ws {
-		reconcilePeriod := defaultReconcilePeriod
-		if w.ReconcilePeriod != nil {
-			reconcilePeriod = w.ReconcilePeriod.Duration
+		options := ctrl.Options{
+			MetricsBindAddress:      metricsAddr,
+			LeaderElection:          enableLeaderElection,
+			LeaderElectionID:        leaderElectionID,
+			LeaderElectionNamespace: leaderElectionNamespace,
+			Port:                    webhookPort,
+			CertDir:                 webhookCertDir,
+			NewClient:               manager.NewDelegatingClientFunc(),
 		}
-
-		maxConcurrentReconciles := defaultMaxConcurrentReconciles
-		if w.MaxConcurrentReconciles != nil {
-			maxConcurrentReconciles = *w.MaxConcurrentReconciles
+		manager.ConfigureWatchNamespaces(&options, setupLog)
+		mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
+		if err != nil {
+			setupLog.Error(err, "unable to start manager")
+			os.Exit(1)
 		}
 
-		r, err := reconciler.New(
-			reconciler.WithChart(*w.Chart),
-			reconciler.WithGroupVersionKind(w.GroupVersionKind),
-			reconciler.WithOverrideValues(w.OverrideValues),
-			reconciler.SkipDependentWatches(w.WatchDependentResources != nil && !*w.WatchDependentResources),
-			reconciler.WithMaxConcurrentReconciles(maxConcurrentReconciles),
-			reconciler.WithReconcilePeriod(reconcilePeriod),
-			reconciler.WithInstallAnnotations(annotation.DefaultInstallAnnotations...),
-			reconciler.WithUpgradeAnnotations(annotation.DefaultUpgradeAnnotations...),
-			reconciler.WithUninstallAnnotations(annotation.DefaultUninstallAnnotations...),
+		ws, err := watches.Load(watchesFile,
+			watches.WithChartLoader(chartloader.New(chartloader.WithCacheDir(chartCacheDir))),
+			watches.WithSecretReader(mgr.GetAPIReader()),
 		)
 		if err != nil {
-			setupLog.Error(err, "unable to create helm reconciler", "controller", "Helm")
+			setupLog.Error(err, "unable to load watches.yaml", "path", watchesFile)
 			os.Exit(1)
 		}
 
-		if err := r.SetupWithManager(mgr); err != nil {
-			setupLog.Error(err, "unable to create controller", "controller", "Helm")
+		for _, w := range ws {
+			reconcilePeriod := defaultReconcilePeriod
+			if w.ReconcilePeriod != nil {
+				reconcilePeriod = w.ReconcilePeriod.Duration
+			}
+
+			maxConcurrentReconciles := defaultMaxConcurrentReconciles
+			if w.MaxConcurrentReconciles != nil {
+				maxConcurrentReconciles = *w.MaxConcurrentReconciles
+			}
+
+			// Watches only validate the wait timeout if they wait, so it
+			// is ignored otherwise.
+			waitTimeout := defaultWaitTimeout
+			if w.WaitTimeout != nil && w.WaitTimeout.Duration > 0 {
+				waitTimeout = w.WaitTimeout.Duration
+			}
+
+			maxHistory := defaultMaxHistory
+			if w.MaxHistory != nil {
+				maxHistory = *w.MaxHistory
+			}
+
+			renderResyncInterval := defaultRenderResyncInterval
+			if w.RenderResyncInterval != nil {
+				renderResyncInterval = w.RenderResyncInterval.Duration
+			}
+
+			var remediation reconciler.Remediation
+			if w.Remediation != nil {
+				remediation = reconciler.Remediation{
+					Retries:     w.Remediation.Retries,
+					KeepFailed:  w.Remediation.KeepFailed,
+					Rollback:    w.Remediation.Rollback,
+					OnExhausted: reconciler.RemediationAction(w.Remediation.OnExhausted),
+				}
+			}
+
+			chartVersions := make([]chart.Chart, 0, len(w.ChartVersions))
+			for _, c := range w.ChartVersions {
+				chartVersions = append(chartVersions, *c)
+			}
+
+			r, err := reconciler.New(
+				reconciler.WithChart(*w.Chart),
+				reconciler.WithChartVersions(chartVersions...),
+				reconciler.WithChartVersionField(w.ChartVersionField),
+				reconciler.WithGroupVersionKind(w.GroupVersionKind),
+				reconciler.WithOverrideValues(w.OverrideValues),
+				reconciler.WithValuesFromField(w.ValuesFromField),
+				reconciler.WithReleaseNameFunc(w.ReleaseNameFunc),
+				reconciler.WithTargetNamespaceFunc(w.TargetNamespaceFunc),
+				reconciler.WithStorageDriver(w.StorageDriverGetter),
+				reconciler.WithDriftMode(reconciler.DriftMode(w.DriftMode)),
+				reconciler.WithDriftStrategy(reconciler.DriftStrategy(w.DriftStrategy)),
+				reconciler.WithFieldManager(w.FieldManager),
+				reconciler.WithIgnoreDifferences(w.IgnoreDifferences...),
+				reconciler.WithWait(w.Wait != nil && *w.Wait),
+				reconciler.WithWaitTimeout(waitTimeout),
+				reconciler.WithAtomic(w.Atomic != nil && *w.Atomic),
+				reconciler.WithCleanupOnFail(w.CleanupOnFail != nil && *w.CleanupOnFail),
+				reconciler.WithRemediation(remediation),
+				reconciler.WithRemediationField(w.RemediationField),
+				reconciler.WithMaxHistory(maxHistory),
+				reconciler.WithRenderResyncInterval(renderResyncInterval),
+				reconciler.WithManifestMode(reconciler.ManifestMode(w.ManifestMode)),
+				reconciler.SkipDependentWatches(w.WatchDependentResources != nil && !*w.WatchDependentResources),
+				reconciler.WithValidatingWebhook(w.ValidatingWebhook != nil && *w.ValidatingWebhook),
+				reconciler.WithMaxConcurrentReconciles(maxConcurrentReconciles),
+				reconciler.WithReconcilePeriod(reconcilePeriod),
+				reconciler.WithInstallAnnotations(annotation.DefaultInstallAnnotations...),
+				reconciler.WithUpgradeAnnotations(annotation.DefaultUpgradeAnnotations...),
+				reconciler.WithUninstallAnnotations(annotation.DefaultUninstallAnnotations...),
+				reconciler.WithRollbackAnnotation(annotation.RollbackToRevision{}),
+				reconciler.WithPauseAnnotation(annotation.Paused{}),
+				reconciler.WithChartVersionAnnotation(annotation.ChartVersionConstraint{}),
+				reconciler.WithPaused(w.Paused != nil && *w.Paused),
+				reconciler.SkipUninstallWhilePaused(w.UninstallWhilePaused != nil && !*w.UninstallWhilePaused),
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create helm reconciler", "controller", "Helm")
+				os.Exit(1)
+			}
+
+			if err := r.SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "Helm")
+				os.Exit(1)
+			}
+			setupLog.Info("configured watch", "gvk", w.GroupVersionKind, "chartPath", w.ChartPath, "maxConcurrentReconciles", maxConcurrentReconciles, "reconcilePeriod", reconcilePeriod)
+		}
+
+		setupLog.Info("starting manager")
+		if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+			setupLog.Error(err, "problem running manager")
 			os.Exit(1)
 		}
-		setupLog.Info("configured watch", "gvk", w.GroupVersionKind, "chartPath", w.ChartPath, "maxConcurrentReconciles", maxConcurrentReconciles, "reconcilePeriod", reconcilePeriod)
 	}
 
-	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		setupLog.Error(err, "problem running manager")
+	f := cmd.Flags()
+	f.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	f.BoolVar(&enableLeaderElection, "enable-leader-election", false,
+		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	f.StringVar(&leaderElectionID, "leader-election-id", "",
+		"Name of the configmap that is used for holding the leader lock.")
+	f.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
+		"Namespace in which to create the leader election configmap for holding the leader lock (required if running locally).")
+
+	f.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook server binds to, if validating webhooks are enabled for a watch.")
+	f.StringVar(&webhookCertDir, "webhook-cert-dir", "", "Directory that contains the webhook server key and certificate (tls.key and tls.crt). Defaults to <temp-dir>/k8s-webhook-server/serving-certs.")
+
+	f.StringVar(&watchesFile, "watches-file", "./watches.yaml", "Path to watches.yaml file.")
+	f.StringVar(&chartCacheDir, "chart-cache-dir", filepath.Join(os.TempDir(), "helm-operator", "charts"), "Directory in which charts downloaded from registries and repositories are cached.")
+	f.DurationVar(&defaultReconcilePeriod, "reconcile-period", time.Minute, "Default reconcile period for controllers (use 0 to disable periodic reconciliation)")
+	f.IntVar(&defaultMaxConcurrentReconciles, "max-concurrent-reconciles", runtime.NumCPU(), "Default maximum number of concurrent reconciles for controllers.")
+	f.DurationVar(&defaultWaitTimeout, "wait-timeout", 5*time.Minute, "Default time to wait for release resources to become ready when waiting is enabled for a watch.")
+	f.IntVar(&defaultMaxHistory, "max-history", 0, "Default maximum number of release records kept for each release (use 0 for no limit).")
+	f.DurationVar(&defaultRenderResyncInterval, "render-resync-interval", 10*time.Minute, "Default interval after which releases are rendered again even if their chart, values, and cluster capabilities are unchanged (use 0 to render on every reconcile).")
+
+	// Deprecated: --max-workers flag does not align well with the name of the option it configures on the controller
+	//   (MaxConcurrentReconciles). Flag `--max-concurrent-reconciles` should be used instead.
+	f.IntVar(&defaultMaxWorkers, "max-workers", runtime.NumCPU(), "Default maximum number of concurrent reconciles for controllers.")
+	if err := f.MarkHidden("max-workers"); err != nil {
+		setupLog.Error(err, "failed to hide --max-workers flag")
 		os.Exit(1)
 	}
+	f.AddGoFlagSet(flag.CommandLine)
+	return cmd
 }
@@ -17,8 +17,10 @@ limitations under the License.
 package annotation
 
 import (
+	"fmt"
 	"strconv"
 
+	"github.com/Masterminds/semver/v3"
 	"helm.sh/helm/v3/pkg/action"
 
 	helmclient "github.com/joelanford/helm-operator/pkg/client"
@@ -26,7 +28,7 @@ import (
 
 var (
 	DefaultInstallAnnotations   = []Install{InstallDescription{}, InstallDisableHooks{}}
-	DefaultUpgradeAnnotations   = []Upgrade{UpgradeDescription{}, UpgradeDisableHooks{}, UpgradeForce{}}
+	DefaultUpgradeAnnotations   = []Upgrade{UpgradeDescription{}, UpgradeDisableHooks{}, UpgradeForce{}, UpgradeMaxHistory{}}
 	DefaultUninstallAnnotations = []Uninstall{UninstallDescription{}, UninstallDisableHooks{}}
 )
 
@@ -45,6 +47,26 @@ type Uninstall interface {
 	UninstallOption(string) helmclient.UninstallOption
 }
 
+// Pause is an annotation that pauses reconciliation of a custom resource.
+type Pause interface {
+	Name() string
+	Paused(string) (bool, error)
+}
+
+// Rollback is an annotation that requests a rollback of the release to the
+// revision returned by Revision.
+type Rollback interface {
+	Name() string
+	Revision(string) (int, error)
+}
+
+// ChartVersion is an annotation that selects the version of the chart used
+// for a custom resource with the semver constraint returned by ChartVersion.
+type ChartVersion interface {
+	Name() string
+	ChartVersion(string) (*semver.Constraints, error)
+}
+
 type InstallDisableHooks struct {
 	CustomName string
 }
@@ -57,11 +79,16 @@ const (
 	DefaultUpgradeDisableHooksName   = DefaultDomain + "/upgrade-disable-hooks"
 	DefaultUninstallDisableHooksName = DefaultDomain + "/uninstall-disable-hooks"
 
-	DefaultUpgradeForceName = DefaultDomain + "/upgrade-force"
+	DefaultUpgradeForceName      = DefaultDomain + "/upgrade-force"
+	DefaultUpgradeMaxHistoryName = DefaultDomain + "/upgrade-max-history"
 
 	DefaultInstallDescriptionName   = DefaultDomain + "/install-description"
 	DefaultUpgradeDescriptionName   = DefaultDomain + "/upgrade-description"
 	DefaultUninstallDescriptionName = DefaultDomain + "/uninstall-description"
+
+	DefaultRollbackToRevisionName = DefaultDomain + "/rollback-to-revision"
+	DefaultPausedName             = DefaultDomain + "/paused"
+	DefaultChartVersionName       = DefaultDomain + "/chart-version"
 )
 
 func (i InstallDisableHooks) Name() string {
@@ -130,6 +157,32 @@ func (u UpgradeForce) UpgradeOption(val string) helmclient.UpgradeOption {
 	}
 }
 
+// UpgradeMaxHistory limits the number of release records that are kept when
+// the release is upgraded. 0 means no limit. Invalid values are ignored, so
+// the limit configured for the reconciler is used instead.
+type UpgradeMaxHistory struct {
+	CustomName string
+}
+
+var _ Upgrade = &UpgradeMaxHistory{}
+
+func (u UpgradeMaxHistory) Name() string {
+	if u.CustomName != "" {
+		return u.CustomName
+	}
+	return DefaultUpgradeMaxHistoryName
+}
+
+func (u UpgradeMaxHistory) UpgradeOption(val string) helmclient.UpgradeOption {
+	maxHistory, err := strconv.Atoi(val)
+	return func(upgrade *action.Upgrade) error {
+		if err == nil && maxHistory >= 0 {
+			upgrade.MaxHistory = maxHistory
+		}
+		return nil
+	}
+}
+
 type UninstallDisableHooks struct {
 	CustomName string
 }
@@ -210,3 +263,65 @@ func (u UninstallDescription) UninstallOption(v string) helmclient.UninstallOpti
 		return nil
 	}
 }
+
+var _ Rollback = &RollbackToRevision{}
+
+type RollbackToRevision struct {
+	CustomName string
+}
+
+func (r RollbackToRevision) Name() string {
+	if r.CustomName != "" {
+		return r.CustomName
+	}
+	return DefaultRollbackToRevisionName
+}
+
+func (r RollbackToRevision) Revision(v string) (int, error) {
+	revision, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid revision %q: %w", v, err)
+	}
+	if revision < 1 {
+		return 0, fmt.Errorf("invalid revision %d: must be at least 1", revision)
+	}
+	return revision, nil
+}
+
+var _ Pause = &Paused{}
+
+type Paused struct {
+	CustomName string
+}
+
+func (p Paused) Name() string {
+	if p.CustomName != "" {
+		return p.CustomName
+	}
+	return DefaultPausedName
+}
+
+func (p Paused) Paused(v string) (bool, error) {
+	return strconv.ParseBool(v)
+}
+
+var _ ChartVersion = &ChartVersionConstraint{}
+
+type ChartVersionConstraint struct {
+	CustomName string
+}
+
+func (c ChartVersionConstraint) Name() string {
+	if c.CustomName != "" {
+		return c.CustomName
+	}
+	return DefaultChartVersionName
+}
+
+func (c ChartVersionConstraint) ChartVersion(v string) (*semver.Constraints, error) {
+	constraint, err := semver.NewConstraint(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart version %q: %w", v, err)
+	}
+	return constraint, nil
+}
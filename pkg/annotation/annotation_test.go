@@ -190,6 +190,37 @@ var _ = Describe("Annotation", func() {
 				Expect(upgrade.Description).To(Equal("test description"))
 			})
 		})
+
+		Describe("MaxHistory", func() {
+			var a annotation.UpgradeMaxHistory
+
+			BeforeEach(func() {
+				a = annotation.UpgradeMaxHistory{}
+			})
+
+			It("should return a default name", func() {
+				Expect(a.Name()).To(Equal(annotation.DefaultUpgradeMaxHistoryName))
+			})
+
+			It("should return a custom name", func() {
+				const customName = "custom.domain/custom-name"
+				a.CustomName = customName
+				Expect(a.Name()).To(Equal(customName))
+			})
+
+			It("should set max history", func() {
+				Expect(a.UpgradeOption("5")(&upgrade)).To(Succeed())
+				Expect(upgrade.MaxHistory).To(Equal(5))
+			})
+
+			It("should keep max history with invalid value", func() {
+				upgrade.MaxHistory = 10
+				Expect(a.UpgradeOption("invalid")(&upgrade)).To(Succeed())
+				Expect(upgrade.MaxHistory).To(Equal(10))
+				Expect(a.UpgradeOption("-1")(&upgrade)).To(Succeed())
+				Expect(upgrade.MaxHistory).To(Equal(10))
+			})
+		})
 	})
 
 	Describe("Uninstall", func() {
@@ -257,4 +288,102 @@ var _ = Describe("Annotation", func() {
 			})
 		})
 	})
+
+	Describe("Rollback", func() {
+		Describe("ToRevision", func() {
+			var a annotation.RollbackToRevision
+
+			BeforeEach(func() {
+				a = annotation.RollbackToRevision{}
+			})
+
+			It("should return a default name", func() {
+				Expect(a.Name()).To(Equal(annotation.DefaultRollbackToRevisionName))
+			})
+
+			It("should return a custom name", func() {
+				const customName = "custom.domain/custom-name"
+				a.CustomName = customName
+				Expect(a.Name()).To(Equal(customName))
+			})
+
+			It("should parse a revision", func() {
+				Expect(a.Revision("3")).To(Equal(3))
+			})
+
+			It("should fail with an invalid value", func() {
+				_, err := a.Revision("invalid")
+				Expect(err).NotTo(BeNil())
+			})
+
+			It("should fail with a revision less than 1", func() {
+				_, err := a.Revision("0")
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("Pause", func() {
+		Describe("Paused", func() {
+			var a annotation.Paused
+
+			BeforeEach(func() {
+				a = annotation.Paused{}
+			})
+
+			It("should return a default name", func() {
+				Expect(a.Name()).To(Equal(annotation.DefaultPausedName))
+			})
+
+			It("should return a custom name", func() {
+				const customName = "custom.domain/custom-name"
+				a.CustomName = customName
+				Expect(a.Name()).To(Equal(customName))
+			})
+
+			It("should pause", func() {
+				Expect(a.Paused("true")).To(BeTrue())
+			})
+
+			It("should not pause", func() {
+				Expect(a.Paused("false")).To(BeFalse())
+			})
+
+			It("should fail with an invalid value", func() {
+				_, err := a.Paused("invalid")
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("ChartVersion", func() {
+		Describe("Constraint", func() {
+			var a annotation.ChartVersionConstraint
+
+			BeforeEach(func() {
+				a = annotation.ChartVersionConstraint{}
+			})
+
+			It("should return a default name", func() {
+				Expect(a.Name()).To(Equal(annotation.DefaultChartVersionName))
+			})
+
+			It("should return a custom name", func() {
+				const customName = "custom.domain/custom-name"
+				a.CustomName = customName
+				Expect(a.Name()).To(Equal(customName))
+			})
+
+			It("should parse a constraint", func() {
+				c, err := a.ChartVersion("~1.2")
+				Expect(err).To(BeNil())
+				Expect(c.String()).To(Equal("~1.2"))
+			})
+
+			It("should fail with an invalid value", func() {
+				_, err := a.ChartVersion("invalid")
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
 })
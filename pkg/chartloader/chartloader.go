@@ -0,0 +1,279 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chartloader loads Helm charts from local paths, OCI registries, and
+// HTTP chart repositories. Downloaded chart archives are cached on disk by
+// their digest.
+package chartloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// OCIScheme is the URL scheme of charts stored in OCI registries.
+const OCIScheme = "oci://"
+
+// maxChartSize is the maximum size of a downloaded chart archive or index.
+const maxChartSize = 64 << 20
+
+// Reference identifies a chart.
+//
+// A chart is either:
+//   - a local chart directory or archive, if Path has no scheme,
+//   - an OCI artifact, if Path is an "oci://host/repository" URL. The tag is
+//     Version or, if Version is empty, the tag in Path (e.g. "oci://host/repo:1.0.0"),
+//   - a chart in an HTTP chart repository, if Repo is set. Version is a semver
+//     constraint; the latest matching version is used.
+type Reference struct {
+	Path    string
+	Repo    string
+	Name    string
+	Version string
+
+	// Digest pins the "sha256:<hex>" digest of the chart archive. Loading a
+	// chart fails if its digest is different, and pinned charts are loaded
+	// from the cache without contacting the registry or repository.
+	Digest string
+
+	// Username and Password are used to authenticate with the registry or
+	// repository.
+	Username string
+	Password string
+}
+
+// IsRemote returns whether the chart is downloaded from a registry or
+// repository.
+func (r Reference) IsRemote() bool {
+	return r.Repo != "" || strings.HasPrefix(r.Path, OCIScheme)
+}
+
+func (r Reference) String() string {
+	if r.Repo != "" {
+		s := fmt.Sprintf("%s/%s", strings.TrimSuffix(r.Repo, "/"), r.Name)
+		if r.Version != "" {
+			s += "@" + r.Version
+		}
+		return s
+	}
+	if strings.HasPrefix(r.Path, OCIScheme) && r.Version != "" {
+		return r.Path + ":" + r.Version
+	}
+	return r.Path
+}
+
+// Loader loads charts.
+type Loader struct {
+	cacheDir   string
+	httpClient *http.Client
+}
+
+// Option configures a Loader.
+type Option func(*Loader)
+
+// WithCacheDir configures the directory in which downloaded chart archives
+// are cached. By default, a directory in os.TempDir() is used.
+func WithCacheDir(dir string) Option {
+	return func(l *Loader) {
+		l.cacheDir = dir
+	}
+}
+
+// WithHTTPClient configures the client used to contact registries and
+// repositories. By default, http.DefaultClient is used.
+func WithHTTPClient(c *http.Client) Option {
+	return func(l *Loader) {
+		l.httpClient = c
+	}
+}
+
+// New returns a new Loader.
+func New(opts ...Option) *Loader {
+	l := &Loader{
+		cacheDir:   filepath.Join(os.TempDir(), "helm-operator", "charts"),
+		httpClient: http.DefaultClient,
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	return l
+}
+
+// Load loads the chart identified by ref.
+func (l *Loader) Load(ref Reference) (*chart.Chart, error) {
+	if !ref.IsRemote() {
+		if ref.Digest != "" {
+			return nil, errors.New("digest can only be pinned for remote charts")
+		}
+		return loader.Load(ref.Path)
+	}
+	if ref.Repo != "" && ref.Name == "" {
+		return nil, errors.New("chart name must be set for chart repositories")
+	}
+	if ref.Digest != "" {
+		if err := validateDigest(ref.Digest); err != nil {
+			return nil, err
+		}
+		if chrt, err := l.loadCached(ref.Digest); err == nil {
+			return chrt, nil
+		}
+	}
+
+	var (
+		r   resolved
+		err error
+	)
+	if ref.Repo != "" {
+		r, err = l.resolveRepo(ref)
+	} else {
+		r, err = l.resolveOCI(ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolve chart %s: %w", ref, err)
+	}
+	if ref.Digest != "" && r.digest != "" && r.digest != ref.Digest {
+		return nil, fmt.Errorf("chart %s has digest %s, expected %s", ref, r.digest, ref.Digest)
+	}
+	if r.digest != "" {
+		if chrt, err := l.loadCached(r.digest); err == nil {
+			return chrt, nil
+		}
+	}
+
+	data, err := r.download()
+	if err != nil {
+		return nil, fmt.Errorf("download chart %s: %w", ref, err)
+	}
+	digest := digestOf(data)
+	if r.digest != "" && digest != r.digest {
+		return nil, fmt.Errorf("downloaded chart %s has digest %s, expected %s", ref, digest, r.digest)
+	}
+	if ref.Digest != "" && digest != ref.Digest {
+		return nil, fmt.Errorf("downloaded chart %s has digest %s, expected %s", ref, digest, ref.Digest)
+	}
+
+	chrt, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("load chart %s: %w", ref, err)
+	}
+	if err := l.storeCached(digest, data); err != nil {
+		return nil, fmt.Errorf("cache chart %s: %w", ref, err)
+	}
+	return chrt, nil
+}
+
+// resolved is a chart whose location, and possibly digest, is known.
+type resolved struct {
+	digest   string
+	download func() ([]byte, error)
+}
+
+func (l *Loader) cachePath(digest string) string {
+	return filepath.Join(l.cacheDir, strings.Replace(digest, ":", "-", 1)+".tgz")
+}
+
+func (l *Loader) loadCached(digest string) (*chart.Chart, error) {
+	data, err := ioutil.ReadFile(l.cachePath(digest))
+	if err != nil {
+		return nil, err
+	}
+	if digestOf(data) != digest {
+		return nil, fmt.Errorf("cached chart %s is corrupt", digest)
+	}
+	return loader.LoadArchive(bytes.NewReader(data))
+}
+
+// storeCached writes the chart archive to the cache. The archive is written
+// to a temporary file first, so that concurrent loaders never see a partial
+// archive.
+func (l *Loader) storeCached(digest string, data []byte) error {
+	if err := os.MkdirAll(l.cacheDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(l.cacheDir, ".download-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.cachePath(digest))
+}
+
+// get sends a GET request for url and returns the response body. If
+// username is set, the request uses basic authentication.
+func (l *Loader) get(url, username, password string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxChartSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxChartSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxChartSize)
+	}
+	return data, nil
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func validateDigest(digest string) error {
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	if hexDigest == digest {
+		return fmt.Errorf("invalid digest %q: only sha256 digests are supported", digest)
+	}
+	if b, err := hex.DecodeString(hexDigest); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartloader_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+
+	. "github.com/joelanford/helm-operator/pkg/chartloader"
+)
+
+const testChartPath = "../../testdata/test-chart-0.1.0.tgz"
+
+var _ = Describe("Loader", func() {
+	var (
+		archive  []byte
+		digest   string
+		cacheDir string
+		requests int
+	)
+
+	BeforeEach(func() {
+		var err error
+		archive, err = ioutil.ReadFile(testChartPath)
+		Expect(err).To(BeNil())
+		sum := sha256.Sum256(archive)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+
+		cacheDir, err = ioutil.TempDir("", "chartloader-")
+		Expect(err).To(BeNil())
+		requests = 0
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("should load local charts", func() {
+		chrt, err := New(WithCacheDir(cacheDir)).Load(Reference{Path: testChartPath})
+		Expect(err).To(BeNil())
+		Expect(chrt.Metadata.Name).To(Equal("test-chart"))
+	})
+
+	It("should not pin digests of local charts", func() {
+		_, err := New(WithCacheDir(cacheDir)).Load(Reference{Path: testChartPath, Digest: digest})
+		Expect(err).To(MatchError(ContainSubstring("remote charts")))
+	})
+
+	Describe("OCI registries", func() {
+		var (
+			srv *httptest.Server
+			l   *Loader
+			ref Reference
+		)
+
+		BeforeEach(func() {
+			mux := http.NewServeMux()
+			srv = httptest.NewTLSServer(mux)
+
+			// The registry stand-in requires a bearer token, which its token
+			// endpoint issues for basic credentials.
+			mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+				if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				Expect(r.URL.Query().Get("scope")).To(Equal("repository:charts/test-chart:pull"))
+				_, _ = w.Write([]byte(`{"token":"secret-token"}`))
+			})
+			mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.Header.Get("Authorization") != "Bearer secret-token" {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:charts/test-chart:pull"`, srv.URL))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				switch r.URL.Path {
+				case "/v2/charts/test-chart/manifests/0.1.0":
+					Expect(r.Header.Get("Accept")).To(ContainSubstring("application/vnd.oci.image.manifest.v1+json"))
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{
+						"schemaVersion": 2,
+						"layers": []map[string]interface{}{{
+							"mediaType": "application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+							"digest":    digest,
+							"size":      len(archive),
+						}},
+					})
+				case "/v2/charts/test-chart/blobs/" + digest:
+					_, _ = w.Write(archive)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+
+			l = New(WithCacheDir(cacheDir), WithHTTPClient(srv.Client()))
+			ref = Reference{
+				Path:     "oci://" + strings.TrimPrefix(srv.URL, "https://") + "/charts/test-chart",
+				Version:  "0.1.0",
+				Username: "user",
+				Password: "pass",
+			}
+		})
+
+		AfterEach(func() {
+			srv.Close()
+		})
+
+		It("should load charts", func() {
+			chrt, err := l.Load(ref)
+			Expect(err).To(BeNil())
+			Expect(chrt.Metadata.Version).To(Equal("0.1.0"))
+		})
+
+		It("should load charts with the tag in the path", func() {
+			ref.Path, ref.Version = ref.Path+":0.1.0", ""
+			chrt, err := l.Load(ref)
+			Expect(err).To(BeNil())
+			Expect(chrt.Metadata.Version).To(Equal("0.1.0"))
+		})
+
+		It("should fail without credentials", func() {
+			ref.Username, ref.Password = "", ""
+			_, err := l.Load(ref)
+			Expect(err).To(MatchError(ContainSubstring("authorize")))
+		})
+
+		It("should fail without a version", func() {
+			ref.Version = ""
+			_, err := l.Load(ref)
+			Expect(err).To(MatchError(ContainSubstring("require a version")))
+		})
+
+		It("should load pinned charts from the cache", func() {
+			ref.Digest = digest
+			_, err := l.Load(ref)
+			Expect(err).To(BeNil())
+
+			srv.Close()
+			chrt, err := l.Load(ref)
+			Expect(err).To(BeNil())
+			Expect(chrt.Metadata.Name).To(Equal("test-chart"))
+		})
+
+		It("should fail if the pinned digest does not match", func() {
+			ref.Digest = "sha256:" + strings.Repeat("0", 64)
+			_, err := l.Load(ref)
+			Expect(err).To(MatchError(ContainSubstring("expected " + ref.Digest)))
+		})
+	})
+
+	Describe("HTTP chart repositories", func() {
+		var (
+			srv *httptest.Server
+			l   *Loader
+			ref Reference
+		)
+
+		BeforeEach(func() {
+			mux := http.NewServeMux()
+			srv = httptest.NewServer(mux)
+
+			idx := repo.NewIndexFile()
+			idx.Add(&chart.Metadata{APIVersion: "v2", Name: "test-chart", Version: "0.1.0"}, "test-chart-0.1.0.tgz", srv.URL+"/charts", strings.TrimPrefix(digest, "sha256:"))
+			idx.Add(&chart.Metadata{APIVersion: "v2", Name: "test-chart", Version: "0.2.0"}, "test-chart-0.2.0.tgz", srv.URL+"/charts", strings.Repeat("0", 64))
+			index, err := yaml.Marshal(idx)
+			Expect(err).To(BeNil())
+
+			basicAuth := func(h http.HandlerFunc) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					requests++
+					if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					h(w, r)
+				}
+			}
+			mux.HandleFunc("/index.yaml", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(index)
+			}))
+			mux.HandleFunc("/charts/test-chart-0.1.0.tgz", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(archive)
+			}))
+			mux.HandleFunc("/charts/test-chart-0.2.0.tgz", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(archive)
+			}))
+
+			l = New(WithCacheDir(cacheDir))
+			ref = Reference{Repo: srv.URL, Name: "test-chart", Version: "~0.1.0", Username: "user", Password: "pass"}
+		})
+
+		AfterEach(func() {
+			srv.Close()
+		})
+
+		It("should load the latest matching version", func() {
+			chrt, err := l.Load(ref)
+			Expect(err).To(BeNil())
+			Expect(chrt.Metadata.Version).To(Equal("0.1.0"))
+			Expect(requests).To(Equal(2))
+		})
+
+		It("should only download charts once", func() {
+			_, err := l.Load(ref)
+			Expect(err).To(BeNil())
+			_, err = l.Load(ref)
+			Expect(err).To(BeNil())
+			Expect(requests).To(Equal(3))
+		})
+
+		It("should fail if the downloaded chart does not match the index digest", func() {
+			ref.Version = "0.2.0"
+			_, err := l.Load(ref)
+			Expect(err).To(MatchError(ContainSubstring("has digest " + digest)))
+		})
+
+		It("should fail without credentials", func() {
+			ref.Username, ref.Password = "", ""
+			_, err := l.Load(ref)
+			Expect(err).To(MatchError(ContainSubstring("401")))
+		})
+
+		It("should fail for unknown charts", func() {
+			ref.Name = "unknown"
+			_, err := l.Load(ref)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
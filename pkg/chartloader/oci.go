@@ -0,0 +1,236 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartloader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+	// Helm 3.0-3.6 pushed charts with the legacy chart layer media type.
+	chartLayerMediaType       = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	legacyChartLayerMediaType = "application/tar+gzip"
+)
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// parseOCIPath splits an "oci://host/repository[:tag]" path into its host,
+// repository, and tag.
+func parseOCIPath(path string) (string, string, string, error) {
+	rest := strings.TrimPrefix(path, OCIScheme)
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return "", "", "", fmt.Errorf("invalid OCI reference %q", path)
+	}
+	host, repository := rest[:slash], rest[slash+1:]
+	tag := ""
+	if colon := strings.LastIndex(repository, ":"); colon > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:colon], repository[colon+1:]
+	}
+	return host, repository, tag, nil
+}
+
+// resolveOCI fetches the manifest of the chart referenced by ref and finds
+// its chart layer.
+func (l *Loader) resolveOCI(ref Reference) (resolved, error) {
+	host, repository, tag, err := parseOCIPath(ref.Path)
+	if err != nil {
+		return resolved{}, err
+	}
+	if ref.Version != "" {
+		if tag != "" && tag != ref.Version {
+			return resolved{}, fmt.Errorf("tag %q does not match version %q", tag, ref.Version)
+		}
+		tag = ref.Version
+	}
+	if tag == "" {
+		return resolved{}, errors.New("OCI chart references require a version")
+	}
+	// OCI tags cannot contain "+", so Helm replaces it with "_".
+	tag = strings.Replace(tag, "+", "_", -1)
+
+	c := &registryClient{loader: l, host: host, username: ref.Username, password: ref.Password}
+	data, err := c.get(fmt.Sprintf("/v2/%s/manifests/%s", repository, tag), ociManifestMediaType)
+	if err != nil {
+		return resolved{}, err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return resolved{}, fmt.Errorf("decode manifest: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != chartLayerMediaType && layer.MediaType != legacyChartLayerMediaType {
+			continue
+		}
+		if err := validateDigest(layer.Digest); err != nil {
+			return resolved{}, fmt.Errorf("chart layer: %w", err)
+		}
+		blobPath := fmt.Sprintf("/v2/%s/blobs/%s", repository, layer.Digest)
+		return resolved{
+			digest:   layer.Digest,
+			download: func() ([]byte, error) { return c.get(blobPath, "") },
+		}, nil
+	}
+	return resolved{}, errors.New("manifest has no chart layer")
+}
+
+// registryClient sends requests to an OCI registry. It supports anonymous,
+// basic, and bearer token authentication.
+type registryClient struct {
+	loader   *Loader
+	host     string
+	username string
+	password string
+
+	authorization string
+}
+
+func (c *registryClient) get(path, accept string) ([]byte, error) {
+	u := "https://" + c.host + path
+	resp, err := c.do(u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && c.authorization == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authorize(challenge); err != nil {
+			return nil, fmt.Errorf("authorize with %s: %w", c.host, err)
+		}
+		if resp, err = c.do(u, accept); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", u, resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+func (c *registryClient) do(u, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.loader.httpClient.Do(req)
+}
+
+// authorize sets the authorization used for requests according to the
+// WWW-Authenticate challenge of the registry.
+func (c *registryClient) authorize(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return errors.New("registry requires credentials")
+		}
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.username+":"+c.password))
+		return nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return fmt.Errorf("invalid bearer realm %q", params["realm"])
+		}
+		q := realm.Query()
+		for _, k := range []string{"service", "scope"} {
+			if v, ok := params[k]; ok {
+				q.Set(k, v)
+			}
+		}
+		realm.RawQuery = q.Encode()
+		data, err := c.loader.get(realm.String(), c.username, c.password)
+		if err != nil {
+			return err
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.Unmarshal(data, &token); err != nil {
+			return fmt.Errorf("decode token: %w", err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		if token.Token == "" {
+			return errors.New("token response has no token")
+		}
+		c.authorization = "Bearer " + token.Token
+		return nil
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	for _, kv := range splitParams(parts[1]) {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(kv[:i]))] = strings.Trim(strings.TrimSpace(kv[i+1:]), `"`)
+	}
+	return parts[0], params
+}
+
+// splitParams splits s on commas that are not inside quotes.
+func splitParams(s string) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartloader
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// resolveRepo finds the chart version matching ref in the index of its HTTP
+// chart repository.
+func (l *Loader) resolveRepo(ref Reference) (resolved, error) {
+	base, err := url.Parse(strings.TrimSuffix(ref.Repo, "/") + "/")
+	if err != nil {
+		return resolved{}, err
+	}
+	indexURL, err := base.Parse("index.yaml")
+	if err != nil {
+		return resolved{}, err
+	}
+	data, err := l.get(indexURL.String(), ref.Username, ref.Password)
+	if err != nil {
+		return resolved{}, err
+	}
+
+	idx := &repo.IndexFile{}
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return resolved{}, err
+	}
+	if idx.APIVersion == "" {
+		return resolved{}, repo.ErrNoAPIVersion
+	}
+	idx.SortEntries()
+	cv, err := idx.Get(ref.Name, ref.Version)
+	if err != nil {
+		return resolved{}, err
+	}
+	if len(cv.URLs) == 0 {
+		return resolved{}, errors.New("chart version has no URLs")
+	}
+	chartURL, err := base.Parse(cv.URLs[0])
+	if err != nil {
+		return resolved{}, err
+	}
+
+	r := resolved{download: func() ([]byte, error) {
+		// Credentials are only sent to the repository's own host.
+		if chartURL.Host != base.Host {
+			return l.get(chartURL.String(), "", "")
+		}
+		return l.get(chartURL.String(), ref.Username, ref.Password)
+	}}
+	if cv.Digest != "" {
+		r.digest = cv.Digest
+		if !strings.HasPrefix(r.digest, "sha256:") {
+			r.digest = "sha256:" + r.digest
+		}
+	}
+	return r, nil
+}
@@ -18,9 +18,14 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"gomodules.xyz/jsonpatch/v2"
 	"helm.sh/helm/v3/pkg/action"
@@ -29,6 +34,7 @@ import (
 	helmkube "helm.sh/helm/v3/pkg/kube"
 	"helm.sh/helm/v3/pkg/postrender"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
@@ -36,12 +42,15 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	apitypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/cli-runtime/pkg/resource"
 	"sigs.k8s.io/yaml"
 
+	"github.com/joelanford/helm-operator/pkg/diff"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/controllerutil"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/handler"
 )
@@ -61,13 +70,157 @@ type ActionInterface interface {
 	Install(name, namespace string, chrt *chart.Chart, vals map[string]interface{}, opts ...InstallOption) (*release.Release, error)
 	Upgrade(name, namespace string, chrt *chart.Chart, vals map[string]interface{}, opts ...UpgradeOption) (*release.Release, error)
 	Uninstall(name string, opts ...UninstallOption) (*release.UninstallReleaseResponse, error)
-	Reconcile(rel *release.Release) error
+	Rollback(name string, opts ...RollbackOption) error
+	History(name string, opts ...HistoryOption) ([]*release.Release, error)
+	PruneHistory(name string, max int) (int, error)
+	Reconcile(rel *release.Release, opts ...ReconcileOption) ([]Drift, error)
+}
+
+// FailedReleaseError is returned by Install and Upgrade if the release
+// failed after it was rendered. Release is the failed release. It is left in
+// place, so that callers can decide whether to keep it, uninstall it, or roll
+// it back.
+type FailedReleaseError struct {
+	Release *release.Release
+	Err     error
+}
+
+func (e *FailedReleaseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FailedReleaseError) Unwrap() error {
+	return e.Err
 }
 
 type GetOption func(*action.Get) error
 type InstallOption func(*action.Install) error
 type UpgradeOption func(*action.Upgrade) error
 type UninstallOption func(*action.Uninstall) error
+type RollbackOption func(*action.Rollback) error
+type HistoryOption func(*action.History) error
+type ReconcileOption func(*ReconcileOptions) error
+
+// ReconcileOptions configures a release reconciliation.
+type ReconcileOptions struct {
+	// DryRun reports drift without correcting it.
+	DryRun bool
+
+	// ServerSideApply corrects drift with server-side apply instead of a
+	// client-side patch. Fields of drifted resources that are managed by
+	// other field managers are not overwritten, but reported as conflicts.
+	ServerSideApply bool
+
+	// FieldManager is the field manager used for server-side apply. It
+	// defaults to DefaultFieldManager.
+	FieldManager string
+
+	// IgnoreDifferences are rules for fields whose differences from the
+	// release manifest are neither reported nor corrected. Ignored fields
+	// are set when missing resources are re-created.
+	IgnoreDifferences diff.IgnoreRules
+
+	// Cache, if set, is read instead of the API server to get the current
+	// state of resources of the kinds that it caches.
+	Cache ObjectCache
+}
+
+// ObjectCache is a cache of objects of some kinds, such as the informer
+// cache of a controller-runtime manager.
+type ObjectCache interface {
+	// Get gets the object with key from the cache.
+	Get(ctx context.Context, key apitypes.NamespacedName, obj runtime.Object) error
+
+	// Caches reports whether objects of gvk are cached. Get is only called
+	// for objects of cached kinds.
+	Caches(gvk schema.GroupVersionKind) bool
+}
+
+// ReleaseResourceLabel is the label that the post-renderer of action clients
+// sets on every resource of a release, so that the resources of releases can
+// be selected by label. ManifestsEqual disregards it, so the resources of
+// releases that were installed before the label was introduced are only
+// labeled when the release is upgraded for another reason.
+const ReleaseResourceLabel = "helm.operator-sdk/release-resource"
+
+// ReleaseResourceSelector selects the resources of releases that carry
+// ReleaseResourceLabel.
+var ReleaseResourceSelector = labels.SelectorFromSet(labels.Set{ReleaseResourceLabel: "true"})
+
+// ManifestsEqual returns whether the release manifests a and b render the
+// same resources, disregarding ReleaseResourceLabel. Manifests that cannot be
+// parsed are only equal if they are identical.
+func ManifestsEqual(a, b string) bool {
+	if a == b {
+		return true
+	}
+	objsA, err := unlabeledObjects(a)
+	if err != nil {
+		return false
+	}
+	objsB, err := unlabeledObjects(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(objsA, objsB)
+}
+
+// unlabeledObjects parses the resources of manifest, in order, and removes
+// ReleaseResourceLabel from them.
+func unlabeledObjects(manifest string) ([]map[string]interface{}, error) {
+	docs := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	objs := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(docs[k]), &obj); err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{Object: obj}
+		if l := u.GetLabels(); l != nil {
+			delete(l, ReleaseResourceLabel)
+			if len(l) == 0 {
+				l = nil
+			}
+			u.SetLabels(l)
+		}
+		objs = append(objs, u.Object)
+	}
+	return objs, nil
+}
+
+// DefaultFieldManager is the default field manager used to correct drift
+// with server-side apply.
+const DefaultFieldManager = "helm-operator"
+
+// Drift describes a release resource that has diverged from the release
+// manifest.
+type Drift struct {
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	Name             string
+
+	// Missing is true if the resource does not exist.
+	Missing bool
+
+	// Fields are the sorted paths (e.g. `spec.template.spec.containers`) of
+	// the fields that differ from the release manifest.
+	Fields []string
+
+	// Conflicts describe the fields that could not be corrected with
+	// server-side apply because they are managed by other field managers,
+	// e.g. `spec.replicas: conflict with "kube-controller-manager"`. A
+	// resource with conflicts is not changed.
+	Conflicts []string
+}
 
 func NewActionClientGetter(acg ActionConfigGetter) ActionClientGetter {
 	return &actionClientGetter{acg}
@@ -123,24 +276,12 @@ func (c *actionClient) Install(name, namespace string, chrt *chart.Chart, vals m
 	rel, err := install.Run(chrt, vals)
 	if err != nil {
 		c.conf.Log("Install failed")
+		// In certain cases, Install will return a partial release in the
+		// response even when it doesn't record the release in its release
+		// store (e.g. when there is an error rendering the release manifest).
+		// Uninstalling such a release fails with a not found error.
 		if rel != nil {
-			// Uninstall the failed release installation so that we can retry
-			// the installation again during the next reconciliation. In many
-			// cases, the issue is unresolvable without a change to the CR, but
-			// controller-runtime will backoff on retries after failed attempts.
-			//
-			// In certain cases, Install will return a partial release in
-			// the response even when it doesn't record the release in its release
-			// store (e.g. when there is an error rendering the release manifest).
-			// In that case the rollback will fail with a not found error because
-			// there was nothing to rollback.
-			//
-			// Only return an error about a rollback failure if the failure was
-			// caused by something other than the release not being found.
-			_, uninstallErr := c.Uninstall(name)
-			if !errors.Is(uninstallErr, driver.ErrReleaseNotFound) {
-				return nil, fmt.Errorf("uninstall failed: %v: original install error: %w", uninstallErr, err)
-			}
+			return nil, &FailedReleaseError{Release: rel, Err: err}
 		}
 		return nil, err
 	}
@@ -158,19 +299,11 @@ func (c *actionClient) Upgrade(name, namespace string, chrt *chart.Chart, vals m
 	upgrade.Namespace = namespace
 	rel, err := upgrade.Run(name, chrt, vals)
 	if err != nil {
+		// As of Helm 2.13, if Upgrade returns a non-nil release, that means
+		// the release was also recorded in the release store, so it can be
+		// rolled back.
 		if rel != nil {
-			rollback := action.NewRollback(c.conf)
-			rollback.Force = true
-
-			// As of Helm 2.13, if Upgrade returns a non-nil release, that
-			// means the release was also recorded in the release store.
-			// Therefore, we should perform the rollback when we have a non-nil
-			// release. Any rollback error here would be unexpected, so always
-			// log both the update and rollback errors.
-			rollbackErr := rollback.Run(name)
-			if rollbackErr != nil {
-				return nil, fmt.Errorf("rollback failed: %v: original upgrade error: %w", rollbackErr, err)
-			}
+			return nil, &FailedReleaseError{Release: rel, Err: err}
 		}
 		return nil, err
 	}
@@ -187,20 +320,102 @@ func (c *actionClient) Uninstall(name string, opts ...UninstallOption) (*release
 	return uninstall.Run(name)
 }
 
-func (c *actionClient) Reconcile(rel *release.Release) error {
+func (c *actionClient) Rollback(name string, opts ...RollbackOption) error {
+	rollback := action.NewRollback(c.conf)
+	for _, o := range opts {
+		if err := o(rollback); err != nil {
+			return err
+		}
+	}
+	return rollback.Run(name)
+}
+
+func (c *actionClient) History(name string, opts ...HistoryOption) ([]*release.Release, error) {
+	history := action.NewHistory(c.conf)
+	for _, o := range opts {
+		if err := o(history); err != nil {
+			return nil, err
+		}
+	}
+	return history.Run(name)
+}
+
+// PruneHistory deletes the oldest records of the release until at most max
+// records are left, and returns the number of records that are left. Like
+// Helm, it never deletes the deployed release. If max is not positive, no
+// records are deleted.
+func (c *actionClient) PruneHistory(name string, max int) (int, error) {
+	history, err := c.conf.Releases.History(name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if max <= 0 || len(history) <= max {
+		return len(history), nil
+	}
+
+	releaseutil.SortByRevision(history)
+	remaining := len(history)
+	for _, rel := range history[:len(history)-max] {
+		if rel.Info != nil && rel.Info.Status == release.StatusDeployed {
+			continue
+		}
+		if _, err := c.conf.Releases.Delete(rel.Name, rel.Version); err != nil {
+			return remaining, fmt.Errorf("delete release version %d: %w", rel.Version, err)
+		}
+		remaining--
+	}
+	return remaining, nil
+}
+
+// Reconcile re-creates or patches the resources of rel that have diverged
+// from the release manifest and returns the drift it found. If the DryRun
+// option is set, the drift is only reported.
+func (c *actionClient) Reconcile(rel *release.Release, opts ...ReconcileOption) ([]Drift, error) {
+	reconcileOpts := &ReconcileOptions{}
+	for _, o := range opts {
+		if err := o(reconcileOpts); err != nil {
+			return nil, err
+		}
+	}
+	if reconcileOpts.FieldManager == "" {
+		reconcileOpts.FieldManager = DefaultFieldManager
+	}
+
 	infos, err := c.conf.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return infos.Visit(func(expected *resource.Info, err error) error {
+	var drifts []Drift
+	err = infos.Visit(func(expected *resource.Info, err error) error {
 		if err != nil {
 			return fmt.Errorf("visit error: %w", err)
 		}
 
 		helper := resource.NewHelper(expected.Client, expected.Mapping)
+		drift := Drift{
+			GroupVersionKind: expected.Mapping.GroupVersionKind,
+			Namespace:        expected.Namespace,
+			Name:             expected.Name,
+		}
 
-		existing, err := helper.Get(expected.Namespace, expected.Name, expected.Export)
+		existing, err := getExisting(helper, expected, reconcileOpts.Cache)
 		if apierrors.IsNotFound(err) {
+			drift.Missing = true
+			drifts = append(drifts, drift)
+			if reconcileOpts.DryRun {
+				return nil
+			}
+			if reconcileOpts.ServerSideApply {
+				// Applying a missing resource creates it with the operator
+				// as the manager of all of its fields.
+				if _, err := apply(helper, expected, reconcileOpts); err != nil {
+					return fmt.Errorf("apply error: %w", err)
+				}
+				return nil
+			}
 			if _, err := helper.Create(expected.Namespace, true, expected.Object); err != nil {
 				return fmt.Errorf("create error: %w", err)
 			}
@@ -209,6 +424,17 @@ func (c *actionClient) Reconcile(rel *release.Release) error {
 			return fmt.Errorf("could not get object: %w", err)
 		}
 
+		if len(reconcileOpts.IgnoreDifferences) > 0 {
+			if existing, err = ignoreDifferences(reconcileOpts.IgnoreDifferences, existing); err != nil {
+				return err
+			}
+			info := *expected
+			if info.Object, err = ignoreDifferences(reconcileOpts.IgnoreDifferences, expected.Object); err != nil {
+				return err
+			}
+			expected = &info
+		}
+
 		patch, patchType, err := createPatch(existing, expected)
 		if err != nil {
 			return fmt.Errorf("error creating patch: %w", err)
@@ -219,6 +445,25 @@ func (c *actionClient) Reconcile(rel *release.Release) error {
 			return nil
 		}
 
+		drift.Fields, err = patchFields(patch, patchType)
+		if err != nil {
+			return fmt.Errorf("error reading patch: %w", err)
+		}
+		if reconcileOpts.ServerSideApply {
+			// In dry-run mode, the apply is still sent as a dry-run request
+			// so that conflicts are reported.
+			drift.Conflicts, err = apply(helper, expected, reconcileOpts)
+			if err != nil {
+				return fmt.Errorf("apply error: %w", err)
+			}
+			drifts = append(drifts, drift)
+			return nil
+		}
+		drifts = append(drifts, drift)
+		if reconcileOpts.DryRun {
+			return nil
+		}
+
 		_, err = helper.Patch(expected.Namespace, expected.Name, patchType, patch,
 			&metav1.PatchOptions{})
 		if err != nil {
@@ -226,6 +471,84 @@ func (c *actionClient) Reconcile(rel *release.Release) error {
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return drifts, nil
+}
+
+// getExisting returns the current state of the resource of expected. It is
+// read from cache if cache has the resource; resources that are not in the
+// cache are read from the API server, since the cache may not have observed
+// them yet or may not cover their namespace.
+func getExisting(helper *resource.Helper, expected *resource.Info, cache ObjectCache) (runtime.Object, error) {
+	gvk := expected.Mapping.GroupVersionKind
+	if cache != nil && cache.Caches(gvk) {
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(gvk)
+		key := apitypes.NamespacedName{Namespace: expected.Namespace, Name: expected.Name}
+		if err := cache.Get(context.TODO(), key, existing); err == nil {
+			return existing, nil
+		}
+	}
+	return helper.Get(expected.Namespace, expected.Name, expected.Export)
+}
+
+// ignoreDifferences returns a copy of obj without the fields ignored by rules.
+func ignoreDifferences(rules diff.IgnoreRules, obj runtime.Object) (runtime.Object, error) {
+	var u *unstructured.Unstructured
+	if uObj, ok := obj.(*unstructured.Unstructured); ok {
+		u = uObj.DeepCopy()
+	} else {
+		m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+		u = &unstructured.Unstructured{Object: m}
+	}
+	rules.Strip(u)
+	return u, nil
+}
+
+// apply applies expected with server-side apply without forcing conflicts. If
+// the apply fails because of conflicts with other field managers, the
+// conflicts are returned.
+func apply(helper *resource.Helper, expected *resource.Info, opts *ReconcileOptions) ([]string, error) {
+	data, err := json.Marshal(expected.Object)
+	if err != nil {
+		return nil, err
+	}
+	force := false
+	patchOpts := &metav1.PatchOptions{FieldManager: opts.FieldManager, Force: &force}
+	if opts.DryRun {
+		patchOpts.DryRun = []string{metav1.DryRunAll}
+	}
+	_, err = helper.Patch(expected.Namespace, expected.Name, apitypes.ApplyPatchType, data, patchOpts)
+	if conflicts := applyConflicts(err); len(conflicts) > 0 {
+		return conflicts, nil
+	}
+	return nil, err
+}
+
+// applyConflicts returns the field conflicts of a failed server-side apply.
+func applyConflicts(err error) []string {
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		return nil
+	}
+	status := statusErr.Status()
+	if status.Reason != metav1.StatusReasonConflict || status.Details == nil {
+		return nil
+	}
+	var conflicts []string
+	for _, cause := range status.Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s: %s", strings.TrimPrefix(cause.Field, "."), cause.Message))
+	}
+	sort.Strings(conflicts)
+	return conflicts
 }
 
 func createPatch(existing runtime.Object, expected *resource.Info) ([]byte, apitypes.PatchType, error) {
@@ -294,6 +617,72 @@ func createJSONMergePatch(existingJSON, expectedJSON []byte) ([]byte, error) {
 	return json.Marshal(patchOps)
 }
 
+// patchFields returns the sorted paths of the fields set by patch. Paths stop
+// at lists, so a change to any container is reported as
+// `spec.template.spec.containers`.
+func patchFields(patch []byte, patchType apitypes.PatchType) ([]string, error) {
+	fields := map[string]struct{}{}
+	switch patchType {
+	case apitypes.JSONPatchType:
+		var ops []jsonpatch.JsonPatchOperation
+		if err := json.Unmarshal(patch, &ops); err != nil {
+			return nil, err
+		}
+		for _, op := range ops {
+			fields[jsonPointerToPath(op.Path)] = struct{}{}
+		}
+	default:
+		var m map[string]interface{}
+		if err := json.Unmarshal(patch, &m); err != nil {
+			return nil, err
+		}
+		collectMergePatchFields(fields, "", m)
+	}
+
+	out := make([]string, 0, len(fields))
+	for f := range fields {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func collectMergePatchFields(fields map[string]struct{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		// Skip strategic merge patch directives, e.g. $setElementOrder.
+		if strings.HasPrefix(k, "$") {
+			continue
+		}
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+			collectMergePatchFields(fields, path, child)
+			continue
+		}
+		fields[path] = struct{}{}
+	}
+}
+
+// jsonPointerToPath converts a JSON pointer such as `/spec/ports/0/port` to a
+// field path such as `spec.ports[0].port`.
+func jsonPointerToPath(pointer string) string {
+	var b strings.Builder
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		if _, err := strconv.Atoi(token); err == nil {
+			b.WriteString("[" + token + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(token)
+	}
+	return b.String()
+}
+
 func createPostRenderer(rm meta.RESTMapper, kubeClient kube.Interface, owner Object) postrender.PostRenderer {
 	return &ownerPostRenderer{rm, kubeClient, owner}
 }
@@ -320,6 +709,12 @@ func (pr *ownerPostRenderer) Run(in *bytes.Buffer) (*bytes.Buffer, error) {
 			return err
 		}
 		u := &unstructured.Unstructured{Object: objMap}
+		l := u.GetLabels()
+		if l == nil {
+			l = map[string]string{}
+		}
+		l[ReleaseResourceLabel] = "true"
+		u.SetLabels(l)
 		useOwnerRef, err := controllerutil.SupportsOwnerReference(pr.rm, pr.owner, u)
 		if err != nil {
 			return err
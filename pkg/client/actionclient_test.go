@@ -36,6 +36,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	apitypes "k8s.io/apimachinery/pkg/types"
@@ -44,6 +45,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/yaml"
 
+	"github.com/joelanford/helm-operator/pkg/diff"
 	"github.com/joelanford/helm-operator/pkg/internal/testutil"
 )
 
@@ -143,13 +145,17 @@ var _ = Describe("ActionClient", func() {
 					})
 					verifyRelease(cl, obj.GetNamespace(), rel)
 				})
-				It("should uninstall a failed install", func() {
+				It("should return a failed install", func() {
 					By("failing to install the release", func() {
 						chrt := testutil.MustLoadChart("../../testdata/test-chart-0.1.0.tgz")
 						chrt.Templates[2].Data = append(chrt.Templates[2].Data, []byte("\ngibberish")...)
 						r, err := ac.Install(obj.GetName(), obj.GetNamespace(), &chrt, vals)
 						Expect(err).NotTo(BeNil())
 						Expect(r).To(BeNil())
+
+						var failed *FailedReleaseError
+						Expect(errors.As(err, &failed)).To(BeTrue())
+						Expect(failed.Release.Name).To(Equal(obj.GetName()))
 					})
 					verifyNoRelease(cl, obj.GetNamespace(), obj.GetName(), nil)
 				})
@@ -176,6 +182,11 @@ var _ = Describe("ActionClient", func() {
 					Expect(resp).To(BeNil())
 				})
 			})
+			var _ = Describe("PruneHistory", func() {
+				It("should return no records", func() {
+					Expect(ac.PruneHistory(obj.GetName(), 1)).To(Equal(0))
+				})
+			})
 		})
 
 		When("release is installed", func() {
@@ -255,12 +266,42 @@ var _ = Describe("ActionClient", func() {
 					})
 					verifyRelease(cl, obj.GetNamespace(), rel)
 				})
-				It("should rollback a failed upgrade", func() {
-					By("failing to install the release", func() {
+				It("should keep a failed upgrade", func() {
+					By("failing to upgrade the release", func() {
 						vals = chartutil.Values{"service": map[string]interface{}{"type": "ClusterIP"}}
 						r, err := ac.Upgrade(obj.GetName(), obj.GetNamespace(), &chrt, vals)
 						Expect(err).NotTo(BeNil())
 						Expect(r).To(BeNil())
+
+						var failed *FailedReleaseError
+						Expect(errors.As(err, &failed)).To(BeTrue())
+						Expect(failed.Release.Version).To(Equal(installedRelease.Version + 1))
+					})
+					By("getting the failed release", func() {
+						rel, err := ac.Get(obj.GetName())
+						Expect(err).To(BeNil())
+						Expect(rel.Version).To(Equal(installedRelease.Version + 1))
+						Expect(rel.Info.Status).To(Equal(release.StatusFailed))
+					})
+				})
+				When("using an option function that returns an error", func() {
+					It("should fail", func() {
+						opt := func(*action.Upgrade) error { return errors.New("expect this error") }
+						r, err := ac.Upgrade(obj.GetName(), obj.GetNamespace(), &chrt, vals, opt)
+						Expect(err).To(MatchError("expect this error"))
+						Expect(r).To(BeNil())
+					})
+				})
+			})
+			var _ = Describe("Rollback", func() {
+				It("should succeed", func() {
+					By("upgrading the release", func() {
+						_, err := ac.Upgrade(obj.GetName(), obj.GetNamespace(), &chrt, vals)
+						Expect(err).To(BeNil())
+					})
+					By("rolling back the release", func() {
+						opt := func(r *action.Rollback) error { r.Version = installedRelease.Version; return nil }
+						Expect(ac.Rollback(obj.GetName(), opt)).To(Succeed())
 					})
 					tmp := *installedRelease
 					rollbackRelease := &tmp
@@ -269,13 +310,53 @@ var _ = Describe("ActionClient", func() {
 				})
 				When("using an option function that returns an error", func() {
 					It("should fail", func() {
-						opt := func(*action.Upgrade) error { return errors.New("expect this error") }
-						r, err := ac.Upgrade(obj.GetName(), obj.GetNamespace(), &chrt, vals, opt)
+						opt := func(*action.Rollback) error { return errors.New("expect this error") }
+						Expect(ac.Rollback(obj.GetName(), opt)).To(MatchError("expect this error"))
+					})
+				})
+			})
+			var _ = Describe("History", func() {
+				It("should succeed", func() {
+					By("upgrading the release", func() {
+						_, err := ac.Upgrade(obj.GetName(), obj.GetNamespace(), &chrt, vals)
+						Expect(err).To(BeNil())
+					})
+					By("getting the release history", func() {
+						rels, err := ac.History(obj.GetName())
+						Expect(err).To(BeNil())
+						Expect(rels).To(HaveLen(2))
+					})
+				})
+				When("using an option function that returns an error", func() {
+					It("should fail", func() {
+						opt := func(*action.History) error { return errors.New("expect this error") }
+						rels, err := ac.History(obj.GetName(), opt)
 						Expect(err).To(MatchError("expect this error"))
-						Expect(r).To(BeNil())
+						Expect(rels).To(BeNil())
 					})
 				})
 			})
+			var _ = Describe("PruneHistory", func() {
+				BeforeEach(func() {
+					for i := 0; i < 2; i++ {
+						_, err := ac.Upgrade(obj.GetName(), obj.GetNamespace(), &chrt, vals)
+						Expect(err).To(BeNil())
+					}
+				})
+				It("should delete the oldest records", func() {
+					Expect(ac.PruneHistory(obj.GetName(), 2)).To(Equal(2))
+
+					rels, err := ac.History(obj.GetName())
+					Expect(err).To(BeNil())
+					Expect(rels).To(HaveLen(2))
+					for _, rel := range rels {
+						Expect(rel.Version).To(BeNumerically(">", installedRelease.Version))
+					}
+				})
+				It("should not delete records when max is not positive", func() {
+					Expect(ac.PruneHistory(obj.GetName(), 0)).To(Equal(3))
+				})
+			})
 			var _ = Describe("Uninstall", func() {
 				It("should succeed", func() {
 					var (
@@ -302,8 +383,9 @@ var _ = Describe("ActionClient", func() {
 			var _ = Describe("Reconcile", func() {
 				It("should succeed", func() {
 					By("reconciling the release", func() {
-						err := ac.Reconcile(installedRelease)
+						drifts, err := ac.Reconcile(installedRelease)
 						Expect(err).To(BeNil())
+						Expect(drifts).To(BeEmpty())
 					})
 					verifyRelease(cl, obj.GetNamespace(), installedRelease)
 				})
@@ -316,11 +398,40 @@ var _ = Describe("ActionClient", func() {
 						}
 					})
 					By("reconciling the release", func() {
-						err := ac.Reconcile(installedRelease)
+						drifts, err := ac.Reconcile(installedRelease)
 						Expect(err).To(BeNil())
+						Expect(drifts).NotTo(BeEmpty())
+						for _, d := range drifts {
+							Expect(d.Missing).To(BeTrue())
+						}
 					})
 					verifyRelease(cl, obj.GetNamespace(), installedRelease)
 				})
+				It("should only report deleted resources in dry-run mode", func() {
+					objs := manifestToObjects(installedRelease.Manifest)
+					By("deleting the manifest resources", func() {
+						for _, obj := range objs {
+							err := cl.Delete(context.TODO(), obj)
+							Expect(err).To(BeNil())
+						}
+					})
+					By("reconciling the release in dry-run mode", func() {
+						opt := func(o *ReconcileOptions) error { o.DryRun = true; return nil }
+						drifts, err := ac.Reconcile(installedRelease, opt)
+						Expect(err).To(BeNil())
+						Expect(drifts).To(HaveLen(len(objs)))
+					})
+					By("verifying the resources were not re-created", func() {
+						for _, obj := range objs {
+							key, err := client.ObjectKeyFromObject(obj)
+							Expect(err).To(BeNil())
+							u := &unstructured.Unstructured{}
+							u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+							err = cl.Get(context.TODO(), key, u)
+							Expect(apierrors.IsNotFound(err)).To(BeTrue())
+						}
+					})
+				})
 				It("should patch changed resources", func() {
 					By("changing manifest resources", func() {
 						objs := manifestToObjects(installedRelease.Manifest)
@@ -342,11 +453,145 @@ var _ = Describe("ActionClient", func() {
 						}
 					})
 					By("reconciling the release", func() {
-						err := ac.Reconcile(installedRelease)
+						drifts, err := ac.Reconcile(installedRelease)
 						Expect(err).To(BeNil())
+						Expect(drifts).NotTo(BeEmpty())
+						for _, d := range drifts {
+							Expect(d.Fields).To(ContainElement("metadata.labels.app.kubernetes.io/managed-by"))
+						}
 					})
 					verifyRelease(cl, obj.GetNamespace(), installedRelease)
 				})
+				It("should read resources of cached kinds from the cache", func() {
+					cache := &testObjectCache{reader: cl, mutate: func(u *unstructured.Unstructured) {
+						labels := u.GetLabels()
+						labels["app.kubernetes.io/managed-by"] = "Unmanaged"
+						u.SetLabels(labels)
+					}}
+					opt := func(o *ReconcileOptions) error { o.DryRun = true; o.Cache = cache; return nil }
+					drifts, err := ac.Reconcile(installedRelease, opt)
+					Expect(err).To(BeNil())
+					Expect(drifts).To(HaveLen(len(manifestToObjects(installedRelease.Manifest))))
+					for _, d := range drifts {
+						Expect(d.Fields).To(ContainElement("metadata.labels.app.kubernetes.io/managed-by"))
+					}
+				})
+				It("should read resources missing from the cache from the API server", func() {
+					cache := &testObjectCache{}
+					opt := func(o *ReconcileOptions) error { o.DryRun = true; o.Cache = cache; return nil }
+					drifts, err := ac.Reconcile(installedRelease, opt)
+					Expect(err).To(BeNil())
+					Expect(drifts).To(BeEmpty())
+				})
+				It("should not report or patch ignored fields", func() {
+					objs := manifestToObjects(installedRelease.Manifest)
+					By("changing manifest resources", func() {
+						for _, obj := range objs {
+							key, err := client.ObjectKeyFromObject(obj)
+							Expect(err).To(BeNil())
+
+							u := &unstructured.Unstructured{}
+							u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+							Expect(cl.Get(context.TODO(), key, u)).To(Succeed())
+							labels := u.GetLabels()
+							labels["app.kubernetes.io/managed-by"] = "Unmanaged"
+							u.SetLabels(labels)
+							Expect(cl.Update(context.TODO(), u)).To(Succeed())
+						}
+					})
+					By("reconciling the release while ignoring the changed field", func() {
+						var rules diff.IgnoreRules
+						for _, obj := range objs {
+							gvk := obj.GetObjectKind().GroupVersionKind()
+							rules = append(rules, diff.IgnoreRule{
+								Group:        gvk.Group,
+								Kind:         gvk.Kind,
+								JSONPointers: []string{"/metadata/labels/app.kubernetes.io~1managed-by"},
+							})
+						}
+						opt := func(o *ReconcileOptions) error { o.IgnoreDifferences = rules; return nil }
+						drifts, err := ac.Reconcile(installedRelease, opt)
+						Expect(err).To(BeNil())
+						Expect(drifts).To(BeEmpty())
+					})
+					By("verifying the changed fields were not patched", func() {
+						for _, obj := range objs {
+							key, err := client.ObjectKeyFromObject(obj)
+							Expect(err).To(BeNil())
+
+							u := &unstructured.Unstructured{}
+							u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+							Expect(cl.Get(context.TODO(), key, u)).To(Succeed())
+							Expect(u.GetLabels()).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "Unmanaged"))
+						}
+					})
+				})
+				It("should re-create deleted resources with server-side apply", func() {
+					By("deleting the manifest resources", func() {
+						objs := manifestToObjects(installedRelease.Manifest)
+						for _, obj := range objs {
+							err := cl.Delete(context.TODO(), obj)
+							Expect(err).To(BeNil())
+						}
+					})
+					By("reconciling the release with server-side apply", func() {
+						opt := func(o *ReconcileOptions) error { o.ServerSideApply = true; return nil }
+						drifts, err := ac.Reconcile(installedRelease, opt)
+						Expect(err).To(BeNil())
+						Expect(drifts).NotTo(BeEmpty())
+						for _, d := range drifts {
+							Expect(d.Missing).To(BeTrue())
+							Expect(d.Conflicts).To(BeEmpty())
+						}
+					})
+					verifyRelease(cl, obj.GetNamespace(), installedRelease)
+				})
+				It("should report conflicts instead of overwriting fields of other managers", func() {
+					objs := manifestToObjects(installedRelease.Manifest)
+					By("changing manifest resources", func() {
+						for _, obj := range objs {
+							key, err := client.ObjectKeyFromObject(obj)
+							Expect(err).To(BeNil())
+
+							u := &unstructured.Unstructured{}
+							u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+							Expect(cl.Get(context.TODO(), key, u)).To(Succeed())
+							labels := u.GetLabels()
+							labels["app.kubernetes.io/managed-by"] = "Unmanaged"
+							u.SetLabels(labels)
+							Expect(cl.Update(context.TODO(), u)).To(Succeed())
+						}
+					})
+					By("reconciling the release with server-side apply", func() {
+						opt := func(o *ReconcileOptions) error { o.ServerSideApply = true; return nil }
+						drifts, err := ac.Reconcile(installedRelease, opt)
+						Expect(err).To(BeNil())
+						Expect(drifts).To(HaveLen(len(objs)))
+						for _, d := range drifts {
+							Expect(d.Fields).To(ContainElement("metadata.labels.app.kubernetes.io/managed-by"))
+							Expect(d.Conflicts).To(ContainElement(HavePrefix("metadata.labels.app.kubernetes.io/managed-by: ")))
+						}
+					})
+					By("verifying the changed fields were not overwritten", func() {
+						for _, obj := range objs {
+							key, err := client.ObjectKeyFromObject(obj)
+							Expect(err).To(BeNil())
+
+							u := &unstructured.Unstructured{}
+							u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+							Expect(cl.Get(context.TODO(), key, u)).To(Succeed())
+							Expect(u.GetLabels()).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "Unmanaged"))
+						}
+					})
+				})
+				When("using an option function that returns an error", func() {
+					It("should fail", func() {
+						opt := func(*ReconcileOptions) error { return errors.New("expect this error") }
+						drifts, err := ac.Reconcile(installedRelease, opt)
+						Expect(err).To(MatchError("expect this error"))
+						Expect(drifts).To(BeNil())
+					})
+				})
 			})
 		})
 	})
@@ -518,6 +763,42 @@ var _ = Describe("ActionClient", func() {
 		})
 	})
 
+	var _ = Describe("applyConflicts", func() {
+		It("lists field manager conflicts", func() {
+			err := apierrors.NewApplyConflict([]metav1.StatusCause{
+				{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.replicas", Message: `conflict with "kube-controller-manager" using apps/v1`},
+				{Type: metav1.CauseTypeFieldManagerConflict, Field: ".metadata.labels.app", Message: `conflict with "kubectl-edit" using apps/v1`},
+			}, "Apply failed with 2 conflicts")
+			Expect(applyConflicts(err)).To(Equal([]string{
+				`metadata.labels.app: conflict with "kubectl-edit" using apps/v1`,
+				`spec.replicas: conflict with "kube-controller-manager" using apps/v1`,
+			}))
+		})
+		It("ignores other errors", func() {
+			Expect(applyConflicts(errors.New("not a conflict"))).To(BeEmpty())
+			Expect(applyConflicts(apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "foo", errors.New("stale")))).To(BeEmpty())
+		})
+	})
+
+	var _ = Describe("patchFields", func() {
+		It("lists the fields of a strategic merge patch", func() {
+			patch := []byte(`{"metadata":{"labels":{"app":"foo"}},"spec":{"replicas":3,"template":{"spec":{"$setElementOrder/containers":[{"name":"c"}],"containers":[{"image":"bar","name":"c"}]}}}}`)
+			fields, err := patchFields(patch, apitypes.StrategicMergePatchType)
+			Expect(err).To(BeNil())
+			Expect(fields).To(Equal([]string{"metadata.labels.app", "spec.replicas", "spec.template.spec.containers"}))
+		})
+		It("lists the fields of a JSON patch", func() {
+			patch := []byte(`[{"op":"replace","path":"/spec/ports/0/port","value":80},{"op":"add","path":"/metadata/annotations/example.com~1foo","value":"bar"}]`)
+			fields, err := patchFields(patch, apitypes.JSONPatchType)
+			Expect(err).To(BeNil())
+			Expect(fields).To(Equal([]string{"metadata.annotations.example.com/foo", "spec.ports[0].port"}))
+		})
+		It("fails on an invalid patch", func() {
+			_, err := patchFields([]byte(`{`), apitypes.JSONPatchType)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
 	var _ = Describe("ownerPostRenderer", func() {
 		var (
 			pr    ownerPostRenderer
@@ -536,7 +817,7 @@ var _ = Describe("ActionClient", func() {
 			pr = ownerPostRenderer{
 				owner:      owner,
 				rm:         rm,
-				kubeClient: kube.New(newRESTClientGetter(cfg, rm, owner.GetNamespace())),
+				kubeClient: kube.New(newRESTClientGetter(cfg, rm, newSharedDiscoveryClient(cfg), owner.GetNamespace())),
 			}
 		})
 
@@ -544,9 +825,71 @@ var _ = Describe("ActionClient", func() {
 			_, err := pr.Run(bytes.NewBufferString("test"))
 			Expect(err).NotTo(BeNil())
 		})
+
+		It("labels release resources", func() {
+			out, err := pr.Run(bytes.NewBufferString(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cm
+  namespace: ` + owner.GetNamespace() + `
+  labels:
+    app: test
+`))
+			Expect(err).To(BeNil())
+			objs := manifestToObjects(out.String())
+			Expect(objs).To(HaveLen(1))
+			u := objs[0].(*unstructured.Unstructured)
+			Expect(u.GetLabels()).To(Equal(map[string]string{"app": "test", ReleaseResourceLabel: "true"}))
+			Expect(ReleaseResourceSelector.Matches(labels.Set(u.GetLabels()))).To(BeTrue())
+		})
+	})
+
+	var _ = Describe("ManifestsEqual", func() {
+		const (
+			unlabeled = "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test-cm\n"
+			labeled   = "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  labels:\n    helm.operator-sdk/release-resource: \"true\"\n  name: test-cm\n"
+			changed   = "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  labels:\n    helm.operator-sdk/release-resource: \"true\"\n  name: other-cm\n"
+		)
+
+		It("ignores the release resource label", func() {
+			Expect(ManifestsEqual(labeled, unlabeled)).To(BeTrue())
+			Expect(ManifestsEqual(unlabeled+labeled, labeled+labeled)).To(BeTrue())
+		})
+		It("detects changed resources", func() {
+			Expect(ManifestsEqual(changed, unlabeled)).To(BeFalse())
+			Expect(ManifestsEqual(labeled+labeled, labeled)).To(BeFalse())
+		})
+		It("does not equate invalid manifests", func() {
+			Expect(ManifestsEqual("---\n{", labeled)).To(BeFalse())
+		})
 	})
 })
 
+// testObjectCache is an ObjectCache of all kinds that reads objects from
+// reader and changes them with mutate. Without a reader, no objects are
+// found.
+type testObjectCache struct {
+	reader client.Reader
+	mutate func(*unstructured.Unstructured)
+}
+
+func (c *testObjectCache) Get(ctx context.Context, key apitypes.NamespacedName, obj runtime.Object) error {
+	u := obj.(*unstructured.Unstructured)
+	if c.reader == nil {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: u.GetKind()}, key.Name)
+	}
+	if err := c.reader.Get(ctx, key, u); err != nil {
+		return err
+	}
+	c.mutate(u)
+	return nil
+}
+
+func (c *testObjectCache) Caches(schema.GroupVersionKind) bool {
+	return true
+}
+
 func manifestToObjects(manifest string) []runtime.Object {
 	objs := []runtime.Object{}
 	for _, m := range releaseutil.SplitManifests(manifest) {
@@ -573,7 +916,7 @@ func verifyRelease(cl client.Client, ns string, rel *release.Release) {
 		Expect(rel.Info.Description).To(Equal(mockTestDesc))
 	})
 
-	By("verifying the release resources exist", func() {
+	By("verifying the release resources exist and are labeled", func() {
 		objs := manifestToObjects(rel.Manifest)
 		for _, obj := range objs {
 			key, err := client.ObjectKeyFromObject(obj)
@@ -581,6 +924,7 @@ func verifyRelease(cl client.Client, ns string, rel *release.Release) {
 
 			err = cl.Get(context.TODO(), key, obj)
 			Expect(err).To(BeNil())
+			Expect(obj.(*unstructured.Unstructured).GetLabels()).To(HaveKeyWithValue(ReleaseResourceLabel, "true"))
 		}
 	})
 }
@@ -17,23 +17,28 @@ limitations under the License.
 package client
 
 import (
-	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/kube"
 	"helm.sh/helm/v3/pkg/storage"
-	"helm.sh/helm/v3/pkg/storage/driver"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
+// namespaceClientsIdleTimeout is the time after which the cached clients of a
+// namespace are dropped if no action configuration was requested for the
+// namespace, e.g. because the custom resources in it were deleted.
+const namespaceClientsIdleTimeout = 30 * time.Minute
+
 type Object interface {
 	runtime.Object
 	metav1.Object
@@ -43,25 +48,116 @@ type ActionConfigGetter interface {
 	ActionConfigFor(obj Object) (*action.Configuration, error)
 }
 
-func NewActionConfigGetter(cfg *rest.Config, rm meta.RESTMapper, log logr.Logger) ActionConfigGetter {
-	return &actionConfigGetter{
-		cfg:        cfg,
-		restMapper: rm,
-		log:        log,
+// ObjectToStringMapper maps an object to a string, such as the name or
+// namespace of the release that the object defines.
+type ObjectToStringMapper func(Object) (string, error)
+
+// ActionConfigGetterOption configures optional behavior of the
+// ActionConfigGetter returned by NewActionConfigGetter.
+type ActionConfigGetterOption func(*actionConfigGetter)
+
+// WithNamespaceMapper configures the ActionConfigGetter to use the namespace
+// returned by m for an object's release resources and release storage. By
+// default, the namespace of the object is used.
+func WithNamespaceMapper(m ObjectToStringMapper) ActionConfigGetterOption {
+	return func(acg *actionConfigGetter) {
+		acg.namespaceMapper = m
+	}
+}
+
+// WithStorageDriver configures the ActionConfigGetter to store Helm release
+// records using the drivers returned by g. By default, release records are
+// stored in Secrets in the release namespace.
+func WithStorageDriver(g StorageDriverGetter) ActionConfigGetterOption {
+	return func(acg *actionConfigGetter) {
+		acg.storageDriverGetter = g
+	}
+}
+
+// NewActionConfigGetter returns an ActionConfigGetter for the cluster of cfg.
+//
+// The REST client getter, Kubernetes clients and discovery information used
+// by the action configurations are cached and shared by all objects whose
+// releases are in the same namespace. The clients of a namespace are dropped
+// when they have not been used for a while. The Kubernetes clientsets used to
+// store release records share the rate limiter of cfg, or one with the QPS
+// and burst of cfg. The discovery information is fetched again when rm maps
+// an API that it does not contain, e.g. of a newly installed CRD.
+func NewActionConfigGetter(cfg *rest.Config, rm meta.RESTMapper, log logr.Logger, opts ...ActionConfigGetterOption) ActionConfigGetter {
+	dc := newSharedDiscoveryClient(cfg)
+	acg := &actionConfigGetter{
+		cfg:                 cfg,
+		restMapper:          &apiObservingRESTMapper{RESTMapper: rm, discovery: dc},
+		discovery:           dc,
+		rateLimiter:         newRateLimiter(cfg),
+		log:                 log,
+		storageDriverGetter: SecretsStorageDriver(),
+		now:                 time.Now,
+		namespaces:          map[string]*namespaceClients{},
 	}
+	for _, o := range opts {
+		o(acg)
+	}
+	return acg
 }
 
 var _ ActionConfigGetter = &actionConfigGetter{}
 
 type actionConfigGetter struct {
-	cfg        *rest.Config
-	restMapper meta.RESTMapper
-	log        logr.Logger
+	cfg             *rest.Config
+	restMapper      meta.RESTMapper
+	log             logr.Logger
+	namespaceMapper ObjectToStringMapper
+
+	storageDriverGetter StorageDriverGetter
+
+	discovery   *sharedDiscoveryClient
+	rateLimiter flowcontrol.RateLimiter
+	now         func() time.Time
+	mu          sync.Mutex
+	namespaces  map[string]*namespaceClients
+}
+
+// newRateLimiter returns the rate limiter of cfg. If cfg has none, it returns
+// a rate limiter with the QPS and burst of cfg, or nil if cfg disables rate
+// limiting with a negative QPS.
+func newRateLimiter(cfg *rest.Config) flowcontrol.RateLimiter {
+	if cfg.RateLimiter != nil {
+		return cfg.RateLimiter
+	}
+	if cfg.QPS < 0 {
+		return nil
+	}
+	qps, burst := cfg.QPS, cfg.Burst
+	if qps == 0 {
+		qps = rest.DefaultQPS
+	}
+	if burst == 0 {
+		burst = rest.DefaultBurst
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+}
+
+// namespaceClients are the clients used by the action configurations of a
+// namespace. They are created again when the discovery information changes,
+// since the Kubernetes client caches the OpenAPI schema used to validate
+// release manifests.
+type namespaceClients struct {
+	generation int64
+	lastUsed   time.Time
+	rcg        genericclioptions.RESTClientGetter
+	kubeClient *kube.Client
+	clientSet  *kubernetes.Clientset
 }
 
 func (acg *actionConfigGetter) ActionConfigFor(obj Object) (*action.Configuration, error) {
-	// Create a RESTClientGetter
-	rcg := newRESTClientGetter(acg.cfg, acg.restMapper, obj.GetNamespace())
+	ns := obj.GetNamespace()
+	if acg.namespaceMapper != nil {
+		var err error
+		if ns, err = acg.namespaceMapper(obj); err != nil {
+			return nil, fmt.Errorf("get release namespace: %w", err)
+		}
+	}
 
 	// Setup the debug log function that Helm will use
 	debugLog := func(format string, v ...interface{}) {
@@ -70,53 +166,85 @@ func (acg *actionConfigGetter) ActionConfigFor(obj Object) (*action.Configuratio
 		}
 	}
 
-	// Create a client that helm will use to manage release resources.
-	// The passed object is used as an owner reference on every
-	// object the client creates.
-	kc := kube.New(rcg)
-	kc.Log = debugLog
-
-	// Create the Kubernetes Secrets client. The passed object is
-	// also used as an owner reference in the release secrets
-	// created by this client.
-	kcs, err := cmdutil.NewFactory(rcg).KubernetesClientSet()
+	nc, err := acg.namespaceClients(ns, debugLog)
 	if err != nil {
 		return nil, err
 	}
 
-	ownerRef := metav1.NewControllerRef(obj, obj.GetObjectKind().GroupVersionKind())
-	d := driver.NewSecrets(&ownerRefSecretClient{
-		SecretInterface: kcs.CoreV1().Secrets(obj.GetNamespace()),
-		refs:            []metav1.OwnerReference{*ownerRef},
-	})
+	// The passed object is used as an owner of the release records.
+	// Owner references across namespaces are not allowed, so the release
+	// records only get an owner reference when the object is cluster-scoped
+	// or lives in the release namespace.
+	var refs []metav1.OwnerReference
+	if obj.GetNamespace() == "" || obj.GetNamespace() == ns {
+		refs = append(refs, *metav1.NewControllerRef(obj, obj.GetObjectKind().GroupVersionKind()))
+	}
 
-	// Also, use the debug log for the storage driver
-	d.Log = debugLog
+	// Create the storage driver, which also uses the debug log
+	d, err := acg.storageDriverGetter(nc.clientSet, ns, refs, debugLog)
+	if err != nil {
+		return nil, fmt.Errorf("get storage driver: %w", err)
+	}
 
 	// Initialize the storage backend
 	s := storage.Init(d)
 
 	return &action.Configuration{
-		RESTClientGetter: rcg,
+		RESTClientGetter: nc.rcg,
 		Releases:         s,
-		KubeClient:       kc,
+		KubeClient:       nc.kubeClient,
 		Log:              debugLog,
 	}, nil
 }
 
-var _ v1.SecretInterface = &ownerRefSecretClient{}
+// namespaceClients returns the cached clients of namespace ns, creating them
+// if they do not exist or the discovery information has changed. It drops the
+// clients of namespaces that have been idle for namespaceClientsIdleTimeout.
+func (acg *actionConfigGetter) namespaceClients(ns string, log func(string, ...interface{})) (*namespaceClients, error) {
+	generation := acg.discovery.generation()
+
+	acg.mu.Lock()
+	defer acg.mu.Unlock()
+	now := acg.now()
+	for k, nc := range acg.namespaces {
+		if now.Sub(nc.lastUsed) > namespaceClientsIdleTimeout {
+			delete(acg.namespaces, k)
+		}
+	}
+	if nc, ok := acg.namespaces[ns]; ok && nc.generation == generation {
+		nc.lastUsed = now
+		return nc, nil
+	}
+
+	// Create a client that helm will use to manage release resources.
+	rcg := newRESTClientGetter(acg.cfg, acg.restMapper, acg.discovery, ns)
+	kc := kube.New(rcg)
+	kc.Log = log
+
+	// Create the Kubernetes clientset used by storage drivers that
+	// store release records in Kubernetes objects. Helm's client creates
+	// a new clientset whenever it needs one, so it shares this one instead.
+	// The clientsets of all namespaces share one rate limiter.
+	csCfg := rest.CopyConfig(acg.cfg)
+	csCfg.RateLimiter = acg.rateLimiter
+	kcs, err := kubernetes.NewForConfig(csCfg)
+	if err != nil {
+		return nil, err
+	}
+	kc.Factory = &clientSetFactory{Factory: kc.Factory, clientSet: kcs}
 
-type ownerRefSecretClient struct {
-	v1.SecretInterface
-	refs []metav1.OwnerReference
+	nc := &namespaceClients{generation: generation, lastUsed: now, rcg: rcg, kubeClient: kc, clientSet: kcs}
+	acg.namespaces[ns] = nc
+	return nc, nil
 }
 
-func (c *ownerRefSecretClient) Create(ctx context.Context, in *corev1.Secret, opts metav1.CreateOptions) (*corev1.Secret, error) {
-	in.OwnerReferences = append(in.OwnerReferences, c.refs...)
-	return c.SecretInterface.Create(ctx, in, opts)
+// clientSetFactory is a kube.Factory that returns the same clientset every
+// time.
+type clientSetFactory struct {
+	kube.Factory
+	clientSet *kubernetes.Clientset
 }
 
-func (c *ownerRefSecretClient) Update(ctx context.Context, in *corev1.Secret, opts metav1.UpdateOptions) (*corev1.Secret, error) {
-	in.OwnerReferences = append(in.OwnerReferences, c.refs...)
-	return c.SecretInterface.Update(ctx, in, opts)
+func (f *clientSetFactory) KubernetesClientSet() (*kubernetes.Clientset, error) {
+	return f.clientSet, nil
 }
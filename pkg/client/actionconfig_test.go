@@ -17,8 +17,27 @@ limitations under the License.
 package client
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 
 	"github.com/joelanford/helm-operator/pkg/internal/testutil"
@@ -45,5 +64,277 @@ var _ = Describe("ActionConfig", func() {
 			Expect(err).To(BeNil())
 			Expect(ac).NotTo(BeNil())
 		})
+		It("should use the namespace from the namespace mapper", func() {
+			rm, err := apiutil.NewDiscoveryRESTMapper(cfg)
+			Expect(err).To(BeNil())
+
+			acg := NewActionConfigGetter(cfg, rm, nil, WithNamespaceMapper(func(Object) (string, error) {
+				return "other-namespace", nil
+			}))
+			ac, err := acg.ActionConfigFor(obj)
+			Expect(err).To(BeNil())
+			Expect(ac).NotTo(BeNil())
+
+			rcg, ok := ac.RESTClientGetter.(genericclioptions.RESTClientGetter)
+			Expect(ok).To(BeTrue())
+			ns, _, err := rcg.ToRawKubeConfigLoader().Namespace()
+			Expect(err).To(BeNil())
+			Expect(ns).To(Equal("other-namespace"))
+		})
+		It("should share the clients of a namespace", func() {
+			rm, err := apiutil.NewDiscoveryRESTMapper(cfg)
+			Expect(err).To(BeNil())
+
+			acg := NewActionConfigGetter(cfg, rm, nil)
+			ac1, err := acg.ActionConfigFor(obj)
+			Expect(err).To(BeNil())
+			ac2, err := acg.ActionConfigFor(obj)
+			Expect(err).To(BeNil())
+			Expect(ac2.KubeClient).To(BeIdenticalTo(ac1.KubeClient))
+			Expect(ac2.RESTClientGetter).To(BeIdenticalTo(ac1.RESTClientGetter))
+			Expect(ac2.Releases).NotTo(BeIdenticalTo(ac1.Releases))
+
+			other := testutil.BuildTestCR(gvk)
+			other.SetNamespace("other-namespace")
+			ac3, err := acg.ActionConfigFor(other)
+			Expect(err).To(BeNil())
+			Expect(ac3.KubeClient).NotTo(BeIdenticalTo(ac1.KubeClient))
+		})
+		It("should fail when the namespace mapper fails", func() {
+			acg := NewActionConfigGetter(cfg, nil, nil, WithNamespaceMapper(func(Object) (string, error) {
+				return "", errors.New("mapper error")
+			}))
+			_, err := acg.ActionConfigFor(obj)
+			Expect(err).To(MatchError(ContainSubstring("mapper error")))
+		})
+	})
+})
+
+var _ = Describe("refreshingDiscoveryClient", func() {
+	var (
+		srv      *httptest.Server
+		requests *int64
+		now      time.Time
+		shared   *sharedDiscoveryClient
+		dc       *refreshingDiscoveryClient
+		rm       *meta.DefaultRESTMapper
+	)
+
+	BeforeEach(func() {
+		srv, requests = newFakeAPIServer()
+		shared = newSharedDiscoveryClient(&rest.Config{Host: srv.URL})
+		var err error
+		dc, err = shared.get()
+		Expect(err).To(BeNil())
+		now = time.Now()
+		dc.now = func() time.Time { return now }
+
+		rm = meta.NewDefaultRESTMapper(nil)
+		rm.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+		rm.Add(gvk, meta.RESTScopeNamespace)
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	It("should ignore invalidations until the refresh interval has passed", func() {
+		_, err := dc.ServerGroups()
+		Expect(err).To(BeNil())
+		_, err = dc.ServerVersion()
+		Expect(err).To(BeNil())
+		fetched := atomic.LoadInt64(requests)
+
+		dc.Invalidate()
+		_, err = dc.ServerGroups()
+		Expect(err).To(BeNil())
+		_, err = dc.ServerVersion()
+		Expect(err).To(BeNil())
+		Expect(atomic.LoadInt64(requests)).To(Equal(fetched))
+		Expect(dc.generation()).To(BeEquivalentTo(0))
+
+		now = now.Add(discoveryRefreshInterval)
+		dc.Invalidate()
+		_, err = dc.ServerGroups()
+		Expect(err).To(BeNil())
+		Expect(atomic.LoadInt64(requests)).To(BeNumerically(">", fetched))
+		Expect(dc.generation()).To(BeEquivalentTo(1))
+	})
+
+	It("should be invalidated when a REST mapping of an unknown API is observed", func() {
+		m := &apiObservingRESTMapper{RESTMapper: rm, discovery: shared}
+		_, err := dc.ServerGroups()
+		Expect(err).To(BeNil())
+
+		_, err = m.RESTMapping(schema.GroupKind{Kind: "ConfigMap"}, "v1")
+		Expect(err).To(BeNil())
+		dc.Invalidate()
+		Expect(dc.generation()).To(BeEquivalentTo(0))
+
+		_, err = m.RESTMapping(gvk.GroupKind(), gvk.Version)
+		Expect(err).To(BeNil())
+		dc.Invalidate()
+		Expect(dc.generation()).To(BeEquivalentTo(1))
 	})
 })
+
+var _ = Describe("actionConfigGetter", func() {
+	var (
+		srv *httptest.Server
+		now time.Time
+		acg *actionConfigGetter
+		obj Object
+	)
+
+	BeforeEach(func() {
+		srv, _ = newFakeAPIServer()
+		rm := meta.NewDefaultRESTMapper(nil)
+		rm.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+		acg = NewActionConfigGetter(&rest.Config{Host: srv.URL}, rm, nil).(*actionConfigGetter)
+		now = time.Now()
+		acg.now = func() time.Time { return now }
+		obj = testutil.BuildTestCR(gvk)
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	clientSetOf := func(ac *action.Configuration) *kubernetes.Clientset {
+		kc, ok := ac.KubeClient.(*kube.Client)
+		Expect(ok).To(BeTrue())
+		cs, err := kc.Factory.KubernetesClientSet()
+		Expect(err).To(BeNil())
+		return cs
+	}
+
+	It("should share one rate limiter between the clientsets of all namespaces", func() {
+		ac1, err := acg.ActionConfigFor(obj)
+		Expect(err).To(BeNil())
+		other := testutil.BuildTestCR(gvk)
+		other.SetNamespace("other-namespace")
+		ac2, err := acg.ActionConfigFor(other)
+		Expect(err).To(BeNil())
+
+		cs1, cs2 := clientSetOf(ac1), clientSetOf(ac2)
+		Expect(cs2).NotTo(BeIdenticalTo(cs1))
+		Expect(cs1.CoreV1().RESTClient().GetRateLimiter()).NotTo(BeNil())
+		Expect(cs2.CoreV1().RESTClient().GetRateLimiter()).To(BeIdenticalTo(cs1.CoreV1().RESTClient().GetRateLimiter()))
+	})
+
+	It("should drop the clients of idle namespaces", func() {
+		ac1, err := acg.ActionConfigFor(obj)
+		Expect(err).To(BeNil())
+
+		now = now.Add(namespaceClientsIdleTimeout)
+		other := testutil.BuildTestCR(gvk)
+		other.SetNamespace("other-namespace")
+		_, err = acg.ActionConfigFor(other)
+		Expect(err).To(BeNil())
+		Expect(acg.namespaces).To(HaveLen(2))
+
+		now = now.Add(time.Second)
+		_, err = acg.ActionConfigFor(other)
+		Expect(err).To(BeNil())
+		Expect(acg.namespaces).To(HaveLen(1))
+		Expect(acg.namespaces).To(HaveKey("other-namespace"))
+
+		ac2, err := acg.ActionConfigFor(obj)
+		Expect(err).To(BeNil())
+		Expect(ac2.KubeClient).NotTo(BeIdenticalTo(ac1.KubeClient))
+	})
+})
+
+// BenchmarkActionConfigFor measures what every reconcile of a release does
+// with Helm: it gets an action configuration and renders the release in a
+// dry run. "Uncached" uses a new ActionConfigGetter for every reconcile, so
+// nothing is shared between reconciles.
+func BenchmarkActionConfigFor(b *testing.B) {
+	srv, requests := newFakeAPIServer()
+	defer srv.Close()
+	// The rate limit is raised so that it does not dominate the results.
+	restConfig := &rest.Config{Host: srv.URL, QPS: 1000, Burst: 1000}
+
+	rm := meta.NewDefaultRESTMapper(nil)
+	rm.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+
+	obj := testutil.BuildTestCR(gvk)
+	chrt := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: "v2", Name: "bench", Version: "0.1.0"},
+		Templates: []*chart.File{{
+			Name: "templates/configmap.yaml",
+			Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  key: value\n"),
+		}},
+	}
+
+	run := func(b *testing.B, getter func() ActionConfigGetter) {
+		atomic.StoreInt64(requests, 0)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ac, err := getter().ActionConfigFor(obj)
+			if err != nil {
+				b.Fatal(err)
+			}
+			install := action.NewInstall(ac)
+			install.ReleaseName = "bench"
+			install.Namespace = obj.GetNamespace()
+			install.DryRun = true
+			if _, err := install.Run(chrt, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(atomic.LoadInt64(requests))/float64(b.N), "requests/op")
+	}
+
+	b.Run("Uncached", func(b *testing.B) {
+		run(b, func() ActionConfigGetter { return NewActionConfigGetter(restConfig, rm, nil) })
+	})
+	b.Run("Cached", func(b *testing.B) {
+		acg := NewActionConfigGetter(restConfig, rm, nil)
+		run(b, func() ActionConfigGetter { return acg })
+	})
+}
+
+// newFakeAPIServer returns a server that serves the discovery information of
+// a cluster that only has the core API, an empty OpenAPI schema and no
+// Secrets or ConfigMaps. It counts the requests it serves.
+func newFakeAPIServer() (*httptest.Server, *int64) {
+	var requests int64
+	write := func(w http.ResponseWriter, code int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		switch r.URL.Path {
+		case "/version":
+			write(w, http.StatusOK, version.Info{GitVersion: "v1.18.2", Major: "1", Minor: "18"})
+		case "/api":
+			write(w, http.StatusOK, metav1.APIVersions{Versions: []string{"v1"}})
+		case "/apis":
+			write(w, http.StatusOK, metav1.APIGroupList{})
+		case "/api/v1":
+			write(w, http.StatusOK, metav1.APIResourceList{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: metav1.Verbs{"get", "list", "create"}},
+					{Name: "secrets", Namespaced: true, Kind: "Secret", Verbs: metav1.Verbs{"get", "list", "create"}},
+				},
+			})
+		case "/openapi/v2":
+			w.WriteHeader(http.StatusOK)
+		case "/api/v1/namespaces/default/secrets":
+			write(w, http.StatusOK, corev1.SecretList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "SecretList"}})
+		default:
+			write(w, http.StatusNotFound, metav1.Status{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+				Status:   metav1.StatusFailure,
+				Reason:   metav1.StatusReasonNotFound,
+				Code:     http.StatusNotFound,
+			})
+		}
+	}))
+	return srv, &requests
+}
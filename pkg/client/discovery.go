@@ -0,0 +1,179 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	cached "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/rest"
+)
+
+// discoveryRefreshInterval is the interval after which the shared discovery
+// information is fetched again, even if no new APIs have been detected.
+const discoveryRefreshInterval = 5 * time.Minute
+
+// sharedDiscoveryClient is a discovery client that is shared by the action
+// configurations of all namespaces. It is created on first use.
+type sharedDiscoveryClient struct {
+	cfg *rest.Config
+
+	once   sync.Once
+	client *refreshingDiscoveryClient
+	err    error
+}
+
+func newSharedDiscoveryClient(cfg *rest.Config) *sharedDiscoveryClient {
+	return &sharedDiscoveryClient{cfg: cfg}
+}
+
+func (s *sharedDiscoveryClient) get() (*refreshingDiscoveryClient, error) {
+	s.once.Do(func() {
+		var dc discovery.DiscoveryInterface
+		dc, s.err = discovery.NewDiscoveryClientForConfig(s.cfg)
+		if s.err != nil {
+			return
+		}
+		s.client = &refreshingDiscoveryClient{
+			CachedDiscoveryInterface: cached.NewMemCacheClient(dc),
+			now:                      time.Now,
+		}
+		s.client.refreshed = s.client.now()
+	})
+	return s.client, s.err
+}
+
+// generation returns the generation of the discovery information, or 0 if
+// the discovery client could not be created.
+func (s *sharedDiscoveryClient) generation() int64 {
+	dc, err := s.get()
+	if err != nil {
+		return 0
+	}
+	return dc.generation()
+}
+
+var _ discovery.CachedDiscoveryInterface = &refreshingDiscoveryClient{}
+
+// refreshingDiscoveryClient caches the discovery information and the server
+// version of the cluster. Helm invalidates the discovery client before every
+// install, upgrade and rollback to fetch the capabilities of the cluster.
+// Those invalidations are ignored unless the cached information is older
+// than discoveryRefreshInterval or a REST mapping of an API that it does not
+// contain has been observed.
+type refreshingDiscoveryClient struct {
+	discovery.CachedDiscoveryInterface
+	now func() time.Time
+
+	mu        sync.Mutex
+	refreshed time.Time
+	stale     bool
+	gen       int64
+	version   *version.Info
+}
+
+func (d *refreshingDiscoveryClient) Invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.stale && d.now().Before(d.refreshed.Add(discoveryRefreshInterval)) {
+		return
+	}
+	d.CachedDiscoveryInterface.Invalidate()
+	d.refreshed = d.now()
+	d.stale = false
+	d.gen++
+	d.version = nil
+}
+
+func (d *refreshingDiscoveryClient) ServerVersion() (*version.Info, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.version == nil {
+		v, err := d.CachedDiscoveryInterface.ServerVersion()
+		if err != nil {
+			return nil, err
+		}
+		d.version = v
+	}
+	return d.version, nil
+}
+
+// generation is incremented whenever the cached information is invalidated.
+func (d *refreshingDiscoveryClient) generation() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.gen
+}
+
+// observe marks the cached information as stale if it has been fetched and
+// does not contain gvk.
+func (d *refreshingDiscoveryClient) observe(gvk schema.GroupVersionKind) {
+	if !d.Fresh() {
+		return
+	}
+	resources, err := d.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if err == nil {
+		for _, r := range resources.APIResources {
+			if r.Kind == gvk.Kind {
+				return
+			}
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stale = true
+}
+
+// apiObservingRESTMapper passes the REST mappings of a RESTMapper to the
+// shared discovery client, so that the discovery information is fetched
+// again once the RESTMapper has detected new APIs, e.g. of newly installed
+// CRDs.
+type apiObservingRESTMapper struct {
+	meta.RESTMapper
+	discovery *sharedDiscoveryClient
+}
+
+func (m *apiObservingRESTMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	mapping, err := m.RESTMapper.RESTMapping(gk, versions...)
+	if err == nil {
+		m.observe(mapping)
+	}
+	return mapping, err
+}
+
+func (m *apiObservingRESTMapper) RESTMappings(gk schema.GroupKind, versions ...string) ([]*meta.RESTMapping, error) {
+	mappings, err := m.RESTMapper.RESTMappings(gk, versions...)
+	if err == nil {
+		for _, mapping := range mappings {
+			m.observe(mapping)
+		}
+	}
+	return mappings, err
+}
+
+func (m *apiObservingRESTMapper) observe(mapping *meta.RESTMapping) {
+	dc, err := m.discovery.get()
+	if err != nil {
+		return
+	}
+	dc.observe(mapping.GroupVersionKind)
+}
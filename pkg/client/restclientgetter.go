@@ -17,12 +17,9 @@ limitations under the License.
 package client
 
 import (
-	"sync"
-
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/discovery"
-	cached "k8s.io/client-go/discovery/cached"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -30,10 +27,11 @@ import (
 
 var _ genericclioptions.RESTClientGetter = &restClientGetter{}
 
-func newRESTClientGetter(cfg *rest.Config, rm meta.RESTMapper, ns string) genericclioptions.RESTClientGetter {
+func newRESTClientGetter(cfg *rest.Config, rm meta.RESTMapper, dc *sharedDiscoveryClient, ns string) genericclioptions.RESTClientGetter {
 	return &restClientGetter{
 		restConfig:      cfg,
 		restMapper:      rm,
+		discoveryClient: dc,
 		namespaceConfig: &namespaceClientConfig{ns},
 	}
 }
@@ -41,10 +39,8 @@ func newRESTClientGetter(cfg *rest.Config, rm meta.RESTMapper, ns string) generi
 type restClientGetter struct {
 	restConfig      *rest.Config
 	restMapper      meta.RESTMapper
+	discoveryClient *sharedDiscoveryClient
 	namespaceConfig clientcmd.ClientConfig
-
-	setupDiscoveryClient  sync.Once
-	cachedDiscoveryClient discovery.CachedDiscoveryInterface
 }
 
 func (c *restClientGetter) ToRESTConfig() (*rest.Config, error) {
@@ -52,21 +48,11 @@ func (c *restClientGetter) ToRESTConfig() (*rest.Config, error) {
 }
 
 func (c *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
-	var (
-		dc  discovery.DiscoveryInterface
-		err error
-	)
-	c.setupDiscoveryClient.Do(func() {
-		dc, err = discovery.NewDiscoveryClientForConfig(c.restConfig)
-		if err != nil {
-			return
-		}
-		c.cachedDiscoveryClient = cached.NewMemCacheClient(dc)
-	})
+	dc, err := c.discoveryClient.get()
 	if err != nil {
 		return nil, err
 	}
-	return c.cachedDiscoveryClient, nil
+	return dc, nil
 }
 
 func (c *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
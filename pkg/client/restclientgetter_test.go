@@ -34,9 +34,10 @@ var _ = Describe("restClientGetter", func() {
 
 	When("the config is invalid", func() {
 		BeforeEach(func() {
-			rcg = newRESTClientGetter(&rest.Config{
+			restConfig := &rest.Config{
 				Host: "ftp:///path/to/foobar",
-			}, rm, "test-ns")
+			}
+			rcg = newRESTClientGetter(restConfig, rm, newSharedDiscoveryClient(restConfig), "test-ns")
 			Expect(rcg).NotTo(BeNil())
 		})
 
@@ -53,7 +54,7 @@ var _ = Describe("restClientGetter", func() {
 			rm, err = apiutil.NewDynamicRESTMapper(cfg)
 			Expect(err).To(BeNil())
 
-			rcg = newRESTClientGetter(cfg, rm, "test-ns")
+			rcg = newRESTClientGetter(cfg, rm, newSharedDiscoveryClient(cfg), "test-ns")
 			Expect(rcg).NotTo(BeNil())
 		})
 
@@ -0,0 +1,307 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SQLDialect is the SQL dialect of a database used to store release records.
+// Its value is the name of the database/sql driver for the dialect.
+type SQLDialect string
+
+const (
+	// SQLDialectPostgres is the dialect of PostgreSQL databases, which are
+	// accessed using the "postgres" driver registered by Helm.
+	SQLDialectPostgres SQLDialect = "postgres"
+
+	// SQLDialectSQLite is the dialect of SQLite databases. The program must
+	// register a "sqlite3" database/sql driver itself.
+	SQLDialectSQLite SQLDialect = "sqlite3"
+)
+
+// SQLDriverName is the name of the SQL storage driver.
+const SQLDriverName = "SQL"
+
+const sqlReleaseTable = "helm_operator_releases"
+
+// sqlLabelColumns maps the release labels that Helm queries by to the
+// columns that store them.
+var sqlLabelColumns = map[string]string{
+	"name":    "name",
+	"owner":   "owner",
+	"status":  "status",
+	"version": "version",
+}
+
+var _ driver.Driver = &sqlDriver{}
+
+// sqlDriver stores release records in a single table of a SQL database. It
+// only uses SQL that is portable between the supported dialects, so that it
+// can be tested against SQLite.
+type sqlDriver struct {
+	db        *sql.DB
+	dialect   SQLDialect
+	namespace string
+	ownerUIDs string
+
+	Log func(string, ...interface{})
+}
+
+func newSQLDriver(db *sql.DB, dialect SQLDialect, namespace string, refs []metav1.OwnerReference) *sqlDriver {
+	uids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		uids = append(uids, string(ref.UID))
+	}
+	return &sqlDriver{
+		db:        db,
+		dialect:   dialect,
+		namespace: namespace,
+		ownerUIDs: strings.Join(uids, ","),
+		Log:       func(string, ...interface{}) {},
+	}
+}
+
+func (d *sqlDriver) Name() string {
+	return SQLDriverName
+}
+
+func (d *sqlDriver) ensureTable() error {
+	_, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS ` + sqlReleaseTable + ` (
+		key VARCHAR(255) NOT NULL,
+		namespace VARCHAR(253) NOT NULL,
+		name VARCHAR(64) NOT NULL,
+		version INTEGER NOT NULL,
+		status VARCHAR(64) NOT NULL,
+		owner VARCHAR(64) NOT NULL,
+		owner_uids TEXT NOT NULL,
+		body TEXT NOT NULL,
+		PRIMARY KEY (key, namespace)
+	)`)
+	if err != nil {
+		return fmt.Errorf("create release table: %w", err)
+	}
+	return nil
+}
+
+// bind rewrites the ? placeholders of query for the dialect of the driver.
+func (d *sqlDriver) bind(query string) string {
+	if d.dialect != SQLDialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (d *sqlDriver) Get(key string) (*release.Release, error) {
+	var body string
+	err := d.db.QueryRow(d.bind(`SELECT body FROM `+sqlReleaseTable+` WHERE key = ? AND namespace = ?`), key, d.namespace).Scan(&body)
+	if err == sql.ErrNoRows {
+		return nil, driver.ErrReleaseNotFound
+	}
+	if err != nil {
+		d.Log("get: failed to get %q: %s", key, err)
+		return nil, err
+	}
+	return decodeSQLRelease(body)
+}
+
+func (d *sqlDriver) List(filter func(*release.Release) bool) ([]*release.Release, error) {
+	rels, err := d.query(map[string]string{"owner": "helm"})
+	if err != nil {
+		d.Log("list: failed to list: %s", err)
+		return nil, err
+	}
+	var out []*release.Release
+	for _, rel := range rels {
+		if filter(rel) {
+			out = append(out, rel)
+		}
+	}
+	return out, nil
+}
+
+func (d *sqlDriver) Query(labels map[string]string) ([]*release.Release, error) {
+	rels, err := d.query(labels)
+	if err != nil {
+		d.Log("query: failed to query with labels: %s", err)
+		return nil, err
+	}
+	if len(rels) == 0 {
+		return nil, driver.ErrReleaseNotFound
+	}
+	return rels, nil
+}
+
+func (d *sqlDriver) query(labels map[string]string) ([]*release.Release, error) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	q := `SELECT body FROM ` + sqlReleaseTable + ` WHERE namespace = ?`
+	args := []interface{}{d.namespace}
+	for _, k := range keys {
+		col, ok := sqlLabelColumns[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown label %q", k)
+		}
+		q += ` AND ` + col + ` = ?`
+		args = append(args, labels[k])
+	}
+
+	rows, err := d.db.Query(d.bind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rels []*release.Release
+	for rows.Next() {
+		var body string
+		if err := rows.Scan(&body); err != nil {
+			return nil, err
+		}
+		rel, err := decodeSQLRelease(body)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, rel)
+	}
+	return rels, rows.Err()
+}
+
+func (d *sqlDriver) Create(key string, rls *release.Release) error {
+	if _, err := d.Get(key); err == nil {
+		return driver.ErrReleaseExists
+	} else if err != driver.ErrReleaseNotFound {
+		return err
+	}
+	body, err := encodeSQLRelease(rls)
+	if err != nil {
+		d.Log("create: failed to encode release %q: %s", rls.Name, err)
+		return err
+	}
+	_, err = d.db.Exec(d.bind(`INSERT INTO `+sqlReleaseTable+` (key, namespace, name, version, status, owner, owner_uids, body) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		key, d.namespace, rls.Name, rls.Version, releaseStatus(rls), "helm", d.ownerUIDs, body)
+	if err != nil {
+		d.Log("create: failed to create %q: %s", key, err)
+		return err
+	}
+	return nil
+}
+
+func (d *sqlDriver) Update(key string, rls *release.Release) error {
+	body, err := encodeSQLRelease(rls)
+	if err != nil {
+		d.Log("update: failed to encode release %q: %s", rls.Name, err)
+		return err
+	}
+	res, err := d.db.Exec(d.bind(`UPDATE `+sqlReleaseTable+` SET version = ?, status = ?, owner_uids = ?, body = ? WHERE key = ? AND namespace = ?`),
+		rls.Version, releaseStatus(rls), d.ownerUIDs, body, key, d.namespace)
+	if err != nil {
+		d.Log("update: failed to update %q: %s", key, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return driver.ErrReleaseNotFound
+	}
+	return nil
+}
+
+func (d *sqlDriver) Delete(key string) (*release.Release, error) {
+	rls, err := d.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.db.Exec(d.bind(`DELETE FROM `+sqlReleaseTable+` WHERE key = ? AND namespace = ?`), key, d.namespace); err != nil {
+		d.Log("delete: failed to delete %q: %s", key, err)
+		return nil, err
+	}
+	return rls, nil
+}
+
+func releaseStatus(rls *release.Release) string {
+	if rls.Info == nil {
+		return ""
+	}
+	return rls.Info.Status.String()
+}
+
+// encodeSQLRelease encodes a release the same way as Helm's drivers: as
+// base64 encoded, gzipped JSON.
+func encodeSQLRelease(rls *release.Release) (string, error) {
+	b, err := json.Marshal(rls)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeSQLRelease(data string) (*release.Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if b, err = ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+	var rls release.Release
+	if err := json.Unmarshal(b, &rls); err != nil {
+		return nil, err
+	}
+	return &rls, nil
+}
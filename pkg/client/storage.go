@@ -0,0 +1,190 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// StorageDriverGetter returns the driver used to store the records of
+// releases in namespace. refs are the owner references of the release
+// records; drivers that store records in Kubernetes objects set them on
+// every object they create or update. log is the debug log for the driver.
+type StorageDriverGetter func(cs kubernetes.Interface, namespace string, refs []metav1.OwnerReference, log func(string, ...interface{})) (driver.Driver, error)
+
+// SecretsStorageDriver returns a StorageDriverGetter that stores release
+// records in Secrets in the release namespace. This is Helm's default.
+func SecretsStorageDriver() StorageDriverGetter {
+	return func(cs kubernetes.Interface, namespace string, refs []metav1.OwnerReference, log func(string, ...interface{})) (driver.Driver, error) {
+		d := driver.NewSecrets(&ownerRefSecretClient{
+			SecretInterface: cs.CoreV1().Secrets(namespace),
+			refs:            refs,
+		})
+		d.Log = log
+		return d, nil
+	}
+}
+
+// ConfigMapsStorageDriver returns a StorageDriverGetter that stores release
+// records in ConfigMaps in the release namespace.
+func ConfigMapsStorageDriver() StorageDriverGetter {
+	return func(cs kubernetes.Interface, namespace string, refs []metav1.OwnerReference, log func(string, ...interface{})) (driver.Driver, error) {
+		d := driver.NewConfigMaps(&ownerRefConfigMapClient{
+			ConfigMapInterface: cs.CoreV1().ConfigMaps(namespace),
+			refs:               refs,
+		})
+		d.Log = log
+		return d, nil
+	}
+}
+
+// MemoryStorageDriver returns a StorageDriverGetter that stores release
+// records in memory. All drivers returned by the getter share the same
+// records, which are lost when the process exits, so it is intended for
+// tests.
+//
+// Release records stored in memory are not Kubernetes objects, so they have
+// no owner references and are only removed when the release is uninstalled.
+func MemoryStorageDriver() StorageDriverGetter {
+	mem := &sharedMemory{Memory: driver.NewMemory()}
+	return func(_ kubernetes.Interface, namespace string, _ []metav1.OwnerReference, _ func(string, ...interface{})) (driver.Driver, error) {
+		return &namespacedMemory{mem: mem, namespace: namespace}, nil
+	}
+}
+
+// SQLStorageDriver returns a StorageDriverGetter that stores release records
+// in db, which must have been opened with the database/sql driver for
+// dialect. The table used to store release records is created if it does
+// not exist.
+//
+// Release records stored in a database are not Kubernetes objects, so
+// instead of owner references the UIDs of the owners are stored with each
+// record. Like in-memory records, they are only removed when the release is
+// uninstalled.
+func SQLStorageDriver(db *sql.DB, dialect SQLDialect) StorageDriverGetter {
+	var (
+		once    sync.Once
+		initErr error
+	)
+	return func(_ kubernetes.Interface, namespace string, refs []metav1.OwnerReference, log func(string, ...interface{})) (driver.Driver, error) {
+		d := newSQLDriver(db, dialect, namespace, refs)
+		d.Log = log
+		once.Do(func() { initErr = d.ensureTable() })
+		if initErr != nil {
+			return nil, initErr
+		}
+		return d, nil
+	}
+}
+
+var _ v1.SecretInterface = &ownerRefSecretClient{}
+
+type ownerRefSecretClient struct {
+	v1.SecretInterface
+	refs []metav1.OwnerReference
+}
+
+func (c *ownerRefSecretClient) Create(ctx context.Context, in *corev1.Secret, opts metav1.CreateOptions) (*corev1.Secret, error) {
+	in.OwnerReferences = append(in.OwnerReferences, c.refs...)
+	return c.SecretInterface.Create(ctx, in, opts)
+}
+
+func (c *ownerRefSecretClient) Update(ctx context.Context, in *corev1.Secret, opts metav1.UpdateOptions) (*corev1.Secret, error) {
+	in.OwnerReferences = append(in.OwnerReferences, c.refs...)
+	return c.SecretInterface.Update(ctx, in, opts)
+}
+
+var _ v1.ConfigMapInterface = &ownerRefConfigMapClient{}
+
+type ownerRefConfigMapClient struct {
+	v1.ConfigMapInterface
+	refs []metav1.OwnerReference
+}
+
+func (c *ownerRefConfigMapClient) Create(ctx context.Context, in *corev1.ConfigMap, opts metav1.CreateOptions) (*corev1.ConfigMap, error) {
+	in.OwnerReferences = append(in.OwnerReferences, c.refs...)
+	return c.ConfigMapInterface.Create(ctx, in, opts)
+}
+
+func (c *ownerRefConfigMapClient) Update(ctx context.Context, in *corev1.ConfigMap, opts metav1.UpdateOptions) (*corev1.ConfigMap, error) {
+	in.OwnerReferences = append(in.OwnerReferences, c.refs...)
+	return c.ConfigMapInterface.Update(ctx, in, opts)
+}
+
+// sharedMemory is a Memory driver shared by the drivers of many namespaces.
+// The namespace of a Memory driver is part of its state, so every operation
+// sets it while holding the lock.
+type sharedMemory struct {
+	sync.Mutex
+	*driver.Memory
+}
+
+var _ driver.Driver = &namespacedMemory{}
+
+type namespacedMemory struct {
+	mem       *sharedMemory
+	namespace string
+}
+
+func (m *namespacedMemory) lock() *driver.Memory {
+	m.mem.Lock()
+	m.mem.SetNamespace(m.namespace)
+	return m.mem.Memory
+}
+
+func (m *namespacedMemory) Name() string {
+	return driver.MemoryDriverName
+}
+
+func (m *namespacedMemory) Get(key string) (*release.Release, error) {
+	defer m.mem.Unlock()
+	return m.lock().Get(key)
+}
+
+func (m *namespacedMemory) List(filter func(*release.Release) bool) ([]*release.Release, error) {
+	defer m.mem.Unlock()
+	return m.lock().List(filter)
+}
+
+func (m *namespacedMemory) Query(labels map[string]string) ([]*release.Release, error) {
+	defer m.mem.Unlock()
+	return m.lock().Query(labels)
+}
+
+func (m *namespacedMemory) Create(key string, rls *release.Release) error {
+	defer m.mem.Unlock()
+	return m.lock().Create(key, rls)
+}
+
+func (m *namespacedMemory) Update(key string, rls *release.Release) error {
+	defer m.mem.Unlock()
+	return m.lock().Update(key, rls)
+}
+
+func (m *namespacedMemory) Delete(key string) (*release.Release, error) {
+	defer m.mem.Unlock()
+	return m.lock().Delete(key)
+}
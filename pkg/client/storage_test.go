@@ -0,0 +1,202 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Storage", func() {
+	var (
+		refs []metav1.OwnerReference
+		log  func(string, ...interface{})
+	)
+	BeforeEach(func() {
+		refs = []metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "TestApp", Name: "test", UID: types.UID("test-uid")}}
+		log = func(string, ...interface{}) {}
+	})
+
+	// testDriver exercises the parts of a driver that Helm's release storage
+	// relies on.
+	testDriver := func(newDriver func(namespace string) driver.Driver) {
+		It("should store and query release records by namespace", func() {
+			d := newDriver("ns1")
+			other := newDriver("ns2")
+
+			rel := testRelease("test", "ns1", 1, release.StatusDeployed)
+			Expect(d.Create(testReleaseKey(rel), rel)).To(Succeed())
+			Expect(d.Create(testReleaseKey(rel), rel)).To(MatchError(driver.ErrReleaseExists))
+
+			got, err := d.Get(testReleaseKey(rel))
+			Expect(err).To(BeNil())
+			Expect(got.Name).To(Equal("test"))
+			Expect(got.Version).To(Equal(1))
+
+			_, err = other.Get(testReleaseKey(rel))
+			Expect(err).To(MatchError(driver.ErrReleaseNotFound))
+
+			rels, err := d.Query(map[string]string{"name": "test", "owner": "helm", "status": "deployed"})
+			Expect(err).To(BeNil())
+			Expect(rels).To(HaveLen(1))
+
+			_, err = other.Query(map[string]string{"name": "test", "owner": "helm"})
+			Expect(err).To(MatchError(driver.ErrReleaseNotFound))
+		})
+		It("should update, list, and delete release records", func() {
+			d := newDriver("ns1")
+
+			rel := testRelease("test", "ns1", 1, release.StatusDeployed)
+			Expect(d.Create(testReleaseKey(rel), rel)).To(Succeed())
+			rel.Info.Status = release.StatusSuperseded
+			Expect(d.Update(testReleaseKey(rel), rel)).To(Succeed())
+			rel2 := testRelease("test", "ns1", 2, release.StatusDeployed)
+			Expect(d.Create(testReleaseKey(rel2), rel2)).To(Succeed())
+
+			rels, err := d.Query(map[string]string{"name": "test", "owner": "helm", "status": "deployed"})
+			Expect(err).To(BeNil())
+			Expect(rels).To(HaveLen(1))
+			Expect(rels[0].Version).To(Equal(2))
+
+			rels, err = d.List(func(*release.Release) bool { return true })
+			Expect(err).To(BeNil())
+			Expect(rels).To(HaveLen(2))
+
+			deleted, err := d.Delete(testReleaseKey(rel))
+			Expect(err).To(BeNil())
+			Expect(deleted.Version).To(Equal(1))
+			_, err = d.Get(testReleaseKey(rel))
+			Expect(err).To(MatchError(driver.ErrReleaseNotFound))
+		})
+	}
+
+	var _ = Describe("SecretsStorageDriver", func() {
+		It("should set owner references on release secrets", func() {
+			cs := fake.NewSimpleClientset()
+			d, err := SecretsStorageDriver()(cs, "ns1", refs, log)
+			Expect(err).To(BeNil())
+
+			rel := testRelease("test", "ns1", 1, release.StatusDeployed)
+			Expect(d.Create(testReleaseKey(rel), rel)).To(Succeed())
+
+			s, err := cs.CoreV1().Secrets("ns1").Get(context.TODO(), testReleaseKey(rel), metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(s.OwnerReferences).To(Equal(refs))
+		})
+	})
+
+	var _ = Describe("ConfigMapsStorageDriver", func() {
+		var cs *fake.Clientset
+		BeforeEach(func() {
+			cs = fake.NewSimpleClientset()
+		})
+		testDriver(func(namespace string) driver.Driver {
+			d, err := ConfigMapsStorageDriver()(cs, namespace, refs, log)
+			Expect(err).To(BeNil())
+			return d
+		})
+		It("should set owner references on release configmaps", func() {
+			d, err := ConfigMapsStorageDriver()(cs, "ns1", refs, log)
+			Expect(err).To(BeNil())
+
+			rel := testRelease("test", "ns1", 1, release.StatusDeployed)
+			Expect(d.Create(testReleaseKey(rel), rel)).To(Succeed())
+
+			cm, err := cs.CoreV1().ConfigMaps("ns1").Get(context.TODO(), testReleaseKey(rel), metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(cm.OwnerReferences).To(Equal(refs))
+		})
+	})
+
+	var _ = Describe("MemoryStorageDriver", func() {
+		var g StorageDriverGetter
+		BeforeEach(func() {
+			g = MemoryStorageDriver()
+		})
+		testDriver(func(namespace string) driver.Driver {
+			d, err := g(nil, namespace, refs, log)
+			Expect(err).To(BeNil())
+			return d
+		})
+	})
+
+	var _ = Describe("SQLStorageDriver", func() {
+		var (
+			dir string
+			db  *sql.DB
+			g   StorageDriverGetter
+		)
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "helm-operator-storage-")
+			Expect(err).To(BeNil())
+			db, err = sql.Open(string(SQLDialectSQLite), filepath.Join(dir, "releases.db"))
+			Expect(err).To(BeNil())
+			g = SQLStorageDriver(db, SQLDialectSQLite)
+		})
+		AfterEach(func() {
+			Expect(db.Close()).To(Succeed())
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+		testDriver(func(namespace string) driver.Driver {
+			d, err := g(nil, namespace, refs, log)
+			Expect(err).To(BeNil())
+			return d
+		})
+		It("should store the owner UIDs of release records", func() {
+			d, err := g(nil, "ns1", refs, log)
+			Expect(err).To(BeNil())
+
+			rel := testRelease("test", "ns1", 1, release.StatusDeployed)
+			Expect(d.Create(testReleaseKey(rel), rel)).To(Succeed())
+
+			var uids string
+			Expect(db.QueryRow(`SELECT owner_uids FROM `+sqlReleaseTable+` WHERE key = ?`, testReleaseKey(rel)).Scan(&uids)).To(Succeed())
+			Expect(uids).To(Equal("test-uid"))
+		})
+		It("should bind postgres placeholders", func() {
+			d := newSQLDriver(nil, SQLDialectPostgres, "ns1", nil)
+			Expect(d.bind("a = ? AND b = ?")).To(Equal("a = $1 AND b = $2"))
+		})
+	})
+})
+
+func testRelease(name, namespace string, version int, status release.Status) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Version:   version,
+		Info:      &release.Info{Status: status},
+	}
+}
+
+func testReleaseKey(rel *release.Release) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", rel.Name, rel.Version)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package crd generates CustomResourceDefinitions for the custom resources
+// reconciled by the Helm operator. The schema of the spec is derived from the
+// chart of each custom resource.
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/joelanford/helm-operator/pkg/reconciler"
+)
+
+// Version is a version of a custom resource.
+type Version struct {
+	GroupVersionKind schema.GroupVersionKind
+	Chart            *chart.Chart
+
+	// ChartVersionField, ValuesFromField, and RemediationField are the
+	// reserved spec fields configured with reconciler.WithChartVersionField,
+	// reconciler.WithValuesFromField, and reconciler.WithRemediationField,
+	// if any.
+	ChartVersionField string
+	ValuesFromField   string
+	RemediationField  string
+}
+
+// Generate returns the CRDs of the custom resources of versions. Versions
+// with the same group and kind are served by a single CRD, whose storage
+// version is the first of its versions. CRDs are returned in the order of the
+// first of their versions.
+func Generate(versions []Version) ([]apiextv1.CustomResourceDefinition, error) {
+	var (
+		crds  []apiextv1.CustomResourceDefinition
+		index = map[schema.GroupKind]int{}
+	)
+	for _, v := range versions {
+		gvk := v.GroupVersionKind
+		if gvk.Group == "" || gvk.Version == "" || gvk.Kind == "" {
+			return nil, fmt.Errorf("invalid GVK %q: group, version and kind must not be empty", gvk)
+		}
+		if v.Chart == nil || v.Chart.Metadata == nil {
+			return nil, fmt.Errorf("GVK %q: chart must not be nil", gvk)
+		}
+		crdVersion, err := generateVersion(v)
+		if err != nil {
+			return nil, fmt.Errorf("GVK %q: %w", gvk, err)
+		}
+
+		i, ok := index[gvk.GroupKind()]
+		if !ok {
+			i = len(crds)
+			index[gvk.GroupKind()] = i
+			crds = append(crds, newCRD(gvk))
+			crdVersion.Storage = true
+		}
+		for _, existing := range crds[i].Spec.Versions {
+			if existing.Name == crdVersion.Name {
+				return nil, fmt.Errorf("duplicate GVK %q", gvk)
+			}
+		}
+		crds[i].Spec.Versions = append(crds[i].Spec.Versions, crdVersion)
+	}
+	return crds, nil
+}
+
+func newCRD(gvk schema.GroupVersionKind) apiextv1.CustomResourceDefinition {
+	singular := strings.ToLower(gvk.Kind)
+	plural := pluralize(singular)
+	return apiextv1.CustomResourceDefinition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiextv1.SchemeGroupVersion.String(),
+			Kind:       "CustomResourceDefinition",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s.%s", plural, gvk.Group),
+		},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group: gvk.Group,
+			Names: apiextv1.CustomResourceDefinitionNames{
+				Kind:     gvk.Kind,
+				ListKind: gvk.Kind + "List",
+				Singular: singular,
+				Plural:   plural,
+			},
+			Scope: apiextv1.NamespaceScoped,
+		},
+	}
+}
+
+func generateVersion(v Version) (apiextv1.CustomResourceDefinitionVersion, error) {
+	spec, err := SpecSchema(v.Chart)
+	if err != nil {
+		return apiextv1.CustomResourceDefinitionVersion{}, err
+	}
+	spec.Description = fmt.Sprintf("Values of the %s chart.", v.Chart.Name())
+	if v.ChartVersionField != "" || v.ValuesFromField != "" || v.RemediationField != "" {
+		if spec.Properties == nil {
+			spec.Properties = map[string]apiextv1.JSONSchemaProps{}
+		}
+		if v.ChartVersionField != "" {
+			spec.Properties[v.ChartVersionField] = apiextv1.JSONSchemaProps{
+				Type:        "string",
+				Description: "Semver constraint that selects the version of the chart.",
+			}
+		}
+		if v.ValuesFromField != "" {
+			spec.Properties[v.ValuesFromField] = reconciler.ValuesReferencesSchema()
+		}
+		if v.RemediationField != "" {
+			spec.Properties[v.RemediationField] = reconciler.RemediationSchema()
+		}
+	}
+	status := reconciler.StatusSchema()
+
+	return apiextv1.CustomResourceDefinitionVersion{
+		Name:    v.GroupVersionKind.Version,
+		Served:  true,
+		Storage: false,
+		Schema: &apiextv1.CustomResourceValidation{
+			OpenAPIV3Schema: &apiextv1.JSONSchemaProps{
+				Description: fmt.Sprintf("%s is the Schema for the %s API", v.GroupVersionKind.Kind, pluralize(strings.ToLower(v.GroupVersionKind.Kind))),
+				Type:        "object",
+				Properties: map[string]apiextv1.JSONSchemaProps{
+					"apiVersion": {Type: "string"},
+					"kind":       {Type: "string"},
+					"metadata":   {Type: "object"},
+					"spec":       spec,
+					"status":     status,
+				},
+			},
+		},
+		Subresources: &apiextv1.CustomResourceSubresources{
+			Status: &apiextv1.CustomResourceSubresourceStatus{},
+		},
+		AdditionalPrinterColumns: []apiextv1.CustomResourceColumnDefinition{
+			{Name: "Resources", Type: "integer", JSONPath: ".status.resourceCount", Description: "The number of resources of the deployed release."},
+			{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+		},
+	}, nil
+}
+
+// pluralize returns the plural of the lower case kind singular.
+func pluralize(singular string) string {
+	switch {
+	case strings.HasSuffix(singular, "s"), strings.HasSuffix(singular, "x"), strings.HasSuffix(singular, "z"),
+		strings.HasSuffix(singular, "ch"), strings.HasSuffix(singular, "sh"):
+		return singular + "es"
+	case strings.HasSuffix(singular, "y") && len(singular) > 1 && !strings.ContainsAny(singular[len(singular)-2:len(singular)-1], "aeiou"):
+		return singular[:len(singular)-1] + "ies"
+	default:
+		return singular + "s"
+	}
+}
+
+// Marshal returns the YAML manifest of crd, without its status and other
+// fields that are set by the API server.
+func Marshal(crd apiextv1.CustomResourceDefinition) ([]byte, error) {
+	data, err := json.Marshal(crd)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	delete(m, "status")
+	if meta, ok := m["metadata"].(map[string]interface{}); ok {
+		delete(meta, "creationTimestamp")
+	}
+	out, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("---\n"), out...), nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	. "github.com/joelanford/helm-operator/pkg/crd"
+	"github.com/joelanford/helm-operator/pkg/internal/testutil"
+)
+
+var _ = Describe("Generate", func() {
+	var (
+		chrt *chart.Chart
+		gvk  schema.GroupVersionKind
+	)
+
+	BeforeEach(func() {
+		c := testutil.MustLoadChart("../../testdata/test-chart-0.1.0.tgz")
+		chrt = &c
+		gvk = schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Nginx"}
+	})
+
+	It("should generate a structural CRD", func() {
+		crds, err := Generate([]Version{{GroupVersionKind: gvk, Chart: chrt, ChartVersionField: "chartVersion", ValuesFromField: "valuesFrom", RemediationField: "remediation"}})
+		Expect(err).To(BeNil())
+		Expect(crds).To(HaveLen(1))
+
+		crd := crds[0]
+		Expect(crd.Name).To(Equal("nginxes.example.com"))
+		Expect(crd.Spec.Names).To(Equal(apiextv1.CustomResourceDefinitionNames{
+			Kind:     "Nginx",
+			ListKind: "NginxList",
+			Singular: "nginx",
+			Plural:   "nginxes",
+		}))
+		Expect(crd.Spec.Versions).To(HaveLen(1))
+		v := crd.Spec.Versions[0]
+		Expect(v.Storage).To(BeTrue())
+		Expect(v.Subresources.Status).NotTo(BeNil())
+		Expect(v.AdditionalPrinterColumns).To(ContainElement(apiextv1.CustomResourceColumnDefinition{
+			Name:        "Resources",
+			Type:        "integer",
+			JSONPath:    ".status.resourceCount",
+			Description: "The number of resources of the deployed release.",
+		}))
+
+		root := v.Schema.OpenAPIV3Schema
+		expectStructural(root)
+		spec := root.Properties["spec"]
+		Expect(spec.Properties).To(HaveKey("replicaCount"))
+		Expect(spec.Properties["replicaCount"].Type).To(Equal("integer"))
+		Expect(string(spec.Properties["replicaCount"].Default.Raw)).To(Equal("1"))
+		Expect(spec.Properties).To(HaveKey("chartVersion"))
+		Expect(spec.Properties).To(HaveKey("valuesFrom"))
+		Expect(spec.Properties).To(HaveKey("remediation"))
+		Expect(root.Properties["status"].Properties).To(HaveKey("conditions"))
+	})
+
+	It("should serve versions of the same kind from one CRD", func() {
+		gvk2 := gvk
+		gvk2.Version = "v2"
+		other := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Memcached"}
+		crds, err := Generate([]Version{
+			{GroupVersionKind: gvk, Chart: chrt},
+			{GroupVersionKind: other, Chart: chrt},
+			{GroupVersionKind: gvk2, Chart: chrt},
+		})
+		Expect(err).To(BeNil())
+		Expect(crds).To(HaveLen(2))
+		Expect(crds[0].Spec.Versions).To(HaveLen(2))
+		Expect(crds[0].Spec.Versions[0].Storage).To(BeTrue())
+		Expect(crds[0].Spec.Versions[1].Storage).To(BeFalse())
+		Expect(crds[1].Name).To(Equal("memcacheds.example.com"))
+	})
+
+	It("should fail with duplicate GVKs", func() {
+		_, err := Generate([]Version{{GroupVersionKind: gvk, Chart: chrt}, {GroupVersionKind: gvk, Chart: chrt}})
+		Expect(err).To(MatchError(ContainSubstring("duplicate GVK")))
+	})
+
+	It("should fail without a group", func() {
+		gvk.Group = ""
+		_, err := Generate([]Version{{GroupVersionKind: gvk, Chart: chrt}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should pluralize kinds", func() {
+		for kind, plural := range map[string]string{"Policy": "policies", "Gateway": "gateways", "Cache": "caches", "Mesh": "meshes"} {
+			gvk.Kind = kind
+			crds, err := Generate([]Version{{GroupVersionKind: gvk, Chart: chrt}})
+			Expect(err).To(BeNil())
+			Expect(crds[0].Spec.Names.Plural).To(Equal(plural))
+		}
+	})
+})
+
+var _ = Describe("Marshal", func() {
+	It("should omit fields set by the API server", func() {
+		c := testutil.MustLoadChart("../../testdata/test-chart-0.1.0.tgz")
+		gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Nginx"}
+		crds, err := Generate([]Version{{GroupVersionKind: gvk, Chart: &c}})
+		Expect(err).To(BeNil())
+
+		data, err := Marshal(crds[0])
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(HavePrefix("---\napiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\n"))
+		Expect(string(data)).NotTo(ContainSubstring("creationTimestamp: null"))
+		Expect(string(data)).NotTo(ContainSubstring("storedVersions"))
+	})
+})
+
+var _ = Describe("SpecSchema", func() {
+	It("should convert values.schema.json", func() {
+		chrt := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "test"},
+			Schema: []byte(`{
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"type": "object",
+				"required": ["image"],
+				"additionalProperties": false,
+				"properties": {
+					"replicas": {"type": "integer", "exclusiveMinimum": 0},
+					"image": {
+						"type": "object",
+						"properties": {"tag": {"type": ["string", "null"]}}
+					},
+					"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+					"ports": {"type": "array", "items": [{"type": "integer"}]},
+					"any": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+					"ref": {"$ref": "#/definitions/foo"}
+				},
+				"definitions": {"foo": {"type": "string"}}
+			}`),
+		}
+		s, err := SpecSchema(chrt)
+		Expect(err).To(BeNil())
+		expectStructural(&apiextv1.JSONSchemaProps{Type: "object", Properties: map[string]apiextv1.JSONSchemaProps{"spec": s}})
+
+		Expect(s.Required).To(Equal([]string{"image"}))
+		Expect(s.XPreserveUnknownFields).To(BeNil())
+		Expect(*s.Properties["replicas"].Minimum).To(BeEquivalentTo(0))
+		Expect(s.Properties["replicas"].ExclusiveMinimum).To(BeTrue())
+		Expect(s.Properties["image"].Properties["tag"].Type).To(Equal("string"))
+		Expect(s.Properties["image"].Properties["tag"].Nullable).To(BeTrue())
+		Expect(*s.Properties["image"].XPreserveUnknownFields).To(BeTrue())
+		Expect(s.Properties["labels"].AdditionalProperties.Schema.Type).To(Equal("string"))
+		Expect(*s.Properties["any"].XPreserveUnknownFields).To(BeTrue())
+		Expect(*s.Properties["ref"].XPreserveUnknownFields).To(BeTrue())
+	})
+
+	It("should infer a schema from values", func() {
+		chrt := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "test"},
+			Values: map[string]interface{}{
+				"name":        "test",
+				"enabled":     true,
+				"ratio":       0.5,
+				"tags":        []interface{}{"a", "b"},
+				"mixed":       []interface{}{"a", 1.0},
+				"nothing":     nil,
+				"annotations": map[string]interface{}{},
+			},
+		}
+		s, err := SpecSchema(chrt)
+		Expect(err).To(BeNil())
+		expectStructural(&apiextv1.JSONSchemaProps{Type: "object", Properties: map[string]apiextv1.JSONSchemaProps{"spec": s}})
+
+		Expect(s.Properties["name"].Type).To(Equal("string"))
+		Expect(string(s.Properties["name"].Default.Raw)).To(Equal(`"test"`))
+		Expect(s.Properties["enabled"].Type).To(Equal("boolean"))
+		Expect(s.Properties["ratio"].Type).To(Equal("number"))
+		Expect(s.Properties["tags"].Items.Schema.Type).To(Equal("string"))
+		Expect(*s.Properties["mixed"].Items.Schema.XPreserveUnknownFields).To(BeTrue())
+		Expect(s.Properties["nothing"].Nullable).To(BeTrue())
+		Expect(s.Properties["annotations"].Type).To(Equal("object"))
+	})
+
+	It("should fail with an invalid values schema", func() {
+		_, err := SpecSchema(&chart.Chart{Metadata: &chart.Metadata{Name: "test"}, Schema: []byte("{")})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func expectStructural(s *apiextv1.JSONSchemaProps) {
+	internal := &apiextensions.JSONSchemaProps{}
+	Expect(apiextv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(s, internal, nil)).To(Succeed())
+	ss, err := structuralschema.NewStructural(internal)
+	Expect(err).To(BeNil())
+	Expect(structuralschema.ValidateStructural(nil, ss)).To(BeEmpty())
+}
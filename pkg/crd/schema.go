@@ -0,0 +1,254 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"helm.sh/helm/v3/pkg/chart"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// SpecSchema returns a structural schema for the values of chrt. It is
+// converted from the chart's values.schema.json or, if the chart has none,
+// inferred from the types and defaults of the chart's values.yaml.
+//
+// Objects keep unknown fields, unless the values schema sets
+// additionalProperties to false, so that values that are not described by the
+// schema are not pruned.
+func SpecSchema(chrt *chart.Chart) (apiextv1.JSONSchemaProps, error) {
+	if len(chrt.Schema) == 0 {
+		return inferSchema(chrt.Values), nil
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(chrt.Schema, &m); err != nil {
+		return apiextv1.JSONSchemaProps{}, fmt.Errorf("invalid values schema: %w", err)
+	}
+	data, err := json.Marshal(structural(m))
+	if err != nil {
+		return apiextv1.JSONSchemaProps{}, err
+	}
+	var props apiextv1.JSONSchemaProps
+	if err := json.Unmarshal(data, &props); err != nil {
+		return apiextv1.JSONSchemaProps{}, fmt.Errorf("convert values schema: %w", err)
+	}
+	return props, nil
+}
+
+// unsupportedKeywords are JSON schema keywords that cannot be used in
+// structural schemas or that are not supported by Kubernetes.
+var unsupportedKeywords = []string{
+	"$schema", "$id", "id", "$comment", "definitions", "$defs", "dependencies",
+	"patternProperties", "additionalItems", "allOf", "anyOf", "oneOf", "not",
+	"const", "contains", "propertyNames", "if", "then", "else", "examples",
+}
+
+// structural converts the JSON schema m into a structural schema. Keywords
+// that cannot be converted are dropped, so the converted schema may accept
+// more values than m.
+func structural(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	for _, k := range unsupportedKeywords {
+		delete(out, k)
+	}
+	if _, ok := out["$ref"]; ok {
+		// References are not supported, so the referenced values are not
+		// validated.
+		return preserveUnknown(out["description"])
+	}
+
+	// Kubernetes only supports a single type, and represents null with
+	// nullable.
+	switch t := out["type"].(type) {
+	case []interface{}:
+		var types []string
+		for _, v := range t {
+			if s, _ := v.(string); s == "null" {
+				out["nullable"] = true
+			} else if s != "" {
+				types = append(types, s)
+			}
+		}
+		delete(out, "type")
+		if len(types) == 1 {
+			out["type"] = types[0]
+		} else {
+			return preserveUnknown(out["description"])
+		}
+	case string:
+		if t == "null" {
+			return preserveUnknown(out["description"])
+		}
+	}
+
+	// Draft 6 and later use numbers for exclusive bounds.
+	for _, bound := range []struct{ exclusive, inclusive string }{{"exclusiveMinimum", "minimum"}, {"exclusiveMaximum", "maximum"}} {
+		if n, ok := out[bound.exclusive].(float64); ok {
+			out[bound.inclusive] = n
+			out[bound.exclusive] = true
+		}
+	}
+
+	if props, ok := out["properties"].(map[string]interface{}); ok {
+		converted := make(map[string]interface{}, len(props))
+		for k, v := range props {
+			if p, ok := v.(map[string]interface{}); ok {
+				converted[k] = structural(p)
+			} else {
+				converted[k] = preserveUnknown(nil)
+			}
+		}
+		out["properties"] = converted
+	}
+	switch items := out["items"].(type) {
+	case map[string]interface{}:
+		out["items"] = structural(items)
+	case nil:
+	default:
+		// Tuples cannot be represented in structural schemas.
+		out["items"] = preserveUnknown(nil)
+	}
+
+	if _, ok := out["type"]; !ok {
+		switch {
+		case out["properties"] != nil || out["additionalProperties"] != nil || out["required"] != nil:
+			out["type"] = "object"
+		case out["items"] != nil:
+			out["type"] = "array"
+		default:
+			out["x-kubernetes-preserve-unknown-fields"] = true
+		}
+	}
+	if out["type"] == "array" && out["items"] == nil {
+		out["items"] = preserveUnknown(nil)
+	}
+	if out["type"] == "object" {
+		additional, hasAdditional := out["additionalProperties"]
+		switch a := additional.(type) {
+		case bool:
+			delete(out, "additionalProperties")
+			if a {
+				out["x-kubernetes-preserve-unknown-fields"] = true
+			}
+		case map[string]interface{}:
+			if out["properties"] != nil {
+				// properties and additionalProperties are mutually
+				// exclusive in structural schemas.
+				delete(out, "additionalProperties")
+				out["x-kubernetes-preserve-unknown-fields"] = true
+			} else {
+				out["additionalProperties"] = structural(a)
+			}
+		}
+		if !hasAdditional {
+			out["x-kubernetes-preserve-unknown-fields"] = true
+		}
+	}
+	return out
+}
+
+func preserveUnknown(description interface{}) map[string]interface{} {
+	m := map[string]interface{}{"x-kubernetes-preserve-unknown-fields": true}
+	if description != nil {
+		m["description"] = description
+	}
+	return m
+}
+
+// inferSchema infers a schema from the type of the value v, with v as its
+// default.
+func inferSchema(v interface{}) apiextv1.JSONSchemaProps {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		props := apiextv1.JSONSchemaProps{Type: "object", XPreserveUnknownFields: boolPtr(true)}
+		if len(v) > 0 {
+			props.Properties = make(map[string]apiextv1.JSONSchemaProps, len(v))
+			for k, val := range v {
+				props.Properties[k] = inferSchema(val)
+			}
+		}
+		return props
+	case []interface{}:
+		items := apiextv1.JSONSchemaProps{XPreserveUnknownFields: boolPtr(true)}
+		if t := sameScalarType(v); t != "" {
+			items = apiextv1.JSONSchemaProps{Type: t}
+		}
+		return apiextv1.JSONSchemaProps{
+			Type:    "array",
+			Items:   &apiextv1.JSONSchemaPropsOrArray{Schema: &items},
+			Default: jsonDefault(v),
+		}
+	case nil:
+		return apiextv1.JSONSchemaProps{Nullable: true, XPreserveUnknownFields: boolPtr(true)}
+	default:
+		t := scalarType(v)
+		if t == "" {
+			return apiextv1.JSONSchemaProps{XPreserveUnknownFields: boolPtr(true)}
+		}
+		return apiextv1.JSONSchemaProps{Type: t, Default: jsonDefault(v)}
+	}
+}
+
+func scalarType(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int32, int64:
+		return "integer"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return ""
+	}
+}
+
+// sameScalarType returns the scalar type of the elements of list, if they
+// all have the same scalar type.
+func sameScalarType(list []interface{}) string {
+	t := ""
+	for _, v := range list {
+		vt := scalarType(v)
+		if vt == "" || (t != "" && vt != t) {
+			return ""
+		}
+		t = vt
+	}
+	return t
+}
+
+func jsonDefault(v interface{}) *apiextv1.JSON {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return &apiextv1.JSON{Raw: data}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diff configures which differences between release resources and the
+// release manifest the Helm operator ignores.
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// IgnoreRule ignores fields of the release resources it selects, e.g. the
+// replicas of deployments that are scaled by a HorizontalPodAutoscaler:
+//
+//	group: apps
+//	kind: Deployment
+//	jsonPointers:
+//	- /spec/replicas
+//
+// Ignored fields are neither reported nor corrected as drift, and changes to
+// them do not trigger a reconciliation.
+type IgnoreRule struct {
+	// Group, Version and Kind select resources by their GroupVersionKind.
+	// An empty Group selects the core group, and an empty Version selects
+	// all versions.
+	Group   string `json:"group,omitempty"`
+	Version string `json:"version,omitempty"`
+	Kind    string `json:"kind"`
+
+	// Name is a pattern, as used by path.Match, that selects resources by
+	// name, e.g. "*-worker". An empty Name selects all resources.
+	Name string `json:"name,omitempty"`
+
+	// JSONPointers are RFC 6901 JSON pointers to the ignored fields, e.g.
+	// `/metadata/annotations/example.com~1owner`.
+	JSONPointers []string `json:"jsonPointers,omitempty"`
+
+	// JSONPaths are JSONPath expressions of the ignored fields, e.g.
+	// `.spec.template.spec.containers[*].image`. Only child (`.name`,
+	// `['name']`), index (`[0]`) and wildcard (`[*]`, `.*`) selectors are
+	// supported.
+	JSONPaths []string `json:"jsonPaths,omitempty"`
+}
+
+// IgnoreRules is a list of rules.
+type IgnoreRules []IgnoreRule
+
+// Validate returns an error if a rule has no kind or fields, or has an invalid
+// name pattern or field path.
+func (rs IgnoreRules) Validate() error {
+	for i, r := range rs {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("ignore rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r IgnoreRule) validate() error {
+	if r.Kind == "" {
+		return errors.New("kind must not be empty")
+	}
+	if len(r.JSONPointers) == 0 && len(r.JSONPaths) == 0 {
+		return errors.New("at least one JSON pointer or JSONPath is required")
+	}
+	if _, err := path.Match(r.Name, ""); err != nil {
+		return fmt.Errorf("invalid name pattern %q: %w", r.Name, err)
+	}
+	_, err := r.paths()
+	return err
+}
+
+// Matches returns true if r selects obj.
+func (r IgnoreRule) Matches(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	if r.Kind != gvk.Kind || r.Group != gvk.Group || (r.Version != "" && r.Version != gvk.Version) {
+		return false
+	}
+	if r.Name == "" {
+		return true
+	}
+	ok, err := path.Match(r.Name, obj.GetName())
+	return err == nil && ok
+}
+
+// Strip removes the ignored fields of the rules that select obj from obj.
+// Rules are expected to be valid; invalid field paths are skipped.
+func (rs IgnoreRules) Strip(obj *unstructured.Unstructured) {
+	for _, r := range rs {
+		if !r.Matches(obj) {
+			continue
+		}
+		paths, err := r.paths()
+		if err != nil {
+			continue
+		}
+		for _, p := range paths {
+			obj.Object = remove(obj.Object, p).(map[string]interface{})
+		}
+	}
+}
+
+func (r IgnoreRule) paths() ([][]segment, error) {
+	paths := make([][]segment, 0, len(r.JSONPointers)+len(r.JSONPaths))
+	for _, p := range r.JSONPointers {
+		segs, err := parseJSONPointer(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON pointer %q: %w", p, err)
+		}
+		paths = append(paths, segs)
+	}
+	for _, p := range r.JSONPaths {
+		segs, err := parseJSONPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSONPath %q: %w", p, err)
+		}
+		paths = append(paths, segs)
+	}
+	return paths, nil
+}
+
+// segment is a step of a field path. Keys are used as list indices when the
+// path reaches a list.
+type segment struct {
+	key      string
+	wildcard bool
+}
+
+func parseJSONPointer(p string) ([]segment, error) {
+	if !strings.HasPrefix(p, "/") || p == "/" {
+		return nil, errors.New("must start with / and select a field")
+	}
+	tokens := strings.Split(p[1:], "/")
+	segs := make([]segment, 0, len(tokens))
+	for _, t := range tokens {
+		segs = append(segs, segment{key: strings.NewReplacer("~1", "/", "~0", "~").Replace(t)})
+	}
+	return segs, nil
+}
+
+func parseJSONPath(p string) ([]segment, error) {
+	s := strings.TrimSpace(p)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimPrefix(s, "$")
+
+	var segs []segment
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, errors.New("empty field name")
+			}
+			segs = append(segs, segment{key: s[:end], wildcard: s[:end] == "*"})
+			s = s[end:]
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, errors.New("unterminated [")
+			}
+			sel := s[1:end]
+			s = s[end+1:]
+			switch {
+			case sel == "*":
+				segs = append(segs, segment{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				segs = append(segs, segment{key: sel[1 : len(sel)-1]})
+			default:
+				if _, err := strconv.Atoi(sel); err != nil {
+					return nil, fmt.Errorf("unsupported selector [%s]", sel)
+				}
+				segs = append(segs, segment{key: sel})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q", s[0])
+		}
+	}
+	if len(segs) == 0 {
+		return nil, errors.New("must select a field")
+	}
+	return segs, nil
+}
+
+// remove removes the fields at p from v and returns v. Lists are replaced
+// rather than modified in place when elements are removed from them.
+func remove(v interface{}, p []segment) interface{} {
+	if len(p) == 0 {
+		return v
+	}
+	seg, rest := p[0], p[1:]
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if !seg.wildcard && k != seg.key {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(v, k)
+			} else {
+				v[k] = remove(child, rest)
+			}
+		}
+		return v
+	case []interface{}:
+		if seg.wildcard {
+			if len(rest) == 0 {
+				return []interface{}{}
+			}
+			for i := range v {
+				v[i] = remove(v[i], rest)
+			}
+			return v
+		}
+		i, err := strconv.Atoi(seg.key)
+		if err != nil || i < 0 || i >= len(v) {
+			return v
+		}
+		if len(rest) == 0 {
+			out := make([]interface{}, 0, len(v)-1)
+			return append(append(out, v[:i]...), v[i+1:]...)
+		}
+		v[i] = remove(v[i], rest)
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	. "github.com/joelanford/helm-operator/pkg/diff"
+)
+
+var _ = Describe("IgnoreRules", func() {
+	var obj *unstructured.Unstructured
+
+	BeforeEach(func() {
+		obj = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":        "test-web",
+				"annotations": map[string]interface{}{"example.com/owner": "me", "keep": "me"},
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(3),
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "a", "image": "a:1"},
+							map[string]interface{}{"name": "b", "image": "b:1"},
+						},
+					},
+				},
+			},
+		}}
+	})
+
+	Describe("Validate", func() {
+		It("should accept valid rules", func() {
+			rules := IgnoreRules{{Group: "apps", Kind: "Deployment", Name: "*-web", JSONPointers: []string{"/spec/replicas"}, JSONPaths: []string{"{.spec.template.spec.containers[*].image}"}}}
+			Expect(rules.Validate()).To(Succeed())
+		})
+		It("should require a kind", func() {
+			Expect(IgnoreRules{{JSONPointers: []string{"/spec/replicas"}}}.Validate()).To(MatchError(ContainSubstring("kind")))
+		})
+		It("should require fields", func() {
+			Expect(IgnoreRules{{Kind: "Deployment"}}.Validate()).To(MatchError(ContainSubstring("JSON pointer")))
+		})
+		It("should reject invalid name patterns", func() {
+			Expect(IgnoreRules{{Kind: "Deployment", Name: "[", JSONPointers: []string{"/spec"}}}.Validate()).To(MatchError(ContainSubstring("name pattern")))
+		})
+		It("should reject invalid JSON pointers", func() {
+			Expect(IgnoreRules{{Kind: "Deployment", JSONPointers: []string{"spec/replicas"}}}.Validate()).To(MatchError(ContainSubstring("invalid JSON pointer")))
+		})
+		It("should reject unsupported JSONPaths", func() {
+			Expect(IgnoreRules{{Kind: "Deployment", JSONPaths: []string{".spec.containers[?(@.name=='a')]"}}}.Validate()).To(MatchError(ContainSubstring("invalid JSONPath")))
+		})
+	})
+
+	Describe("Matches", func() {
+		It("should match by group, kind and name pattern", func() {
+			Expect(IgnoreRule{Group: "apps", Kind: "Deployment", Name: "*-web"}.Matches(obj)).To(BeTrue())
+			Expect(IgnoreRule{Group: "apps", Version: "v1", Kind: "Deployment"}.Matches(obj)).To(BeTrue())
+			Expect(IgnoreRule{Group: "apps", Kind: "Deployment", Name: "*-worker"}.Matches(obj)).To(BeFalse())
+			Expect(IgnoreRule{Group: "apps", Version: "v1beta1", Kind: "Deployment"}.Matches(obj)).To(BeFalse())
+			Expect(IgnoreRule{Kind: "Deployment"}.Matches(obj)).To(BeFalse())
+		})
+	})
+
+	Describe("Strip", func() {
+		It("should remove fields selected by JSON pointers", func() {
+			IgnoreRules{{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas", "/metadata/annotations/example.com~1owner"}}}.Strip(obj)
+			_, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "replicas")
+			Expect(found).To(BeFalse())
+			Expect(obj.GetAnnotations()).To(Equal(map[string]string{"keep": "me"}))
+		})
+		It("should remove fields selected by JSONPaths", func() {
+			IgnoreRules{{Group: "apps", Kind: "Deployment", JSONPaths: []string{"$.spec.template.spec.containers[*].image", ".metadata.annotations['example.com/owner']"}}}.Strip(obj)
+			containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+			Expect(containers).To(Equal([]interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "b"},
+			}))
+			Expect(obj.GetAnnotations()).To(Equal(map[string]string{"keep": "me"}))
+		})
+		It("should remove list elements by index", func() {
+			IgnoreRules{{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/template/spec/containers/0"}}}.Strip(obj)
+			containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+			Expect(containers).To(Equal([]interface{}{map[string]interface{}{"name": "b", "image": "b:1"}}))
+		})
+		It("should ignore missing fields", func() {
+			IgnoreRules{{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/missing/field", "/spec/template/spec/containers/5"}}}.Strip(obj)
+			Expect(obj.Object).To(HaveKey("spec"))
+		})
+		It("should not change objects the rules do not select", func() {
+			IgnoreRules{{Group: "apps", Kind: "StatefulSet", JSONPointers: []string{"/spec/replicas"}}}.Strip(obj)
+			replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+			Expect(replicas).To(Equal(int64(3)))
+		})
+	})
+})
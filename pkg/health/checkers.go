@@ -0,0 +1,181 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// The built-in checkers follow the same rules as `kubectl rollout status`
+// where applicable.
+
+func checkDeployment(obj *unstructured.Unstructured) (Result, error) {
+	d := &appsv1.Deployment{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, d); err != nil {
+		return Result{}, err
+	}
+	if d.Generation > d.Status.ObservedGeneration {
+		return Progressing("waiting for deployment spec update to be observed"), nil
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return Degraded(fmt.Sprintf("deployment exceeded its progress deadline: %s", c.Message)), nil
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Status.UpdatedReplicas < replicas {
+		return Progressing(fmt.Sprintf("%d of %d updated replicas", d.Status.UpdatedReplicas, replicas)), nil
+	}
+	if d.Status.Replicas > d.Status.UpdatedReplicas {
+		return Progressing(fmt.Sprintf("%d old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)), nil
+	}
+	if d.Status.AvailableReplicas < d.Status.UpdatedReplicas {
+		return Progressing(fmt.Sprintf("%d of %d updated replicas are available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)), nil
+	}
+	return Healthy(), nil
+}
+
+func checkStatefulSet(obj *unstructured.Unstructured) (Result, error) {
+	s := &appsv1.StatefulSet{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, s); err != nil {
+		return Result{}, err
+	}
+	if s.Generation > s.Status.ObservedGeneration {
+		return Progressing("waiting for statefulset spec update to be observed"), nil
+	}
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	if s.Status.ReadyReplicas < replicas {
+		return Progressing(fmt.Sprintf("%d of %d replicas are ready", s.Status.ReadyReplicas, replicas)), nil
+	}
+	if s.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return Healthy(), nil
+	}
+	if ru := s.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil && *ru.Partition > 0 {
+		if s.Status.UpdatedReplicas < replicas-*ru.Partition {
+			return Progressing(fmt.Sprintf("%d of %d partitioned replicas are updated", s.Status.UpdatedReplicas, replicas-*ru.Partition)), nil
+		}
+		return Healthy(), nil
+	}
+	if s.Status.UpdateRevision != s.Status.CurrentRevision {
+		return Progressing(fmt.Sprintf("%d of %d replicas are updated", s.Status.UpdatedReplicas, replicas)), nil
+	}
+	return Healthy(), nil
+}
+
+func checkDaemonSet(obj *unstructured.Unstructured) (Result, error) {
+	ds := &appsv1.DaemonSet{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, ds); err != nil {
+		return Result{}, err
+	}
+	if ds.Generation > ds.Status.ObservedGeneration {
+		return Progressing("waiting for daemonset spec update to be observed"), nil
+	}
+	if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		return Healthy(), nil
+	}
+	if ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+		return Progressing(fmt.Sprintf("%d of %d updated pods are scheduled", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)), nil
+	}
+	if ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled {
+		return Progressing(fmt.Sprintf("%d of %d updated pods are available", ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled)), nil
+	}
+	return Healthy(), nil
+}
+
+func checkJob(obj *unstructured.Unstructured) (Result, error) {
+	j := &batchv1.Job{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, j); err != nil {
+		return Result{}, err
+	}
+	for _, c := range j.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return Healthy(), nil
+		case batchv1.JobFailed:
+			return Degraded(fmt.Sprintf("job failed: %s", c.Message)), nil
+		}
+	}
+	return Progressing(fmt.Sprintf("job has %d active and %d succeeded pods", j.Status.Active, j.Status.Succeeded)), nil
+}
+
+func checkPod(obj *unstructured.Unstructured) (Result, error) {
+	p := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, p); err != nil {
+		return Result{}, err
+	}
+	switch p.Status.Phase {
+	case corev1.PodSucceeded:
+		return Healthy(), nil
+	case corev1.PodFailed:
+		return Degraded(fmt.Sprintf("pod failed: %s", p.Status.Message)), nil
+	case corev1.PodRunning:
+		for _, c := range p.Status.Conditions {
+			if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+				return Healthy(), nil
+			}
+		}
+		return Progressing("pod is running but not ready"), nil
+	}
+	return Progressing(fmt.Sprintf("pod is %s", phaseOrUnknown(string(p.Status.Phase)))), nil
+}
+
+func checkPersistentVolumeClaim(obj *unstructured.Unstructured) (Result, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pvc); err != nil {
+		return Result{}, err
+	}
+	switch pvc.Status.Phase {
+	case corev1.ClaimBound:
+		return Healthy(), nil
+	case corev1.ClaimLost:
+		return Degraded("persistent volume claim lost its volume"), nil
+	}
+	return Progressing(fmt.Sprintf("persistent volume claim is %s", phaseOrUnknown(string(pvc.Status.Phase)))), nil
+}
+
+func checkService(obj *unstructured.Unstructured) (Result, error) {
+	svc := &corev1.Service{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, svc); err != nil {
+		return Result{}, err
+	}
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return Progressing("waiting for load balancer ingress"), nil
+	}
+	return Healthy(), nil
+}
+
+func phaseOrUnknown(phase string) string {
+	if phase == "" {
+		return "Unknown"
+	}
+	return phase
+}
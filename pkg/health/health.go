@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Status is the health of a single release resource.
+type Status string
+
+const (
+	// StatusHealthy means the resource has reached its desired state.
+	StatusHealthy Status = "Healthy"
+
+	// StatusProgressing means the resource is still working towards its
+	// desired state, e.g. a Deployment that is rolling out.
+	StatusProgressing Status = "Progressing"
+
+	// StatusDegraded means the resource has failed to reach its desired state
+	// and is not expected to without intervention, e.g. a failed Job.
+	StatusDegraded Status = "Degraded"
+)
+
+// Result is the outcome of a health check.
+type Result struct {
+	Status  Status
+	Message string
+}
+
+// Healthy returns a healthy Result.
+func Healthy() Result {
+	return Result{Status: StatusHealthy}
+}
+
+// Progressing returns a progressing Result with the given message.
+func Progressing(message string) Result {
+	return Result{Status: StatusProgressing, Message: message}
+}
+
+// Degraded returns a degraded Result with the given message.
+func Degraded(message string) Result {
+	return Result{Status: StatusDegraded, Message: message}
+}
+
+// Checker checks the health of a live release resource.
+type Checker interface {
+	Check(obj *unstructured.Unstructured) (Result, error)
+}
+
+// CheckerFunc is a function that implements Checker.
+type CheckerFunc func(obj *unstructured.Unstructured) (Result, error)
+
+func (f CheckerFunc) Check(obj *unstructured.Unstructured) (Result, error) {
+	return f(obj)
+}
+
+// Checkers maps the GroupVersionKinds of release resources to the Checker
+// used for resources of that kind. Resources of a kind without a Checker are
+// considered healthy as long as they exist.
+type Checkers map[schema.GroupVersionKind]Checker
+
+// Check checks the health of obj with the Checker registered for its
+// GroupVersionKind. The second return value is false if no Checker is
+// registered.
+func (c Checkers) Check(obj *unstructured.Unstructured) (Result, bool, error) {
+	checker, ok := c[obj.GroupVersionKind()]
+	if !ok || checker == nil {
+		return Healthy(), false, nil
+	}
+	res, err := checker.Check(obj)
+	return res, true, err
+}
+
+// DefaultCheckers returns a new set of Checkers for the core Kubernetes
+// workload kinds.
+func DefaultCheckers() Checkers {
+	return Checkers{
+		{Group: "apps", Version: "v1", Kind: "Deployment"}:        CheckerFunc(checkDeployment),
+		{Group: "apps", Version: "v1", Kind: "StatefulSet"}:       CheckerFunc(checkStatefulSet),
+		{Group: "apps", Version: "v1", Kind: "DaemonSet"}:         CheckerFunc(checkDaemonSet),
+		{Group: "batch", Version: "v1", Kind: "Job"}:              CheckerFunc(checkJob),
+		{Group: "", Version: "v1", Kind: "Pod"}:                   CheckerFunc(checkPod),
+		{Group: "", Version: "v1", Kind: "PersistentVolumeClaim"}: CheckerFunc(checkPersistentVolumeClaim),
+		{Group: "", Version: "v1", Kind: "Service"}:               CheckerFunc(checkService),
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	. "github.com/joelanford/helm-operator/pkg/health"
+)
+
+var _ = Describe("Health", func() {
+	var checkers Checkers
+
+	BeforeEach(func() {
+		checkers = DefaultCheckers()
+	})
+
+	check := func(obj map[string]interface{}) Result {
+		res, found, err := checkers.Check(&unstructured.Unstructured{Object: obj})
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		return res
+	}
+
+	var _ = Describe("Checkers", func() {
+		It("should consider kinds without a checker healthy", func() {
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"})
+			res, found, err := checkers.Check(obj)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeFalse())
+			Expect(res).To(Equal(Healthy()))
+		})
+		It("should use a custom checker", func() {
+			gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Database"}
+			checkers[gvk] = CheckerFunc(func(*unstructured.Unstructured) (Result, error) {
+				return Degraded("custom"), nil
+			})
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(gvk)
+			res, found, err := checkers.Check(obj)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(res).To(Equal(Degraded("custom")))
+		})
+	})
+
+	var _ = Describe("Deployment", func() {
+		deployment := func(status map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "test", "generation": int64(2)},
+				"spec":       map[string]interface{}{"replicas": int64(2)},
+				"status":     status,
+			}
+		}
+		It("should be progressing when the spec update is not observed", func() {
+			Expect(check(deployment(map[string]interface{}{"observedGeneration": int64(1)})).Status).To(Equal(StatusProgressing))
+		})
+		It("should be progressing when replicas are not available", func() {
+			res := check(deployment(map[string]interface{}{
+				"observedGeneration": int64(2),
+				"replicas":           int64(2),
+				"updatedReplicas":    int64(2),
+				"availableReplicas":  int64(1),
+			}))
+			Expect(res).To(Equal(Progressing("1 of 2 updated replicas are available")))
+		})
+		It("should be degraded when the progress deadline is exceeded", func() {
+			res := check(deployment(map[string]interface{}{
+				"observedGeneration": int64(2),
+				"conditions": []interface{}{map[string]interface{}{
+					"type":    "Progressing",
+					"status":  "False",
+					"reason":  "ProgressDeadlineExceeded",
+					"message": "timed out",
+				}},
+			}))
+			Expect(res.Status).To(Equal(StatusDegraded))
+		})
+		It("should be healthy when rolled out", func() {
+			res := check(deployment(map[string]interface{}{
+				"observedGeneration": int64(2),
+				"replicas":           int64(2),
+				"updatedReplicas":    int64(2),
+				"availableReplicas":  int64(2),
+			}))
+			Expect(res).To(Equal(Healthy()))
+		})
+	})
+
+	var _ = Describe("StatefulSet", func() {
+		It("should be progressing until all replicas are updated", func() {
+			res := check(map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "StatefulSet",
+				"spec":       map[string]interface{}{"replicas": int64(1), "updateStrategy": map[string]interface{}{"type": "RollingUpdate"}},
+				"status": map[string]interface{}{
+					"readyReplicas":   int64(1),
+					"currentRevision": "a",
+					"updateRevision":  "b",
+				},
+			})
+			Expect(res.Status).To(Equal(StatusProgressing))
+		})
+	})
+
+	var _ = Describe("DaemonSet", func() {
+		It("should be healthy when all pods are updated and available", func() {
+			res := check(map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "DaemonSet",
+				"spec":       map[string]interface{}{"updateStrategy": map[string]interface{}{"type": "RollingUpdate"}},
+				"status": map[string]interface{}{
+					"desiredNumberScheduled": int64(3),
+					"updatedNumberScheduled": int64(3),
+					"numberAvailable":        int64(3),
+				},
+			})
+			Expect(res).To(Equal(Healthy()))
+		})
+	})
+
+	var _ = Describe("Job", func() {
+		job := func(condType string) map[string]interface{} {
+			return map[string]interface{}{
+				"apiVersion": "batch/v1",
+				"kind":       "Job",
+				"status": map[string]interface{}{
+					"conditions": []interface{}{map[string]interface{}{"type": condType, "status": "True", "message": "msg"}},
+				},
+			}
+		}
+		It("should be healthy when complete", func() {
+			Expect(check(job("Complete"))).To(Equal(Healthy()))
+		})
+		It("should be degraded when failed", func() {
+			Expect(check(job("Failed"))).To(Equal(Degraded("job failed: msg")))
+		})
+	})
+
+	var _ = Describe("Pod", func() {
+		It("should be progressing when pending", func() {
+			res := check(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"status":     map[string]interface{}{"phase": "Pending"},
+			})
+			Expect(res).To(Equal(Progressing("pod is Pending")))
+		})
+	})
+
+	var _ = Describe("PersistentVolumeClaim", func() {
+		It("should be healthy when bound", func() {
+			res := check(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "PersistentVolumeClaim",
+				"status":     map[string]interface{}{"phase": "Bound"},
+			})
+			Expect(res).To(Equal(Healthy()))
+		})
+	})
+
+	var _ = Describe("Service", func() {
+		It("should be progressing without load balancer ingress", func() {
+			res := check(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"spec":       map[string]interface{}{"type": "LoadBalancer"},
+			})
+			Expect(res.Status).To(Equal(StatusProgressing))
+		})
+	})
+})
@@ -23,14 +23,17 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	crtpredicate "sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/joelanford/helm-operator/pkg/diff"
 )
 
 var log = logf.Log.WithName("predicate")
 
 type GenerationChangedPredicate = crtpredicate.GenerationChangedPredicate
 
-// DependentPredicateFuncs returns functions defined for filtering events
-func DependentPredicateFuncs() crtpredicate.Funcs {
+// DependentPredicateFuncs returns functions defined for filtering events.
+// Updates that only change fields ignored by ignore are filtered.
+func DependentPredicateFuncs(ignore diff.IgnoreRules) crtpredicate.Funcs {
 	dependentPredicate := crtpredicate.Funcs{
 		// We don't need to reconcile dependent resource creation events
 		// because dependent resources are only ever created during
@@ -59,7 +62,9 @@ func DependentPredicateFuncs() crtpredicate.Funcs {
 		// Reconcile when a dependent resource is updated, so that it can
 		// be patched back to the resource managed by the CR, if
 		// necessary. Ignore updates that only change the status and
-		// resourceVersion.
+		// resourceVersion, or fields ignored by ignore. Changes to
+		// ignored fields also change the generation and managed fields,
+		// so those are disregarded as well when there are rules.
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			old := e.ObjectOld.(*unstructured.Unstructured).DeepCopy()
 			new := e.ObjectNew.(*unstructured.Unstructured).DeepCopy()
@@ -68,6 +73,13 @@ func DependentPredicateFuncs() crtpredicate.Funcs {
 			delete(new.Object, "status")
 			old.SetResourceVersion("")
 			new.SetResourceVersion("")
+			if len(ignore) > 0 {
+				for _, o := range []*unstructured.Unstructured{old, new} {
+					ignore.Strip(o)
+					o.SetGeneration(0)
+					o.SetManagedFields(nil)
+				}
+			}
 
 			if reflect.DeepEqual(old.Object, new.Object) {
 				return false
@@ -58,6 +58,10 @@ type Condition struct {
 	Reason             ConditionReason        `json:"reason,omitempty"`
 	Message            string                 `json:"message,omitempty"`
 	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+
+	// ObservedGeneration is the generation of the object when the condition
+	// was last set.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 // IsTrue Condition whether the condition status is "True".
@@ -131,7 +135,9 @@ func (conditions Conditions) IsUnknownFor(t ConditionType) bool {
 
 // SetCondition adds (or updates) the set of conditions with the given
 // condition. It returns a boolean value indicating whether the set condition
-// is new or was a change to the existing condition with the same type.
+// is new or was a change to the existing condition with the same type. If the
+// observed generation of the given condition is not set, the observed
+// generation of the existing condition is kept.
 func (conditions *Conditions) SetCondition(newCond Condition) bool {
 	newCond.LastTransitionTime = metav1.Time{Time: clock.Now()}
 
@@ -140,9 +146,13 @@ func (conditions *Conditions) SetCondition(newCond Condition) bool {
 			if condition.Status == newCond.Status {
 				newCond.LastTransitionTime = condition.LastTransitionTime
 			}
+			if newCond.ObservedGeneration == 0 {
+				newCond.ObservedGeneration = condition.ObservedGeneration
+			}
 			changed := condition.Status != newCond.Status ||
 				condition.Reason != newCond.Reason ||
-				condition.Message != newCond.Message
+				condition.Message != newCond.Message ||
+				condition.ObservedGeneration != newCond.ObservedGeneration
 			(*conditions)[i] = newCond
 			return changed
 		}
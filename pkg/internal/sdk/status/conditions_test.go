@@ -154,6 +154,33 @@ func TestConditionsSetExistsDifferentStatus(t *testing.T) {
 	assert.Equal(t, expectedCondition, *actualCondition)
 }
 
+func TestConditionsSetExistsDifferentObservedGeneration(t *testing.T) {
+	existingCondition := generateCondition("A", corev1.ConditionTrue)
+	existingCondition.ObservedGeneration = 1
+	conditions := initConditions(existingCondition)
+
+	setCondition := existingCondition
+	setCondition.ObservedGeneration = 2
+	assert.True(t, conditions.SetCondition(setCondition))
+
+	expectedCondition := withLastTransitionTime(setCondition, initTime)
+	actualCondition := conditions.GetCondition(expectedCondition.Type)
+	assert.Equal(t, expectedCondition, *actualCondition)
+}
+
+func TestConditionsSetKeepsObservedGeneration(t *testing.T) {
+	existingCondition := generateCondition("A", corev1.ConditionTrue)
+	existingCondition.ObservedGeneration = 1
+	conditions := initConditions(existingCondition)
+
+	setCondition := existingCondition
+	setCondition.ObservedGeneration = 0
+	assert.False(t, conditions.SetCondition(setCondition))
+
+	actualCondition := conditions.GetCondition(setCondition.Type)
+	assert.Equal(t, int64(1), actualCondition.ObservedGeneration)
+}
+
 func TestConditionsGetNotExists(t *testing.T) {
 	conditions := initConditions(generateCondition("A", corev1.ConditionTrue))
 
@@ -17,12 +17,21 @@ limitations under the License.
 package manager
 
 import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
+// NewDelegatingClientFunc returns a manager.NewClientFunc that creates a
+// client that reads objects from the cache and writes them to the API
+// server. Secrets are read from the API server, so that the cache does not
+// need to keep every Secret of the watched namespaces in memory.
 func NewDelegatingClientFunc() manager.NewClientFunc {
 	return func(cache cache.Cache, config *rest.Config, options client.Options) (client.Client, error) {
 		c, err := client.New(config, options)
@@ -30,9 +39,43 @@ func NewDelegatingClientFunc() manager.NewClientFunc {
 			return nil, err
 		}
 		return &client.DelegatingClient{
-			Reader:       cache,
+			Reader:       &uncachedSecretsReader{cache: cache, client: c},
 			Writer:       c,
 			StatusClient: c,
 		}, nil
 	}
 }
+
+// uncachedSecretsReader reads Secrets from client and all other objects
+// from cache.
+type uncachedSecretsReader struct {
+	cache  client.Reader
+	client client.Reader
+}
+
+func (r *uncachedSecretsReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if isSecretKind(obj) {
+		return r.client.Get(ctx, key, obj)
+	}
+	return r.cache.Get(ctx, key, obj)
+}
+
+func (r *uncachedSecretsReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	if isSecretKind(list) {
+		return r.client.List(ctx, list, opts...)
+	}
+	return r.cache.List(ctx, list, opts...)
+}
+
+// isSecretKind returns whether obj is a Secret or a SecretList, either typed
+// or unstructured.
+func isSecretKind(obj runtime.Object) bool {
+	switch o := obj.(type) {
+	case *corev1.Secret, *corev1.SecretList:
+		return true
+	case *unstructured.Unstructured, *unstructured.UnstructuredList:
+		gvk := o.GetObjectKind().GroupVersionKind()
+		return gvk.Group == "" && (gvk.Kind == "Secret" || gvk.Kind == "SecretList")
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUncachedSecretsReader(t *testing.T) {
+	// Secrets are only known to the API server, and ConfigMaps only to the
+	// cache, so every read fails unless it is delegated to the right reader.
+	r := &uncachedSecretsReader{
+		cache: fake.NewFakeClientWithScheme(scheme.Scheme, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "test"},
+		}),
+		client: fake.NewFakeClientWithScheme(scheme.Scheme, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "test"},
+		}),
+	}
+
+	unstructuredObj := func(kind string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kind))
+		return u
+	}
+	unstructuredList := func(kind string) *unstructured.UnstructuredList {
+		u := &unstructured.UnstructuredList{}
+		u.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kind))
+		return u
+	}
+
+	key := client.ObjectKey{Namespace: "ns", Name: "test"}
+	for _, tc := range []struct {
+		name string
+		obj  runtime.Object
+	}{
+		{name: "typed Secret", obj: &corev1.Secret{}},
+		{name: "unstructured Secret", obj: unstructuredObj("Secret")},
+		{name: "typed ConfigMap", obj: &corev1.ConfigMap{}},
+		{name: "unstructured ConfigMap", obj: unstructuredObj("ConfigMap")},
+	} {
+		if err := r.Get(context.TODO(), key, tc.obj); err != nil {
+			t.Errorf("get %s: %v", tc.name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		list runtime.Object
+	}{
+		{name: "typed SecretList", list: &corev1.SecretList{}},
+		{name: "unstructured SecretList", list: unstructuredList("SecretList")},
+		{name: "typed ConfigMapList", list: &corev1.ConfigMapList{}},
+		{name: "unstructured ConfigMapList", list: unstructuredList("ConfigMapList")},
+	} {
+		if err := r.List(context.TODO(), tc.list, client.InNamespace("ns")); err != nil {
+			t.Errorf("list %s: %v", tc.name, err)
+			continue
+		}
+		items, err := meta.ExtractList(tc.list)
+		if err != nil {
+			t.Fatalf("extract %s: %v", tc.name, err)
+		}
+		if len(items) != 1 {
+			t.Errorf("list %s: got %d items, want 1", tc.name, len(items))
+		}
+	}
+}
@@ -80,6 +80,20 @@ var _ = Describe("NewDelegatingClientFunc", func() {
 		wg.Wait()
 	})
 
+	It("should read Secrets uncached", func() {
+		c, err := cache.New(cfg, cache.Options{})
+		Expect(err).To(BeNil())
+
+		cl, err := NewDelegatingClientFunc()(c, cfg, client.Options{})
+		Expect(err).To(BeNil())
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"}}
+		Expect(cl.Create(context.TODO(), secret)).To(Succeed())
+		Expect(cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "secret"}, &v1.Secret{})).To(Succeed())
+		Expect(cl.List(context.TODO(), &v1.SecretList{}, client.InNamespace("default"))).To(Succeed())
+		Expect(cl.List(context.TODO(), &v1.PodList{}, client.InNamespace("default"))).To(BeAssignableToTypeOf(&cache.ErrCacheNotStarted{}))
+	})
+
 	It("should fail with an invalid config", func() {
 		clientFunc := NewDelegatingClientFunc()
 		Expect(clientFunc).NotTo(BeNil())
@@ -0,0 +1,265 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// defaultResync is the resync period of metadata informers if the cache
+// options do not set one. It matches the default of controller-runtime.
+const defaultResync = 10 * time.Hour
+
+// ReleaseSecretSelector selects the Secrets in which Helm stores release
+// records.
+var ReleaseSecretSelector = labels.SelectorFromSet(labels.Set{"owner": "helm"})
+
+// MetadataCache is a cache that also provides informers for the objects that
+// match a label selector. Unlike the informers of the cache, they only keep
+// the selected objects, or only their metadata, in memory.
+type MetadataCache interface {
+	cache.Cache
+
+	// GetMetadataInformer returns an informer for the metadata of the
+	// objects of gvk that match selector in the namespaces of the cache.
+	// The objects of its events are *metav1.PartialObjectMetadata.
+	GetMetadataInformer(gvk schema.GroupVersionKind, selector labels.Selector) (cache.Informer, error)
+
+	// GetSelectedInformer returns an informer for the objects of gvk that
+	// match selector in the namespaces of the cache. The objects of its
+	// events are *unstructured.Unstructured.
+	GetSelectedInformer(gvk schema.GroupVersionKind, selector labels.Selector) (SelectedInformer, error)
+}
+
+// SelectedInformer is an informer for the objects that match a label
+// selector.
+type SelectedInformer interface {
+	cache.Informer
+
+	// Get gets the object with key from the informer into obj. It returns
+	// a NotFound error if the informer has no such object, e.g. because the
+	// object does not match the selector.
+	Get(key types.NamespacedName, obj *unstructured.Unstructured) error
+}
+
+// NewMetadataCacheFunc returns a cache.NewCacheFunc that creates a
+// MetadataCache from the cache created by newCache. Metadata informers watch
+// namespaces, or the namespace of the cache options if namespaces is empty.
+func NewMetadataCacheFunc(newCache cache.NewCacheFunc, namespaces ...string) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		c, err := newCache(config, opts)
+		if err != nil {
+			return nil, err
+		}
+		client, err := metadata.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		nss := namespaces
+		if len(nss) == 0 {
+			nss = []string{opts.Namespace}
+		}
+		resync := defaultResync
+		if opts.Resync != nil {
+			resync = *opts.Resync
+		}
+		return &metadataCache{
+			Cache:         c,
+			client:        client,
+			dynamicClient: dynamicClient,
+			opts:          opts,
+			namespaces:    nss,
+			resync:        resync,
+			informers:     map[string]*multiNamespaceInformer{},
+		}, nil
+	}
+}
+
+var _ MetadataCache = &metadataCache{}
+
+type metadataCache struct {
+	cache.Cache
+	client        metadata.Interface
+	dynamicClient dynamic.Interface
+	opts          cache.Options
+	namespaces    []string
+	resync        time.Duration
+
+	mu        sync.Mutex
+	informers map[string]*multiNamespaceInformer
+	stop      <-chan struct{}
+}
+
+func (c *metadataCache) GetMetadataInformer(gvk schema.GroupVersionKind, selector labels.Selector) (cache.Informer, error) {
+	return c.getInformer("metadata", gvk, selector, func(gvr schema.GroupVersionResource, ns string, tweak dynamicinformer.TweakListOptionsFunc) toolscache.SharedIndexInformer {
+		return metadatainformer.NewFilteredMetadataInformer(c.client, gvr, ns, c.resync, toolscache.Indexers{}, metadatainformer.TweakListOptionsFunc(tweak)).Informer()
+	})
+}
+
+func (c *metadataCache) GetSelectedInformer(gvk schema.GroupVersionKind, selector labels.Selector) (SelectedInformer, error) {
+	return c.getInformer("unstructured", gvk, selector, func(gvr schema.GroupVersionResource, ns string, tweak dynamicinformer.TweakListOptionsFunc) toolscache.SharedIndexInformer {
+		return dynamicinformer.NewFilteredDynamicInformer(c.dynamicClient, gvr, ns, c.resync, toolscache.Indexers{}, tweak).Informer()
+	})
+}
+
+// getInformer returns the informer for the objects of gvk that match
+// selector, which newInformer creates for each namespace of the cache, or
+// once for cluster-scoped kinds, if the cache has no such informer yet.
+func (c *metadataCache) getInformer(typ string, gvk schema.GroupVersionKind, selector labels.Selector, newInformer func(schema.GroupVersionResource, string, dynamicinformer.TweakListOptionsFunc) toolscache.SharedIndexInformer) (*multiNamespaceInformer, error) {
+	key := fmt.Sprintf("%s;%s;%s", typ, gvk, selector)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if inf, ok := c.informers[key]; ok {
+		return inf, nil
+	}
+
+	mapping, err := c.opts.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	// Cluster-scoped objects are not in any namespace, so a single informer
+	// watches them across the cluster.
+	namespaces := c.namespaces
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	inf := &multiNamespaceInformer{resource: mapping.Resource.GroupResource()}
+	for _, ns := range namespaces {
+		inf.informers = append(inf.informers, newInformer(mapping.Resource, ns, func(o *metav1.ListOptions) {
+			o.LabelSelector = selector.String()
+		}))
+	}
+	c.informers[key] = inf
+
+	// Informers that are requested after the cache was started are started
+	// right away.
+	if c.stop != nil {
+		inf.run(c.stop)
+	}
+	return inf, nil
+}
+
+func (c *metadataCache) Start(stop <-chan struct{}) error {
+	c.mu.Lock()
+	c.stop = stop
+	for _, inf := range c.informers {
+		inf.run(stop)
+	}
+	c.mu.Unlock()
+	return c.Cache.Start(stop)
+}
+
+func (c *metadataCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	if !c.Cache.WaitForCacheSync(stop) {
+		return false
+	}
+	c.mu.Lock()
+	var synced []toolscache.InformerSynced
+	for _, inf := range c.informers {
+		synced = append(synced, inf.HasSynced)
+	}
+	c.mu.Unlock()
+	return toolscache.WaitForCacheSync(stop, synced...)
+}
+
+var _ SelectedInformer = &multiNamespaceInformer{}
+
+// multiNamespaceInformer combines the informers of several namespaces.
+type multiNamespaceInformer struct {
+	resource  schema.GroupResource
+	informers []toolscache.SharedIndexInformer
+}
+
+func (i *multiNamespaceInformer) Get(key types.NamespacedName, obj *unstructured.Unstructured) error {
+	storeKey := key.Name
+	if key.Namespace != "" {
+		storeKey = key.String()
+	}
+	for _, inf := range i.informers {
+		item, exists, err := inf.GetStore().GetByKey(storeKey)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		u, ok := item.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("informer of %s does not store unstructured objects", i.resource)
+		}
+		u.DeepCopyInto(obj)
+		return nil
+	}
+	return apierrors.NewNotFound(i.resource, key.Name)
+}
+
+func (i *multiNamespaceInformer) run(stop <-chan struct{}) {
+	for _, inf := range i.informers {
+		go inf.Run(stop)
+	}
+}
+
+func (i *multiNamespaceInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	for _, inf := range i.informers {
+		inf.AddEventHandler(handler)
+	}
+}
+
+func (i *multiNamespaceInformer) AddEventHandlerWithResyncPeriod(handler toolscache.ResourceEventHandler, resyncPeriod time.Duration) {
+	for _, inf := range i.informers {
+		inf.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+	}
+}
+
+func (i *multiNamespaceInformer) AddIndexers(indexers toolscache.Indexers) error {
+	for _, inf := range i.informers {
+		if err := inf.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *multiNamespaceInformer) HasSynced() bool {
+	for _, inf := range i.informers {
+		if !inf.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	. "github.com/joelanford/helm-operator/pkg/manager"
+)
+
+var _ = Describe("NewMetadataCacheFunc", func() {
+	It("should inform about the metadata of selected objects in the watched namespaces", func() {
+		cl, err := client.New(cfg, client.Options{})
+		Expect(err).To(BeNil())
+
+		By("creating release and other secrets in a watched and an unwatched namespace")
+		var namespaces []string
+		for i := 0; i < 2; i++ {
+			ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("metadata-%s", rand.String(5))}}
+			Expect(cl.Create(context.TODO(), ns)).To(Succeed())
+			namespaces = append(namespaces, ns.Name)
+			for _, s := range []*v1.Secret{
+				{ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: ns.Name, Labels: map[string]string{"owner": "helm"}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: ns.Name}},
+			} {
+				Expect(cl.Create(context.TODO(), s)).To(Succeed())
+			}
+		}
+
+		By("getting a metadata informer for release secrets")
+		rm, err := apiutil.NewDynamicRESTMapper(cfg)
+		Expect(err).To(BeNil())
+		c, err := NewMetadataCacheFunc(cache.New, namespaces[0])(cfg, cache.Options{Mapper: rm})
+		Expect(err).To(BeNil())
+		mc, ok := c.(MetadataCache)
+		Expect(ok).To(BeTrue())
+		inf, err := mc.GetMetadataInformer(v1.SchemeGroupVersion.WithKind("Secret"), ReleaseSecretSelector)
+		Expect(err).To(BeNil())
+
+		var (
+			m     sync.Mutex
+			added []string
+		)
+		inf.AddEventHandler(toolscache.ResourceEventHandlerFuncs{AddFunc: func(obj interface{}) {
+			m.Lock()
+			defer m.Unlock()
+			o := obj.(*metav1.PartialObjectMetadata)
+			added = append(added, o.Namespace+"/"+o.Name)
+		}})
+
+		By("starting the cache and waiting for it to sync")
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			Expect(c.Start(done)).To(Succeed())
+			wg.Done()
+		}()
+		Expect(c.WaitForCacheSync(done)).To(BeTrue())
+
+		By("verifying only the release secret of the watched namespace was added")
+		Eventually(func() []string {
+			m.Lock()
+			defer m.Unlock()
+			return append([]string(nil), added...)
+		}).Should(ConsistOf(namespaces[0] + "/release"))
+		close(done)
+		wg.Wait()
+	})
+
+	It("should get selected objects from their informers", func() {
+		cl, err := client.New(cfg, client.Options{})
+		Expect(err).To(BeNil())
+
+		By("creating a labeled and an unlabeled config map")
+		ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("selected-%s", rand.String(5))}}
+		Expect(cl.Create(context.TODO(), ns)).To(Succeed())
+		for _, cm := range []*v1.ConfigMap{
+			{ObjectMeta: metav1.ObjectMeta{Name: "labeled", Namespace: ns.Name, Labels: map[string]string{"app": "test"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled", Namespace: ns.Name}},
+		} {
+			Expect(cl.Create(context.TODO(), cm)).To(Succeed())
+		}
+
+		By("starting the cache after getting an informer for labeled config maps")
+		rm, err := apiutil.NewDynamicRESTMapper(cfg)
+		Expect(err).To(BeNil())
+		c, err := NewMetadataCacheFunc(cache.New, ns.Name)(cfg, cache.Options{Mapper: rm})
+		Expect(err).To(BeNil())
+		inf, err := c.(MetadataCache).GetSelectedInformer(v1.SchemeGroupVersion.WithKind("ConfigMap"), labels.SelectorFromSet(labels.Set{"app": "test"}))
+		Expect(err).To(BeNil())
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			Expect(c.Start(done)).To(Succeed())
+			wg.Done()
+		}()
+		Expect(c.WaitForCacheSync(done)).To(BeTrue())
+
+		By("verifying only the labeled config map can be read")
+		u := &unstructured.Unstructured{}
+		Expect(inf.Get(types.NamespacedName{Namespace: ns.Name, Name: "labeled"}, u)).To(Succeed())
+		Expect(u.GetKind()).To(Equal("ConfigMap"))
+		Expect(u.GetLabels()).To(HaveKeyWithValue("app", "test"))
+		err = inf.Get(types.NamespacedName{Namespace: ns.Name, Name: "unlabeled"}, &unstructured.Unstructured{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		close(done)
+		wg.Wait()
+	})
+
+	It("should get selected cluster-scoped objects of caches of namespaces", func() {
+		cl, err := client.New(cfg, client.Options{})
+		Expect(err).To(BeNil())
+
+		By("creating a labeled cluster role")
+		name := fmt.Sprintf("selected-%s", rand.String(5))
+		role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"app": "test"}}}
+		Expect(cl.Create(context.TODO(), role)).To(Succeed())
+
+		By("starting a cache of a namespace after getting an informer for labeled cluster roles")
+		rm, err := apiutil.NewDynamicRESTMapper(cfg)
+		Expect(err).To(BeNil())
+		c, err := NewMetadataCacheFunc(cache.New, "default")(cfg, cache.Options{Mapper: rm})
+		Expect(err).To(BeNil())
+		inf, err := c.(MetadataCache).GetSelectedInformer(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), labels.SelectorFromSet(labels.Set{"app": "test"}))
+		Expect(err).To(BeNil())
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			Expect(c.Start(done)).To(Succeed())
+			wg.Done()
+		}()
+		Expect(c.WaitForCacheSync(done)).To(BeTrue())
+
+		By("verifying the cluster role can be read")
+		u := &unstructured.Unstructured{}
+		Expect(inf.Get(types.NamespacedName{Name: name}, u)).To(Succeed())
+		Expect(u.GetKind()).To(Equal("ClusterRole"))
+		close(done)
+		wg.Wait()
+	})
+})
@@ -30,19 +30,24 @@ const (
 	WatchNamespaceEnvVar = "WATCH_NAMESPACE"
 )
 
+// ConfigureWatchNamespaces configures the cache of options to watch the
+// namespaces in the WATCH_NAMESPACE environment variable, or all namespaces
+// if it is not set. The cache is a MetadataCache.
 func ConfigureWatchNamespaces(options *manager.Options, log logr.Logger) {
 	namespaces := lookupEnv()
 	if len(namespaces) != 0 {
 		log.Info("watching namespaces", "namespaces", namespaces)
 		if len(namespaces) > 1 {
-			options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+			options.NewCache = NewMetadataCacheFunc(cache.MultiNamespacedCacheBuilder(namespaces), namespaces...)
 		} else {
 			options.Namespace = namespaces[0]
+			options.NewCache = NewMetadataCacheFunc(cache.New)
 		}
 		return
 	}
 	log.Info("watching all namespaces")
 	options.Namespace = v1.NamespaceAll
+	options.NewCache = NewMetadataCacheFunc(cache.New)
 }
 
 func lookupEnv() []string {
@@ -51,21 +51,21 @@ var _ = Describe("ConfigureWatchNamespaces", func() {
 	It("should watch all namespaces when no env set", func() {
 		ConfigureWatchNamespaces(&opts, log)
 		Expect(opts.Namespace).To(Equal(""))
-		Expect(opts.NewCache).To(BeNil())
+		Expect(opts.NewCache).NotTo(BeNil())
 	})
 
 	It("should watch all namespaces when WATCH_NAMESPACE is empty", func() {
 		Expect(os.Setenv(WatchNamespaceEnvVar, ""))
 		ConfigureWatchNamespaces(&opts, log)
 		Expect(opts.Namespace).To(Equal(""))
-		Expect(opts.NewCache).To(BeNil())
+		Expect(opts.NewCache).NotTo(BeNil())
 	})
 
 	It("should watch one namespace when WATCH_NAMESPACE is has one namespace", func() {
 		Expect(os.Setenv(WatchNamespaceEnvVar, "watch"))
 		ConfigureWatchNamespaces(&opts, log)
 		Expect(opts.Namespace).To(Equal("watch"))
-		Expect(opts.NewCache).To(BeNil())
+		Expect(opts.NewCache).NotTo(BeNil())
 	})
 
 	It("should watch multiple namespaces when WATCH_NAMESPACE has multiple namespaces", func() {
@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/joelanford/helm-operator/pkg/internal/sdk/status"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/conditions"
+	"github.com/joelanford/helm-operator/pkg/values"
+)
+
+const (
+	errorClassPermanent = "permanent"
+	errorClassTransient = "transient"
+)
+
+// permanentError is an error that recurs until the custom resource changes,
+// such as a template error or a schema violation. Reconciliation of a custom
+// resource is not retried after a permanent error until its generation, its
+// annotations, or its values change.
+type permanentError struct {
+	reason status.ConditionReason
+	err    error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func permanent(reason status.ConditionReason, err error) error {
+	return &permanentError{reason: reason, err: err}
+}
+
+// Messages of Helm errors that are not typed. Helm returns errors rendering
+// templates, validating values and validating manifests as plain errors, and
+// it joins errors updating resources into a single message, which drops the
+// API errors. These messages are only matched if err has no typed cause.
+var (
+	renderErrorMessages = []string{
+		"parse error at (",
+		"parse error in (",
+		"execution error at (",
+		"execution error in (",
+		"rendering template failed",
+		"rendering template has a nested reference",
+		"YAML parse error on",
+		"error converting YAML to JSON",
+		"chart requires kubeVersion",
+	}
+	invalidValuesMessage   = "values don't meet the specifications of the schema"
+	invalidResourceMessage = "error validating data"
+)
+
+// classifyError returns err as a permanent error if it recurs until the
+// custom resource changes. Any other error is transient.
+//
+// Schema errors of the values and API errors that reject a request as invalid
+// are detected by their types. Errors that Helm only returns as text are
+// detected by their messages.
+func classifyError(err error) error {
+	if err == nil || errors.As(err, new(*permanentError)) {
+		return err
+	}
+	if errors.As(err, new(values.SchemaErrors)) {
+		return permanent(conditions.ReasonInvalidValues, err)
+	}
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) && isInvalid(apiStatus.Status()) {
+		if isImmutableField(apiStatus.Status()) {
+			return permanent(conditions.ReasonImmutableField, err)
+		}
+		return permanent(conditions.ReasonInvalidResource, err)
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, apivalidation.FieldImmutableErrorMsg):
+		return permanent(conditions.ReasonImmutableField, err)
+	case strings.Contains(msg, invalidValuesMessage):
+		return permanent(conditions.ReasonInvalidValues, err)
+	case containsAny(msg, renderErrorMessages):
+		return permanent(conditions.ReasonRenderError, err)
+	case strings.Contains(msg, invalidResourceMessage):
+		return permanent(conditions.ReasonInvalidResource, err)
+	}
+	return err
+}
+
+// errorClass returns the class of err for metrics.
+func errorClass(err error) string {
+	if errors.As(err, new(*permanentError)) {
+		return errorClassPermanent
+	}
+	return errorClassTransient
+}
+
+// errorReason returns the reason of a permanent error, or reason if err is
+// transient.
+func errorReason(err error, reason status.ConditionReason) status.ConditionReason {
+	var pErr *permanentError
+	if errors.As(err, &pErr) {
+		return pErr.reason
+	}
+	return reason
+}
+
+// isInvalid returns whether s is the status of an API error that rejects a
+// request as invalid.
+func isInvalid(s metav1.Status) bool {
+	switch s.Reason {
+	case metav1.StatusReasonInvalid, metav1.StatusReasonBadRequest:
+		return true
+	}
+	return false
+}
+
+// isImmutableField returns whether s is the status of an API error that
+// rejects a change of an immutable field. The API server reports such changes
+// as invalid field values with a well-known message.
+func isImmutableField(s metav1.Status) bool {
+	if s.Details == nil {
+		return false
+	}
+	for _, c := range s.Details.Causes {
+		if c.Type == metav1.CauseTypeFieldValueInvalid && strings.Contains(c.Message, apivalidation.FieldImmutableErrorMsg) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter returns the delay before retrying after err that is suggested
+// by the API server, if any.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *apierrors.StatusError
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+	seconds, ok := apierrors.SuggestsClientDelay(apiErr)
+	if !ok || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// inputsChecksum returns a checksum of the annotations of obj and its values.
+// Along with the generation of obj, it determines whether a permanent error
+// of obj is still current.
+func inputsChecksum(obj *unstructured.Unstructured, vals map[string]interface{}) string {
+	data, err := json.Marshal([]interface{}{obj.GetAnnotations(), vals})
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/conditions"
+	"github.com/joelanford/helm-operator/pkg/values"
+)
+
+var _ = Describe("classifyError", func() {
+	gk := schema.GroupKind{Group: "apps", Kind: "Deployment"}
+
+	It("should classify template errors as permanent", func() {
+		err := classifyError(errors.New(`parse error at (chart/templates/service.yaml:4): function "foo" not defined`))
+		Expect(errorClass(err)).To(Equal(errorClassPermanent))
+		Expect(errorReason(err, conditions.ReasonReconcileError)).To(Equal(conditions.ReasonRenderError))
+	})
+
+	It("should classify immutable field conflicts as permanent", func() {
+		immutable := apierrors.NewInvalid(gk, "test", field.ErrorList{field.Invalid(field.NewPath("spec", "selector"), "", "field is immutable")})
+		err := classifyError(fmt.Errorf("cannot patch \"test\" with kind Deployment: %w", immutable))
+		Expect(errorReason(err, conditions.ReasonReconcileError)).To(Equal(conditions.ReasonImmutableField))
+	})
+
+	It("should classify immutable field conflicts that Helm only reports as text as permanent", func() {
+		err := classifyError(errors.New(`cannot patch "test" with kind Job: Job.batch "test" is invalid: spec.template: Invalid value: "": field is immutable`))
+		Expect(errorReason(err, conditions.ReasonReconcileError)).To(Equal(conditions.ReasonImmutableField))
+	})
+
+	It("should classify wrapped invalid API errors as permanent", func() {
+		invalid := apierrors.NewInvalid(gk, "test", field.ErrorList{field.Required(field.NewPath("spec", "selector"), "")})
+		err := classifyError(fmt.Errorf("failed to create resource: %w", invalid))
+		Expect(errorReason(err, conditions.ReasonReconcileError)).To(Equal(conditions.ReasonInvalidResource))
+	})
+
+	It("should classify schema errors of the values as permanent", func() {
+		err := classifyError(fmt.Errorf("validate values: %w", values.SchemaErrors{{Path: "replicas", Description: "Invalid type"}}))
+		Expect(errorReason(err, conditions.ReasonReconcileError)).To(Equal(conditions.ReasonInvalidValues))
+	})
+
+	It("should keep the reason of permanent errors", func() {
+		err := classifyError(fmt.Errorf("wrapped: %w", permanent(conditions.ReasonInvalidValues, errors.New("replicas: invalid type"))))
+		Expect(errorReason(err, conditions.ReasonReconcileError)).To(Equal(conditions.ReasonInvalidValues))
+	})
+
+	It("should classify other errors as transient", func() {
+		for _, err := range []error{
+			errors.New("timed out waiting for the condition"),
+			apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "test", errors.New("modified")),
+			apierrors.NewServerTimeout(schema.GroupResource{Group: "apps", Resource: "deployments"}, "get", 2),
+		} {
+			err = classifyError(err)
+			Expect(errorClass(err)).To(Equal(errorClassTransient), err.Error())
+			Expect(errorReason(err, conditions.ReasonReconcileError)).To(Equal(conditions.ReasonReconcileError))
+		}
+	})
+})
+
+var _ = Describe("retryAfter", func() {
+	It("should return the delay suggested by the API server", func() {
+		err := fmt.Errorf("get: %w", apierrors.NewServerTimeout(schema.GroupResource{Resource: "secrets"}, "get", 3))
+		d, ok := retryAfter(err)
+		Expect(ok).To(BeTrue())
+		Expect(d).To(Equal(3 * time.Second))
+	})
+
+	It("should not return a delay for other errors", func() {
+		_, ok := retryAfter(apierrors.NewConflict(schema.GroupResource{Resource: "secrets"}, "test", errors.New("modified")))
+		Expect(ok).To(BeFalse())
+		_, ok = retryAfter(errors.New("error"))
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("inputsChecksum", func() {
+	It("should change with the annotations and values", func() {
+		obj := &unstructured.Unstructured{}
+		vals := map[string]interface{}{"replicas": 1}
+		sum := inputsChecksum(obj, vals)
+		Expect(inputsChecksum(obj, map[string]interface{}{"replicas": 1})).To(Equal(sum))
+		Expect(inputsChecksum(obj, map[string]interface{}{"replicas": 2})).NotTo(Equal(sum))
+		obj.SetAnnotations(map[string]string{"a": "b"})
+		Expect(inputsChecksum(obj, vals)).NotTo(Equal(sum))
+	})
+})
@@ -29,18 +29,44 @@ const (
 	TypeDeployed       = "Deployed"
 	TypeReleaseFailed  = "ReleaseFailed"
 	TypeIrreconcilable = "Irreconcilable"
+	TypeReady          = "Ready"
+	TypeDrifted        = "Drifted"
+	TypePaused         = "Paused"
 
 	ReasonInstallSuccessful   = status.ConditionReason("InstallSuccessful")
 	ReasonUpgradeSuccessful   = status.ConditionReason("UpgradeSuccessful")
 	ReasonUninstallSuccessful = status.ConditionReason("UninstallSuccessful")
 
-	ReasonErrorGettingClient       = status.ConditionReason("ErrorGettingClient")
-	ReasonErrorGettingValues       = status.ConditionReason("ErrorGettingValues")
-	ReasonErrorGettingReleaseState = status.ConditionReason("ErrorGettingReleaseState")
-	ReasonInstallError             = status.ConditionReason("InstallError")
-	ReasonUpgradeError             = status.ConditionReason("UpgradeError")
-	ReasonReconcileError           = status.ConditionReason("ReconcileError")
-	ReasonUninstallError           = status.ConditionReason("UninstallError")
+	ReasonResourcesReady       = status.ConditionReason("ResourcesReady")
+	ReasonResourcesProgressing = status.ConditionReason("ResourcesProgressing")
+	ReasonResourcesDegraded    = status.ConditionReason("ResourcesDegraded")
+
+	ReasonDriftCorrected = status.ConditionReason("DriftCorrected")
+	ReasonDriftDetected  = status.ConditionReason("DriftDetected")
+	ReasonDriftConflict  = status.ConditionReason("DriftConflict")
+
+	ReasonReconcilePaused = status.ConditionReason("ReconcilePaused")
+
+	ReasonErrorGettingReleaseTarget = status.ConditionReason("ErrorGettingReleaseTarget")
+	ReasonErrorGettingClient        = status.ConditionReason("ErrorGettingClient")
+	ReasonErrorGettingValues        = status.ConditionReason("ErrorGettingValues")
+	ReasonInvalidValues             = status.ConditionReason("InvalidValues")
+	ReasonErrorGettingReleaseState  = status.ConditionReason("ErrorGettingReleaseState")
+	ReasonInstallError              = status.ConditionReason("InstallError")
+	ReasonUpgradeError              = status.ConditionReason("UpgradeError")
+	ReasonReconcileError            = status.ConditionReason("ReconcileError")
+	ReasonUninstallError            = status.ConditionReason("UninstallError")
+	ReasonRollbackError             = status.ConditionReason("RollbackError")
+	ReasonChartVersionNotAllowed    = status.ConditionReason("ChartVersionNotAllowed")
+	ReasonErrorCheckingReadiness    = status.ConditionReason("ErrorCheckingReadiness")
+
+	// Reasons of permanent errors. InvalidValues and ChartVersionNotAllowed
+	// are permanent errors, too.
+	ReasonRenderError     = status.ConditionReason("RenderError")
+	ReasonInvalidResource = status.ConditionReason("InvalidResource")
+	ReasonImmutableField  = status.ConditionReason("ImmutableField")
+
+	ReasonInvalidRemediation = status.ConditionReason("InvalidRemediation")
 )
 
 func Initialized(stat corev1.ConditionStatus, reason status.ConditionReason, message interface{}) status.Condition {
@@ -59,6 +85,18 @@ func Irreconcilable(stat corev1.ConditionStatus, reason status.ConditionReason,
 	return newCondition(TypeIrreconcilable, stat, reason, message)
 }
 
+func Ready(stat corev1.ConditionStatus, reason status.ConditionReason, message interface{}) status.Condition {
+	return newCondition(TypeReady, stat, reason, message)
+}
+
+func Drifted(stat corev1.ConditionStatus, reason status.ConditionReason, message interface{}) status.Condition {
+	return newCondition(TypeDrifted, stat, reason, message)
+}
+
+func Paused(stat corev1.ConditionStatus, reason status.ConditionReason, message interface{}) status.Condition {
+	return newCondition(TypePaused, stat, reason, message)
+}
+
 func newCondition(t status.ConditionType, s corev1.ConditionStatus, r status.ConditionReason, m interface{}) status.Condition {
 	message := fmt.Sprintf("%s", m)
 	return status.Condition{
@@ -65,6 +65,42 @@ var _ = Describe("Conditions", func() {
 		})
 	})
 
+	var _ = Describe("Ready", func() {
+		It("should return a Ready condition with the correct status, reason, and message", func() {
+			e := status.Condition{
+				Type:    TypeReady,
+				Status:  corev1.ConditionFalse,
+				Reason:  ReasonResourcesProgressing,
+				Message: "message",
+			}
+			Expect(Ready(e.Status, e.Reason, e.Message)).To(Equal(e))
+		})
+	})
+
+	var _ = Describe("Drifted", func() {
+		It("should return a Drifted condition with the correct status, reason, and message", func() {
+			e := status.Condition{
+				Type:    TypeDrifted,
+				Status:  corev1.ConditionTrue,
+				Reason:  ReasonDriftDetected,
+				Message: "message",
+			}
+			Expect(Drifted(e.Status, e.Reason, e.Message)).To(Equal(e))
+		})
+	})
+
+	var _ = Describe("Paused", func() {
+		It("should return a Paused condition with the correct status, reason, and message", func() {
+			e := status.Condition{
+				Type:    TypePaused,
+				Status:  corev1.ConditionTrue,
+				Reason:  ReasonReconcilePaused,
+				Message: "message",
+			}
+			Expect(Paused(e.Status, e.Reason, e.Message)).To(Equal(e))
+		})
+	})
+
 	var _ = Describe("Irreconcilable", func() {
 		It("should return an Irreconcilable condition with the correct message", func() {
 			err := errors.New("error message")
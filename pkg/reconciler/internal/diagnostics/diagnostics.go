@@ -0,0 +1,374 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diagnostics summarizes why the resources of a failed release did
+// not become ready.
+package diagnostics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Limits of a Summary, which keep the status of a custom resource small.
+const (
+	MaxPods          = 5
+	MaxEvents        = 10
+	MaxHooks         = 5
+	MaxMessageLength = 256
+	MaxSummaryLength = 1024
+)
+
+// Summary is a bounded summary of diagnostics about the resources of a
+// failed release.
+type Summary struct {
+	// ReleaseVersion is the version of the failed release.
+	ReleaseVersion int `json:"releaseVersion"`
+
+	Pods   []Pod   `json:"pods,omitempty"`
+	Events []Event `json:"events,omitempty"`
+	Hooks  []Hook  `json:"hooks,omitempty"`
+}
+
+// Pod is a failing container of a pod of the release. If the pod failed
+// before its containers were created, e.g. because it could not be
+// scheduled, Container is empty.
+type Pod struct {
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	Container    string `json:"container,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Message      string `json:"message,omitempty"`
+	RestartCount int32  `json:"restartCount,omitempty"`
+}
+
+// Event is a recent warning event of a resource of the release.
+type Event struct {
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace,omitempty"`
+	Name      string      `json:"name"`
+	Reason    string      `json:"reason,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Count     int32       `json:"count,omitempty"`
+	LastSeen  metav1.Time `json:"lastSeen"`
+}
+
+// Hook is a failed Helm hook of the release.
+type Hook struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Empty returns whether s has no diagnostics.
+func (s *Summary) Empty() bool {
+	return len(s.Pods) == 0 && len(s.Events) == 0 && len(s.Hooks) == 0
+}
+
+// String returns a one-line summary of s of at most MaxSummaryLength bytes.
+func (s *Summary) String() string {
+	var msgs []string
+	for _, h := range s.Hooks {
+		msgs = append(msgs, fmt.Sprintf("hook %s %s: %s", h.Kind, h.Name, reasonMessage(h.Reason, h.Message)))
+	}
+	for _, p := range s.Pods {
+		name := p.Namespace + "/" + p.Name
+		if p.Container != "" {
+			name += " container " + p.Container
+		}
+		msgs = append(msgs, fmt.Sprintf("pod %s: %s", name, reasonMessage(p.Reason, p.Message)))
+	}
+	for _, e := range s.Events {
+		msgs = append(msgs, fmt.Sprintf("event %s %s: %s", e.Kind, e.Name, reasonMessage(e.Reason, e.Message)))
+	}
+	return truncate(strings.Join(msgs, "; "), MaxSummaryLength)
+}
+
+// Collect gathers diagnostics about the resources and hooks of rel: failing
+// containers of the pods of its workloads, failed hook Jobs, and warning
+// events of its resources since the given time. It only reads the
+// namespaces of the resources of rel.
+func Collect(ctx context.Context, c client.Reader, rel *release.Release, since time.Time) (*Summary, error) {
+	s := &Summary{ReleaseVersion: rel.Version}
+	objs, err := objects(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	// Events of pods and replica sets are matched by the prefix of their
+	// names, since those objects may already have been deleted.
+	involved := map[string]struct{}{}
+	var prefixes []string
+	for _, o := range objs {
+		involved[o.GetKind()+"/"+o.GetNamespace()+"/"+o.GetName()] = struct{}{}
+		if isWorkload(o) {
+			prefixes = append(prefixes, o.GetNamespace()+"/"+o.GetName()+"-")
+		}
+	}
+
+	for _, o := range objs {
+		if !isWorkload(o) {
+			continue
+		}
+		pods, err := podsOf(ctx, c, o)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pods {
+			s.Pods = append(s.Pods, podFailures(&pods[i])...)
+		}
+	}
+	if len(s.Pods) > MaxPods {
+		s.Pods = s.Pods[:MaxPods]
+	}
+
+	for _, h := range rel.Hooks {
+		if h.LastRun.Phase != release.HookPhaseFailed {
+			continue
+		}
+		hook := Hook{Kind: h.Kind, Name: h.Name}
+		if h.Kind == "Job" {
+			obj := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal([]byte(h.Manifest), obj); err != nil {
+				return nil, err
+			}
+			namespace := obj.GetNamespace()
+			if namespace == "" {
+				namespace = rel.Namespace
+			}
+			hook.Reason, hook.Message, err = jobFailure(ctx, c, namespace, h.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.Hooks = append(s.Hooks, hook)
+		if len(s.Hooks) == MaxHooks {
+			break
+		}
+	}
+
+	namespaces := map[string]struct{}{}
+	for _, o := range objs {
+		if o.GetNamespace() != "" {
+			namespaces[o.GetNamespace()] = struct{}{}
+		}
+	}
+	for ns := range namespaces {
+		events := &corev1.EventList{}
+		if err := c.List(ctx, events, client.InNamespace(ns), client.MatchingFields{"type": corev1.EventTypeWarning}); err != nil {
+			return nil, err
+		}
+		for _, e := range events.Items {
+			lastSeen := eventTime(&e)
+			if e.Type != corev1.EventTypeWarning || lastSeen.Before(since) {
+				continue
+			}
+			ref := e.InvolvedObject
+			key := ref.Namespace + "/" + ref.Name
+			if _, ok := involved[ref.Kind+"/"+key]; !ok && !hasAnyPrefix(key, prefixes) {
+				continue
+			}
+			s.Events = append(s.Events, Event{
+				Kind:      ref.Kind,
+				Namespace: ref.Namespace,
+				Name:      ref.Name,
+				Reason:    e.Reason,
+				Message:   truncate(e.Message, MaxMessageLength),
+				Count:     e.Count,
+				LastSeen:  metav1.NewTime(lastSeen),
+			})
+		}
+	}
+	sort.SliceStable(s.Events, func(i, j int) bool {
+		return s.Events[j].LastSeen.Before(&s.Events[i].LastSeen)
+	})
+	if len(s.Events) > MaxEvents {
+		s.Events = s.Events[:MaxEvents]
+	}
+	return s, nil
+}
+
+// objects returns the resources and hooks of rel. Their namespace defaults
+// to the namespace of rel.
+func objects(rel *release.Release) ([]*unstructured.Unstructured, error) {
+	manifests := make([]string, 0, len(rel.Hooks)+1)
+	for _, m := range releaseutil.SplitManifests(rel.Manifest) {
+		manifests = append(manifests, m)
+	}
+	for _, h := range rel.Hooks {
+		manifests = append(manifests, h.Manifest)
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, m := range manifests {
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(m), obj); err != nil {
+			return nil, err
+		}
+		if obj.GroupVersionKind().Empty() {
+			continue
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(rel.Namespace)
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+func isWorkload(obj *unstructured.Unstructured) bool {
+	switch obj.GroupVersionKind().GroupKind().String() {
+	case "Deployment.apps", "StatefulSet.apps", "DaemonSet.apps", "ReplicaSet.apps", "Job.batch", "Pod":
+		return true
+	}
+	return false
+}
+
+// podsOf returns the pods of the workload obj, which are selected by the
+// labels of its pod template.
+func podsOf(ctx context.Context, c client.Reader, obj *unstructured.Unstructured) ([]corev1.Pod, error) {
+	if obj.GetKind() == "Pod" {
+		pod := corev1.Pod{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: obj.GetName()}, &pod)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return []corev1.Pod{pod}, err
+	}
+	podLabels, _, err := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+	if err != nil || len(podLabels) == 0 {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(obj.GetNamespace()), client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(podLabels)}); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// podFailures returns the failing containers of pod. If none of its
+// containers fail, it returns why the pod failed or is not scheduled, if it
+// did.
+func podFailures(pod *corev1.Pod) []Pod {
+	var failures []Pod
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		p := Pod{Namespace: pod.Namespace, Name: pod.Name, Container: cs.Name, RestartCount: cs.RestartCount}
+		switch {
+		case cs.State.Waiting != nil && cs.State.Waiting.Reason != "ContainerCreating" && cs.State.Waiting.Reason != "PodInitializing":
+			p.Reason, p.Message = cs.State.Waiting.Reason, cs.State.Waiting.Message
+			if t := cs.LastTerminationState.Terminated; p.Message == "" && t != nil {
+				p.Message = terminatedMessage(t)
+			}
+		case cs.State.Terminated != nil && cs.State.Terminated.ExitCode != 0:
+			p.Reason, p.Message = cs.State.Terminated.Reason, terminatedMessage(cs.State.Terminated)
+		default:
+			continue
+		}
+		p.Message = truncate(p.Message, MaxMessageLength)
+		failures = append(failures, p)
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+
+	if pod.Status.Phase == corev1.PodFailed {
+		return []Pod{{Namespace: pod.Namespace, Name: pod.Name, Reason: pod.Status.Reason, Message: truncate(pod.Status.Message, MaxMessageLength)}}
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse {
+			return []Pod{{Namespace: pod.Namespace, Name: pod.Name, Reason: c.Reason, Message: truncate(c.Message, MaxMessageLength)}}
+		}
+	}
+	return nil
+}
+
+// jobFailure returns the reason and message of the Failed condition of a
+// Job, if it still exists.
+func jobFailure(ctx context.Context, c client.Reader, namespace, name string) (string, string, error) {
+	job := &batchv1.Job{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, job)
+	if apierrors.IsNotFound(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			return cond.Reason, truncate(cond.Message, MaxMessageLength), nil
+		}
+	}
+	return "", "", nil
+}
+
+func terminatedMessage(t *corev1.ContainerStateTerminated) string {
+	if t.Message != "" {
+		return fmt.Sprintf("exit code %d: %s", t.ExitCode, t.Message)
+	}
+	return fmt.Sprintf("exit code %d", t.ExitCode)
+}
+
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+func reasonMessage(reason, message string) string {
+	switch {
+	case reason == "":
+		return message
+	case message == "":
+		return reason
+	}
+	return reason + ": " + message
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
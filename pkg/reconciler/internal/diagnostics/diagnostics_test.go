@@ -0,0 +1,175 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagnostics_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/release"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/joelanford/helm-operator/pkg/reconciler/internal/diagnostics"
+)
+
+const deployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+`
+
+const hookJob = `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+`
+
+var _ = Describe("Collect", func() {
+	var (
+		rel   *release.Release
+		since time.Time
+	)
+
+	BeforeEach(func() {
+		rel = &release.Release{
+			Name:      "test",
+			Namespace: "ns",
+			Version:   2,
+			Manifest:  "---\n" + deployment,
+			Hooks: []*release.Hook{{
+				Name:     "migrate",
+				Kind:     "Job",
+				Manifest: hookJob,
+				LastRun:  release.HookExecution{Phase: release.HookPhaseFailed},
+			}},
+		}
+		since = time.Now().Add(-time.Minute)
+	})
+
+	pod := func(name string, labels map[string]string, st corev1.PodStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, Labels: labels},
+			Status:     st,
+		}
+	}
+	event := func(name, kind, objName, typ string, last time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "ns", Name: name},
+			InvolvedObject: corev1.ObjectReference{Kind: kind, Namespace: "ns", Name: objName},
+			Type:           typ,
+			Reason:         "BackOff",
+			Message:        "Back-off restarting failed container",
+			Count:          3,
+			LastTimestamp:  metav1.NewTime(last),
+		}
+	}
+
+	It("should collect failing containers, failed hooks and recent warning events", func() {
+		objs := []runtime.Object{
+			pod("app-5d8f-abcde", map[string]string{"app": "app"}, corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:                 "app",
+					RestartCount:         4,
+					State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "config not found"}},
+				}},
+			}),
+			pod("app-5d8f-fghij", map[string]string{"app": "app"}, corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable", Message: "0/3 nodes are available"}},
+			}),
+			pod("unrelated", map[string]string{"app": "other"}, corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "other", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}},
+			}),
+			&batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "migrate"},
+				Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{
+					Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit",
+				}}},
+			},
+			event("e1", "Pod", "app-5d8f-abcde", corev1.EventTypeWarning, time.Now()),
+			event("e2", "Pod", "unrelated", corev1.EventTypeWarning, time.Now()),
+			event("e3", "Deployment", "app", corev1.EventTypeNormal, time.Now()),
+			event("e4", "Deployment", "app", corev1.EventTypeWarning, time.Now().Add(-time.Hour)),
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, objs...)
+
+		s, err := Collect(context.TODO(), c, rel, since)
+		Expect(err).To(BeNil())
+		Expect(s.ReleaseVersion).To(Equal(2))
+		Expect(s.Pods).To(ConsistOf(
+			Pod{Namespace: "ns", Name: "app-5d8f-abcde", Container: "app", Reason: "CrashLoopBackOff", Message: "exit code 1: config not found", RestartCount: 4},
+			Pod{Namespace: "ns", Name: "app-5d8f-fghij", Reason: "Unschedulable", Message: "0/3 nodes are available"},
+		))
+		Expect(s.Hooks).To(Equal([]Hook{{Kind: "Job", Name: "migrate", Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"}}))
+		Expect(s.Events).To(HaveLen(1))
+		Expect(s.Events[0].Name).To(Equal("app-5d8f-abcde"))
+		Expect(s.Events[0].Reason).To(Equal("BackOff"))
+		Expect(s.Empty()).To(BeFalse())
+		Expect(s.String()).To(ContainSubstring("hook Job migrate: BackoffLimitExceeded"))
+		Expect(s.String()).To(ContainSubstring("pod ns/app-5d8f-abcde container app: CrashLoopBackOff"))
+	})
+
+	It("should bound the summary", func() {
+		var objs []runtime.Object
+		for i := 0; i < 2*MaxEvents; i++ {
+			objs = append(objs,
+				pod(fmt.Sprintf("app-%d", i), map[string]string{"app": "app"}, corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted", Message: strings.Repeat("x", 2*MaxMessageLength)}),
+				event(fmt.Sprintf("e%d", i), "Pod", fmt.Sprintf("app-%d", i), corev1.EventTypeWarning, time.Now()),
+			)
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, objs...)
+
+		s, err := Collect(context.TODO(), c, rel, since)
+		Expect(err).To(BeNil())
+		Expect(s.Pods).To(HaveLen(MaxPods))
+		Expect(s.Pods[0].Message).To(HaveLen(MaxMessageLength))
+		Expect(s.Events).To(HaveLen(MaxEvents))
+	})
+
+	It("should report failed hooks that no longer exist", func() {
+		s, err := Collect(context.TODO(), fake.NewFakeClientWithScheme(scheme.Scheme), rel, since)
+		Expect(err).To(BeNil())
+		Expect(s.Hooks).To(Equal([]Hook{{Kind: "Job", Name: "migrate"}}))
+		Expect(s.Pods).To(BeEmpty())
+		Expect(s.Events).To(BeEmpty())
+	})
+
+	It("should fail with an invalid manifest", func() {
+		rel.Manifest = "---\nfoobar"
+		_, err := Collect(context.TODO(), fake.NewFakeClientWithScheme(scheme.Scheme), rel, since)
+		Expect(err).To(HaveOccurred())
+	})
+})
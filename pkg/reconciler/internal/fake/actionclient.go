@@ -51,13 +51,19 @@ type ActionClient struct {
 	Installs   []InstallCall
 	Upgrades   []UpgradeCall
 	Uninstalls []UninstallCall
+	Rollbacks  []RollbackCall
+	Histories  []HistoryCall
+	Prunes     []PruneHistoryCall
 	Reconciles []ReconcileCall
 
 	HandleGet       func() (*release.Release, error)
 	HandleInstall   func() (*release.Release, error)
 	HandleUpgrade   func() (*release.Release, error)
 	HandleUninstall func() (*release.UninstallReleaseResponse, error)
-	HandleReconcile func() error
+	HandleRollback  func() error
+	HandleHistory   func() ([]*release.Release, error)
+	HandlePrune     func() (int, error)
+	HandleReconcile func() ([]client.Drift, error)
 }
 
 func NewActionClient() ActionClient {
@@ -67,20 +73,35 @@ func NewActionClient() ActionClient {
 	uninstFunc := func(err error) func() (*release.UninstallReleaseResponse, error) {
 		return func() (*release.UninstallReleaseResponse, error) { return nil, err }
 	}
-	recFunc := func(err error) func() error {
+	rollbackFunc := func(err error) func() error {
 		return func() error { return err }
 	}
+	histFunc := func(err error) func() ([]*release.Release, error) {
+		return func() ([]*release.Release, error) { return nil, err }
+	}
+	pruneFunc := func(err error) func() (int, error) {
+		return func() (int, error) { return 0, err }
+	}
+	recFunc := func(err error) func() ([]client.Drift, error) {
+		return func() ([]client.Drift, error) { return nil, err }
+	}
 	return ActionClient{
 		Gets:       make([]GetCall, 0),
 		Installs:   make([]InstallCall, 0),
 		Upgrades:   make([]UpgradeCall, 0),
 		Uninstalls: make([]UninstallCall, 0),
+		Rollbacks:  make([]RollbackCall, 0),
+		Histories:  make([]HistoryCall, 0),
+		Prunes:     make([]PruneHistoryCall, 0),
 		Reconciles: make([]ReconcileCall, 0),
 
 		HandleGet:       relFunc(errors.New("get not implemented")),
 		HandleInstall:   relFunc(errors.New("install not implemented")),
 		HandleUpgrade:   relFunc(errors.New("upgrade not implemented")),
 		HandleUninstall: uninstFunc(errors.New("uninstall not implemented")),
+		HandleRollback:  rollbackFunc(errors.New("rollback not implemented")),
+		HandleHistory:   histFunc(errors.New("history not implemented")),
+		HandlePrune:     pruneFunc(errors.New("prune history not implemented")),
 		HandleReconcile: recFunc(errors.New("reconcile not implemented")),
 	}
 }
@@ -113,8 +134,24 @@ type UninstallCall struct {
 	Opts []client.UninstallOption
 }
 
+type RollbackCall struct {
+	Name string
+	Opts []client.RollbackOption
+}
+
+type HistoryCall struct {
+	Name string
+	Opts []client.HistoryOption
+}
+
+type PruneHistoryCall struct {
+	Name string
+	Max  int
+}
+
 type ReconcileCall struct {
 	Release *release.Release
+	Opts    []client.ReconcileOption
 }
 
 func (c *ActionClient) Get(name string, opts ...client.GetOption) (*release.Release, error) {
@@ -137,7 +174,22 @@ func (c *ActionClient) Uninstall(name string, opts ...client.UninstallOption) (*
 	return c.HandleUninstall()
 }
 
-func (c *ActionClient) Reconcile(rel *release.Release) error {
-	c.Reconciles = append(c.Reconciles, ReconcileCall{rel})
+func (c *ActionClient) Rollback(name string, opts ...client.RollbackOption) error {
+	c.Rollbacks = append(c.Rollbacks, RollbackCall{name, opts})
+	return c.HandleRollback()
+}
+
+func (c *ActionClient) History(name string, opts ...client.HistoryOption) ([]*release.Release, error) {
+	c.Histories = append(c.Histories, HistoryCall{name, opts})
+	return c.HandleHistory()
+}
+
+func (c *ActionClient) PruneHistory(name string, max int) (int, error) {
+	c.Prunes = append(c.Prunes, PruneHistoryCall{name, max})
+	return c.HandlePrune()
+}
+
+func (c *ActionClient) Reconcile(rel *release.Release, opts ...client.ReconcileOption) ([]client.Drift, error) {
+	c.Reconciles = append(c.Reconciles, ReconcileCall{rel, opts})
 	return c.HandleReconcile()
 }
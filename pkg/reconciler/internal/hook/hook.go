@@ -17,83 +17,237 @@ limitations under the License.
 package hook
 
 import (
+	"context"
+	"fmt"
 	"sync"
 
 	"github.com/go-logr/logr"
 	"helm.sh/helm/v3/pkg/release"
-	"helm.sh/helm/v3/pkg/releaseutil"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	crtpredicate "sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
-	"sigs.k8s.io/yaml"
 
+	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/diff"
+	"github.com/joelanford/helm-operator/pkg/health"
 	"github.com/joelanford/helm-operator/pkg/hook"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/controllerutil"
 	sdkhandler "github.com/joelanford/helm-operator/pkg/internal/sdk/handler"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/predicate"
+	helmmanager "github.com/joelanford/helm-operator/pkg/manager"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/inventory"
 )
 
-func NewDependentResourceWatcher(c controller.Controller, rm meta.RESTMapper) hook.PostHook {
+// DependentResourceWatcher is a PostHook that watches the resources of
+// releases.
+type DependentResourceWatcher interface {
+	hook.PostHook
+
+	// Watches reports whether resources of gvk are watched, i.e. whether
+	// Get can read them from an informer.
+	Watches(gvk schema.GroupVersionKind) bool
+
+	// Get gets the resource with key of a watched kind from the informer
+	// that watches it.
+	Get(ctx context.Context, key types.NamespacedName, obj runtime.Object) error
+}
+
+// NewDependentResourceWatcher returns a PostHook that watches the resources
+// in the inventory of a release. In addition to the dependent predicate, updates that change the
+// health status of a resource, as determined by checkers, trigger a
+// reconciliation so that readiness is reported promptly. Updates that only
+// change fields ignored by ignore do not.
+//
+// Resources are watched with the informers of c, the cache of the
+// controller's manager. If c is a MetadataCache, kinds whose resources all
+// carry the label set by the post-renderer of action clients are watched
+// with informers that only keep the labeled resources in memory. Kinds with
+// unlabeled resources, e.g. of releases that were installed before the label
+// was introduced, are watched with the informers of c. If unlabeled resources
+// of a kind appear after its labeled resources are watched, the informer of c
+// supersedes the selected informer, whose events are dropped from then on.
+func NewDependentResourceWatcher(c controller.Controller, rm meta.RESTMapper, cache cache.Cache, checkers health.Checkers, ignore diff.IgnoreRules) DependentResourceWatcher {
 	return &dependentResourceWatcher{
 		controller: c,
 		restMapper: rm,
+		cache:      cache,
+		checkers:   checkers,
+		ignore:     ignore,
 		m:          sync.Mutex{},
-		watches:    make(map[schema.GroupVersionKind]struct{}),
+		watches:    make(map[schema.GroupVersionKind]*watch),
 	}
 }
 
 type dependentResourceWatcher struct {
 	controller controller.Controller
 	restMapper meta.RESTMapper
+	cache      cache.Cache
+	checkers   health.Checkers
+	ignore     diff.IgnoreRules
 
 	m       sync.Mutex
-	watches map[schema.GroupVersionKind]struct{}
+	watches map[schema.GroupVersionKind]*watch
+}
+
+// watch records how the resources of a kind are watched.
+type watch struct {
+	// selected is the informer that watches the labeled resources of the
+	// kind, if any.
+	selected helmmanager.SelectedInformer
+
+	// full is whether all resources of the kind are watched with the
+	// informer of the cache, which supersedes selected.
+	full bool
+}
+
+// isFull returns whether all resources of gvk are watched.
+func (d *dependentResourceWatcher) isFull(gvk schema.GroupVersionKind) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	w, ok := d.watches[gvk]
+	return ok && w.full
 }
 
 func (d *dependentResourceWatcher) Exec(owner *unstructured.Unstructured, rel release.Release, log logr.Logger) error {
 	// using predefined functions for filtering events
-	dependentPredicate := predicate.DependentPredicateFuncs()
+	dependentPredicate := predicate.DependentPredicateFuncs(d.ignore)
+	dependentUpdate := dependentPredicate.UpdateFunc
+	dependentPredicate.UpdateFunc = func(e event.UpdateEvent) bool {
+		return dependentUpdate(e) || d.healthChanged(e)
+	}
+
+	objs, err := inventory.FromRelease(&rel, d.restMapper)
+	if err != nil {
+		return err
+	}
+	unlabeled := map[schema.GroupVersionKind]bool{}
+	for _, o := range objs {
+		if !helmclient.ReleaseResourceSelector.Matches(labels.Set(o.Labels)) {
+			unlabeled[o.GroupVersionKind] = true
+		}
+	}
+	metadataCache, selectable := d.cache.(helmmanager.MetadataCache)
 
-	resources := releaseutil.SplitManifests(rel.Manifest)
 	d.m.Lock()
 	defer d.m.Unlock()
-	for _, r := range resources {
-		var obj unstructured.Unstructured
-		err := yaml.Unmarshal([]byte(r), &obj)
-		if err != nil {
-			return err
+	for _, o := range objs {
+		depGVK := o.GroupVersionKind
+		w, ok := d.watches[depGVK]
+		if ok && (w.full || !unlabeled[depGVK]) {
+			continue
 		}
 
-		depGVK := obj.GroupVersionKind()
-		if _, ok := d.watches[depGVK]; ok || depGVK.Empty() {
-			continue
+		obj := o.Unstructured()
+		var src source.Source = &source.Kind{Type: obj}
+		preds := []crtpredicate.Predicate{dependentPredicate}
+		var inf helmmanager.SelectedInformer
+		if !ok && selectable && !unlabeled[depGVK] {
+			if inf, err = metadataCache.GetSelectedInformer(depGVK, helmclient.ReleaseResourceSelector); err != nil {
+				return err
+			}
+			src = &source.Informer{Informer: inf}
+			preds = append(preds, d.selectedPredicate(depGVK))
 		}
 
-		useOwnerRef, err := controllerutil.SupportsOwnerReference(d.restMapper, owner, &obj)
+		useOwnerRef, err := controllerutil.SupportsOwnerReference(d.restMapper, owner, obj)
 		if err != nil {
 			return err
 		}
 
 		if useOwnerRef {
-			if err := d.controller.Watch(&source.Kind{Type: &obj}, &handler.EnqueueRequestForOwner{
+			if err := d.controller.Watch(src, &handler.EnqueueRequestForOwner{
 				OwnerType:    owner,
 				IsController: true,
-			}, dependentPredicate); err != nil {
+			}, preds...); err != nil {
 				return err
 			}
 		} else {
-			if err := d.controller.Watch(&source.Kind{Type: &obj}, &sdkhandler.EnqueueRequestForAnnotation{
+			if err := d.controller.Watch(src, &sdkhandler.EnqueueRequestForAnnotation{
 				Type: owner.GetObjectKind().GroupVersionKind().GroupKind().String(),
-			}, dependentPredicate); err != nil {
+			}, preds...); err != nil {
 				return err
 			}
 		}
 
-		d.watches[depGVK] = struct{}{}
+		if inf != nil {
+			d.watches[depGVK] = &watch{selected: inf}
+			log.V(1).Info("Watching labeled dependent resources", "dependentAPIVersion", depGVK.GroupVersion(), "dependentKind", depGVK.Kind)
+			continue
+		}
+		if ok {
+			w.full = true
+			log.V(1).Info("Watching all dependent resources instead of labeled ones", "dependentAPIVersion", depGVK.GroupVersion(), "dependentKind", depGVK.Kind)
+			continue
+		}
+		d.watches[depGVK] = &watch{full: true}
 		log.V(1).Info("Watching dependent resource", "dependentAPIVersion", depGVK.GroupVersion(), "dependentKind", depGVK.Kind)
 	}
 	return nil
 }
+
+// selectedPredicate returns a predicate that drops the events of the
+// selected informer of gvk once all resources of gvk are watched, so that
+// events of labeled resources are not handled twice. The selected informer
+// is not stopped, since other controllers of the cache may share it.
+func (d *dependentResourceWatcher) selectedPredicate(gvk schema.GroupVersionKind) crtpredicate.Predicate {
+	return crtpredicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return !d.isFull(gvk) },
+		UpdateFunc:  func(event.UpdateEvent) bool { return !d.isFull(gvk) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return !d.isFull(gvk) },
+		GenericFunc: func(event.GenericEvent) bool { return !d.isFull(gvk) },
+	}
+}
+
+func (d *dependentResourceWatcher) Watches(gvk schema.GroupVersionKind) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	_, ok := d.watches[gvk]
+	return ok
+}
+
+func (d *dependentResourceWatcher) Get(ctx context.Context, key types.NamespacedName, obj runtime.Object) error {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	d.m.Lock()
+	var inf helmmanager.SelectedInformer
+	if w, ok := d.watches[gvk]; ok && !w.full {
+		inf = w.selected
+	}
+	d.m.Unlock()
+
+	// Kinds that are watched completely are read from the informer of the
+	// cache, which has unlabeled resources, too.
+	if inf == nil {
+		return d.cache.Get(ctx, key, obj)
+	}
+	u, isUnstructured := obj.(*unstructured.Unstructured)
+	if !isUnstructured {
+		return fmt.Errorf("labeled resources of kind %s can only be read into unstructured objects", gvk)
+	}
+	return inf.Get(key, u)
+}
+
+func (d *dependentResourceWatcher) healthChanged(e event.UpdateEvent) bool {
+	old, okOld := e.ObjectOld.(*unstructured.Unstructured)
+	new, okNew := e.ObjectNew.(*unstructured.Unstructured)
+	if !okOld || !okNew {
+		return false
+	}
+	oldRes, found, oldErr := d.checkers.Check(old)
+	if !found {
+		return false
+	}
+	newRes, _, newErr := d.checkers.Check(new)
+	if oldErr != nil || newErr != nil {
+		return (oldErr == nil) != (newErr == nil)
+	}
+	return oldRes.Status != newRes.Status
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package hook_test
 
 import (
+	"context"
+	"errors"
 	"strings"
 
 	"github.com/go-logr/logr/testing"
@@ -25,12 +27,22 @@ import (
 	"helm.sh/helm/v3/pkg/release"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/diff"
+	"github.com/joelanford/helm-operator/pkg/health"
 	"github.com/joelanford/helm-operator/pkg/hook"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/fake"
 	sdkhandler "github.com/joelanford/helm-operator/pkg/internal/sdk/handler"
+	helmmanager "github.com/joelanford/helm-operator/pkg/manager"
 	internalhook "github.com/joelanford/helm-operator/pkg/reconciler/internal/hook"
 )
 
@@ -66,7 +78,7 @@ var _ = Describe("Hook", func() {
 				rel = &release.Release{
 					Manifest: strings.Join([]string{rsOwnerNamespace}, "---\n"),
 				}
-				drw = internalhook.NewDependentResourceWatcher(c, rm)
+				drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
 			})
 			It("should fail with an invalid release manifest", func() {
 				rel.Manifest = "---\nfoobar"
@@ -110,13 +122,115 @@ var _ = Describe("Hook", func() {
 				rel = &release.Release{
 					Manifest: strings.Join([]string{clusterRole, clusterRole, rsOwnerNamespace, rsOwnerNamespace}, "---\n"),
 				}
-				drw = internalhook.NewDependentResourceWatcher(c, rm)
+				drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
 				Expect(drw.Exec(owner, *rel, log)).To(Succeed())
 				Expect(c.WatchCalls).To(HaveLen(2))
 				Expect(c.WatchCalls[0].Handler).To(BeAssignableToTypeOf(&handler.EnqueueRequestForOwner{}))
 				Expect(c.WatchCalls[1].Handler).To(BeAssignableToTypeOf(&handler.EnqueueRequestForOwner{}))
 			})
 
+			It("should report the watched resource kinds", func() {
+				owner = &unstructured.Unstructured{
+					Object: map[string]interface{}{
+						"apiVersion": "rbac.authorization.k8s.io/v1",
+						"kind":       "ClusterRole",
+						"metadata": map[string]interface{}{
+							"name": "testClusterRole",
+						},
+					},
+				}
+				rel = &release.Release{
+					Manifest: strings.Join([]string{clusterRole, rsOwnerNamespace}, "---\n"),
+				}
+				w := internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
+				rsGVK := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}
+				Expect(w.Watches(rsGVK)).To(BeFalse())
+				Expect(w.Exec(owner, *rel, log)).To(Succeed())
+				Expect(w.Watches(rsGVK)).To(BeTrue())
+				Expect(w.Watches(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"})).To(BeFalse())
+			})
+
+			Context("with a metadata cache", func() {
+				var (
+					mc    *testMetadataCache
+					rsGVK schema.GroupVersionKind
+					key   types.NamespacedName
+				)
+
+				BeforeEach(func() {
+					owner = &unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"name":      "testDeployment",
+								"namespace": "ownerNamespace",
+							},
+						},
+					}
+					mc = &testMetadataCache{}
+					rsGVK = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}
+					key = types.NamespacedName{Namespace: "ownerNamespace", Name: "testReplicaSet"}
+				})
+
+				It("should watch labeled resources with selected informers", func() {
+					rel = &release.Release{
+						Manifest: strings.Join([]string{rsLabeled}, "---\n"),
+					}
+					w := internalhook.NewDependentResourceWatcher(c, rm, mc, nil, nil)
+					Expect(w.Exec(owner, *rel, log)).To(Succeed())
+					Expect(c.WatchCalls).To(HaveLen(1))
+					Expect(c.WatchCalls[0].Source).To(Equal(&source.Informer{Informer: mc.informer}))
+					Expect(mc.selector).To(Equal(helmclient.ReleaseResourceSelector))
+					Expect(w.Watches(rsGVK)).To(BeTrue())
+
+					u := &unstructured.Unstructured{}
+					u.SetGroupVersionKind(rsGVK)
+					Expect(w.Get(context.TODO(), key, u)).To(Succeed())
+					Expect(u.GetAnnotations()).To(HaveKeyWithValue("read-from", "informer"))
+				})
+
+				It("should watch all resources of kinds with unlabeled resources", func() {
+					rel = &release.Release{
+						Manifest: strings.Join([]string{rsLabeled}, "---\n"),
+					}
+					w := internalhook.NewDependentResourceWatcher(c, rm, mc, nil, nil)
+					Expect(w.Exec(owner, *rel, log)).To(Succeed())
+					rel.Manifest = strings.Join([]string{rsOwnerNamespace}, "---\n")
+					Expect(w.Exec(owner, *rel, log)).To(Succeed())
+					Expect(w.Exec(owner, *rel, log)).To(Succeed())
+					Expect(c.WatchCalls).To(HaveLen(2))
+					Expect(c.WatchCalls[1].Source).To(BeAssignableToTypeOf(&source.Kind{}))
+
+					By("dropping the events of the superseded selected informer", func() {
+						obj := &unstructured.Unstructured{}
+						obj.SetGroupVersionKind(rsGVK)
+						e := event.CreateEvent{Meta: obj, Object: obj}
+						Expect(c.WatchCalls[0].Predicates).To(HaveLen(2))
+						Expect(c.WatchCalls[0].Predicates[1].Create(e)).To(BeFalse())
+						Expect(c.WatchCalls[1].Predicates).To(HaveLen(1))
+					})
+
+					u := &unstructured.Unstructured{}
+					u.SetGroupVersionKind(rsGVK)
+					Expect(w.Get(context.TODO(), key, u)).To(Succeed())
+					Expect(u.GetAnnotations()).To(HaveKeyWithValue("read-from", "cache"))
+				})
+
+				It("should not add selected informers to kinds whose resources are all watched", func() {
+					rel = &release.Release{
+						Manifest: strings.Join([]string{rsOwnerNamespace}, "---\n"),
+					}
+					w := internalhook.NewDependentResourceWatcher(c, rm, mc, nil, nil)
+					Expect(w.Exec(owner, *rel, log)).To(Succeed())
+					rel.Manifest = strings.Join([]string{rsLabeled}, "---\n")
+					Expect(w.Exec(owner, *rel, log)).To(Succeed())
+					Expect(c.WatchCalls).To(HaveLen(1))
+					Expect(c.WatchCalls[0].Source).To(BeAssignableToTypeOf(&source.Kind{}))
+					Expect(mc.informer).To(BeNil())
+				})
+			})
+
 			Context("when the owner is cluster-scoped", func() {
 				BeforeEach(func() {
 					owner = &unstructured.Unstructured{
@@ -133,7 +247,7 @@ var _ = Describe("Hook", func() {
 					rel = &release.Release{
 						Manifest: strings.Join([]string{rsOwnerNamespace, ssOtherNamespace}, "---\n"),
 					}
-					drw = internalhook.NewDependentResourceWatcher(c, rm)
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
 					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
 					Expect(c.WatchCalls).To(HaveLen(2))
 					Expect(c.WatchCalls[0].Handler).To(BeAssignableToTypeOf(&handler.EnqueueRequestForOwner{}))
@@ -144,7 +258,7 @@ var _ = Describe("Hook", func() {
 					rel = &release.Release{
 						Manifest: strings.Join([]string{clusterRole, clusterRoleBinding}, "---\n"),
 					}
-					drw = internalhook.NewDependentResourceWatcher(c, rm)
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
 					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
 					Expect(c.WatchCalls).To(HaveLen(2))
 					Expect(c.WatchCalls[0].Handler).To(BeAssignableToTypeOf(&handler.EnqueueRequestForOwner{}))
@@ -170,7 +284,18 @@ var _ = Describe("Hook", func() {
 					rel = &release.Release{
 						Manifest: strings.Join([]string{rsOwnerNamespace}, "---\n"),
 					}
-					drw = internalhook.NewDependentResourceWatcher(c, rm)
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
+					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
+					Expect(c.WatchCalls).To(HaveLen(1))
+					Expect(c.WatchCalls[0].Handler).To(BeAssignableToTypeOf(&handler.EnqueueRequestForOwner{}))
+				})
+
+				It("should watch namespace-scoped resources without a namespace in the release namespace with ownerRef handler", func() {
+					rel = &release.Release{
+						Namespace: "ownerNamespace",
+						Manifest:  strings.Join([]string{rsNoNamespace}, "---\n"),
+					}
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
 					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
 					Expect(c.WatchCalls).To(HaveLen(1))
 					Expect(c.WatchCalls[0].Handler).To(BeAssignableToTypeOf(&handler.EnqueueRequestForOwner{}))
@@ -180,17 +305,71 @@ var _ = Describe("Hook", func() {
 					rel = &release.Release{
 						Manifest: strings.Join([]string{clusterRole}, "---\n"),
 					}
-					drw = internalhook.NewDependentResourceWatcher(c, rm)
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
 					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
 					Expect(c.WatchCalls).To(HaveLen(1))
 					Expect(c.WatchCalls[0].Handler).To(BeAssignableToTypeOf(&sdkhandler.EnqueueRequestForAnnotation{}))
 				})
 
+				It("should reconcile status updates that change resource health", func() {
+					rel = &release.Release{
+						Manifest: strings.Join([]string{ssOtherNamespace}, "---\n"),
+					}
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, health.DefaultCheckers(), nil)
+					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
+					Expect(c.WatchCalls).To(HaveLen(1))
+					Expect(c.WatchCalls[0].Predicates).To(HaveLen(1))
+					p := c.WatchCalls[0].Predicates[0]
+
+					newStatefulSet := func(status map[string]interface{}) *unstructured.Unstructured {
+						return &unstructured.Unstructured{Object: map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "StatefulSet",
+							"metadata":   map[string]interface{}{"name": "otherTestStatefulSet", "namespace": "otherNamespace"},
+							"spec":       map[string]interface{}{"replicas": int64(1)},
+							"status":     status,
+						}}
+					}
+					progressing := newStatefulSet(map[string]interface{}{"readyReplicas": int64(0)})
+					stillProgressing := newStatefulSet(map[string]interface{}{"readyReplicas": int64(0), "replicas": int64(1)})
+					healthy := newStatefulSet(map[string]interface{}{"readyReplicas": int64(1)})
+
+					Expect(p.Update(event.UpdateEvent{ObjectOld: progressing, ObjectNew: stillProgressing})).To(BeFalse())
+					Expect(p.Update(event.UpdateEvent{ObjectOld: progressing, ObjectNew: healthy})).To(BeTrue())
+				})
+
+				It("should not reconcile updates that only change ignored fields", func() {
+					rel = &release.Release{
+						Manifest: strings.Join([]string{ssOtherNamespace}, "---\n"),
+					}
+					ignore := diff.IgnoreRules{{Group: "apps", Kind: "StatefulSet", JSONPointers: []string{"/spec/replicas"}}}
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, ignore)
+					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
+					Expect(c.WatchCalls).To(HaveLen(1))
+					Expect(c.WatchCalls[0].Predicates).To(HaveLen(1))
+					p := c.WatchCalls[0].Predicates[0]
+
+					newStatefulSet := func(generation, replicas int64, image string) *unstructured.Unstructured {
+						return &unstructured.Unstructured{Object: map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "StatefulSet",
+							"metadata":   map[string]interface{}{"name": "otherTestStatefulSet", "namespace": "otherNamespace", "generation": generation},
+							"spec":       map[string]interface{}{"replicas": replicas, "image": image},
+						}}
+					}
+					orig := newStatefulSet(1, 1, "a")
+					scaled := newStatefulSet(2, 3, "a")
+					changed := newStatefulSet(2, 1, "b")
+
+					Expect(p.Update(event.UpdateEvent{ObjectOld: orig, ObjectNew: scaled})).To(BeFalse())
+					Expect(p.Update(event.UpdateEvent{ObjectOld: orig, ObjectNew: changed})).To(BeTrue())
+				})
+
 				It("should watch namespace-scoped resources in a different namespace with annotation handler", func() {
 					rel = &release.Release{
 						Manifest: strings.Join([]string{ssOtherNamespace}, "---\n"),
 					}
-					drw = internalhook.NewDependentResourceWatcher(c, rm)
+					drw = internalhook.NewDependentResourceWatcher(c, rm, nil, nil, nil)
 					Expect(drw.Exec(owner, *rel, log)).To(Succeed())
 					Expect(c.WatchCalls).To(HaveLen(1))
 					Expect(c.WatchCalls[0].Handler).To(BeAssignableToTypeOf(&sdkhandler.EnqueueRequestForAnnotation{}))
@@ -207,6 +386,12 @@ kind: ReplicaSet
 metadata:
   name: testReplicaSet
   namespace: ownerNamespace
+`
+	rsNoNamespace = `
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: testReplicaSet
 `
 	ssOtherNamespace = `
 apiVersion: apps/v1
@@ -214,6 +399,15 @@ kind: StatefulSet
 metadata:
   name: otherTestStatefulSet
   namespace: otherNamespace
+`
+	rsLabeled = `
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: testReplicaSet
+  namespace: ownerNamespace
+  labels:
+    helm.operator-sdk/release-resource: "true"
 `
 	clusterRole = `
 apiVersion: rbac.authorization.k8s.io/v1
@@ -228,3 +422,36 @@ metadata:
   name: testClusterRoleBinding
 `
 )
+
+// testMetadataCache is a MetadataCache whose selected informers and cache
+// annotate the objects they read with where they were read from. Its other
+// methods are not implemented.
+type testMetadataCache struct {
+	cache.Cache
+	informer *testSelectedInformer
+	selector labels.Selector
+}
+
+func (c *testMetadataCache) GetMetadataInformer(schema.GroupVersionKind, labels.Selector) (cache.Informer, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *testMetadataCache) GetSelectedInformer(_ schema.GroupVersionKind, selector labels.Selector) (helmmanager.SelectedInformer, error) {
+	c.selector = selector
+	c.informer = &testSelectedInformer{}
+	return c.informer, nil
+}
+
+func (c *testMetadataCache) Get(_ context.Context, _ types.NamespacedName, obj runtime.Object) error {
+	obj.(*unstructured.Unstructured).SetAnnotations(map[string]string{"read-from": "cache"})
+	return nil
+}
+
+type testSelectedInformer struct {
+	cache.Informer
+}
+
+func (i *testSelectedInformer) Get(_ types.NamespacedName, obj *unstructured.Unstructured) error {
+	obj.SetAnnotations(map[string]string{"read-from": "informer"})
+	return nil
+}
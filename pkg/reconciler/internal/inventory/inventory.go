@@ -0,0 +1,98 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory lists the resources of a Helm release.
+package inventory
+
+import (
+	"fmt"
+	"sort"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Object identifies a resource of a release.
+type Object struct {
+	schema.GroupVersionKind
+	Namespace string
+	Name      string
+
+	// Labels are the labels of the resource in the manifest.
+	Labels map[string]string
+}
+
+// Unstructured returns an object with the group, version, kind, namespace,
+// and name of o.
+func (o Object) Unstructured() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(o.GroupVersionKind)
+	obj.SetNamespace(o.Namespace)
+	obj.SetName(o.Name)
+	return obj
+}
+
+func (o Object) String() string {
+	if o.Namespace == "" {
+		return fmt.Sprintf("%s %s", o.Kind, o.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+// FromRelease returns the resources in the manifest of rel, in manifest
+// order.
+//
+// Templates often omit the namespace of namespaced resources, which Helm then
+// creates in the release namespace. If rm is not nil, it is used to set the
+// namespace of such resources to the release namespace and to clear the
+// namespace of cluster-scoped resources. Resources whose kind rm does not
+// know, e.g. of CRDs installed by the release, keep their namespace.
+func FromRelease(rel *release.Release, rm meta.RESTMapper) ([]Object, error) {
+	manifests := releaseutil.SplitManifests(rel.Manifest)
+	keys := make([]string, 0, len(manifests))
+	for k := range manifests {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var objs []Object
+	for _, k := range keys {
+		u := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(manifests[k]), u); err != nil {
+			return nil, err
+		}
+		gvk := u.GroupVersionKind()
+		if gvk.Empty() {
+			continue
+		}
+		o := Object{GroupVersionKind: gvk, Namespace: u.GetNamespace(), Name: u.GetName(), Labels: u.GetLabels()}
+		if rm != nil {
+			if mapping, err := rm.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+				if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+					o.Namespace = ""
+				} else if o.Namespace == "" {
+					o.Namespace = rel.Namespace
+				}
+			}
+		}
+		objs = append(objs, o)
+	}
+	return objs, nil
+}
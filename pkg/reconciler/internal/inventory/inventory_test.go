@@ -0,0 +1,104 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	. "github.com/joelanford/helm-operator/pkg/reconciler/internal/inventory"
+)
+
+var _ = Describe("FromRelease", func() {
+	var (
+		rel *release.Release
+		rm  *meta.DefaultRESTMapper
+	)
+
+	deployment := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	clusterRole := schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}
+	widget := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+
+	BeforeEach(func() {
+		rel = &release.Release{Namespace: "release-ns", Manifest: `---
+# Source: chart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deploy
+  labels:
+    app: deploy
+---
+# Source: chart/templates/clusterrole.yaml
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: role
+  namespace: ignored
+---
+# Source: chart/templates/empty.yaml
+---
+# Source: chart/templates/widget.yaml
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+`}
+		rm = meta.NewDefaultRESTMapper(nil)
+		rm.Add(deployment, meta.RESTScopeNamespace)
+		rm.Add(clusterRole, meta.RESTScopeRoot)
+	})
+
+	It("should list the resources of the release", func() {
+		objs, err := FromRelease(rel, rm)
+		Expect(err).To(BeNil())
+		Expect(objs).To(Equal([]Object{
+			{GroupVersionKind: deployment, Namespace: "release-ns", Name: "deploy", Labels: map[string]string{"app": "deploy"}},
+			{GroupVersionKind: clusterRole, Name: "role"},
+			{GroupVersionKind: widget, Name: "widget"},
+		}))
+	})
+
+	It("should keep namespaces without a REST mapper", func() {
+		objs, err := FromRelease(rel, nil)
+		Expect(err).To(BeNil())
+		Expect(objs).To(HaveLen(3))
+		Expect(objs[0].Namespace).To(BeEmpty())
+		Expect(objs[1].Namespace).To(Equal("ignored"))
+	})
+
+	It("should fail with an invalid manifest", func() {
+		rel.Manifest = "---\nfoobar"
+		_, err := FromRelease(rel, rm)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Object", func() {
+	It("should convert to an unstructured object", func() {
+		o := Object{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, Namespace: "ns", Name: "name"}
+		u := o.Unstructured()
+		Expect(u.GetAPIVersion()).To(Equal("apps/v1"))
+		Expect(u.GetKind()).To(Equal("Deployment"))
+		Expect(u.GetNamespace()).To(Equal("ns"))
+		Expect(u.GetName()).To(Equal("name"))
+		Expect(o.String()).To(Equal("Deployment ns/name"))
+	})
+})
@@ -0,0 +1,213 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rendercache remembers the releases whose manifest matched the
+// rendered manifest of their inputs, so that they are not rendered again on
+// every reconciliation.
+package rendercache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+)
+
+// CapabilitiesRefreshInterval is the interval at which the fingerprint of the
+// cluster capabilities is refreshed.
+const CapabilitiesRefreshInterval = time.Minute
+
+// CapabilitiesFunc returns a fingerprint of the capabilities of the cluster,
+// i.e. its version and API versions, which are available to chart templates.
+type CapabilitiesFunc func() (string, error)
+
+// DiscoveryCapabilities returns a CapabilitiesFunc that discovers the
+// capabilities of the cluster with dc.
+func DiscoveryCapabilities(dc discovery.DiscoveryInterface) CapabilitiesFunc {
+	return func() (string, error) {
+		version, err := dc.ServerVersion()
+		if err != nil {
+			return "", err
+		}
+		groups, err := dc.ServerGroups()
+		if err != nil {
+			return "", err
+		}
+		var versions []string
+		for _, g := range groups.Groups {
+			for _, v := range g.Versions {
+				versions = append(versions, v.GroupVersion)
+			}
+		}
+		sort.Strings(versions)
+		return checksum([]interface{}{version.GitVersion, versions})
+	}
+}
+
+// Cache remembers the fingerprint of the inputs with which the release of a
+// custom resource was last rendered, if the rendered manifest matched the
+// manifest of the release. Entries expire after the resync interval, so that
+// releases are rendered at least once per interval.
+//
+// A nil *Cache and a Cache with a non-positive resync interval remember
+// nothing.
+type Cache struct {
+	resyncInterval time.Duration
+	capabilities   CapabilitiesFunc
+	now            func() time.Time
+
+	mu      sync.Mutex
+	entries map[types.UID]entry
+	digests map[*chart.Chart]string
+
+	capsMu      sync.Mutex
+	caps        string
+	capsFetched time.Time
+}
+
+type entry struct {
+	fingerprint    string
+	releaseVersion int
+	rendered       time.Time
+}
+
+// New returns a Cache whose entries expire after resyncInterval. The
+// fingerprint of the cluster capabilities is refreshed with caps at most once
+// per CapabilitiesRefreshInterval.
+func New(resyncInterval time.Duration, caps CapabilitiesFunc) *Cache {
+	return &Cache{
+		resyncInterval: resyncInterval,
+		capabilities:   caps,
+		now:            time.Now,
+		entries:        map[types.UID]entry{},
+		digests:        map[*chart.Chart]string{},
+	}
+}
+
+// Fingerprint returns the fingerprint of rendering chrt with inputs, e.g. a
+// checksum of the values and annotations of a custom resource, in the cluster.
+func (c *Cache) Fingerprint(chrt *chart.Chart, inputs string) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	digest, err := c.chartDigest(chrt)
+	if err != nil {
+		return "", err
+	}
+	caps, err := c.clusterCapabilities()
+	if err != nil {
+		return "", err
+	}
+	return checksum([]string{digest, inputs, caps})
+}
+
+// Unchanged returns true if the release of the custom resource with uid was
+// at releaseVersion when it was last rendered with fingerprint, less than the
+// resync interval ago, and the rendered manifest matched.
+func (c *Cache) Unchanged(uid types.UID, releaseVersion int, fingerprint string) bool {
+	if c == nil || c.resyncInterval <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[uid]
+	return ok && e.fingerprint == fingerprint && e.releaseVersion == releaseVersion && c.now().Before(e.rendered.Add(c.resyncInterval))
+}
+
+// Add records that the rendered manifest of the release of the custom resource
+// with uid matched the manifest of its releaseVersion.
+func (c *Cache) Add(uid types.UID, releaseVersion int, fingerprint string) {
+	if c == nil || c.resyncInterval <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[uid] = entry{fingerprint: fingerprint, releaseVersion: releaseVersion, rendered: c.now()}
+}
+
+// Delete forgets the release of the custom resource with uid.
+func (c *Cache) Delete(uid types.UID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, uid)
+}
+
+// chartDigest returns the digest of chrt and its dependencies. Charts are not
+// modified once they are loaded, so digests are computed once per chart.
+func (c *Cache) chartDigest(chrt *chart.Chart) (string, error) {
+	c.mu.Lock()
+	digest, ok := c.digests[chrt]
+	c.mu.Unlock()
+	if ok {
+		return digest, nil
+	}
+
+	h := sha256.New()
+	var write func(*chart.Chart) error
+	write = func(chrt *chart.Chart) error {
+		if err := json.NewEncoder(h).Encode(chrt); err != nil {
+			return err
+		}
+		for _, dep := range chrt.Dependencies() {
+			if err := write(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := write(chrt); err != nil {
+		return "", err
+	}
+	digest = fmt.Sprintf("sha256:%x", h.Sum(nil))
+
+	c.mu.Lock()
+	c.digests[chrt] = digest
+	c.mu.Unlock()
+	return digest, nil
+}
+
+func (c *Cache) clusterCapabilities() (string, error) {
+	if c.capabilities == nil {
+		return "", nil
+	}
+	c.capsMu.Lock()
+	defer c.capsMu.Unlock()
+	if c.capsFetched.IsZero() || !c.now().Before(c.capsFetched.Add(CapabilitiesRefreshInterval)) {
+		caps, err := c.capabilities()
+		if err != nil {
+			return "", err
+		}
+		c.caps, c.capsFetched = caps, c.now()
+	}
+	return c.caps, nil
+}
+
+func checksum(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
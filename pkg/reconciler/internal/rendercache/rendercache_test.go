@@ -0,0 +1,120 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rendercache
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		c     *Cache
+		now   time.Time
+		caps  string
+		calls int
+		chrt  *chart.Chart
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		caps, calls = "v1.18.2", 0
+		c = New(time.Hour, func() (string, error) {
+			calls++
+			return caps, nil
+		})
+		c.now = func() time.Time { return now }
+		chrt = &chart.Chart{
+			Metadata:  &chart.Metadata{Name: "test", Version: "0.1.0"},
+			Templates: []*chart.File{{Name: "templates/service.yaml", Data: []byte("kind: Service")}},
+		}
+	})
+
+	It("should remember unchanged releases", func() {
+		fp, err := c.Fingerprint(chrt, "inputs")
+		Expect(err).To(BeNil())
+		Expect(c.Unchanged("uid", 1, fp)).To(BeFalse())
+
+		c.Add("uid", 1, fp)
+		Expect(c.Unchanged("uid", 1, fp)).To(BeTrue())
+		Expect(c.Unchanged("uid", 2, fp)).To(BeFalse())
+		Expect(c.Unchanged("other", 1, fp)).To(BeFalse())
+	})
+
+	It("should change the fingerprint with the inputs, chart, and capabilities", func() {
+		fp, err := c.Fingerprint(chrt, "inputs")
+		Expect(err).To(BeNil())
+
+		Expect(c.Fingerprint(chrt, "other inputs")).NotTo(Equal(fp))
+
+		other := *chrt
+		other.Templates = []*chart.File{{Name: "templates/service.yaml", Data: []byte("kind: ConfigMap")}}
+		Expect(c.Fingerprint(&other, "inputs")).NotTo(Equal(fp))
+
+		caps = "v1.19.0"
+		now = now.Add(CapabilitiesRefreshInterval)
+		Expect(c.Fingerprint(chrt, "inputs")).NotTo(Equal(fp))
+	})
+
+	It("should refresh the capabilities at most once per interval", func() {
+		for i := 0; i < 3; i++ {
+			_, err := c.Fingerprint(chrt, "inputs")
+			Expect(err).To(BeNil())
+		}
+		Expect(calls).To(Equal(1))
+
+		now = now.Add(CapabilitiesRefreshInterval)
+		_, err := c.Fingerprint(chrt, "inputs")
+		Expect(err).To(BeNil())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should fail if the capabilities cannot be discovered", func() {
+		c.capabilities = func() (string, error) { return "", errors.New("discovery failed") }
+		_, err := c.Fingerprint(chrt, "inputs")
+		Expect(err).To(MatchError("discovery failed"))
+	})
+
+	It("should expire entries after the resync interval", func() {
+		c.Add("uid", 1, "fp")
+		now = now.Add(time.Hour)
+		Expect(c.Unchanged("uid", 1, "fp")).To(BeFalse())
+	})
+
+	It("should forget deleted releases", func() {
+		c.Add("uid", 1, "fp")
+		c.Delete("uid")
+		Expect(c.Unchanged("uid", 1, "fp")).To(BeFalse())
+	})
+
+	It("should remember nothing without a resync interval", func() {
+		c.resyncInterval = 0
+		c.Add("uid", 1, "fp")
+		Expect(c.Unchanged("uid", 1, "fp")).To(BeFalse())
+	})
+
+	It("should remember nothing if nil", func() {
+		var nilCache *Cache
+		nilCache.Add(types.UID("uid"), 1, "fp")
+		Expect(nilCache.Unchanged("uid", 1, "fp")).To(BeFalse())
+	})
+})
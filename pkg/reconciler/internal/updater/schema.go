@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package updater
+
+import (
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// StatusSchema returns the OpenAPI schema of the status written by the
+// Updater. It must be kept in sync with helmAppStatus.
+func StatusSchema() apiextv1.JSONSchemaProps {
+	str := apiextv1.JSONSchemaProps{Type: "string"}
+	integer := apiextv1.JSONSchemaProps{Type: "integer"}
+	dateTime := apiextv1.JSONSchemaProps{Type: "string", Format: "date-time"}
+	object := func(description string, required []string, props map[string]apiextv1.JSONSchemaProps) apiextv1.JSONSchemaProps {
+		return apiextv1.JSONSchemaProps{Type: "object", Description: description, Required: required, Properties: props}
+	}
+	array := func(description string, items apiextv1.JSONSchemaProps) apiextv1.JSONSchemaProps {
+		return apiextv1.JSONSchemaProps{Type: "array", Description: description, Items: &apiextv1.JSONSchemaPropsOrArray{Schema: &items}}
+	}
+
+	return object("Status of the Helm release of the custom resource.", nil, map[string]apiextv1.JSONSchemaProps{
+		"observedGeneration": {Type: "integer", Format: "int64", Description: "The generation of the custom resource when the status was last updated."},
+		"conditions": array("Conditions of the custom resource.", object("", []string{"type", "status"}, map[string]apiextv1.JSONSchemaProps{
+			"type":               str,
+			"status":             str,
+			"reason":             str,
+			"message":            str,
+			"lastTransitionTime": dateTime,
+			"observedGeneration": {Type: "integer", Format: "int64"},
+		})),
+		"deployedRelease": object("The deployed release.", nil, map[string]apiextv1.JSONSchemaProps{
+			"name":         str,
+			"namespace":    str,
+			"revision":     integer,
+			"status":       str,
+			"chartName":    str,
+			"chartVersion": str,
+			"appVersion":   str,
+			"lastDeployed": dateTime,
+			"valuesHash":   str,
+			"manifest":     str,
+			"compressedManifest": {
+				Type:        "string",
+				Format:      "byte",
+				Description: "The base64-encoded, gzipped manifest, if the manifest is stored compressed.",
+			},
+		}),
+		"chartVersion": {Type: "string", Description: "The version of the chart of the deployed release."},
+		"resources": array("The inventory of the resources of the deployed release.", object("", []string{"apiVersion", "kind", "name"}, map[string]apiextv1.JSONSchemaProps{
+			"apiVersion": str,
+			"kind":       str,
+			"namespace":  str,
+			"name":       str,
+			"health":     str,
+			"message":    str,
+		})),
+		"resourceCount": {Type: "integer", Description: "The number of resources of the deployed release."},
+		"pendingWait": object("The wait for the resources of a release to become ready.", []string{"releaseVersion", "deadline"}, map[string]apiextv1.JSONSchemaProps{
+			"releaseVersion": integer,
+			"deadline":       dateTime,
+			"install":        {Type: "boolean"},
+		}),
+		"rollback": object("The result of the last requested rollback.", []string{"revision", "releaseVersion", "succeeded"}, map[string]apiextv1.JSONSchemaProps{
+			"revision":       integer,
+			"releaseVersion": integer,
+			"succeeded":      {Type: "boolean"},
+			"message":        str,
+		}),
+		"diagnostics": object("Diagnostics of the last failed release installation or upgrade.", []string{"releaseVersion"}, map[string]apiextv1.JSONSchemaProps{
+			"releaseVersion": integer,
+			"pods": array("Failing containers of the pods of the release.", object("", []string{"namespace", "name"}, map[string]apiextv1.JSONSchemaProps{
+				"namespace":    str,
+				"name":         str,
+				"container":    str,
+				"reason":       str,
+				"message":      str,
+				"restartCount": integer,
+			})),
+			"events": array("Recent warning events of the resources of the release.", object("", []string{"kind", "name", "lastSeen"}, map[string]apiextv1.JSONSchemaProps{
+				"kind":      str,
+				"namespace": str,
+				"name":      str,
+				"reason":    str,
+				"message":   str,
+				"count":     integer,
+				"lastSeen":  dateTime,
+			})),
+			"hooks": array("Failed hooks of the release.", object("", []string{"kind", "name"}, map[string]apiextv1.JSONSchemaProps{
+				"kind":    str,
+				"name":    str,
+				"reason":  str,
+				"message": str,
+			})),
+		}),
+		"permanentError": object("The last permanent error, which is not retried until the custom resource changes.", []string{"reason", "generation", "checksum"}, map[string]apiextv1.JSONSchemaProps{
+			"reason":     str,
+			"generation": {Type: "integer", Format: "int64"},
+			"checksum":   str,
+		}),
+		"failures": {Type: "integer", Description: "The number of consecutive failed installations and upgrades of the last attempted revision."},
+		"lastAttemptedRevision": object("The chart version, generation, and inputs checksum of the last attempted installation or upgrade.", []string{"chartVersion", "generation", "checksum"}, map[string]apiextv1.JSONSchemaProps{
+			"chartVersion": str,
+			"generation":   {Type: "integer", Format: "int64"},
+			"checksum":     str,
+		}),
+	})
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package updater
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/joelanford/helm-operator/pkg/health"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/conditions"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/diagnostics"
+)
+
+var _ = Describe("StatusSchema", func() {
+	It("should describe every field of the status", func() {
+		lastDeployed := metav1.Now()
+		st := helmAppStatus{
+			ObservedGeneration: 1,
+			DeployedRelease: &helmAppRelease{
+				Name:               "name",
+				Namespace:          "ns",
+				Revision:           1,
+				Status:             "deployed",
+				ChartName:          "chart",
+				ChartVersion:       "0.1.0",
+				AppVersion:         "1.0.0",
+				LastDeployed:       &lastDeployed,
+				ValuesHash:         "sha256:0",
+				Manifest:           "manifest",
+				CompressedManifest: []byte("manifest"),
+			},
+			ChartVersion:          "0.1.0",
+			Resources:             []Resource{{APIVersion: "v1", Kind: "Service", Namespace: "ns", Name: "name", Health: health.StatusHealthy, Message: "message"}},
+			ResourceCount:         1,
+			PendingWait:           &Wait{ReleaseVersion: 1, Deadline: metav1.Now(), Install: true},
+			Rollback:              &Rollback{Revision: 1, ReleaseVersion: 2, Succeeded: true, Message: "message"},
+			PermanentError:        &PermanentError{Reason: "RenderError", Generation: 1, Checksum: "sha256:0"},
+			Failures:              1,
+			LastAttemptedRevision: &Revision{ChartVersion: "0.1.0", Generation: 1, Checksum: "sha256:0"},
+			Diagnostics: &diagnostics.Summary{
+				ReleaseVersion: 1,
+				Pods:           []diagnostics.Pod{{Namespace: "ns", Name: "pod", Container: "app", Reason: "CrashLoopBackOff", Message: "message", RestartCount: 1}},
+				Events:         []diagnostics.Event{{Kind: "Pod", Namespace: "ns", Name: "pod", Reason: "BackOff", Message: "message", Count: 1, LastSeen: metav1.Now()}},
+				Hooks:          []diagnostics.Hook{{Kind: "Job", Name: "hook", Reason: "BackoffLimitExceeded", Message: "message"}},
+			},
+		}
+		st.ReconciledGeneration = 1
+		st.setCondition(conditions.Deployed("True", "reason", "message"))
+		st.observe()
+		data, err := json.Marshal(st)
+		Expect(err).To(BeNil())
+		var m map[string]interface{}
+		Expect(json.Unmarshal(data, &m)).To(Succeed())
+
+		expectInSchema(StatusSchema(), m, "status")
+	})
+})
+
+func expectInSchema(schema apiextv1.JSONSchemaProps, v interface{}, path string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		Expect(schema.Type).To(Equal("object"), path)
+		for k, val := range v {
+			prop, ok := schema.Properties[k]
+			Expect(ok).To(BeTrue(), "%s.%s is not in the schema", path, k)
+			expectInSchema(prop, val, path+"."+k)
+		}
+	case []interface{}:
+		Expect(schema.Type).To(Equal("array"), path)
+		for _, val := range v {
+			expectInSchema(*schema.Items.Schema, val, path+"[]")
+		}
+	case string:
+		Expect(schema.Type).To(Equal("string"), path)
+	case float64:
+		Expect(schema.Type).To(Equal("integer"), path)
+	case bool:
+		Expect(schema.Type).To(Equal("boolean"), path)
+	}
+}
@@ -17,17 +17,28 @@ limitations under the License.
 package updater
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
 
 	"helm.sh/helm/v3/pkg/release"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/joelanford/helm-operator/pkg/health"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/controllerutil"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/status"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/diagnostics"
 )
 
 func New(client client.Client) Updater {
@@ -61,10 +72,14 @@ func (u *Updater) Apply(ctx context.Context, obj *unstructured.Unstructured) err
 	// because the object and its status will be garbage-collected
 	if err := retry.RetryOnConflict(backoff, func() error {
 		st := statusFor(obj)
+		st.ReconciledGeneration = obj.GetGeneration()
 		needsStatusUpdate := false
 		for _, f := range u.updateStatusFuncs {
 			needsStatusUpdate = f(st) || needsStatusUpdate
 		}
+		// The status reflects the generation of the object that was
+		// reconciled. Conditions reflect it only if they were set above.
+		needsStatusUpdate = st.observe() || needsStatusUpdate
 		if needsStatusUpdate {
 			uSt, err := runtime.DefaultUnstructuredConverter.ToUnstructured(st)
 			if err != nil {
@@ -116,27 +131,45 @@ func RemoveFinalizer(finalizer string) UpdateFunc {
 
 func EnsureCondition(condition status.Condition) UpdateStatusFunc {
 	return func(status *helmAppStatus) bool {
-		return status.Conditions.SetCondition(condition)
+		return status.setCondition(condition)
 	}
 }
 
 func EnsureConditionUnknown(t status.ConditionType) UpdateStatusFunc {
 	return func(s *helmAppStatus) bool {
-		return s.Conditions.SetCondition(status.Condition{
+		return s.setCondition(status.Condition{
 			Type:   t,
 			Status: corev1.ConditionUnknown,
 		})
 	}
 }
 
-func EnsureDeployedRelease(rel *release.Release) UpdateStatusFunc {
+func RemoveCondition(t status.ConditionType) UpdateStatusFunc {
+	return func(s *helmAppStatus) bool {
+		return s.Conditions.RemoveCondition(t)
+	}
+}
+
+// ManifestMode determines how the manifest of the deployed release is
+// recorded in the status.
+type ManifestMode string
+
+const (
+	// ManifestFull records the manifest as is.
+	ManifestFull ManifestMode = "full"
+
+	// ManifestOmit does not record the manifest.
+	ManifestOmit ManifestMode = "omit"
+
+	// ManifestCompress records the gzipped manifest in
+	// `compressedManifest`.
+	ManifestCompress ManifestMode = "compress"
+)
+
+func EnsureDeployedRelease(rel *release.Release, mode ManifestMode) UpdateStatusFunc {
 	return func(status *helmAppStatus) bool {
-		newRel := helmAppReleaseFor(rel)
-		if status.DeployedRelease == nil && newRel == nil {
-			return false
-		}
-		if status.DeployedRelease != nil && newRel != nil &&
-			*status.DeployedRelease == *newRel {
+		newRel := helmAppReleaseFor(rel, mode)
+		if equality.Semantic.DeepEqual(status.DeployedRelease, newRel) {
 			return false
 		}
 		status.DeployedRelease = newRel
@@ -145,17 +178,255 @@ func EnsureDeployedRelease(rel *release.Release) UpdateStatusFunc {
 }
 
 func RemoveDeployedRelease() UpdateStatusFunc {
-	return EnsureDeployedRelease(nil)
+	return EnsureDeployedRelease(nil, "")
+}
+
+func EnsureChartVersion(version string) UpdateStatusFunc {
+	return func(status *helmAppStatus) bool {
+		if status.ChartVersion == version {
+			return false
+		}
+		status.ChartVersion = version
+		return true
+	}
+}
+
+// EnsureResources sets the resource inventory of the deployed release and
+// its resource count.
+func EnsureResources(resources []Resource) UpdateStatusFunc {
+	return func(status *helmAppStatus) bool {
+		if reflect.DeepEqual(status.Resources, resources) && status.ResourceCount == len(resources) {
+			return false
+		}
+		status.Resources = resources
+		status.ResourceCount = len(resources)
+		return true
+	}
+}
+
+func EnsurePendingWait(wait *Wait) UpdateStatusFunc {
+	return func(status *helmAppStatus) bool {
+		if reflect.DeepEqual(status.PendingWait, wait) {
+			return false
+		}
+		status.PendingWait = wait
+		return true
+	}
+}
+
+func RemovePendingWait() UpdateStatusFunc {
+	return EnsurePendingWait(nil)
+}
+
+// PendingWait returns the pending wait recorded in the status of obj, or nil
+// if there is none.
+func PendingWait(obj *unstructured.Unstructured) *Wait {
+	st := statusFor(obj)
+	if st == nil {
+		return nil
+	}
+	return st.PendingWait
+}
+
+func EnsureRollback(rollback *Rollback) UpdateStatusFunc {
+	return func(status *helmAppStatus) bool {
+		if reflect.DeepEqual(status.Rollback, rollback) {
+			return false
+		}
+		status.Rollback = rollback
+		return true
+	}
+}
+
+// LastRollback returns the result of the last requested rollback recorded in
+// the status of obj, or nil if there is none.
+func LastRollback(obj *unstructured.Unstructured) *Rollback {
+	st := statusFor(obj)
+	if st == nil {
+		return nil
+	}
+	return st.Rollback
+}
+
+func EnsurePermanentError(permanentError *PermanentError) UpdateStatusFunc {
+	return func(status *helmAppStatus) bool {
+		if reflect.DeepEqual(status.PermanentError, permanentError) {
+			return false
+		}
+		status.PermanentError = permanentError
+		return true
+	}
+}
+
+func RemovePermanentError() UpdateStatusFunc {
+	return EnsurePermanentError(nil)
+}
+
+// LastPermanentError returns the permanent error recorded in the status of
+// obj, or nil if there is none.
+func LastPermanentError(obj *unstructured.Unstructured) *PermanentError {
+	st := statusFor(obj)
+	if st == nil {
+		return nil
+	}
+	return st.PermanentError
+}
+
+// EnsureFailures records the number of consecutive failed installations and
+// upgrades of revision.
+func EnsureFailures(failures int, revision *Revision) UpdateStatusFunc {
+	return func(status *helmAppStatus) bool {
+		if status.Failures == failures && reflect.DeepEqual(status.LastAttemptedRevision, revision) {
+			return false
+		}
+		status.Failures = failures
+		status.LastAttemptedRevision = revision
+		return true
+	}
+}
+
+func RemoveFailures() UpdateStatusFunc {
+	return EnsureFailures(0, nil)
+}
+
+// LastFailures returns the number of consecutive failed installations and
+// upgrades, and the revision they attempted, recorded in the status of obj.
+func LastFailures(obj *unstructured.Unstructured) (int, *Revision) {
+	st := statusFor(obj)
+	if st == nil {
+		return 0, nil
+	}
+	return st.Failures, st.LastAttemptedRevision
+}
+
+// EnsureDiagnostics sets the diagnostics of the last failed release
+// installation or upgrade.
+func EnsureDiagnostics(summary *diagnostics.Summary) UpdateStatusFunc {
+	return func(status *helmAppStatus) bool {
+		if reflect.DeepEqual(status.Diagnostics, summary) {
+			return false
+		}
+		status.Diagnostics = summary
+		return true
+	}
+}
+
+func RemoveDiagnostics() UpdateStatusFunc {
+	return EnsureDiagnostics(nil)
 }
 
 type helmAppStatus struct {
-	Conditions      status.Conditions `json:"conditions"`
-	DeployedRelease *helmAppRelease   `json:"deployedRelease,omitempty"`
+	// ObservedGeneration is the generation of the custom resource when the
+	// status was last updated.
+	ObservedGeneration int64             `json:"observedGeneration,omitempty"`
+	Conditions         status.Conditions `json:"conditions"`
+	DeployedRelease    *helmAppRelease   `json:"deployedRelease,omitempty"`
+	ChartVersion       string            `json:"chartVersion,omitempty"`
+	Resources          []Resource        `json:"resources,omitempty"`
+	ResourceCount      int               `json:"resourceCount,omitempty"`
+	PendingWait        *Wait             `json:"pendingWait,omitempty"`
+	Rollback           *Rollback         `json:"rollback,omitempty"`
+	PermanentError     *PermanentError   `json:"permanentError,omitempty"`
+
+	// Failures is the number of consecutive failed installations and
+	// upgrades of LastAttemptedRevision.
+	Failures              int       `json:"failures,omitempty"`
+	LastAttemptedRevision *Revision `json:"lastAttemptedRevision,omitempty"`
+
+	// Diagnostics summarizes why the last release installation or upgrade
+	// failed.
+	Diagnostics *diagnostics.Summary `json:"diagnostics,omitempty"`
+
+	// ReconciledGeneration is the generation of the custom resource being
+	// reconciled. It is not part of the status.
+	ReconciledGeneration int64 `json:"-"`
+}
+
+// PermanentError records a reconciliation error that is not retried until
+// the generation of the custom resource or the checksum of its inputs
+// changes.
+type PermanentError struct {
+	Reason     string `json:"reason"`
+	Generation int64  `json:"generation"`
+	Checksum   string `json:"checksum"`
+}
+
+// Revision identifies the inputs of a release installation or upgrade: the
+// chart version, and the generation and inputs checksum of the custom
+// resource.
+type Revision struct {
+	ChartVersion string `json:"chartVersion"`
+	Generation   int64  `json:"generation"`
+	Checksum     string `json:"checksum"`
+}
+
+// Rollback is the result of a requested rollback of a release.
+type Rollback struct {
+	// Revision is the requested revision.
+	Revision int `json:"revision"`
+
+	// ReleaseVersion is the version of the release after the rollback was
+	// attempted. A successful rollback creates a new release version.
+	ReleaseVersion int `json:"releaseVersion"`
+
+	Succeeded bool   `json:"succeeded"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Wait records that the resources of a release are being waited on to
+// become ready.
+type Wait struct {
+	ReleaseVersion int         `json:"releaseVersion"`
+	Deadline       metav1.Time `json:"deadline"`
+
+	// Install is true if the release was installed rather than upgraded, so
+	// that it is uninstalled rather than rolled back if the wait fails.
+	Install bool `json:"install,omitempty"`
+}
+
+// Resource is an entry of the resource inventory of the deployed release. The
+// health of the resource is optional.
+type Resource struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Name       string        `json:"name"`
+	Health     health.Status `json:"health,omitempty"`
+	Message    string        `json:"message,omitempty"`
+}
+
+// setCondition sets condition, observed at the generation being reconciled,
+// and returns whether it changed the conditions.
+func (s *helmAppStatus) setCondition(condition status.Condition) bool {
+	condition.ObservedGeneration = s.ReconciledGeneration
+	return s.Conditions.SetCondition(condition)
+}
+
+// observe sets the observed generation of the status to the generation being
+// reconciled, and returns whether it changed. Conditions that were not set
+// keep the generation at which they were last set.
+func (s *helmAppStatus) observe() bool {
+	changed := s.ObservedGeneration != s.ReconciledGeneration
+	s.ObservedGeneration = s.ReconciledGeneration
+	return changed
 }
 
 type helmAppRelease struct {
-	Name     string `json:"name,omitempty"`
-	Manifest string `json:"manifest,omitempty"`
+	Name         string       `json:"name,omitempty"`
+	Namespace    string       `json:"namespace,omitempty"`
+	Revision     int          `json:"revision,omitempty"`
+	Status       string       `json:"status,omitempty"`
+	ChartName    string       `json:"chartName,omitempty"`
+	ChartVersion string       `json:"chartVersion,omitempty"`
+	AppVersion   string       `json:"appVersion,omitempty"`
+	LastDeployed *metav1.Time `json:"lastDeployed,omitempty"`
+
+	// ValuesHash is the SHA-256 digest of the values of the release, which
+	// changes whenever the values do.
+	ValuesHash string `json:"valuesHash,omitempty"`
+
+	Manifest           string `json:"manifest,omitempty"`
+	CompressedManifest []byte `json:"compressedManifest,omitempty"`
 }
 
 func statusFor(obj *unstructured.Unstructured) *helmAppStatus {
@@ -181,12 +452,47 @@ func statusFor(obj *unstructured.Unstructured) *helmAppStatus {
 	}
 }
 
-func helmAppReleaseFor(rel *release.Release) *helmAppRelease {
+func helmAppReleaseFor(rel *release.Release, mode ManifestMode) *helmAppRelease {
 	if rel == nil {
 		return nil
 	}
-	return &helmAppRelease{
-		Name:     rel.Name,
-		Manifest: rel.Manifest,
+	out := &helmAppRelease{
+		Name:      rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  rel.Version,
+	}
+	if rel.Info != nil {
+		out.Status = rel.Info.Status.String()
+		if !rel.Info.LastDeployed.IsZero() {
+			// The status only has a precision of seconds.
+			t := metav1.NewTime(rel.Info.LastDeployed.Time.Truncate(time.Second))
+			out.LastDeployed = &t
+		}
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		out.ChartName = rel.Chart.Metadata.Name
+		out.ChartVersion = rel.Chart.Metadata.Version
+		out.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	if rel.Config != nil {
+		if data, err := json.Marshal(rel.Config); err == nil {
+			out.ValuesHash = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		}
 	}
+	switch mode {
+	case ManifestOmit:
+	case ManifestCompress:
+		out.CompressedManifest = compress(rel.Manifest)
+	default:
+		out.Manifest = rel.Manifest
+	}
+	return out
+}
+
+func compress(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(s))
+	_ = zw.Close()
+	return buf.Bytes()
 }
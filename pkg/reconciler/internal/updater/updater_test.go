@@ -17,20 +17,29 @@ limitations under the License.
 package updater
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"io/ioutil"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/kubectl/pkg/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
+	"github.com/joelanford/helm-operator/pkg/health"
 	"github.com/joelanford/helm-operator/pkg/reconciler/internal/conditions"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/diagnostics"
 )
 
 const testFinalizer = "testFinalizer"
@@ -87,6 +96,41 @@ var _ = Describe("Updater", func() {
 			Expect((obj.Object["status"].(map[string]interface{}))["conditions"]).To(HaveLen(1))
 			Expect(obj.GetResourceVersion()).NotTo(Equal(resourceVersion))
 		})
+
+		It("should set the observed generation of the status and its conditions", func() {
+			obj.SetGeneration(2)
+			Expect(client.Update(context.TODO(), obj)).To(Succeed())
+			u.UpdateStatus(EnsureCondition(conditions.Deployed(corev1.ConditionTrue, "", "")))
+
+			Expect(u.Apply(context.TODO(), obj)).To(Succeed())
+			Expect(client.Get(context.TODO(), types.NamespacedName{Namespace: "testNamespace", Name: "testDeployment"}, obj)).To(Succeed())
+			st := statusFor(obj)
+			Expect(st.ObservedGeneration).To(Equal(int64(2)))
+			Expect(st.Conditions).To(HaveLen(1))
+			Expect(st.Conditions[0].ObservedGeneration).To(Equal(int64(2)))
+		})
+
+		It("should keep the observed generation of conditions that were not set", func() {
+			obj.SetGeneration(1)
+			Expect(client.Update(context.TODO(), obj)).To(Succeed())
+			u.UpdateStatus(
+				EnsureCondition(conditions.Deployed(corev1.ConditionTrue, "", "")),
+				EnsureCondition(conditions.Irreconcilable(corev1.ConditionFalse, "", "")),
+			)
+			Expect(u.Apply(context.TODO(), obj)).To(Succeed())
+
+			obj.SetGeneration(2)
+			Expect(client.Update(context.TODO(), obj)).To(Succeed())
+			u = New(client)
+			u.UpdateStatus(EnsureCondition(conditions.Deployed(corev1.ConditionTrue, "", "")))
+
+			Expect(u.Apply(context.TODO(), obj)).To(Succeed())
+			Expect(client.Get(context.TODO(), types.NamespacedName{Namespace: "testNamespace", Name: "testDeployment"}, obj)).To(Succeed())
+			st := statusFor(obj)
+			Expect(st.ObservedGeneration).To(Equal(int64(2)))
+			Expect(st.Conditions.GetCondition(conditions.TypeDeployed).ObservedGeneration).To(Equal(int64(2)))
+			Expect(st.Conditions.GetCondition(conditions.TypeIrreconcilable).ObservedGeneration).To(Equal(int64(1)))
+		})
 	})
 })
 
@@ -147,6 +191,249 @@ var _ = Describe("EnsureCondition", func() {
 	})
 })
 
+var _ = Describe("EnsurePendingWait", func() {
+	var obj *helmAppStatus
+	var wait *Wait
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+		wait = &Wait{ReleaseVersion: 1, Deadline: metav1.Unix(1000, 0)}
+	})
+
+	It("should add pending wait if not present", func() {
+		Expect(EnsurePendingWait(wait)(obj)).To(BeTrue())
+		Expect(obj.PendingWait).To(Equal(wait))
+	})
+
+	It("should not update identical pending wait", func() {
+		obj.PendingWait = &Wait{ReleaseVersion: 1, Deadline: metav1.Unix(1000, 0)}
+		Expect(EnsurePendingWait(wait)(obj)).To(BeFalse())
+	})
+
+	It("should remove pending wait", func() {
+		obj.PendingWait = wait
+		Expect(RemovePendingWait()(obj)).To(BeTrue())
+		Expect(obj.PendingWait).To(BeNil())
+	})
+})
+
+var _ = Describe("PendingWait", func() {
+	It("should return the pending wait from an object's status", func() {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"pendingWait": map[string]interface{}{
+					"releaseVersion": int64(2),
+					"deadline":       "2020-01-01T00:00:00Z",
+				},
+			},
+		}}
+		w := PendingWait(obj)
+		Expect(w).NotTo(BeNil())
+		Expect(w.ReleaseVersion).To(Equal(2))
+		Expect(w.Deadline.UTC()).To(Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("should return nil without a pending wait", func() {
+		Expect(PendingWait(&unstructured.Unstructured{Object: map[string]interface{}{}})).To(BeNil())
+	})
+})
+
+var _ = Describe("EnsureRollback", func() {
+	var obj *helmAppStatus
+	var rollback *Rollback
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+		rollback = &Rollback{Revision: 1, ReleaseVersion: 3, Succeeded: true}
+	})
+
+	It("should add rollback if not present", func() {
+		Expect(EnsureRollback(rollback)(obj)).To(BeTrue())
+		Expect(obj.Rollback).To(Equal(rollback))
+	})
+
+	It("should not update identical rollback", func() {
+		obj.Rollback = &Rollback{Revision: 1, ReleaseVersion: 3, Succeeded: true}
+		Expect(EnsureRollback(rollback)(obj)).To(BeFalse())
+	})
+
+	It("should update rollback if different", func() {
+		obj.Rollback = rollback
+		failed := &Rollback{Revision: 2, ReleaseVersion: 3, Message: "not found"}
+		Expect(EnsureRollback(failed)(obj)).To(BeTrue())
+		Expect(obj.Rollback).To(Equal(failed))
+	})
+})
+
+var _ = Describe("EnsurePermanentError", func() {
+	var obj *helmAppStatus
+	var permanentError *PermanentError
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+		permanentError = &PermanentError{Reason: "RenderError", Generation: 1, Checksum: "sha256:0"}
+	})
+
+	It("should add permanent error if not present", func() {
+		Expect(EnsurePermanentError(permanentError)(obj)).To(BeTrue())
+		Expect(obj.PermanentError).To(Equal(permanentError))
+	})
+
+	It("should not update identical permanent error", func() {
+		obj.PermanentError = &PermanentError{Reason: "RenderError", Generation: 1, Checksum: "sha256:0"}
+		Expect(EnsurePermanentError(permanentError)(obj)).To(BeFalse())
+	})
+
+	It("should remove permanent error", func() {
+		obj.PermanentError = permanentError
+		Expect(RemovePermanentError()(obj)).To(BeTrue())
+		Expect(obj.PermanentError).To(BeNil())
+	})
+})
+
+var _ = Describe("EnsureFailures", func() {
+	var obj *helmAppStatus
+	var revision *Revision
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+		revision = &Revision{ChartVersion: "0.1.0", Generation: 1, Checksum: "sha256:0"}
+	})
+
+	It("should add failures if not present", func() {
+		Expect(EnsureFailures(1, revision)(obj)).To(BeTrue())
+		Expect(obj.Failures).To(Equal(1))
+		Expect(obj.LastAttemptedRevision).To(Equal(revision))
+	})
+
+	It("should not update identical failures", func() {
+		obj.Failures = 1
+		obj.LastAttemptedRevision = &Revision{ChartVersion: "0.1.0", Generation: 1, Checksum: "sha256:0"}
+		Expect(EnsureFailures(1, revision)(obj)).To(BeFalse())
+	})
+
+	It("should update the number of failures", func() {
+		obj.Failures = 1
+		obj.LastAttemptedRevision = revision
+		Expect(EnsureFailures(2, revision)(obj)).To(BeTrue())
+		Expect(obj.Failures).To(Equal(2))
+	})
+
+	It("should remove failures", func() {
+		obj.Failures = 1
+		obj.LastAttemptedRevision = revision
+		Expect(RemoveFailures()(obj)).To(BeTrue())
+		Expect(obj.Failures).To(BeZero())
+		Expect(obj.LastAttemptedRevision).To(BeNil())
+	})
+})
+
+var _ = Describe("EnsureDiagnostics", func() {
+	var obj *helmAppStatus
+	var summary *diagnostics.Summary
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+		summary = &diagnostics.Summary{ReleaseVersion: 2, Hooks: []diagnostics.Hook{{Kind: "Job", Name: "migrate"}}}
+	})
+
+	It("should add diagnostics if not present", func() {
+		Expect(EnsureDiagnostics(summary)(obj)).To(BeTrue())
+		Expect(obj.Diagnostics).To(Equal(summary))
+	})
+
+	It("should not update identical diagnostics", func() {
+		obj.Diagnostics = &diagnostics.Summary{ReleaseVersion: 2, Hooks: []diagnostics.Hook{{Kind: "Job", Name: "migrate"}}}
+		Expect(EnsureDiagnostics(summary)(obj)).To(BeFalse())
+	})
+
+	It("should remove diagnostics", func() {
+		obj.Diagnostics = summary
+		Expect(RemoveDiagnostics()(obj)).To(BeTrue())
+		Expect(obj.Diagnostics).To(BeNil())
+	})
+})
+
+var _ = Describe("LastPermanentError", func() {
+	It("should return the permanent error from an object's status", func() {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"permanentError": map[string]interface{}{
+					"reason":     "RenderError",
+					"generation": int64(2),
+					"checksum":   "sha256:0",
+				},
+			},
+		}}
+		Expect(LastPermanentError(obj)).To(Equal(&PermanentError{Reason: "RenderError", Generation: 2, Checksum: "sha256:0"}))
+	})
+
+	It("should return nil without a permanent error", func() {
+		Expect(LastPermanentError(&unstructured.Unstructured{Object: map[string]interface{}{}})).To(BeNil())
+	})
+})
+
+var _ = Describe("LastFailures", func() {
+	It("should return the failures from an object's status", func() {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"failures": int64(2),
+				"lastAttemptedRevision": map[string]interface{}{
+					"chartVersion": "0.1.0",
+					"generation":   int64(3),
+					"checksum":     "sha256:0",
+				},
+			},
+		}}
+		failures, revision := LastFailures(obj)
+		Expect(failures).To(Equal(2))
+		Expect(revision).To(Equal(&Revision{ChartVersion: "0.1.0", Generation: 3, Checksum: "sha256:0"}))
+	})
+
+	It("should return no failures without a status", func() {
+		failures, revision := LastFailures(&unstructured.Unstructured{Object: map[string]interface{}{}})
+		Expect(failures).To(BeZero())
+		Expect(revision).To(BeNil())
+	})
+})
+
+var _ = Describe("LastRollback", func() {
+	It("should return the last rollback from an object's status", func() {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"rollback": map[string]interface{}{
+					"revision":       int64(1),
+					"releaseVersion": int64(3),
+					"succeeded":      true,
+				},
+			},
+		}}
+		Expect(LastRollback(obj)).To(Equal(&Rollback{Revision: 1, ReleaseVersion: 3, Succeeded: true}))
+	})
+
+	It("should return nil without a rollback", func() {
+		Expect(LastRollback(&unstructured.Unstructured{Object: map[string]interface{}{}})).To(BeNil())
+	})
+})
+
+var _ = Describe("RemoveCondition", func() {
+	var obj *helmAppStatus
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+	})
+
+	It("should remove condition if present", func() {
+		obj.Conditions.SetCondition(conditions.Drifted(corev1.ConditionTrue, "", ""))
+		Expect(RemoveCondition(conditions.TypeDrifted)(obj)).To(BeTrue())
+		Expect(obj.Conditions.GetCondition(conditions.TypeDrifted)).To(BeNil())
+	})
+
+	It("should return false if condition is not present", func() {
+		Expect(RemoveCondition(conditions.TypeDrifted)(obj)).To(BeFalse())
+	})
+})
+
 var _ = Describe("EnsureDeployedRelease", func() {
 	var obj *helmAppStatus
 	var rel *release.Release
@@ -165,27 +452,70 @@ var _ = Describe("EnsureDeployedRelease", func() {
 	})
 
 	It("should add deployed release if not present", func() {
-		Expect(EnsureDeployedRelease(rel)(obj)).To(BeTrue())
+		Expect(EnsureDeployedRelease(rel, ManifestFull)(obj)).To(BeTrue())
 		Expect(obj.DeployedRelease).To(Equal(statusRelease))
 	})
 
 	It("should not update identical deployed release", func() {
 		obj.DeployedRelease = statusRelease
-		Expect(EnsureDeployedRelease(rel)(obj)).To(BeFalse())
+		Expect(EnsureDeployedRelease(rel, ManifestFull)(obj)).To(BeFalse())
 		Expect(obj.DeployedRelease).To(Equal(statusRelease))
 	})
 
 	It("should update deployed release if different name", func() {
 		obj.DeployedRelease = statusRelease
-		Expect(EnsureDeployedRelease(&release.Release{Name: "newName", Manifest: "initialManifest"})(obj)).To(BeTrue())
+		Expect(EnsureDeployedRelease(&release.Release{Name: "newName", Manifest: "initialManifest"}, ManifestFull)(obj)).To(BeTrue())
 		Expect(obj.DeployedRelease).To(Equal(&helmAppRelease{Name: "newName", Manifest: "initialManifest"}))
 	})
 
 	It("should update deployed release if different manifest", func() {
 		obj.DeployedRelease = statusRelease
-		Expect(EnsureDeployedRelease(&release.Release{Name: "initialName", Manifest: "newManifest"})(obj)).To(BeTrue())
+		Expect(EnsureDeployedRelease(&release.Release{Name: "initialName", Manifest: "newManifest"}, ManifestFull)(obj)).To(BeTrue())
 		Expect(obj.DeployedRelease).To(Equal(&helmAppRelease{Name: "initialName", Manifest: "newManifest"}))
 	})
+
+	It("should record the release metadata", func() {
+		deployed := time.Date(2020, time.June, 1, 12, 0, 0, 500, time.UTC)
+		rel.Namespace = "ns"
+		rel.Version = 3
+		rel.Info = &release.Info{Status: release.StatusDeployed, LastDeployed: helmtime.Time{Time: deployed}}
+		rel.Chart = &chart.Chart{Metadata: &chart.Metadata{Name: "test-chart", Version: "0.1.0", AppVersion: "1.0.0"}}
+		rel.Config = map[string]interface{}{"replicaCount": 1}
+
+		Expect(EnsureDeployedRelease(rel, ManifestFull)(obj)).To(BeTrue())
+		Expect(obj.DeployedRelease.Namespace).To(Equal("ns"))
+		Expect(obj.DeployedRelease.Revision).To(Equal(3))
+		Expect(obj.DeployedRelease.Status).To(Equal("deployed"))
+		Expect(obj.DeployedRelease.ChartName).To(Equal("test-chart"))
+		Expect(obj.DeployedRelease.ChartVersion).To(Equal("0.1.0"))
+		Expect(obj.DeployedRelease.AppVersion).To(Equal("1.0.0"))
+		Expect(obj.DeployedRelease.LastDeployed.Time).To(Equal(deployed.Truncate(time.Second)))
+		Expect(obj.DeployedRelease.ValuesHash).To(HavePrefix("sha256:"))
+
+		By("changing the hash with the values")
+		hash := obj.DeployedRelease.ValuesHash
+		rel.Config = map[string]interface{}{"replicaCount": 2}
+		Expect(EnsureDeployedRelease(rel, ManifestFull)(obj)).To(BeTrue())
+		Expect(obj.DeployedRelease.ValuesHash).NotTo(Equal(hash))
+	})
+
+	It("should not record the manifest if it is omitted", func() {
+		Expect(EnsureDeployedRelease(rel, ManifestOmit)(obj)).To(BeTrue())
+		Expect(obj.DeployedRelease.Manifest).To(BeEmpty())
+		Expect(obj.DeployedRelease.CompressedManifest).To(BeEmpty())
+	})
+
+	It("should record the compressed manifest", func() {
+		Expect(EnsureDeployedRelease(rel, ManifestCompress)(obj)).To(BeTrue())
+		Expect(obj.DeployedRelease.Manifest).To(BeEmpty())
+		zr, err := gzip.NewReader(bytes.NewReader(obj.DeployedRelease.CompressedManifest))
+		Expect(err).To(BeNil())
+		manifest, err := ioutil.ReadAll(zr)
+		Expect(err).To(BeNil())
+		Expect(string(manifest)).To(Equal("initialManifest"))
+
+		Expect(EnsureDeployedRelease(rel, ManifestCompress)(obj)).To(BeFalse())
+	})
 })
 
 var _ = Describe("RemoveDeployedRelease", func() {
@@ -212,6 +542,74 @@ var _ = Describe("RemoveDeployedRelease", func() {
 	})
 })
 
+var _ = Describe("EnsureChartVersion", func() {
+	var obj *helmAppStatus
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+	})
+
+	It("should set chart version if not present", func() {
+		Expect(EnsureChartVersion("0.1.0")(obj)).To(BeTrue())
+		Expect(obj.ChartVersion).To(Equal("0.1.0"))
+	})
+
+	It("should not update identical chart version", func() {
+		obj.ChartVersion = "0.1.0"
+		Expect(EnsureChartVersion("0.1.0")(obj)).To(BeFalse())
+	})
+})
+
+var _ = Describe("EnsureResources", func() {
+	var obj *helmAppStatus
+	var resources []Resource
+
+	BeforeEach(func() {
+		obj = &helmAppStatus{}
+		resources = []Resource{{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Namespace:  "testNamespace",
+			Name:       "testDeployment",
+			Health:     health.StatusProgressing,
+			Message:    "0 of 1 updated replicas",
+		}}
+	})
+
+	It("should add resources if not present", func() {
+		Expect(EnsureResources(resources)(obj)).To(BeTrue())
+		Expect(obj.Resources).To(Equal(resources))
+		Expect(obj.ResourceCount).To(Equal(1))
+	})
+
+	It("should not update identical resources", func() {
+		obj.Resources = []Resource{resources[0]}
+		obj.ResourceCount = 1
+		Expect(EnsureResources(resources)(obj)).To(BeFalse())
+	})
+
+	It("should set the resource count of existing resources", func() {
+		obj.Resources = []Resource{resources[0]}
+		Expect(EnsureResources(resources)(obj)).To(BeTrue())
+		Expect(obj.ResourceCount).To(Equal(1))
+	})
+
+	It("should clear resources", func() {
+		obj.Resources = []Resource{resources[0]}
+		obj.ResourceCount = 1
+		Expect(EnsureResources(nil)(obj)).To(BeTrue())
+		Expect(obj.Resources).To(BeNil())
+		Expect(obj.ResourceCount).To(BeZero())
+	})
+
+	It("should update resources with a different health", func() {
+		obj.Resources = []Resource{resources[0]}
+		resources[0].Health = health.StatusHealthy
+		Expect(EnsureResources(resources)(obj)).To(BeTrue())
+		Expect(obj.Resources[0].Health).To(Equal(health.StatusHealthy))
+	})
+})
+
 var _ = Describe("statusFor", func() {
 	var obj *unstructured.Unstructured
 
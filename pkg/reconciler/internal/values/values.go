@@ -57,6 +57,57 @@ func (v *Values) Map() map[string]interface{} {
 	return v.m
 }
 
+// Copy returns a deep copy of v, so that modifying the copy does not modify
+// the object v was created from.
+func (v *Values) Copy() *Values {
+	if v == nil {
+		return nil
+	}
+	return New(copyMap(v.m))
+}
+
+// Remove removes the top-level keys from v.
+func (v *Values) Remove(keys ...string) {
+	for _, k := range keys {
+		delete(v.m, k)
+	}
+}
+
+// Coalesce fills in keys that are missing from v with the values in defaults.
+// Nested maps are merged recursively.
+func (v *Values) Coalesce(defaults map[string]interface{}) {
+	if len(defaults) == 0 {
+		return
+	}
+	v.m = chartutil.CoalesceTables(v.m, copyMap(defaults))
+}
+
+func copyMap(in map[string]interface{}) map[string]interface{} {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+func copyValue(in interface{}) interface{} {
+	switch v := in.(type) {
+	case map[string]interface{}:
+		return copyMap(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = copyValue(v[i])
+		}
+		return out
+	default:
+		return v
+	}
+}
+
 func (v *Values) ApplyOverrides(in map[string]string) error {
 	for inK, inV := range in {
 		val := fmt.Sprintf("%s=%s", inK, os.ExpandEnv(inV))
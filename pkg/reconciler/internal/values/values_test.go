@@ -76,6 +76,61 @@ var _ = Describe("Values", func() {
 		})
 	})
 
+	var _ = Describe("Copy", func() {
+		It("should return nil with nil values", func() {
+			var v *Values
+			Expect(v.Copy()).To(BeNil())
+		})
+
+		It("should not share nested maps and slices", func() {
+			m := map[string]interface{}{
+				"foo":  map[string]interface{}{"bar": "baz"},
+				"list": []interface{}{map[string]interface{}{"a": "b"}},
+			}
+			v := New(m).Copy()
+			Expect(v.Map()).To(Equal(m))
+
+			v.Map()["foo"].(map[string]interface{})["bar"] = "qux"
+			v.Map()["list"].([]interface{})[0].(map[string]interface{})["a"] = "c"
+			Expect(m["foo"]).To(Equal(map[string]interface{}{"bar": "baz"}))
+			Expect(m["list"]).To(Equal([]interface{}{map[string]interface{}{"a": "b"}}))
+		})
+	})
+
+	var _ = Describe("Remove", func() {
+		It("should remove top-level keys", func() {
+			v := New(map[string]interface{}{"foo": "bar", "baz": "qux", "quux": "corge"})
+			v.Remove("foo", "baz", "missing")
+			Expect(v.Map()).To(Equal(map[string]interface{}{"quux": "corge"}))
+		})
+	})
+
+	var _ = Describe("Coalesce", func() {
+		It("should keep existing values and fill in missing values", func() {
+			v := New(map[string]interface{}{"foo": map[string]interface{}{"bar": "baz"}})
+			v.Coalesce(map[string]interface{}{
+				"foo":   map[string]interface{}{"bar": "ignored", "qux": "quux"},
+				"corge": "grault",
+			})
+			Expect(v.Map()).To(Equal(map[string]interface{}{
+				"foo":   map[string]interface{}{"bar": "baz", "qux": "quux"},
+				"corge": "grault",
+			}))
+		})
+
+		It("should keep values without defaults", func() {
+			v := New(map[string]interface{}{"foo": "bar"})
+			v.Coalesce(nil)
+			Expect(v.Map()).To(Equal(map[string]interface{}{"foo": "bar"}))
+		})
+
+		It("should succeed with nil values", func() {
+			v := New(nil)
+			v.Coalesce(map[string]interface{}{"foo": "bar"})
+			Expect(v.Map()).To(Equal(map[string]interface{}{"foo": "bar"}))
+		})
+	})
+
 	var _ = Describe("ApplyOverrides", func() {
 		It("should succeed with empty values", func() {
 			v := New(map[string]interface{}{})
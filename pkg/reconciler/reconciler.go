@@ -20,10 +20,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
 	"helm.sh/helm/v3/pkg/action"
@@ -32,56 +34,112 @@ import (
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	"github.com/joelanford/helm-operator/pkg/annotation"
 	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/diff"
+	"github.com/joelanford/helm-operator/pkg/health"
 	"github.com/joelanford/helm-operator/pkg/hook"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/controllerutil"
+	"github.com/joelanford/helm-operator/pkg/internal/sdk/status"
+	helmmanager "github.com/joelanford/helm-operator/pkg/manager"
 	"github.com/joelanford/helm-operator/pkg/reconciler/internal/conditions"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/diagnostics"
 	internalhook "github.com/joelanford/helm-operator/pkg/reconciler/internal/hook"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/inventory"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/rendercache"
 	"github.com/joelanford/helm-operator/pkg/reconciler/internal/updater"
 	internalvalues "github.com/joelanford/helm-operator/pkg/reconciler/internal/values"
 	"github.com/joelanford/helm-operator/pkg/values"
 )
 
-const uninstallFinalizer = "uninstall-helm-release"
+const (
+	uninstallFinalizer = "uninstall-helm-release"
+
+	defaultWaitTimeout = 5 * time.Minute
+
+	// waitPollInterval is the maximum interval at which the resources of a
+	// release are re-checked while waiting for them to become ready.
+	waitPollInterval = 10 * time.Second
+)
 
 // Reconciler reconciles a Helm object
 type Reconciler struct {
 	client             client.Client
+	apiReader          client.Reader
+	restMapper         meta.RESTMapper
 	actionClientGetter helmclient.ActionClientGetter
 	valueMapper        values.Mapper
 	eventRecorder      record.EventRecorder
 	preHooks           []hook.PreHook
 	postHooks          []hook.PostHook
+	healthCheckers     health.Checkers
+	dependentCache     helmclient.ObjectCache
 
 	log                     logr.Logger
 	gvk                     *schema.GroupVersionKind
 	chrt                    *chart.Chart
+	chartVersions           []*chart.Chart
+	chartVersionField       string
+	valuesFromField         string
+	remediationField        string
 	overrideValues          map[string]string
+	releaseNameFunc         helmclient.ObjectToStringMapper
+	targetNamespaceFunc     helmclient.ObjectToStringMapper
+	storageDriverGetter     helmclient.StorageDriverGetter
+	driftMode               DriftMode
+	driftStrategy           DriftStrategy
+	fieldManager            string
+	ignoreDifferences       diff.IgnoreRules
+	manifestMode            ManifestMode
+	wait                    bool
+	waitTimeout             time.Duration
+	atomic                  bool
+	cleanupOnFail           bool
+	remediation             Remediation
+	maxHistory              int
+	paused                  bool
+	skipUninstallPaused     bool
 	skipDependentWatches    bool
+	validatingWebhook       bool
 	maxConcurrentReconciles int
 	reconcilePeriod         time.Duration
-
-	annotSetupOnce       sync.Once
-	annotations          map[string]struct{}
-	installAnnotations   map[string]annotation.Install
-	upgradeAnnotations   map[string]annotation.Upgrade
-	uninstallAnnotations map[string]annotation.Uninstall
-
-	infoMetric *prometheus.GaugeVec
+	renderResyncInterval    time.Duration
+	renderCache             *rendercache.Cache
+
+	annotSetupOnce         sync.Once
+	annotations            map[string]struct{}
+	installAnnotations     map[string]annotation.Install
+	upgradeAnnotations     map[string]annotation.Upgrade
+	uninstallAnnotations   map[string]annotation.Uninstall
+	rollbackAnnotation     annotation.Rollback
+	pauseAnnotation        annotation.Pause
+	chartVersionAnnotation annotation.ChartVersion
+
+	infoMetric    *prometheus.GaugeVec
+	historyMetric *prometheus.GaugeVec
+	errorsMetric  *prometheus.CounterVec
+
+	renderCacheHitsMetric   prometheus.Counter
+	renderCacheMissesMetric prometheus.Counter
 }
 
 // New creates a new Reconciler that reconciles custom resources that define a
@@ -115,6 +173,12 @@ func New(opts ...Option) (*Reconciler, error) {
 	return r, nil
 }
 
+// StatusSchema returns the OpenAPI schema of the status of CRs, which is
+// managed by the Reconciler.
+func StatusSchema() apiextv1.JSONSchemaProps {
+	return updater.StatusSchema()
+}
+
 func (r *Reconciler) setupAnnotationMaps() {
 	r.annotations = make(map[string]struct{})
 	r.installAnnotations = make(map[string]annotation.Install)
@@ -127,8 +191,31 @@ func (r *Reconciler) setupMetrics() error {
 		Name: fmt.Sprintf("%s_info", strings.ToLower(r.gvk.Kind)),
 		Help: fmt.Sprintf("Information about the %s custom resource.", r.gvk.Kind),
 	}, []string{"namespace", "name"})
+	r.historyMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: fmt.Sprintf("%s_release_history_records", strings.ToLower(r.gvk.Kind)),
+		Help: fmt.Sprintf("Number of release history records of the %s custom resource.", r.gvk.Kind),
+	}, []string{"namespace", "name"})
+
+	r.errorsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: fmt.Sprintf("%s_reconcile_errors_total", strings.ToLower(r.gvk.Kind)),
+		Help: fmt.Sprintf("Number of failed reconciliations of the %s custom resource by error class (permanent or transient).", r.gvk.Kind),
+	}, []string{"namespace", "name", "class"})
+
+	r.renderCacheHitsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("%s_render_cache_hits_total", strings.ToLower(r.gvk.Kind)),
+		Help: fmt.Sprintf("Number of reconciliations of the %s custom resource that skipped rendering the release because its inputs were unchanged.", r.gvk.Kind),
+	})
+	r.renderCacheMissesMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("%s_render_cache_misses_total", strings.ToLower(r.gvk.Kind)),
+		Help: fmt.Sprintf("Number of reconciliations of the %s custom resource that rendered the release to compare it with the deployed release.", r.gvk.Kind),
+	})
 
-	return metrics.Registry.Register(r.infoMetric)
+	for _, m := range []prometheus.Collector{r.infoMetric, r.historyMetric, r.errorsMetric, r.renderCacheHitsMetric, r.renderCacheMissesMetric} {
+		if err := metrics.Registry.Register(m); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // SetupWithManager configures a controller for the Reconciler and registers
@@ -144,6 +231,14 @@ func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.addDefaults(mgr, controllerName)
 	r.setupScheme(mgr)
 
+	if r.renderResyncInterval > 0 && r.renderCache == nil {
+		dc, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+		if err != nil {
+			return err
+		}
+		r.renderCache = rendercache.New(r.renderResyncInterval, rendercache.DiscoveryCapabilities(dc))
+	}
+
 	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r, MaxConcurrentReconciles: r.maxConcurrentReconciles})
 	if err != nil {
 		return err
@@ -153,6 +248,16 @@ func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	if r.canPruneHistory() {
+		if err := mgr.Add(manager.RunnableFunc(r.runHistoryPruner)); err != nil {
+			return err
+		}
+	}
+
+	if r.validatingWebhook {
+		mgr.GetWebhookServer().Register(WebhookPath(*r.gvk), &webhook.Admission{Handler: &valuesValidator{r: r}})
+	}
+
 	r.log.Info("Watching resource",
 		"group", r.gvk.Group,
 		"version", r.gvk.Version,
@@ -228,6 +333,76 @@ func WithChart(chrt chart.Chart) Option {
 	}
 }
 
+// WithChartVersions is an Option that configures other versions of the
+// Reconciler's helm chart. A CR selects the version of its chart with a semver
+// constraint in the annotation configured with WithChartVersionAnnotation or
+// in the spec field configured with WithChartVersionField. Only the version
+// of the chart configured with WithChart and the versions of charts are
+// allowed. CRs that do not select a version use the chart configured with
+// WithChart.
+func WithChartVersions(charts ...chart.Chart) Option {
+	return func(r *Reconciler) error {
+		for i := range charts {
+			r.chartVersions = append(r.chartVersions, &charts[i])
+		}
+		return nil
+	}
+}
+
+// WithChartVersionAnnotation is an Option that configures an annotation that
+// selects the version of the chart of a CR (see WithChartVersions). It takes
+// precedence over the spec field configured with WithChartVersionField. A
+// duplicate annotation name will result in an error.
+func WithChartVersionAnnotation(a annotation.ChartVersion) Option {
+	return func(r *Reconciler) error {
+		r.annotSetupOnce.Do(r.setupAnnotationMaps)
+
+		name := a.Name()
+		if _, ok := r.annotations[name]; ok {
+			return fmt.Errorf("annotation %q already exists", name)
+		}
+
+		r.annotations[name] = struct{}{}
+		r.chartVersionAnnotation = a
+		return nil
+	}
+}
+
+// WithChartVersionField is an Option that configures a top-level spec field
+// that selects the version of the chart of a CR (see WithChartVersions). The
+// field is not passed to the chart as a value.
+func WithChartVersionField(field string) Option {
+	return func(r *Reconciler) error {
+		if strings.Contains(field, ".") {
+			return fmt.Errorf("chart version field %q must be a top-level spec field", field)
+		}
+		r.chartVersionField = field
+		return nil
+	}
+}
+
+// WithValuesFromField is an Option that configures a top-level spec field
+// that lists references to chart values in Secrets and ConfigMaps in the
+// namespace of a CR (see ValuesReference). The field is not passed to the
+// chart as a value. Changes to referenced objects trigger a reconciliation of
+// the CRs that reference them.
+//
+// Values are merged in the following order, from lowest to highest
+// precedence:
+//   - the chart's default values,
+//   - the referenced values, in the order of their references,
+//   - the CR spec,
+//   - the override values configured with WithOverrideValues.
+func WithValuesFromField(field string) Option {
+	return func(r *Reconciler) error {
+		if strings.Contains(field, ".") {
+			return fmt.Errorf("values from field %q must be a top-level spec field", field)
+		}
+		r.valuesFromField = field
+		return nil
+	}
+}
+
 // WithOverrideValues is an Option that configures a Reconciler's override
 // values.
 //
@@ -256,10 +431,58 @@ func WithOverrideValues(overrides map[string]string) Option {
 	}
 }
 
+// WithReleaseNameFunc is an Option that configures the function used to
+// determine the name of the release for a custom resource.
+//
+// By default, the release name is the name of the custom resource.
+func WithReleaseNameFunc(f helmclient.ObjectToStringMapper) Option {
+	return func(r *Reconciler) error {
+		r.releaseNameFunc = f
+		return nil
+	}
+}
+
+// WithTargetNamespaceFunc is an Option that configures the function used to
+// determine the namespace that a custom resource's release is installed into.
+// The release storage is kept in the same namespace. This option is required
+// to reconcile cluster-scoped custom resources.
+//
+// The target namespace is only honored by the default ActionClientGetter. If
+// WithActionClientGetter is used, the configured getter is responsible for
+// using the same namespace.
+//
+// By default, the target namespace is the namespace of the custom resource.
+func WithTargetNamespaceFunc(f helmclient.ObjectToStringMapper) Option {
+	return func(r *Reconciler) error {
+		r.targetNamespaceFunc = f
+		return nil
+	}
+}
+
+// WithStorageDriver is an Option that configures where the default
+// ActionClientGetter stores the records of releases. If
+// WithActionClientGetter is used, this option is ignored.
+//
+// By default, release records are stored in Secrets in the target namespace.
+func WithStorageDriver(g helmclient.StorageDriverGetter) Option {
+	return func(r *Reconciler) error {
+		r.storageDriverGetter = g
+		return nil
+	}
+}
+
 // WithDependentWatchesEnabled is an Option that configures whether the
 // Reconciler will register watches for dependent objects in releases and
 // trigger reconciliations when they change.
 //
+// Kinds whose resources all carry helmclient.ReleaseResourceLabel are watched
+// with informers that only keep the labeled resources in memory if the
+// manager's cache is a MetadataCache. Releases that were installed before the
+// label was introduced are not upgraded just to add it, so their resources
+// are only labeled by their next upgrade. Kinds of unlabeled resources are
+// watched with the informers of the cache, which keep all resources of those
+// kinds in memory.
+//
 // By default, dependent watches are enabled.
 func SkipDependentWatches(skip bool) Option {
 	return func(r *Reconciler) error {
@@ -268,6 +491,21 @@ func SkipDependentWatches(skip bool) Option {
 	}
 }
 
+// WithValidatingWebhook is an Option that configures whether the manager's
+// webhook server serves a validating admission webhook for CRs at
+// WebhookPath. The webhook rejects CRs whose values do not match the values
+// schema of their chart, or that select a chart version that is not allowed.
+// A ValidatingWebhookConfiguration for the webhook must be created
+// separately.
+//
+// By default, the webhook is not served.
+func WithValidatingWebhook(enable bool) Option {
+	return func(r *Reconciler) error {
+		r.validatingWebhook = enable
+		return nil
+	}
+}
+
 // WithMaxConcurrentReconciles is an Option that configures the number of
 // concurrent reconciles that the controller will run.
 //
@@ -296,6 +534,159 @@ func WithReconcilePeriod(rp time.Duration) Option {
 	}
 }
 
+// WithRenderResyncInterval is an Option that configures the reconciler to
+// cache whether the release of a CR matches the release rendered from its
+// inputs. Determining whether a release needs to be upgraded requires
+// rendering it in a dry-run upgrade. The render is skipped while the chart,
+// the values and annotations of the CR, the cluster version and API
+// versions, and the deployed release version are unchanged, but every
+// release is rendered at least once per interval.
+//
+// By default, the interval is 0, which disables the cache.
+func WithRenderResyncInterval(interval time.Duration) Option {
+	return func(r *Reconciler) error {
+		if interval < 0 {
+			return errors.New("render resync interval must not be negative")
+		}
+		r.renderResyncInterval = interval
+		return nil
+	}
+}
+
+// WithWait is an Option that configures the reconciler to wait for the
+// resources of an installed or upgraded release to become ready.
+//
+// Unlike Helm's wait, this does not block a worker. The install or upgrade
+// returns immediately, and later reconciliations re-check the release
+// resources until they are ready or the wait timeout expires. The pending wait
+// is recorded in `status.pendingWait`. When the wait times out, the
+// ReleaseFailed condition is set.
+func WithWait(wait bool) Option {
+	return func(r *Reconciler) error {
+		r.wait = wait
+		return nil
+	}
+}
+
+// WithWaitTimeout is an Option that configures how long the reconciler waits
+// for the resources of a release to become ready.
+//
+// The default is 5 minutes.
+func WithWaitTimeout(timeout time.Duration) Option {
+	return func(r *Reconciler) error {
+		if timeout <= 0 {
+			return errors.New("wait timeout must be positive")
+		}
+		r.waitTimeout = timeout
+		return nil
+	}
+}
+
+// WithAtomic is an Option that configures the reconciler to uninstall a newly
+// installed release, or roll back an upgraded release, when the wait for its
+// resources times out. Like Helm's atomic flag, it implies WithWait(true).
+func WithAtomic(atomic bool) Option {
+	return func(r *Reconciler) error {
+		r.atomic = atomic
+		if atomic {
+			r.wait = true
+		}
+		return nil
+	}
+}
+
+// WithCleanupOnFail is an Option that configures the reconciler to delete
+// resources created by an upgrade or rollback that fails.
+func WithCleanupOnFail(cleanupOnFail bool) Option {
+	return func(r *Reconciler) error {
+		r.cleanupOnFail = cleanupOnFail
+		return nil
+	}
+}
+
+// WithRemediation is an Option that configures how failed release
+// installations and upgrades are remediated (see Remediation). Custom
+// resources can override its fields in the spec field configured with
+// WithRemediationField.
+//
+// By default, failed installations are uninstalled, failed upgrades are
+// rolled back, and both are retried indefinitely.
+func WithRemediation(rem Remediation) Option {
+	return func(r *Reconciler) error {
+		if err := rem.validate(); err != nil {
+			return err
+		}
+		r.remediation = rem
+		return nil
+	}
+}
+
+// WithRemediationField is an Option that configures a top-level spec field in
+// which a CR overrides the fields of the remediation configured with
+// WithRemediation. The field is not passed to the chart as a value.
+func WithRemediationField(field string) Option {
+	return func(r *Reconciler) error {
+		if strings.Contains(field, ".") {
+			return fmt.Errorf("remediation field %q must be a top-level spec field", field)
+		}
+		r.remediationField = field
+		return nil
+	}
+}
+
+// ManifestMode determines how the manifest of the deployed release is recorded
+// in `status.deployedRelease`. Large manifests can exceed the size limit of
+// the custom resource.
+type ManifestMode string
+
+const (
+	// ManifestModeFull records the manifest in `manifest`.
+	ManifestModeFull ManifestMode = ManifestMode(updater.ManifestFull)
+
+	// ManifestModeOmit does not record the manifest.
+	ManifestModeOmit ManifestMode = ManifestMode(updater.ManifestOmit)
+
+	// ManifestModeCompress records the gzipped manifest, base64-encoded, in
+	// `compressedManifest`.
+	ManifestModeCompress ManifestMode = ManifestMode(updater.ManifestCompress)
+)
+
+// WithManifestMode is an Option that configures how the manifest of the
+// deployed release is recorded in the status of the custom resource.
+//
+// The default (and the mode used when mode is empty) is ManifestModeFull.
+func WithManifestMode(mode ManifestMode) Option {
+	return func(r *Reconciler) error {
+		switch mode {
+		case "":
+			mode = ManifestModeFull
+		case ManifestModeFull, ManifestModeOmit, ManifestModeCompress:
+		default:
+			return fmt.Errorf("invalid manifest mode %q: must be one of %q, %q, or %q", mode, ManifestModeFull, ManifestModeOmit, ManifestModeCompress)
+		}
+		r.manifestMode = mode
+		return nil
+	}
+}
+
+// WithMaxHistory is an Option that limits the number of release records kept
+// for each release. Older records are deleted when a release is installed or
+// upgraded, and once for all existing releases when the manager starts. The
+// limit can be changed for a custom resource with an upgrade annotation that
+// sets action.Upgrade's MaxHistory, such as annotation.UpgradeMaxHistory. The
+// number of records that are left is recorded in a metric.
+//
+// By default, or if maxHistory is 0, the number of records is not limited.
+func WithMaxHistory(maxHistory int) Option {
+	return func(r *Reconciler) error {
+		if maxHistory < 0 {
+			return errors.New("max history must not be negative")
+		}
+		r.maxHistory = maxHistory
+		return nil
+	}
+}
+
 // WithInstallAnnotations is an Option that configures Install annotations
 // to enable custom action.Install fields to be set based on the value of
 // annotations found in the custom resource watched by this reconciler.
@@ -359,6 +750,71 @@ func WithUninstallAnnotations(as ...annotation.Uninstall) Option {
 	}
 }
 
+// WithRollbackAnnotation is an Option that configures an annotation that
+// requests a rollback of the release to a previous revision. Each request is
+// acted on once, and its result is recorded in `status.rollback`. While the
+// annotation is set, the release is not upgraded to match the custom resource
+// spec. A duplicate annotation name will result in an error.
+func WithRollbackAnnotation(a annotation.Rollback) Option {
+	return func(r *Reconciler) error {
+		r.annotSetupOnce.Do(r.setupAnnotationMaps)
+
+		name := a.Name()
+		if _, ok := r.annotations[name]; ok {
+			return fmt.Errorf("annotation %q already exists", name)
+		}
+
+		r.annotations[name] = struct{}{}
+		r.rollbackAnnotation = a
+		return nil
+	}
+}
+
+// WithPauseAnnotation is an Option that configures an annotation that pauses
+// reconciliation of a custom resource. Its value overrides the default
+// configured with WithPaused. A duplicate annotation name will result in an
+// error.
+func WithPauseAnnotation(a annotation.Pause) Option {
+	return func(r *Reconciler) error {
+		r.annotSetupOnce.Do(r.setupAnnotationMaps)
+
+		name := a.Name()
+		if _, ok := r.annotations[name]; ok {
+			return fmt.Errorf("annotation %q already exists", name)
+		}
+
+		r.annotations[name] = struct{}{}
+		r.pauseAnnotation = a
+		return nil
+	}
+}
+
+// WithPaused is an Option that configures whether reconciliation of custom
+// resources is paused by default. While a custom resource is paused, its
+// release is not installed, upgraded, rolled back or corrected for drift,
+// but its status is still refreshed and the Paused condition is set.
+//
+// By default, reconciliation is not paused.
+func WithPaused(paused bool) Option {
+	return func(r *Reconciler) error {
+		r.paused = paused
+		return nil
+	}
+}
+
+// SkipUninstallWhilePaused is an Option that configures whether the release
+// of a paused custom resource is left in place when the custom resource is
+// deleted. If skipped, the uninstall finalizer is removed without
+// uninstalling the release.
+//
+// By default, releases of paused custom resources are uninstalled.
+func SkipUninstallWhilePaused(skip bool) Option {
+	return func(r *Reconciler) error {
+		r.skipUninstallPaused = skip
+		return nil
+	}
+}
+
 // WithPreHook is an Option that configures the reconciler to run the given
 // PreHook just before performing any actions (e.g. install, upgrade, uninstall,
 // or reconciliation).
@@ -378,6 +834,120 @@ func WithPostHook(h hook.PostHook) Option {
 	}
 }
 
+// WithHealthChecker is an Option that configures the health.Checker used to
+// determine the readiness of release resources with the given
+// GroupVersionKind. It overrides the default checker for that kind, if any.
+//
+// By default, health.DefaultCheckers are used. Resources of a kind without a
+// checker are considered ready as soon as they exist.
+func WithHealthChecker(gvk schema.GroupVersionKind, c health.Checker) Option {
+	return func(r *Reconciler) error {
+		if r.healthCheckers == nil {
+			r.healthCheckers = health.Checkers{}
+		}
+		r.healthCheckers[gvk] = c
+		return nil
+	}
+}
+
+// DriftMode determines how the Reconciler handles release resources that have
+// diverged from the release manifest.
+type DriftMode string
+
+const (
+	// DriftModeCorrect re-creates or patches drifted resources and reports
+	// the drift.
+	DriftModeCorrect DriftMode = "correct"
+
+	// DriftModeReportOnly reports drifted resources without changing them.
+	DriftModeReportOnly DriftMode = "report-only"
+
+	// DriftModeIgnore skips drift detection entirely.
+	DriftModeIgnore DriftMode = "ignore"
+)
+
+// WithDriftMode is an Option that configures how the reconciler handles
+// release resources that have drifted from the release manifest. Drift is
+// reported in the Drifted condition and in events on the custom resource.
+// Unless SkipDependentWatches is set, the current state of release resources
+// of watched kinds is read from the informers that watch them.
+//
+// The default (and the mode used when mode is empty) is DriftModeCorrect.
+func WithDriftMode(mode DriftMode) Option {
+	return func(r *Reconciler) error {
+		switch mode {
+		case "":
+			mode = DriftModeCorrect
+		case DriftModeCorrect, DriftModeReportOnly, DriftModeIgnore:
+		default:
+			return fmt.Errorf("invalid drift mode %q: must be one of %q, %q, or %q", mode, DriftModeCorrect, DriftModeReportOnly, DriftModeIgnore)
+		}
+		r.driftMode = mode
+		return nil
+	}
+}
+
+// DriftStrategy determines how the Reconciler corrects release resources that
+// have diverged from the release manifest.
+type DriftStrategy string
+
+const (
+	// DriftStrategyPatch patches drifted fields with a client-side patch,
+	// overwriting changes made by anyone else.
+	DriftStrategyPatch DriftStrategy = "patch"
+
+	// DriftStrategyApply corrects drifted fields with server-side apply.
+	// Fields that are managed by other field managers, e.g. the replicas of a
+	// deployment scaled by an autoscaler, are not overwritten; they are
+	// reported as conflicts in the Drifted condition instead.
+	DriftStrategyApply DriftStrategy = "apply"
+)
+
+// WithDriftStrategy is an Option that configures how the reconciler corrects
+// drifted release resources. It has no effect when drift is not corrected
+// (see WithDriftMode).
+//
+// The default (and the strategy used when strategy is empty) is
+// DriftStrategyPatch.
+func WithDriftStrategy(strategy DriftStrategy) Option {
+	return func(r *Reconciler) error {
+		switch strategy {
+		case "":
+			strategy = DriftStrategyPatch
+		case DriftStrategyPatch, DriftStrategyApply:
+		default:
+			return fmt.Errorf("invalid drift strategy %q: must be one of %q or %q", strategy, DriftStrategyPatch, DriftStrategyApply)
+		}
+		r.driftStrategy = strategy
+		return nil
+	}
+}
+
+// WithFieldManager is an Option that configures the field manager used to
+// correct drift with DriftStrategyApply. The default is
+// client.DefaultFieldManager.
+func WithFieldManager(name string) Option {
+	return func(r *Reconciler) error {
+		r.fieldManager = name
+		return nil
+	}
+}
+
+// WithIgnoreDifferences is an Option that configures rules for fields of
+// release resources that may differ from the release manifest, e.g. the
+// replicas of a deployment that is scaled by a HorizontalPodAutoscaler.
+// Ignored fields are neither reported nor corrected as drift, and updates that
+// only change ignored fields do not trigger a reconciliation.
+func WithIgnoreDifferences(rules ...diff.IgnoreRule) Option {
+	return func(r *Reconciler) error {
+		if err := diff.IgnoreRules(rules).Validate(); err != nil {
+			return err
+		}
+		r.ignoreDifferences = append(r.ignoreDifferences, rules...)
+		return nil
+	}
+}
+
 // WithValueMapper is an Option that configures a function that maps values
 // from a custom resource spec to the values passed to Helm
 func WithValueMapper(m values.Mapper) Option {
@@ -395,22 +965,65 @@ func WithValueMapper(m values.Mapper) Option {
 //   - If a release exists and the CR spec has not changed since the last
 //     reconciliation, the release is reconciled. Any dependent resources that
 //     have diverged from the release manifest are re-created or patched so that
-//     they are re-aligned with the release.
+//     they are re-aligned with the release, unless configured otherwise with
+//     WithDriftMode. With DriftStrategyApply, fields managed by other field
+//     managers are not overwritten.
+//   - If a rollback is requested with the annotation configured with
+//     WithRollbackAnnotation, the release is rolled back to the requested
+//     revision once. The release is not upgraded while the annotation is set.
+//   - If reconciliation of the CR is paused (see WithPaused and
+//     WithPauseAnnotation), the release is left unchanged and only the status
+//     is refreshed.
+//   - If the CR selects a chart version (see WithChartVersions), the release
+//     is installed or upgraded with the latest allowed chart version that
+//     matches the selection. The chart version is recorded in
+//     `status.chartVersion`.
 //   - If the CR has been deleted, the release will be uninstalled. The
 //     Reconciler uses a finalizer to ensure the release uninstall succeeds
 //     before CR deletion occurs.
 //
-// If an error occurs during release installation or upgrade, the change will be
-// rolled back to restore the previous state.
+// If an error occurs during release installation or upgrade, the failed release
+// is remediated as configured with WithRemediation. By default, it is
+// uninstalled or rolled back to restore the previous state. Consecutive
+// failures of the same revision of the CR are counted in `status.failures`,
+// and the revision is recorded in `status.lastAttemptedRevision`. Once the
+// retries are exhausted, the revision is not attempted again. Failing pod
+// containers, failed hooks, and recent warning events of the resources of the
+// failed release are recorded in `status.diagnostics` and in an event.
+//
+// Errors are either permanent or transient. Permanent errors recur until the
+// CR changes: templates that fail to render, resources that are invalid or
+// change immutable fields, values that violate the values schema, and chart
+// versions that are not allowed. After a permanent error, the CR is not
+// reconciled again until its generation, annotations, or values change, and
+// the error is recorded in `status.permanentError`. Transient errors are
+// retried with exponential backoff, or after the delay suggested by the API
+// server. Failed reconciliations are counted by error class in the
+// `<kind>_reconcile_errors_total` metric.
 //
 // Reconcile also manages the status field of the custom resource. It includes
-// the release name and manifest in `status.deployedRelease`, and it updates
-// `status.conditions` based on reconciliation progress and success. Condition
-// types include:
+// the name, revision, status, chart, values hash and manifest (see
+// WithManifestMode) of the release in `status.deployedRelease`, and it updates
+// `status.conditions` based on reconciliation progress and success. The
+// status records the `observedGeneration` of the custom resource that was
+// reconciled, and each condition the generation at which it was last set.
+// Condition types include:
 //
 //   - Deployed - a release for this CR is deployed (but not necessarily ready).
-//   - ReleaseFailed - an installation or upgrade failed.
-//   - Irreconcilable - an error occurred during reconciliation
+//   - ReleaseFailed - an installation or upgrade failed, or, when configured
+//     with WithWait, the release resources did not become ready in time.
+//   - Irreconcilable - an error occurred during reconciliation. If the values
+//     of the CR do not match the values schema of the chart, the reason is
+//     InvalidValues and the message lists each invalid value. Other permanent
+//     errors have the reasons RenderError, InvalidResource, ImmutableField,
+//     ChartVersionNotAllowed, and InvalidRemediation.
+//   - Ready - all resources of the deployed release are healthy. The health
+//     of each resource is reported in `status.resources`.
+//   - Paused - reconciliation of the CR is paused.
+//   - Drifted - resources of the deployed release diverged from the release
+//     manifest during the last reconciliation. The message lists the drifted
+//     resources and fields. If drift could not be corrected because of
+//     conflicts with other field managers, the reason is DriftConflict.
 func (r *Reconciler) Reconcile(req ctrl.Request) (res ctrl.Result, err error) {
 	// todo:https://github.com/kubernetes-sigs/controller-runtime/issues/801
 	ctx := context.TODO()
@@ -426,14 +1039,43 @@ func (r *Reconciler) Reconcile(req ctrl.Request) (res ctrl.Result, err error) {
 		return ctrl.Result{}, err
 	}
 
+	var vals chartutil.Values
 	u := updater.New(r.client)
 	defer func() {
+		if err != nil {
+			r.errorsMetric.WithLabelValues(obj.GetNamespace(), obj.GetName(), errorClass(err)).Inc()
+		}
+		var pErr *permanentError
+		if errors.As(err, &pErr) {
+			log.Error(err, "Reconciliation failed with a permanent error, not retrying until the custom resource changes", "reason", pErr.reason)
+			u.UpdateStatus(updater.EnsurePermanentError(&updater.PermanentError{
+				Reason:     string(pErr.reason),
+				Generation: obj.GetGeneration(),
+				Checksum:   inputsChecksum(obj, vals),
+			}))
+			res, err = ctrl.Result{}, nil
+		} else if d, ok := retryAfter(err); ok {
+			log.Error(err, "Reconciliation failed, retrying after the delay suggested by the API server", "retryAfter", d)
+			res, err = ctrl.Result{RequeueAfter: d}, nil
+		}
 		applyErr := u.Apply(ctx, obj)
 		if err == nil && !apierrors.IsNotFound(applyErr) {
 			err = applyErr
 		}
 	}()
 
+	releaseName, releaseNamespace, err := r.getReleaseTarget(obj)
+	if err != nil {
+		u.UpdateStatus(
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonErrorGettingReleaseTarget, err)),
+			updater.EnsureConditionUnknown(conditions.TypeDeployed),
+			updater.EnsureConditionUnknown(conditions.TypeInitialized),
+			updater.EnsureConditionUnknown(conditions.TypeReleaseFailed),
+			updater.RemoveDeployedRelease(),
+		)
+		return ctrl.Result{}, err
+	}
+
 	actionClient, err := r.actionClientGetter.ActionClientFor(obj)
 	if err != nil {
 		u.UpdateStatus(
@@ -441,7 +1083,7 @@ func (r *Reconciler) Reconcile(req ctrl.Request) (res ctrl.Result, err error) {
 			updater.EnsureConditionUnknown(conditions.TypeDeployed),
 			updater.EnsureConditionUnknown(conditions.TypeInitialized),
 			updater.EnsureConditionUnknown(conditions.TypeReleaseFailed),
-			updater.EnsureDeployedRelease(nil),
+			updater.RemoveDeployedRelease(),
 		)
 		// NOTE: If obj has the uninstall finalizer, that means a release WAS deployed at some point
 		//   in the past, but we don't know if it still is because we don't have an actionClient to check.
@@ -463,20 +1105,44 @@ func (r *Reconciler) Reconcile(req ctrl.Request) (res ctrl.Result, err error) {
 	//
 	// We also make sure not to return any errors we encounter so
 	// we can still attempt an uninstall if the CR is being deleted.
-	rel, err := actionClient.Get(obj.GetName())
+	rel, err := actionClient.Get(releaseName)
 	if errors.Is(err, driver.ErrReleaseNotFound) {
 		u.UpdateStatus(updater.EnsureCondition(conditions.Deployed(corev1.ConditionFalse, "", "")))
 	} else if err == nil {
-		ensureDeployedRelease(&u, rel)
+		r.ensureDeployedRelease(&u, rel)
 	}
 	u.UpdateStatus(updater.EnsureCondition(conditions.Initialized(corev1.ConditionTrue, "", "")))
 
+	paused, pausedMessage := r.isPaused(obj, log)
+	if paused {
+		u.UpdateStatus(updater.EnsureCondition(conditions.Paused(corev1.ConditionTrue, conditions.ReasonReconcilePaused, pausedMessage)))
+	} else {
+		u.UpdateStatus(updater.EnsureCondition(conditions.Paused(corev1.ConditionFalse, "", "")))
+	}
+
 	if obj.GetDeletionTimestamp() != nil {
-		err := r.handleDeletion(ctx, actionClient, obj, log)
+		err := r.handleDeletion(ctx, actionClient, obj, releaseName, paused && r.skipUninstallPaused, log)
 		return ctrl.Result{}, err
 	}
 
-	vals, err := r.getValues(obj)
+	if paused {
+		log.V(1).Info("Reconciliation is paused, refreshing status only")
+		if rel != nil {
+			r.updateReadiness(ctx, &u, rel, log)
+		}
+		return ctrl.Result{RequeueAfter: r.reconcilePeriod}, nil
+	}
+
+	chrt, err := r.selectChart(obj)
+	if err != nil {
+		u.UpdateStatus(
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonChartVersionNotAllowed, err)),
+			updater.EnsureConditionUnknown(conditions.TypeReleaseFailed),
+		)
+		return ctrl.Result{}, permanent(conditions.ReasonChartVersionNotAllowed, err)
+	}
+
+	vals, err = r.getValues(ctx, obj, chrt)
 	if err != nil {
 		u.UpdateStatus(
 			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonErrorGettingValues, err)),
@@ -485,16 +1151,69 @@ func (r *Reconciler) Reconcile(req ctrl.Request) (res ctrl.Result, err error) {
 		return ctrl.Result{}, err
 	}
 
-	rel, state, err := r.getReleaseState(actionClient, obj, vals.AsMap())
+	if pErr := updater.LastPermanentError(obj); pErr != nil {
+		if pErr.Generation == obj.GetGeneration() && pErr.Checksum == inputsChecksum(obj, vals) {
+			log.V(1).Info("Skipping reconciliation after a permanent error until the custom resource changes", "reason", pErr.Reason)
+			return ctrl.Result{}, nil
+		}
+		u.UpdateStatus(updater.RemovePermanentError())
+	}
+
+	if err := values.ValidateSchema(chrt, vals); err != nil {
+		u.UpdateStatus(
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonInvalidValues, err)),
+			updater.EnsureConditionUnknown(conditions.TypeReleaseFailed),
+		)
+		return ctrl.Result{}, permanent(conditions.ReasonInvalidValues, err)
+	}
+
+	rem, err := r.remediationFor(obj)
 	if err != nil {
 		u.UpdateStatus(
-			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonErrorGettingReleaseState, err)),
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonInvalidRemediation, err)),
+			updater.EnsureConditionUnknown(conditions.TypeReleaseFailed),
+		)
+		return ctrl.Result{}, permanent(conditions.ReasonInvalidRemediation, err)
+	}
+
+	rel, state, err := r.getReleaseState(actionClient, obj, chrt, releaseName, releaseNamespace, vals.AsMap(), log)
+	if err != nil {
+		err = classifyError(err)
+		u.UpdateStatus(
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, errorReason(err, conditions.ReasonErrorGettingReleaseState), err)),
 			updater.EnsureConditionUnknown(conditions.TypeReleaseFailed),
 			updater.EnsureConditionUnknown(conditions.TypeDeployed),
-			updater.EnsureDeployedRelease(nil),
+			updater.RemoveDeployedRelease(),
 		)
 		return ctrl.Result{}, err
 	}
+
+	if v, ok := obj.GetAnnotations()[r.rollbackAnnotationName()]; ok && rel != nil {
+		rel, err = r.doRollback(actionClient, &u, obj, rel, v, log)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if state == stateNeedsUpgrade {
+			log.Info("Skipping release upgrade while rollback is requested", "annotation", r.rollbackAnnotationName())
+			state = stateUnchanged
+		}
+	}
+
+	a := attempt{
+		revision: &updater.Revision{
+			ChartVersion: chrt.Metadata.Version,
+			Generation:   obj.GetGeneration(),
+			Checksum:     inputsChecksum(obj, vals),
+		},
+		remediation: rem,
+		replace:     state == stateNeedsInstall && rel != nil,
+	}
+	if state == stateNeedsInstall || state == stateNeedsUpgrade {
+		if failures, last := updater.LastFailures(obj); rem.exhausted(failures) && reflect.DeepEqual(last, a.revision) {
+			log.V(1).Info("Skipping release installation or upgrade until the custom resource changes, retries are exhausted", "failures", failures)
+			return ctrl.Result{}, nil
+		}
+	}
 	u.UpdateStatus(updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionFalse, "", "")))
 
 	for _, h := range r.preHooks {
@@ -505,19 +1224,19 @@ func (r *Reconciler) Reconcile(req ctrl.Request) (res ctrl.Result, err error) {
 
 	switch state {
 	case stateNeedsInstall:
-		rel, err = r.doInstall(actionClient, &u, obj, vals.AsMap(), log)
+		rel, err = r.doInstall(actionClient, &u, obj, chrt, releaseName, releaseNamespace, vals.AsMap(), a, log)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 
 	case stateNeedsUpgrade:
-		rel, err = r.doUpgrade(actionClient, &u, obj, vals.AsMap(), log)
+		rel, err = r.doUpgrade(actionClient, &u, obj, chrt, releaseName, releaseNamespace, vals.AsMap(), a, log)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 
 	case stateUnchanged:
-		if err := r.doReconcile(actionClient, &u, rel, log); err != nil {
+		if err := r.doReconcile(actionClient, &u, obj, rel, log); err != nil {
 			return ctrl.Result{}, err
 		}
 	default:
@@ -540,25 +1259,406 @@ func (r *Reconciler) Reconcile(req ctrl.Request) (res ctrl.Result, err error) {
 		}
 	}
 
-	ensureDeployedRelease(&u, rel)
+	r.ensureDeployedRelease(&u, rel)
 	u.UpdateStatus(
-		updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionFalse, "", "")),
 		updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionFalse, "", "")),
+		updater.EnsureChartVersion(chrt.Metadata.Version),
+	)
+	ready := r.updateReadiness(ctx, &u, rel, log)
+
+	return r.handleWait(actionClient, &u, obj, rel, state, a, ready, log)
+}
+
+// handleWait tracks the wait for the resources of rel to become ready, if the
+// reconciler is configured to wait. Rather than blocking, it records the wait
+// deadline in the status and requeues the request until the resources are
+// ready or the deadline passes. Once the deadline passes, the release is
+// marked as failed and, if the reconciler is atomic, it is counted as a
+// failed attempt a and uninstalled or rolled back. The failures of the
+// release are reset once it is ready.
+func (r *Reconciler) handleWait(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, rel *release.Release, state helmReleaseState, a attempt, ready bool, log logr.Logger) (ctrl.Result, error) {
+	wait := updater.PendingWait(obj)
+	if r.wait && (state == stateNeedsInstall || state == stateNeedsUpgrade) {
+		wait = &updater.Wait{
+			ReleaseVersion: rel.Version,
+			Deadline:       metav1.NewTime(time.Now().Add(r.waitTimeout)).Rfc3339Copy(),
+			Install:        state == stateNeedsInstall,
+		}
+	}
+	if !r.wait || wait == nil || wait.ReleaseVersion != rel.Version || ready {
+		u.UpdateStatus(
+			updater.RemovePendingWait(),
+			updater.RemoveFailures(),
+			updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionFalse, "", "")),
+		)
+		return ctrl.Result{RequeueAfter: r.reconcilePeriod}, nil
+	}
+	u.UpdateStatus(updater.EnsurePendingWait(wait))
+
+	if remaining := time.Until(wait.Deadline.Time); remaining > 0 {
+		log.V(1).Info("Waiting for release resources to become ready", "name", rel.Name, "version", rel.Version, "deadline", wait.Deadline)
+		u.UpdateStatus(updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionFalse, "", "")))
+		if remaining > waitPollInterval {
+			remaining = waitPollInterval
+		}
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	reason := conditions.ReasonUpgradeError
+	if wait.Install {
+		reason = conditions.ReasonInstallError
+	}
+	err := errors.New("timed out waiting for release resources to become ready")
+	if !r.atomic {
+		// The pending wait is kept so that the release is no longer
+		// considered failed if its resources eventually become ready.
+		u.UpdateStatus(updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionTrue, reason, err)))
+		return ctrl.Result{RequeueAfter: r.reconcilePeriod}, nil
+	}
+
+	// The timed out release counts as a failed attempt of the revision, so
+	// that it is not installed or upgraded again once the retries of the
+	// remediation are exhausted.
+	if failures := countFailure(u, obj, a); a.remediation.exhausted(failures) {
+		log.Info("Retries of release exhausted", "name", rel.Name, "version", rel.Version, "failures", failures)
+	}
+	if wait.Install {
+		log.Info("Uninstalling release after wait timeout", "name", rel.Name, "version", rel.Version)
+		if _, uninstallErr := actionClient.Uninstall(rel.Name); uninstallErr != nil && !errors.Is(uninstallErr, driver.ErrReleaseNotFound) {
+			err = fmt.Errorf("uninstall failed: %v: original error: %w", uninstallErr, err)
+			u.UpdateStatus(
+				updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonReconcileError, err)),
+				updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionTrue, reason, err)),
+			)
+			return ctrl.Result{}, err
+		}
+		u.UpdateStatus(
+			updater.EnsureCondition(conditions.Deployed(corev1.ConditionFalse, conditions.ReasonUninstallSuccessful, "")),
+			updater.RemoveDeployedRelease(),
+		)
+	} else {
+		log.Info("Rolling back release after wait timeout", "name", rel.Name, "version", rel.Version)
+		if rollbackErr := actionClient.Rollback(rel.Name, func(rb *action.Rollback) error {
+			rb.CleanupOnFail = r.cleanupOnFail
+			return nil
+		}); rollbackErr != nil {
+			err = fmt.Errorf("rollback failed: %v: original error: %w", rollbackErr, err)
+			u.UpdateStatus(
+				updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonReconcileError, err)),
+				updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionTrue, reason, err)),
+			)
+			return ctrl.Result{}, err
+		}
+	}
+	u.UpdateStatus(
+		updater.RemovePendingWait(),
+		updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonReconcileError, err)),
+		updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionTrue, reason, err)),
+	)
+	return ctrl.Result{}, err
+}
+
+// waitTimedOut returns true if the wait for the resources of rel timed out in
+// an earlier reconciliation and the resources have not become ready since.
+func waitTimedOut(obj *unstructured.Unstructured, rel *release.Release) bool {
+	wait := updater.PendingWait(obj)
+	return wait != nil && wait.ReleaseVersion == rel.Version && !time.Now().Before(wait.Deadline.Time)
+}
+
+// maxConditionMessageResources is the maximum number of resources that are
+// listed in the Ready and Drifted condition messages.
+const maxConditionMessageResources = 3
+
+// updateReadiness checks the health of each resource in the release manifest
+// and records the results in the Ready condition and the resource inventory
+// in status.resources. It returns true if all resources are ready.
+// Resources are read from the informers of the dependent resource watches if
+// their kind is watched, and from the API server otherwise, so that health checks do not start informers for all
+// resources of a kind.
+func (r *Reconciler) updateReadiness(ctx context.Context, u *updater.Updater, rel *release.Release, log logr.Logger) bool {
+	var (
+		resources []updater.Resource
+		unready   []string
+		degraded  bool
+	)
+	objs, err := inventory.FromRelease(rel, r.restMapper)
+	if err != nil {
+		log.Error(err, "failed to check readiness of release resources")
+		u.UpdateStatus(updater.EnsureCondition(conditions.Ready(corev1.ConditionUnknown, conditions.ReasonErrorCheckingReadiness, err)))
+		return false
+	}
+	for _, o := range objs {
+		res, err := r.checkResourceHealth(ctx, o)
+		if err != nil {
+			log.Error(err, "failed to check readiness of release resources")
+			u.UpdateStatus(updater.EnsureCondition(conditions.Ready(corev1.ConditionUnknown, conditions.ReasonErrorCheckingReadiness, err)))
+			return false
+		}
+		apiVersion, kind := o.ToAPIVersionAndKind()
+		resources = append(resources, updater.Resource{
+			APIVersion: apiVersion,
+			Kind:       kind,
+			Namespace:  o.Namespace,
+			Name:       o.Name,
+			Health:     res.Status,
+			Message:    res.Message,
+		})
+		if res.Status != health.StatusHealthy {
+			degraded = degraded || res.Status == health.StatusDegraded
+			unready = append(unready, fmt.Sprintf("%s %s: %s", o.Kind, client.ObjectKey{Namespace: o.Namespace, Name: o.Name}, res.Message))
+		}
+	}
+
+	u.UpdateStatus(updater.EnsureResources(resources))
+	if len(unready) == 0 {
+		u.UpdateStatus(updater.EnsureCondition(conditions.Ready(corev1.ConditionTrue, conditions.ReasonResourcesReady, "all release resources are ready")))
+		return true
+	}
+
+	reason := conditions.ReasonResourcesProgressing
+	if degraded {
+		reason = conditions.ReasonResourcesDegraded
+	}
+	u.UpdateStatus(updater.EnsureCondition(conditions.Ready(corev1.ConditionFalse, reason, conditionMessage(unready))))
+	return false
+}
+
+// conditionMessage joins the per-resource messages of a condition, listing
+// at most maxConditionMessageResources of them.
+func conditionMessage(msgs []string) string {
+	if len(msgs) > maxConditionMessageResources {
+		return fmt.Sprintf("%s; and %d more", strings.Join(msgs[:maxConditionMessageResources], "; "), len(msgs)-maxConditionMessageResources)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (r *Reconciler) checkResourceHealth(ctx context.Context, o inventory.Object) (health.Result, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(o.GroupVersionKind)
+	key := client.ObjectKey{Namespace: o.Namespace, Name: o.Name}
+
+	// Resources that are missing from the informers, e.g. unlabeled
+	// resources of kinds that are watched by label, are read from the API
+	// server.
+	cached := r.dependentCache != nil && r.dependentCache.Caches(o.GroupVersionKind)
+	var err error
+	if cached {
+		err = r.dependentCache.Get(ctx, key, obj)
+	}
+	if !cached || apierrors.IsNotFound(err) {
+		err = r.apiReader.Get(ctx, key, obj)
+	}
+	if apierrors.IsNotFound(err) {
+		return health.Progressing("resource not found"), nil
+	}
+	if err != nil {
+		return health.Result{}, err
+	}
+	res, _, err := r.healthCheckers.Check(obj)
+	return res, err
+}
+
+// isPaused returns whether reconciliation of obj is paused and a message
+// describing why.
+func (r *Reconciler) isPaused(obj *unstructured.Unstructured, log logr.Logger) (bool, string) {
+	if r.pauseAnnotation != nil {
+		name := r.pauseAnnotation.Name()
+		if v, ok := obj.GetAnnotations()[name]; ok {
+			paused, err := r.pauseAnnotation.Paused(v)
+			if err == nil {
+				return paused, fmt.Sprintf("reconciliation is paused by annotation %q", name)
+			}
+			log.Error(err, "Ignoring invalid pause annotation", "annotation", name)
+		}
+	}
+	return r.paused, "reconciliation is paused by default"
+}
+
+// canPruneHistory returns whether the history of any release can be limited,
+// either by WithMaxHistory or by the annotation.UpgradeMaxHistory annotation.
+func (r *Reconciler) canPruneHistory() bool {
+	if r.maxHistory > 0 {
+		return true
+	}
+	for _, annot := range r.upgradeAnnotations {
+		switch annot.(type) {
+		case annotation.UpgradeMaxHistory, *annotation.UpgradeMaxHistory:
+			return true
+		}
+	}
+	return false
+}
+
+// runHistoryPruner prunes the history of the releases of all custom resources
+// once, when the manager starts, so that releases that are not installed or
+// upgraded again are pruned as well.
+func (r *Reconciler) runHistoryPruner(<-chan struct{}) error {
+	r.pruneHistory(context.TODO())
+	return nil
+}
+
+// pruneHistory prunes the history of the releases of all custom resources.
+// The releases of paused and deleted custom resources are not pruned.
+func (r *Reconciler) pruneHistory(ctx context.Context) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(r.gvk.GroupVersion().WithKind(r.gvk.Kind + "List"))
+	if err := r.client.List(ctx, list); err != nil {
+		r.log.Error(err, "Failed to list custom resources to prune release history")
+		return
+	}
+
+	for i := range list.Items {
+		obj := &list.Items[i]
+		log := r.log.WithValues("namespace", obj.GetNamespace(), "name", obj.GetName())
+		if obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+		if paused, _ := r.isPaused(obj, log); paused {
+			continue
+		}
+
+		if r.maxHistoryFor(obj) <= 0 {
+			continue
+		}
+
+		releaseName, _, err := r.getReleaseTarget(obj)
+		if err != nil {
+			log.Error(err, "Failed to get release target to prune release history")
+			continue
+		}
+		actionClient, err := r.actionClientGetter.ActionClientFor(obj)
+		if err != nil {
+			log.Error(err, "Failed to get action client to prune release history")
+			continue
+		}
+		r.pruneReleaseHistory(actionClient, obj, releaseName, log)
+	}
+}
+
+// pruneReleaseHistory deletes the records of the release of obj that exceed
+// its max history, and records the number of records that are left in the
+// history metric. The history of releases without a max history is left
+// untouched.
+func (r *Reconciler) pruneReleaseHistory(actionClient helmclient.ActionInterface, obj *unstructured.Unstructured, releaseName string, log logr.Logger) {
+	max := r.maxHistoryFor(obj)
+	if max <= 0 {
+		return
+	}
+	n, err := actionClient.PruneHistory(releaseName, max)
+	if err != nil {
+		log.Error(err, "Failed to prune release history", "release", releaseName)
+	}
+	r.historyMetric.With(map[string]string{
+		"namespace": obj.GetNamespace(),
+		"name":      obj.GetName(),
+	}).Set(float64(n))
+}
+
+// getReleaseTarget returns the name and namespace of the release defined by
+// obj.
+func (r *Reconciler) getReleaseTarget(obj *unstructured.Unstructured) (string, string, error) {
+	name, err := r.releaseNameFunc(obj)
+	if err != nil {
+		return "", "", fmt.Errorf("get release name: %w", err)
+	}
+	if name == "" {
+		return "", "", errors.New("release name must not be empty")
+	}
+	namespace, err := r.targetNamespaceFunc(obj)
+	if err != nil {
+		return "", "", fmt.Errorf("get release namespace: %w", err)
+	}
+	if namespace == "" {
+		return "", "", errors.New("release namespace must not be empty")
+	}
+	return name, namespace, nil
+}
+
+// selectChart returns the latest allowed chart version that matches the
+// version selected by obj, or the default chart if obj does not select a
+// version.
+func (r *Reconciler) selectChart(obj *unstructured.Unstructured) (*chart.Chart, error) {
+	constraint, source, err := r.chartVersionConstraint(obj)
+	if err != nil || constraint == nil {
+		return r.chrt, err
+	}
+
+	var (
+		selected        *chart.Chart
+		selectedVersion *semver.Version
+		allowed         []string
 	)
+	for _, c := range append([]*chart.Chart{r.chrt}, r.chartVersions...) {
+		allowed = append(allowed, c.Metadata.Version)
+		v, err := semver.NewVersion(c.Metadata.Version)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if selectedVersion == nil || v.GreaterThan(selectedVersion) {
+			selected, selectedVersion = c, v
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("%s: no allowed chart version matches %q, allowed versions are %s", source, constraint, strings.Join(allowed, ", "))
+	}
+	return selected, nil
+}
 
-	return ctrl.Result{RequeueAfter: r.reconcilePeriod}, nil
+// chartVersionConstraint returns the chart version constraint selected by
+// obj and a description of where it was found, or a nil constraint if obj
+// does not select a chart version.
+func (r *Reconciler) chartVersionConstraint(obj *unstructured.Unstructured) (*semver.Constraints, string, error) {
+	if r.chartVersionAnnotation != nil {
+		name := r.chartVersionAnnotation.Name()
+		if v, ok := obj.GetAnnotations()[name]; ok {
+			source := fmt.Sprintf("annotation %q", name)
+			c, err := r.chartVersionAnnotation.ChartVersion(v)
+			if err != nil {
+				return nil, source, fmt.Errorf("%s: %w", source, err)
+			}
+			return c, source, nil
+		}
+	}
+	if r.chartVersionField != "" {
+		v, ok, err := unstructured.NestedFieldNoCopy(obj.Object, "spec", r.chartVersionField)
+		if err != nil || !ok || v == nil {
+			return nil, "", err
+		}
+		source := fmt.Sprintf("field spec.%s", r.chartVersionField)
+		s, ok := v.(string)
+		if !ok {
+			return nil, source, fmt.Errorf("%s: must be a string", source)
+		}
+		c, err := semver.NewConstraint(s)
+		if err != nil {
+			return nil, source, fmt.Errorf("%s: invalid chart version %q: %w", source, s, err)
+		}
+		return c, source, nil
+	}
+	return nil, "", nil
 }
 
-func (r *Reconciler) getValues(obj *unstructured.Unstructured) (chartutil.Values, error) {
+func (r *Reconciler) getValues(ctx context.Context, obj *unstructured.Unstructured, chrt *chart.Chart) (chartutil.Values, error) {
 	crVals, err := internalvalues.FromUnstructured(obj)
 	if err != nil {
 		return chartutil.Values{}, err
 	}
+	// Copy the spec, so that removing reserved fields and merging values
+	// does not modify obj.
+	crVals = crVals.Copy()
+	crVals.Remove(r.chartVersionField, r.valuesFromField, r.remediationField)
+
+	fromVals, err := r.getValuesFrom(ctx, obj)
+	if err != nil {
+		return chartutil.Values{}, err
+	}
+	crVals.Coalesce(fromVals)
+
 	if err := crVals.ApplyOverrides(r.overrideValues); err != nil {
 		return chartutil.Values{}, err
 	}
 	vals := r.valueMapper.Map(crVals.Map())
-	vals, err = chartutil.CoalesceValues(r.chrt, vals)
+	vals, err = chartutil.CoalesceValues(chrt, vals)
 	if err != nil {
 		return chartutil.Values{}, err
 	}
@@ -574,7 +1674,7 @@ const (
 	stateError        helmReleaseState = "error"
 )
 
-func (r *Reconciler) handleDeletion(ctx context.Context, actionClient helmclient.ActionInterface, obj *unstructured.Unstructured, log logr.Logger) error {
+func (r *Reconciler) handleDeletion(ctx context.Context, actionClient helmclient.ActionInterface, obj *unstructured.Unstructured, releaseName string, skipUninstall bool, log logr.Logger) error {
 	if !controllerutil.ContainsFinalizer(obj, uninstallFinalizer) {
 		log.Info("Resource is terminated, skipping reconciliation")
 		return nil
@@ -594,7 +1694,12 @@ func (r *Reconciler) handleDeletion(ctx context.Context, actionClient helmclient
 				err = applyErr
 			}
 		}()
-		return r.doUninstall(actionClient, &uninstallUpdater, obj, log)
+		if skipUninstall {
+			log.Info("Reconciliation is paused, removing finalizer without uninstalling release", "name", releaseName)
+			uninstallUpdater.Update(updater.RemoveFinalizer(uninstallFinalizer))
+			return nil
+		}
+		return r.doUninstall(actionClient, &uninstallUpdater, obj, releaseName, log)
 	}(); err != nil {
 		return err
 	}
@@ -604,6 +1709,11 @@ func (r *Reconciler) handleDeletion(ctx context.Context, actionClient helmclient
 		"name":      obj.GetName(),
 	}
 	_ = r.infoMetric.Delete(labels)
+	_ = r.historyMetric.Delete(labels)
+	for _, class := range []string{errorClassPermanent, errorClassTransient} {
+		_ = r.errorsMetric.DeleteLabelValues(obj.GetNamespace(), obj.GetName(), class)
+	}
+	r.renderCache.Delete(obj.GetUID())
 
 	// Since the client is hitting a cache, waiting for the
 	// deletion here will guarantee that the next reconciliation
@@ -615,8 +1725,8 @@ func (r *Reconciler) handleDeletion(ctx context.Context, actionClient helmclient
 	return nil
 }
 
-func (r *Reconciler) getReleaseState(client helmclient.ActionInterface, obj metav1.Object, vals map[string]interface{}) (*release.Release, helmReleaseState, error) {
-	deployedRelease, err := client.Get(obj.GetName())
+func (r *Reconciler) getReleaseState(client helmclient.ActionInterface, obj *unstructured.Unstructured, chrt *chart.Chart, releaseName, releaseNamespace string, vals map[string]interface{}, log logr.Logger) (*release.Release, helmReleaseState, error) {
+	deployedRelease, err := client.Get(releaseName)
 	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
 		return nil, stateError, err
 	}
@@ -625,69 +1735,242 @@ func (r *Reconciler) getReleaseState(client helmclient.ActionInterface, obj meta
 		return nil, stateNeedsInstall, nil
 	}
 
-	var opts []helmclient.UpgradeOption
-	for name, annot := range r.upgradeAnnotations {
-		if v, ok := obj.GetAnnotations()[name]; ok {
-			opts = append(opts, annot.UpgradeOption(v))
+	// Failed releases are only kept if the remediation keeps them, and they
+	// are retried until the retries are exhausted. A failed installation is
+	// replaced, since there is no deployed release to upgrade.
+	if deployedRelease.Info != nil && deployedRelease.Info.Status == release.StatusFailed {
+		history, err := client.History(releaseName)
+		if err != nil {
+			return deployedRelease, stateError, err
+		}
+		for _, h := range history {
+			if h.Info != nil && h.Info.Status == release.StatusDeployed {
+				return deployedRelease, stateNeedsUpgrade, nil
+			}
 		}
+		return deployedRelease, stateNeedsInstall, nil
+	}
+
+	// The dry-run upgrade is skipped if the release was unchanged the last
+	// time it was rendered with the same inputs.
+	fingerprint, err := r.renderCache.Fingerprint(chrt, inputsChecksum(obj, vals))
+	if err != nil {
+		log.V(1).Info("Failed to compute the render fingerprint of the release", "error", err.Error())
+	} else if r.renderCache.Unchanged(obj.GetUID(), deployedRelease.Version, fingerprint) {
+		r.renderCacheHitsMetric.Inc()
+		return deployedRelease, stateUnchanged, nil
+	} else if fingerprint != "" {
+		r.renderCacheMissesMetric.Inc()
 	}
+
+	opts := r.upgradeOptions(obj)
 	opts = append(opts, func(u *action.Upgrade) error {
 		u.DryRun = true
 		return nil
 	})
-	specRelease, err := client.Upgrade(obj.GetName(), obj.GetNamespace(), r.chrt, vals, opts...)
+	specRelease, err := client.Upgrade(releaseName, releaseNamespace, chrt, vals, opts...)
 	if err != nil {
 		return deployedRelease, stateError, err
 	}
-	if specRelease.Manifest != deployedRelease.Manifest {
+	// Releases are not upgraded only to label their resources.
+	if !helmclient.ManifestsEqual(specRelease.Manifest, deployedRelease.Manifest) {
 		return deployedRelease, stateNeedsUpgrade, nil
 	}
+	if fingerprint != "" {
+		r.renderCache.Add(obj.GetUID(), deployedRelease.Version, fingerprint)
+	}
 	return deployedRelease, stateUnchanged, nil
 }
 
-func (r *Reconciler) doInstall(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, vals map[string]interface{}, log logr.Logger) (*release.Release, error) {
+func (r *Reconciler) doInstall(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, chrt *chart.Chart, releaseName, releaseNamespace string, vals map[string]interface{}, a attempt, log logr.Logger) (*release.Release, error) {
 	var opts []helmclient.InstallOption
 	for name, annot := range r.installAnnotations {
 		if v, ok := obj.GetAnnotations()[name]; ok {
 			opts = append(opts, annot.InstallOption(v))
 		}
 	}
-	rel, err := actionClient.Install(obj.GetName(), obj.GetNamespace(), r.chrt, vals, opts...)
+	if a.replace {
+		opts = append(opts, func(install *action.Install) error {
+			install.Replace = true
+			return nil
+		})
+	}
+	since := time.Now()
+	rel, err := actionClient.Install(releaseName, releaseNamespace, chrt, vals, opts...)
 	if err != nil {
+		r.reportDiagnostics(u, obj, conditions.ReasonInstallError, err, since, log)
+		err = classifyError(r.handleFailure(actionClient, u, obj, a, true, err, log))
 		u.UpdateStatus(
-			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonReconcileError, err)),
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, errorReason(err, conditions.ReasonReconcileError), err)),
 			updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionTrue, conditions.ReasonInstallError, err)),
 		)
 		return nil, err
 	}
+	u.UpdateStatus(updater.RemoveDiagnostics())
 	r.reportOverrideEvents(obj)
+	r.pruneReleaseHistory(actionClient, obj, rel.Name, log)
 
 	log.Info("Release installed", "name", rel.Name, "version", rel.Version)
 	return rel, nil
 }
 
-func (r *Reconciler) doUpgrade(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, vals map[string]interface{}, log logr.Logger) (*release.Release, error) {
-	var opts []helmclient.UpgradeOption
-	for name, annot := range r.upgradeAnnotations {
-		if v, ok := obj.GetAnnotations()[name]; ok {
-			opts = append(opts, annot.UpgradeOption(v))
-		}
+func (r *Reconciler) doUpgrade(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, chrt *chart.Chart, releaseName, releaseNamespace string, vals map[string]interface{}, a attempt, log logr.Logger) (*release.Release, error) {
+	opts := r.upgradeOptions(obj)
+	if r.cleanupOnFail {
+		opts = append(opts, func(upgrade *action.Upgrade) error {
+			upgrade.CleanupOnFail = true
+			return nil
+		})
 	}
 
-	rel, err := actionClient.Upgrade(obj.GetName(), obj.GetNamespace(), r.chrt, vals, opts...)
+	since := time.Now()
+	rel, err := actionClient.Upgrade(releaseName, releaseNamespace, chrt, vals, opts...)
 	if err != nil {
+		r.reportDiagnostics(u, obj, conditions.ReasonUpgradeError, err, since, log)
+		err = classifyError(r.handleFailure(actionClient, u, obj, a, false, err, log))
 		u.UpdateStatus(
-			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonReconcileError, err)),
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, errorReason(err, conditions.ReasonReconcileError), err)),
 			updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionTrue, conditions.ReasonUpgradeError, err)),
 		)
 		return nil, err
 	}
+	u.UpdateStatus(updater.RemoveDiagnostics())
 	r.reportOverrideEvents(obj)
+	r.pruneReleaseHistory(actionClient, obj, rel.Name, log)
 
 	log.Info("Release upgraded", "name", rel.Name, "version", rel.Version)
 	return rel, nil
 }
 
+func (r *Reconciler) rollbackAnnotationName() string {
+	if r.rollbackAnnotation == nil {
+		return ""
+	}
+	return r.rollbackAnnotation.Name()
+}
+
+// doRollback rolls back rel to the revision requested by value, the value of
+// the rollback annotation, and returns the resulting release. A request is
+// not acted on again while the release is still at the version recorded for
+// it in `status.rollback`, so failed rollbacks are not retried.
+func (r *Reconciler) doRollback(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, rel *release.Release, value string, log logr.Logger) (*release.Release, error) {
+	revision, err := r.rollbackAnnotation.Revision(value)
+	if err != nil {
+		err = fmt.Errorf("annotation %q: %w", r.rollbackAnnotationName(), err)
+		u.UpdateStatus(updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonRollbackError, err)))
+		return nil, err
+	}
+	if last := updater.LastRollback(obj); last != nil && last.Revision == revision && last.ReleaseVersion == rel.Version {
+		return rel, nil
+	}
+
+	log.Info("Rolling back release", "name", rel.Name, "version", rel.Version, "revision", revision)
+	if err := rollbackToRevision(actionClient, rel.Name, revision, r.cleanupOnFail); err != nil {
+		log.Error(err, "Release rollback failed", "name", rel.Name, "revision", revision)
+		u.UpdateStatus(updater.EnsureRollback(&updater.Rollback{
+			Revision:       revision,
+			ReleaseVersion: rel.Version,
+			Message:        err.Error(),
+		}))
+		r.eventRecorder.Eventf(obj, "Warning", "RollbackFailed",
+			"Failed to roll back release %s to revision %d: %v", rel.Name, revision, err)
+		return rel, nil
+	}
+	r.eventRecorder.Eventf(obj, "Normal", "RollbackSucceeded",
+		"Rolled back release %s to revision %d", rel.Name, revision)
+
+	// Rollbacks always create a new release version.
+	result := &updater.Rollback{
+		Revision:       revision,
+		ReleaseVersion: rel.Version + 1,
+		Succeeded:      true,
+		Message:        fmt.Sprintf("rolled back to revision %d", revision),
+	}
+	newRel, err := actionClient.Get(rel.Name)
+	if err != nil {
+		u.UpdateStatus(
+			updater.EnsureRollback(result),
+			updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonErrorGettingReleaseState, err)),
+		)
+		return nil, err
+	}
+	result.ReleaseVersion = newRel.Version
+	u.UpdateStatus(updater.EnsureRollback(result))
+
+	log.Info("Release rolled back", "name", newRel.Name, "version", newRel.Version, "revision", revision)
+	return newRel, nil
+}
+
+// rollbackToRevision rolls back the release to revision, which must exist in
+// the release history.
+func rollbackToRevision(actionClient helmclient.ActionInterface, name string, revision int, cleanupOnFail bool) error {
+	history, err := actionClient.History(name)
+	if err != nil {
+		return fmt.Errorf("get release history: %w", err)
+	}
+	found := false
+	for _, h := range history {
+		found = found || h.Version == revision
+	}
+	if !found {
+		return fmt.Errorf("revision %d not found in release history", revision)
+	}
+	return actionClient.Rollback(name, func(rb *action.Rollback) error {
+		rb.Version = revision
+		rb.CleanupOnFail = cleanupOnFail
+		return nil
+	})
+}
+
+// upgradeOptions returns the options of upgrades of the release of obj,
+// which are configured by the reconciler and the upgrade annotations of obj.
+func (r *Reconciler) upgradeOptions(obj metav1.Object) []helmclient.UpgradeOption {
+	opts := []helmclient.UpgradeOption{func(upgrade *action.Upgrade) error {
+		upgrade.MaxHistory = r.maxHistory
+		return nil
+	}}
+	for name, annot := range r.upgradeAnnotations {
+		if v, ok := obj.GetAnnotations()[name]; ok {
+			opts = append(opts, annot.UpgradeOption(v))
+		}
+	}
+	return opts
+}
+
+// maxHistoryFor returns the maximum number of release records of the release
+// of obj, taking its upgrade annotations into account.
+func (r *Reconciler) maxHistoryFor(obj metav1.Object) int {
+	upgrade := &action.Upgrade{}
+	for _, o := range r.upgradeOptions(obj) {
+		if err := o(upgrade); err != nil {
+			return r.maxHistory
+		}
+	}
+	return upgrade.MaxHistory
+}
+
+// reportDiagnostics collects diagnostics about the resources of the release
+// that failed with err and records them in `status.diagnostics` and in an
+// event. Only warning events recorded since the release was attempted are
+// collected.
+func (r *Reconciler) reportDiagnostics(u *updater.Updater, obj *unstructured.Unstructured, reason status.ConditionReason, err error, since time.Time, log logr.Logger) {
+	var failedErr *helmclient.FailedReleaseError
+	if !errors.As(err, &failedErr) || failedErr.Release == nil {
+		return
+	}
+	summary, err := diagnostics.Collect(context.TODO(), r.apiReader, failedErr.Release, since.Truncate(time.Second))
+	if err != nil {
+		log.Error(err, "Failed to collect diagnostics of failed release", "name", failedErr.Release.Name)
+		return
+	}
+	if summary.Empty() {
+		u.UpdateStatus(updater.RemoveDiagnostics())
+		return
+	}
+	u.UpdateStatus(updater.EnsureDiagnostics(summary))
+	r.eventRecorder.Eventf(obj, "Warning", string(reason), "Release version %d failed: %s", summary.ReleaseVersion, summary)
+}
+
 func (r *Reconciler) reportOverrideEvents(obj runtime.Object) {
 	for k, v := range r.overrideValues {
 		r.eventRecorder.Eventf(obj, "Warning", "ValueOverridden",
@@ -695,27 +1978,96 @@ func (r *Reconciler) reportOverrideEvents(obj runtime.Object) {
 	}
 }
 
-func (r *Reconciler) doReconcile(actionClient helmclient.ActionInterface, u *updater.Updater, rel *release.Release, log logr.Logger) error {
+func (r *Reconciler) doReconcile(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, rel *release.Release, log logr.Logger) error {
 	// If a change is made to the CR spec that causes a release failure, a
 	// ConditionReleaseFailed is added to the status conditions. If that change
 	// is then reverted to its previous state, the operator will stop
 	// attempting the release and will resume reconciling. In this case, we
 	// need to set the ConditionReleaseFailed to false because the failing
 	// release is no longer being attempted.
-	u.UpdateStatus(
-		updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionFalse, "", "")),
-	)
+	//
+	// A release whose resources did not become ready in time remains failed
+	// until they do.
+	if !waitTimedOut(obj, rel) {
+		u.UpdateStatus(
+			updater.EnsureCondition(conditions.ReleaseFailed(corev1.ConditionFalse, "", "")),
+		)
+	}
 
-	if err := actionClient.Reconcile(rel); err != nil {
-		u.UpdateStatus(updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, conditions.ReasonReconcileError, err)))
+	if r.driftMode == DriftModeIgnore {
+		u.UpdateStatus(updater.RemoveCondition(conditions.TypeDrifted))
+		return nil
+	}
+
+	drifts, err := actionClient.Reconcile(rel, func(o *helmclient.ReconcileOptions) error {
+		o.DryRun = r.driftMode == DriftModeReportOnly
+		o.ServerSideApply = r.driftStrategy == DriftStrategyApply
+		o.FieldManager = r.fieldManager
+		o.IgnoreDifferences = r.ignoreDifferences
+		o.Cache = r.dependentCache
+		return nil
+	})
+	if err != nil {
+		err = classifyError(err)
+		u.UpdateStatus(updater.EnsureCondition(conditions.Irreconcilable(corev1.ConditionTrue, errorReason(err, conditions.ReasonReconcileError), err)))
 		return err
 	}
+	r.reportDrift(u, obj, drifts)
 
-	log.Info("Release reconciled", "name", rel.Name, "version", rel.Version)
+	log.Info("Release reconciled", "name", rel.Name, "version", rel.Version, "driftedResources", len(drifts))
 	return nil
 }
 
-func (r *Reconciler) doUninstall(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, log logr.Logger) error {
+// reportDrift records drifts in the Drifted condition and emits an event for
+// each drifted resource.
+func (r *Reconciler) reportDrift(u *updater.Updater, obj *unstructured.Unstructured, drifts []helmclient.Drift) {
+	if len(drifts) == 0 {
+		u.UpdateStatus(updater.EnsureCondition(conditions.Drifted(corev1.ConditionFalse, "", "")))
+		return
+	}
+
+	corrected := r.driftMode == DriftModeCorrect
+	conflicted := false
+	msgs := make([]string, 0, len(drifts))
+	for _, d := range drifts {
+		resource := fmt.Sprintf("%s %s", d.GroupVersionKind.Kind, client.ObjectKey{Namespace: d.Namespace, Name: d.Name})
+		fields := strings.Join(d.Fields, ", ")
+		conflicts := strings.Join(d.Conflicts, ", ")
+		switch {
+		case d.Missing:
+			msgs = append(msgs, fmt.Sprintf("%s: missing", resource))
+		case len(d.Conflicts) > 0:
+			msgs = append(msgs, fmt.Sprintf("%s: %s (conflicts: %s)", resource, fields, conflicts))
+		default:
+			msgs = append(msgs, fmt.Sprintf("%s: %s", resource, fields))
+		}
+
+		switch {
+		case len(d.Conflicts) > 0:
+			conflicted = true
+			r.eventRecorder.Eventf(obj, "Warning", string(conditions.ReasonDriftConflict), "Drifted fields of %s conflict with other field managers: %s", resource, conflicts)
+		case d.Missing && corrected:
+			r.eventRecorder.Eventf(obj, "Warning", string(conditions.ReasonDriftCorrected), "Re-created missing %s", resource)
+		case d.Missing:
+			r.eventRecorder.Eventf(obj, "Warning", string(conditions.ReasonDriftDetected), "%s is missing", resource)
+		case corrected:
+			r.eventRecorder.Eventf(obj, "Warning", string(conditions.ReasonDriftCorrected), "Patched drifted fields of %s: %s", resource, fields)
+		default:
+			r.eventRecorder.Eventf(obj, "Warning", string(conditions.ReasonDriftDetected), "Drifted fields of %s: %s", resource, fields)
+		}
+	}
+
+	reason := conditions.ReasonDriftDetected
+	switch {
+	case conflicted:
+		reason = conditions.ReasonDriftConflict
+	case corrected:
+		reason = conditions.ReasonDriftCorrected
+	}
+	u.UpdateStatus(updater.EnsureCondition(conditions.Drifted(corev1.ConditionTrue, reason, conditionMessage(msgs))))
+}
+
+func (r *Reconciler) doUninstall(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, releaseName string, log logr.Logger) error {
 	var opts []helmclient.UninstallOption
 	for name, annot := range r.uninstallAnnotations {
 		if v, ok := obj.GetAnnotations()[name]; ok {
@@ -723,7 +2075,7 @@ func (r *Reconciler) doUninstall(actionClient helmclient.ActionInterface, u *upd
 		}
 	}
 
-	resp, err := actionClient.Uninstall(obj.GetName(), opts...)
+	resp, err := actionClient.Uninstall(releaseName, opts...)
 	if errors.Is(err, driver.ErrReleaseNotFound) {
 		log.Info("Release not found, removing finalizer")
 	} else if err != nil {
@@ -751,6 +2103,19 @@ func (r *Reconciler) validate() error {
 	if r.chrt == nil {
 		return errors.New("chart must not be nil")
 	}
+	if len(r.chartVersions) == 0 {
+		return nil
+	}
+	versions := map[string]struct{}{}
+	for _, c := range append([]*chart.Chart{r.chrt}, r.chartVersions...) {
+		if _, err := semver.NewVersion(c.Metadata.Version); err != nil {
+			return fmt.Errorf("invalid chart version %q: %w", c.Metadata.Version, err)
+		}
+		if _, ok := versions[c.Metadata.Version]; ok {
+			return fmt.Errorf("duplicate chart version %q", c.Metadata.Version)
+		}
+		versions[c.Metadata.Version] = struct{}{}
+	}
 	return nil
 }
 
@@ -758,11 +2123,27 @@ func (r *Reconciler) addDefaults(mgr ctrl.Manager, controllerName string) {
 	if r.client == nil {
 		r.client = mgr.GetClient()
 	}
+	if r.apiReader == nil {
+		r.apiReader = mgr.GetAPIReader()
+	}
+	if r.restMapper == nil {
+		r.restMapper = mgr.GetRESTMapper()
+	}
 	if r.log == nil {
 		r.log = ctrl.Log.WithName("controllers").WithName("Helm")
 	}
+	if r.releaseNameFunc == nil {
+		r.releaseNameFunc = func(obj helmclient.Object) (string, error) { return obj.GetName(), nil }
+	}
+	if r.targetNamespaceFunc == nil {
+		r.targetNamespaceFunc = func(obj helmclient.Object) (string, error) { return obj.GetNamespace(), nil }
+	}
 	if r.actionClientGetter == nil {
-		actionConfigGetter := helmclient.NewActionConfigGetter(mgr.GetConfig(), mgr.GetRESTMapper(), r.log)
+		opts := []helmclient.ActionConfigGetterOption{helmclient.WithNamespaceMapper(r.targetNamespaceFunc)}
+		if r.storageDriverGetter != nil {
+			opts = append(opts, helmclient.WithStorageDriver(r.storageDriverGetter))
+		}
+		actionConfigGetter := helmclient.NewActionConfigGetter(mgr.GetConfig(), mgr.GetRESTMapper(), r.log, opts...)
 		r.actionClientGetter = helmclient.NewActionClientGetter(actionConfigGetter)
 	}
 	if r.eventRecorder == nil {
@@ -771,6 +2152,23 @@ func (r *Reconciler) addDefaults(mgr ctrl.Manager, controllerName string) {
 	if r.valueMapper == nil {
 		r.valueMapper = internalvalues.DefaultMapper
 	}
+	if r.driftMode == "" {
+		r.driftMode = DriftModeCorrect
+	}
+	if r.driftStrategy == "" {
+		r.driftStrategy = DriftStrategyPatch
+	}
+	if r.fieldManager == "" {
+		r.fieldManager = helmclient.DefaultFieldManager
+	}
+	if r.waitTimeout == 0 {
+		r.waitTimeout = defaultWaitTimeout
+	}
+	checkers := health.DefaultCheckers()
+	for gvk, c := range r.healthCheckers {
+		checkers[gvk] = c
+	}
+	r.healthCheckers = checkers
 }
 
 func (r *Reconciler) setupScheme(mgr ctrl.Manager) {
@@ -788,15 +2186,28 @@ func (r *Reconciler) setupWatches(mgr ctrl.Manager, c controller.Controller) err
 		return err
 	}
 
-	secret := &corev1.Secret{}
-	secret.SetGroupVersionKind(schema.GroupVersionKind{
-		Group:   "",
-		Version: "v1",
-		Kind:    "Secret",
-	})
+	// Changes of release records are watched to reconcile their owners. If
+	// the cache of the manager supports it, only the metadata of the
+	// Secrets that store release records is kept in memory instead of all
+	// Secrets.
+	secretGVK := corev1.SchemeGroupVersion.WithKind("Secret")
+	secretSource := func(selector labels.Selector) (source.Source, error) {
+		if mc, ok := mgr.GetCache().(helmmanager.MetadataCache); ok {
+			inf, err := mc.GetMetadataInformer(secretGVK, selector)
+			if err != nil {
+				return nil, err
+			}
+			return &source.Informer{Informer: inf}, nil
+		}
+		return &source.Kind{Type: &corev1.Secret{}}, nil
+	}
 
+	releaseSecrets, err := secretSource(helmmanager.ReleaseSecretSelector)
+	if err != nil {
+		return err
+	}
 	if err := c.Watch(
-		&source.Kind{Type: secret},
+		releaseSecrets,
 		&handler.EnqueueRequestForOwner{
 			OwnerType:    obj,
 			IsController: true,
@@ -805,13 +2216,48 @@ func (r *Reconciler) setupWatches(mgr ctrl.Manager, c controller.Controller) err
 		return err
 	}
 
+	if r.valuesFromField != "" {
+		valuesSecrets, err := secretSource(labels.Everything())
+		if err != nil {
+			return err
+		}
+		for _, ref := range []struct {
+			kind string
+			src  source.Source
+		}{
+			{ValuesFromKindSecret, valuesSecrets},
+			{ValuesFromKindConfigMap, &source.Kind{Type: &corev1.ConfigMap{}}},
+		} {
+			if err := c.Watch(
+				ref.src,
+				&handler.EnqueueRequestsFromMapFunc{ToRequests: r.valuesFromRequests(ref.kind)},
+			); err != nil {
+				return err
+			}
+		}
+	}
+
 	if !r.skipDependentWatches {
-		r.postHooks = append([]hook.PostHook{internalhook.NewDependentResourceWatcher(c, mgr.GetRESTMapper())}, r.postHooks...)
+		w := internalhook.NewDependentResourceWatcher(c, mgr.GetRESTMapper(), mgr.GetCache(), r.healthCheckers, r.ignoreDifferences)
+		r.postHooks = append([]hook.PostHook{w}, r.postHooks...)
+		r.dependentCache = &dependentCache{w}
 	}
 	return nil
 }
 
-func ensureDeployedRelease(u *updater.Updater, rel *release.Release) {
+// dependentCache reads dependent resources from the informers that watch
+// them. It only caches the kinds of dependent resources that are watched,
+// since reading other kinds from the cache of the manager would start
+// informers for them.
+type dependentCache struct {
+	internalhook.DependentResourceWatcher
+}
+
+func (c *dependentCache) Caches(gvk schema.GroupVersionKind) bool {
+	return c.Watches(gvk)
+}
+
+func (r *Reconciler) ensureDeployedRelease(u *updater.Updater, rel *release.Release) {
 	reason := conditions.ReasonInstallSuccessful
 	message := "release was successfully installed"
 	if rel.Version > 1 {
@@ -822,8 +2268,12 @@ func ensureDeployedRelease(u *updater.Updater, rel *release.Release) {
 		message = rel.Info.Notes
 	}
 	u.Update(updater.EnsureFinalizer(uninstallFinalizer))
-	u.UpdateStatus(
-		updater.EnsureCondition(conditions.Deployed(corev1.ConditionTrue, reason, message)),
-		updater.EnsureDeployedRelease(rel),
-	)
+	u.UpdateStatus(updater.EnsureDeployedRelease(rel, updater.ManifestMode(r.manifestMode)))
+
+	// A failed release that was kept by the remediation does not change
+	// whether a release is deployed.
+	if rel.Info != nil && rel.Info.Status == release.StatusFailed {
+		return
+	}
+	u.UpdateStatus(updater.EnsureCondition(conditions.Deployed(corev1.ConditionTrue, reason, message)))
 }
@@ -22,12 +22,16 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"text/template"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testing"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/release"
@@ -42,6 +46,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -52,12 +57,18 @@ import (
 
 	"github.com/joelanford/helm-operator/pkg/annotation"
 	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/diff"
+	"github.com/joelanford/helm-operator/pkg/health"
 	"github.com/joelanford/helm-operator/pkg/hook"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/controllerutil"
 	"github.com/joelanford/helm-operator/pkg/internal/sdk/status"
 	"github.com/joelanford/helm-operator/pkg/internal/testutil"
+	helmmanager "github.com/joelanford/helm-operator/pkg/manager"
 	"github.com/joelanford/helm-operator/pkg/reconciler/internal/conditions"
 	helmfake "github.com/joelanford/helm-operator/pkg/reconciler/internal/fake"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/inventory"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/rendercache"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/updater"
 	"github.com/joelanford/helm-operator/pkg/values"
 )
 
@@ -78,6 +89,12 @@ var _ = Describe("Reconciler", func() {
 			Expect(r).NotTo(BeNil())
 			Expect(err).To(BeNil())
 		})
+		It("should fail with duplicate chart versions", func() {
+			chrt := chart.Chart{Metadata: &chart.Metadata{Name: "my-chart", Version: "0.1.0"}}
+			r, err := New(WithChart(chrt), WithChartVersions(chrt), WithGroupVersionKind(schema.GroupVersionKind{}))
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("duplicate chart version")))
+		})
 		It("should return an error if an option func fails", func() {
 			r, err := New(func(r *Reconciler) error { return errors.New("expect this error") })
 			Expect(r).To(BeNil())
@@ -133,6 +150,49 @@ var _ = Describe("Reconciler", func() {
 				Expect(r.chrt).To(Equal(&chrt))
 			})
 		})
+		var _ = Describe("WithChartVersions", func() {
+			It("should set the reconciler chart versions", func() {
+				chrt1 := chart.Chart{Metadata: &chart.Metadata{Name: "my-chart", Version: "0.1.0"}}
+				chrt2 := chart.Chart{Metadata: &chart.Metadata{Name: "my-chart", Version: "0.2.0"}}
+				Expect(WithChartVersions(chrt1, chrt2)(r)).To(Succeed())
+				Expect(r.chartVersions).To(Equal([]*chart.Chart{&chrt1, &chrt2}))
+			})
+		})
+		var _ = Describe("WithChartVersionAnnotation", func() {
+			It("should set the reconciler chart version annotation", func() {
+				a := annotation.ChartVersionConstraint{CustomName: "my.domain/custom-name1"}
+				Expect(WithChartVersionAnnotation(a)(r)).To(Succeed())
+				Expect(r.annotations).To(Equal(map[string]struct{}{
+					"my.domain/custom-name1": struct{}{},
+				}))
+				Expect(r.chartVersionAnnotation).To(Equal(a))
+			})
+			It("should error with duplicate annotation", func() {
+				a1 := annotation.Paused{CustomName: "my.domain/custom-name1"}
+				a2 := annotation.ChartVersionConstraint{CustomName: "my.domain/custom-name1"}
+				Expect(WithPauseAnnotation(a1)(r)).To(Succeed())
+				Expect(WithChartVersionAnnotation(a2)(r)).To(HaveOccurred())
+				Expect(r.chartVersionAnnotation).To(BeNil())
+			})
+		})
+		var _ = Describe("WithChartVersionField", func() {
+			It("should set the reconciler chart version field", func() {
+				Expect(WithChartVersionField("chartVersion")(r)).To(Succeed())
+				Expect(r.chartVersionField).To(Equal("chartVersion"))
+			})
+			It("should fail with a nested field", func() {
+				Expect(WithChartVersionField("chart.version")(r)).NotTo(Succeed())
+			})
+		})
+		var _ = Describe("WithValuesFromField", func() {
+			It("should set the reconciler values from field", func() {
+				Expect(WithValuesFromField("valuesFrom")(r)).To(Succeed())
+				Expect(r.valuesFromField).To(Equal("valuesFrom"))
+			})
+			It("should fail with a nested field", func() {
+				Expect(WithValuesFromField("values.from")(r)).NotTo(Succeed())
+			})
+		})
 		var _ = Describe("WithOverrideValues", func() {
 			It("should succeed with valid overrides", func() {
 				overrides := map[string]string{"foo": "bar"}
@@ -145,6 +205,28 @@ var _ = Describe("Reconciler", func() {
 				Expect(WithOverrideValues(overrides)(r)).NotTo(Succeed())
 			})
 		})
+		var _ = Describe("WithReleaseNameFunc", func() {
+			It("should set the reconciler release name func", func() {
+				f := func(helmclient.Object) (string, error) { return "release-name", nil }
+				Expect(WithReleaseNameFunc(f)(r)).To(Succeed())
+				Expect(r.releaseNameFunc).NotTo(BeNil())
+				Expect(r.releaseNameFunc(nil)).To(Equal("release-name"))
+			})
+		})
+		var _ = Describe("WithTargetNamespaceFunc", func() {
+			It("should set the reconciler target namespace func", func() {
+				f := func(helmclient.Object) (string, error) { return "target-namespace", nil }
+				Expect(WithTargetNamespaceFunc(f)(r)).To(Succeed())
+				Expect(r.targetNamespaceFunc).NotTo(BeNil())
+				Expect(r.targetNamespaceFunc(nil)).To(Equal("target-namespace"))
+			})
+		})
+		var _ = Describe("WithStorageDriver", func() {
+			It("should set the reconciler storage driver getter", func() {
+				Expect(WithStorageDriver(helmclient.MemoryStorageDriver())(r)).To(Succeed())
+				Expect(r.storageDriverGetter).NotTo(BeNil())
+			})
+		})
 		var _ = Describe("SkipDependentWatches", func() {
 			It("should set to false", func() {
 				Expect(SkipDependentWatches(false)(r)).To(Succeed())
@@ -174,6 +256,98 @@ var _ = Describe("Reconciler", func() {
 				Expect(WithReconcilePeriod(-time.Nanosecond)(r)).NotTo(Succeed())
 			})
 		})
+		var _ = Describe("WithDriftMode", func() {
+			It("should set the reconciler drift mode", func() {
+				Expect(WithDriftMode(DriftModeReportOnly)(r)).To(Succeed())
+				Expect(r.driftMode).To(Equal(DriftModeReportOnly))
+			})
+			It("should default to correcting drift", func() {
+				Expect(WithDriftMode("")(r)).To(Succeed())
+				Expect(r.driftMode).To(Equal(DriftModeCorrect))
+			})
+			It("should fail with an invalid drift mode", func() {
+				Expect(WithDriftMode("invalid")(r)).NotTo(Succeed())
+			})
+		})
+		var _ = Describe("WithDriftStrategy", func() {
+			It("should set the reconciler drift strategy", func() {
+				Expect(WithDriftStrategy(DriftStrategyApply)(r)).To(Succeed())
+				Expect(r.driftStrategy).To(Equal(DriftStrategyApply))
+			})
+			It("should default to patching drift", func() {
+				Expect(WithDriftStrategy("")(r)).To(Succeed())
+				Expect(r.driftStrategy).To(Equal(DriftStrategyPatch))
+			})
+			It("should fail with an invalid drift strategy", func() {
+				Expect(WithDriftStrategy("invalid")(r)).NotTo(Succeed())
+			})
+		})
+		var _ = Describe("WithIgnoreDifferences", func() {
+			It("should set the reconciler ignore rules", func() {
+				rule := diff.IgnoreRule{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas"}}
+				Expect(WithIgnoreDifferences(rule)(r)).To(Succeed())
+				Expect(r.ignoreDifferences).To(Equal(diff.IgnoreRules{rule}))
+			})
+			It("should fail with an invalid rule", func() {
+				Expect(WithIgnoreDifferences(diff.IgnoreRule{Kind: "Deployment"})(r)).NotTo(Succeed())
+			})
+		})
+		var _ = Describe("WithFieldManager", func() {
+			It("should set the reconciler field manager", func() {
+				Expect(WithFieldManager("my-operator")(r)).To(Succeed())
+				Expect(r.fieldManager).To(Equal("my-operator"))
+			})
+		})
+		var _ = Describe("WithManifestMode", func() {
+			It("should set the reconciler manifest mode", func() {
+				Expect(WithManifestMode(ManifestModeCompress)(r)).To(Succeed())
+				Expect(r.manifestMode).To(Equal(ManifestModeCompress))
+			})
+			It("should default to recording the full manifest", func() {
+				Expect(WithManifestMode("")(r)).To(Succeed())
+				Expect(r.manifestMode).To(Equal(ManifestModeFull))
+			})
+			It("should fail with an invalid manifest mode", func() {
+				Expect(WithManifestMode("invalid")(r)).NotTo(Succeed())
+			})
+		})
+		var _ = Describe("WithWait", func() {
+			It("should set the reconciler wait", func() {
+				Expect(WithWait(true)(r)).To(Succeed())
+				Expect(r.wait).To(BeTrue())
+			})
+		})
+		var _ = Describe("WithWaitTimeout", func() {
+			It("should set the reconciler wait timeout", func() {
+				Expect(WithWaitTimeout(time.Minute)(r)).To(Succeed())
+				Expect(r.waitTimeout).To(Equal(time.Minute))
+			})
+			It("should fail if value is not positive", func() {
+				Expect(WithWaitTimeout(0)(r)).NotTo(Succeed())
+			})
+		})
+		var _ = Describe("WithAtomic", func() {
+			It("should set the reconciler atomic and wait", func() {
+				Expect(WithAtomic(true)(r)).To(Succeed())
+				Expect(r.atomic).To(BeTrue())
+				Expect(r.wait).To(BeTrue())
+			})
+		})
+		var _ = Describe("WithCleanupOnFail", func() {
+			It("should set the reconciler cleanup on fail", func() {
+				Expect(WithCleanupOnFail(true)(r)).To(Succeed())
+				Expect(r.cleanupOnFail).To(BeTrue())
+			})
+		})
+		var _ = Describe("WithMaxHistory", func() {
+			It("should set the reconciler max history", func() {
+				Expect(WithMaxHistory(10)(r)).To(Succeed())
+				Expect(r.maxHistory).To(Equal(10))
+			})
+			It("should fail if max history is negative", func() {
+				Expect(WithMaxHistory(-1)(r)).NotTo(Succeed())
+			})
+		})
 		var _ = Describe("WithInstallAnnotations", func() {
 			It("should set multiple reconciler install annotations", func() {
 				a1 := annotation.InstallDisableHooks{CustomName: "my.domain/custom-name1"}
@@ -324,6 +498,52 @@ var _ = Describe("Reconciler", func() {
 				}))
 			})
 		})
+		var _ = Describe("WithRollbackAnnotation", func() {
+			It("should set the reconciler rollback annotation", func() {
+				a := annotation.RollbackToRevision{CustomName: "my.domain/custom-name1"}
+				Expect(WithRollbackAnnotation(a)(r)).To(Succeed())
+				Expect(r.annotations).To(Equal(map[string]struct{}{
+					"my.domain/custom-name1": struct{}{},
+				}))
+				Expect(r.rollbackAnnotation).To(Equal(a))
+			})
+			It("should error with duplicate annotation", func() {
+				a1 := annotation.UpgradeForce{CustomName: "my.domain/custom-name1"}
+				a2 := annotation.RollbackToRevision{CustomName: "my.domain/custom-name1"}
+				Expect(WithUpgradeAnnotations(a1)(r)).To(Succeed())
+				Expect(WithRollbackAnnotation(a2)(r)).To(HaveOccurred())
+				Expect(r.rollbackAnnotation).To(BeNil())
+			})
+		})
+		var _ = Describe("WithPauseAnnotation", func() {
+			It("should set the reconciler pause annotation", func() {
+				a := annotation.Paused{CustomName: "my.domain/custom-name1"}
+				Expect(WithPauseAnnotation(a)(r)).To(Succeed())
+				Expect(r.annotations).To(Equal(map[string]struct{}{
+					"my.domain/custom-name1": struct{}{},
+				}))
+				Expect(r.pauseAnnotation).To(Equal(a))
+			})
+			It("should error with duplicate annotation", func() {
+				a1 := annotation.RollbackToRevision{CustomName: "my.domain/custom-name1"}
+				a2 := annotation.Paused{CustomName: "my.domain/custom-name1"}
+				Expect(WithRollbackAnnotation(a1)(r)).To(Succeed())
+				Expect(WithPauseAnnotation(a2)(r)).To(HaveOccurred())
+				Expect(r.pauseAnnotation).To(BeNil())
+			})
+		})
+		var _ = Describe("WithPaused", func() {
+			It("should set the reconciler paused default", func() {
+				Expect(WithPaused(true)(r)).To(Succeed())
+				Expect(r.paused).To(BeTrue())
+			})
+		})
+		var _ = Describe("SkipUninstallWhilePaused", func() {
+			It("should set to true", func() {
+				Expect(SkipUninstallWhilePaused(true)(r)).To(Succeed())
+				Expect(r.skipUninstallPaused).To(BeTrue())
+			})
+		})
 		var _ = Describe("WithPreHook", func() {
 			It("should set a reconciler prehook", func() {
 				called := false
@@ -350,6 +570,22 @@ var _ = Describe("Reconciler", func() {
 				Expect(called).To(BeTrue())
 			})
 		})
+		var _ = Describe("WithHealthChecker", func() {
+			It("should set a reconciler health checker", func() {
+				gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Database"}
+				checker := health.CheckerFunc(func(*unstructured.Unstructured) (health.Result, error) {
+					return health.Healthy(), nil
+				})
+				Expect(WithHealthChecker(gvk, checker)(r)).To(Succeed())
+				Expect(r.healthCheckers).To(HaveKey(gvk))
+			})
+		})
+		var _ = Describe("WithValidatingWebhook", func() {
+			It("should set to true", func() {
+				Expect(WithValidatingWebhook(true)(r)).To(Succeed())
+				Expect(r.validatingWebhook).To(BeTrue())
+			})
+		})
 		var _ = Describe("WithValueMapper", func() {
 			It("should set the reconciler value mapper", func() {
 				mapper := values.MapperFunc(func(chartutil.Values) chartutil.Values {
@@ -429,6 +665,10 @@ var _ = Describe("Reconciler", func() {
 				Expect(err).To(BeNil())
 			})
 			metrics.Registry.Unregister(r.infoMetric)
+			metrics.Registry.Unregister(r.historyMetric)
+			metrics.Registry.Unregister(r.errorsMetric)
+			metrics.Registry.Unregister(r.renderCacheHitsMetric)
+			metrics.Registry.Unregister(r.renderCacheMissesMetric)
 			close(done)
 		})
 
@@ -565,9 +805,182 @@ var _ = Describe("Reconciler", func() {
 						})
 					})
 				})
+				When("values do not match the chart's schema", func() {
+					BeforeEach(func() {
+						schemaChrt := chrt
+						schemaChrt.Schema = []byte(`{"type": "object", "properties": {"replicas": {"type": "string"}}}`)
+						r.chrt = &schemaChrt
+					})
+					It("parks the CR until it changes", func() {
+						By("reconciling without retrying the permanent error", func() {
+							res, err := r.Reconcile(req)
+							Expect(res).To(Equal(reconcile.Result{}))
+							Expect(err).To(BeNil())
+						})
+
+						By("verifying the CR status", func() {
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							objStat := &objStatus{}
+							Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+							Expect(objStat.Status.Conditions.IsUnknownFor(conditions.TypeReleaseFailed)).To(BeTrue())
+							Expect(objStat.Status.DeployedRelease).To(BeNil())
+
+							c := objStat.Status.Conditions.GetCondition(conditions.TypeIrreconcilable)
+							Expect(c).NotTo(BeNil())
+							Expect(c.Status).To(Equal(v1.ConditionTrue))
+							Expect(c.Reason).To(Equal(conditions.ReasonInvalidValues))
+							Expect(c.Message).To(ContainSubstring("replicas: "))
+
+							Expect(objStat.Status.PermanentError).NotTo(BeNil())
+							Expect(objStat.Status.PermanentError.Reason).To(Equal(string(conditions.ReasonInvalidValues)))
+							Expect(objStat.Status.PermanentError.Generation).To(Equal(obj.GetGeneration()))
+						})
+
+						By("skipping reconciliation while the CR is unchanged", func() {
+							r.chrt = &chrt
+							_, err := r.Reconcile(req)
+							Expect(err).To(BeNil())
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							objStat := &objStatus{}
+							Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+							Expect(objStat.Status.DeployedRelease).To(BeNil())
+						})
+
+						By("reconciling once the annotations of the CR change", func() {
+							obj.SetAnnotations(map[string]string{"touched": "true"})
+							Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+							_, err := r.Reconcile(req)
+							Expect(err).To(BeNil())
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							objStat := &objStatus{}
+							Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+							Expect(objStat.Status.DeployedRelease).NotTo(BeNil())
+							Expect(objStat.Status.PermanentError).To(BeNil())
+						})
+					})
+				})
+				When("values are referenced", func() {
+					var secret *v1.Secret
+					BeforeEach(func() {
+						r.valuesFromField = "valuesFrom"
+						secret = &v1.Secret{
+							ObjectMeta: metav1.ObjectMeta{Namespace: obj.GetNamespace(), Name: "test-values"},
+							Data: map[string][]byte{
+								"password":    []byte("s3cr3t"),
+								"values.yaml": []byte("db:\n  user: admin\n  password: overridden\n"),
+							},
+						}
+						Expect(mgr.GetClient().Create(context.TODO(), secret)).To(Succeed())
+					})
+					AfterEach(func() {
+						Expect(mgr.GetClient().Delete(context.TODO(), secret)).To(Succeed())
+					})
+					It("installs the release with the referenced values", func() {
+						Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+							map[string]interface{}{"kind": "Secret", "name": "test-values"},
+							map[string]interface{}{"kind": "Secret", "name": "test-values", "key": "password", "targetPath": "db.password"},
+						}, "spec", "valuesFrom")).To(Succeed())
+						Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+
+						By("successfully reconciling a request", func() {
+							Eventually(func() error {
+								_, err := r.Reconcile(req)
+								return err
+							}).Should(Succeed())
+						})
+
+						By("verifying the release values", func() {
+							rel, err := ac.Get(obj.GetName())
+							Expect(err).To(BeNil())
+							Expect(rel.Config).NotTo(HaveKey("valuesFrom"))
+							Expect(rel.Config).To(HaveKeyWithValue("db", map[string]interface{}{
+								"user":     "admin",
+								"password": "s3cr3t",
+							}))
+						})
+					})
+					It("returns an error if a referenced key does not exist", func() {
+						Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+							map[string]interface{}{"kind": "Secret", "name": "test-values", "key": "missing"},
+						}, "spec", "valuesFrom")).To(Succeed())
+						Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+
+						By("reconciling unsuccessfully", func() {
+							Eventually(func() error {
+								_, err := r.Reconcile(req)
+								return err
+							}).Should(MatchError(ContainSubstring(`key "missing" not found`)))
+						})
+
+						By("verifying the CR status", func() {
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							objStat := &objStatus{}
+							Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+							c := objStat.Status.Conditions.GetCondition(conditions.TypeIrreconcilable)
+							Expect(c).NotTo(BeNil())
+							Expect(c.Reason).To(Equal(conditions.ReasonErrorGettingValues))
+						})
+					})
+				})
+				When("a chart version is selected", func() {
+					BeforeEach(func() {
+						chrt2 := chrt
+						md := *chrt.Metadata
+						md.Version = "0.2.0"
+						chrt2.Metadata = &md
+						r.chartVersions = []*chart.Chart{&chrt2}
+						r.chartVersionAnnotation = annotation.ChartVersionConstraint{}
+					})
+					It("installs the release with the selected chart version", func() {
+						obj.SetAnnotations(map[string]string{annotation.DefaultChartVersionName: "~0.2"})
+						Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+
+						By("successfully reconciling a request", func() {
+							_, err := r.Reconcile(req)
+							Expect(err).To(BeNil())
+						})
+
+						By("verifying the release chart version", func() {
+							rel, err := ac.Get(obj.GetName())
+							Expect(err).To(BeNil())
+							Expect(rel.Chart.Metadata.Version).To(Equal("0.2.0"))
+						})
+
+						By("verifying the CR status", func() {
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							objStat := &objStatus{}
+							Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+							Expect(objStat.Status.ChartVersion).To(Equal("0.2.0"))
+						})
+					})
+					It("parks the CR if the chart version is not allowed", func() {
+						obj.SetAnnotations(map[string]string{annotation.DefaultChartVersionName: "~0.3"})
+						Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+
+						By("reconciling without retrying the permanent error", func() {
+							res, err := r.Reconcile(req)
+							Expect(res).To(Equal(reconcile.Result{}))
+							Expect(err).To(BeNil())
+						})
+
+						By("verifying the CR status", func() {
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							objStat := &objStatus{}
+							Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+							c := objStat.Status.Conditions.GetCondition(conditions.TypeIrreconcilable)
+							Expect(c).NotTo(BeNil())
+							Expect(c.Status).To(Equal(v1.ConditionTrue))
+							Expect(c.Reason).To(Equal(conditions.ReasonChartVersionNotAllowed))
+							Expect(c.Message).To(ContainSubstring("no allowed chart version"))
+							Expect(objStat.Status.DeployedRelease).To(BeNil())
+							Expect(objStat.Status.PermanentError).NotTo(BeNil())
+						})
+					})
+				})
 				When("CR is deleted, release is not present, but uninstall finalizer exists", func() {
 					It("removes the finalizer", func() {
 						By("adding the uninstall finalizer and deleting the CR", func() {
+							r.errorsMetric.WithLabelValues(obj.GetNamespace(), obj.GetName(), errorClassTransient).Inc()
 							obj.SetFinalizers([]string{uninstallFinalizer})
 							Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
 							Expect(mgr.GetClient().Delete(context.TODO(), obj)).To(Succeed())
@@ -583,6 +996,10 @@ var _ = Describe("Reconciler", func() {
 							err := mgr.GetAPIReader().Get(context.TODO(), objKey, obj)
 							Expect(apierrors.IsNotFound(err)).To(BeTrue())
 						})
+
+						By("ensuring the error metrics of the CR are deleted", func() {
+							Expect(r.errorsMetric.DeleteLabelValues(obj.GetNamespace(), obj.GetName(), errorClassTransient)).To(BeFalse())
+						})
 					})
 				})
 				When("all install preconditions met", func() {
@@ -632,84 +1049,356 @@ var _ = Describe("Reconciler", func() {
 							})
 						})
 					})
-					When("installation succeeds", func() {
-						It("installs the release", func() {
-							var (
-								rel *release.Release
-								err error
-							)
-							By("successfully reconciling a request", func() {
-								res, err := r.Reconcile(req)
-								Expect(err).To(BeNil())
-								Expect(res).To(Equal(reconcile.Result{}))
+					When("installation fails with a failed hook", func() {
+						BeforeEach(func() {
+							ac := helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								return nil, driver.ErrReleaseNotFound
+							}
+							ac.HandleInstall = func() (*release.Release, error) {
+								return nil, &helmclient.FailedReleaseError{
+									Release: &release.Release{
+										Name:      objKey.Name,
+										Namespace: objKey.Namespace,
+										Version:   1,
+										Hooks: []*release.Hook{{
+											Name:    "pre-install-check",
+											Kind:    "ConfigMap",
+											LastRun: release.HookExecution{Phase: release.HookPhaseFailed},
+										}},
+									},
+									Err: errors.New("pre-install hook failed"),
+								}
+							}
+							ac.HandleUninstall = func() (*release.UninstallReleaseResponse, error) {
+								return &release.UninstallReleaseResponse{}, nil
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+						})
+						It("records the diagnostics of the failed release", func() {
+							By("returning an error", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(MatchError(ContainSubstring("pre-install hook failed")))
 							})
 
-							By("getting the release and CR", func() {
-								rel, err = ac.Get(obj.GetName())
-								Expect(err).To(BeNil())
-								Expect(rel).NotTo(BeNil())
+							By("getting the CR", func() {
 								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
 							})
 
-							By("verifying the release", func() {
-								Expect(rel.Version).To(Equal(1))
-								verifyRelease(mgr.GetClient(), obj.GetNamespace(), rel)
-							})
-
-							By("verifying override event", func() {
-								verifyEvent(mgr.GetAPIReader(), obj,
-									"Warning",
-									"ValueOverridden",
-									`Chart value "image.repository" overridden to "custom-nginx" by operator`)
-							})
-
-							By("ensuring the uninstall finalizer is present", func() {
-								Expect(obj.GetFinalizers()).To(ContainElement(uninstallFinalizer))
+							By("ensuring the diagnostics are recorded in the status", func() {
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								Expect(objStat.Status.Diagnostics).NotTo(BeNil())
+								Expect(objStat.Status.Diagnostics.ReleaseVersion).To(Equal(1))
+								Expect(objStat.Status.Diagnostics.Hooks).To(HaveLen(1))
+								Expect(objStat.Status.Diagnostics.Hooks[0].Name).To(Equal("pre-install-check"))
 							})
 
-							By("verifying the CR status", func() {
+							By("ensuring the failure is counted", func() {
 								objStat := &objStatus{}
 								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
-								Expect(objStat.Status.Conditions.IsTrueFor(conditions.TypeInitialized)).To(BeTrue())
-								Expect(objStat.Status.Conditions.IsFalseFor(conditions.TypeIrreconcilable)).To(BeTrue())
-								Expect(objStat.Status.Conditions.IsTrueFor(conditions.TypeDeployed)).To(BeTrue())
-								Expect(objStat.Status.Conditions.IsFalseFor(conditions.TypeReleaseFailed)).To(BeTrue())
-								Expect(objStat.Status.DeployedRelease.Name).To(Equal(obj.GetName()))
-								Expect(objStat.Status.DeployedRelease.Manifest).To(Equal(rel.Manifest))
+								Expect(objStat.Status.Failures).To(Equal(1))
 							})
 						})
-						It("calls pre and post hooks", func() {
-							verifyHooksCalled(r, req)
-						})
 					})
-				})
-			})
-			When("requested CR release is installed", func() {
-				var (
-					installedRelease *release.Release
-				)
-				BeforeEach(func() {
-					// Reconcile once to get the release installed and finalizers added
-					var err error
-					res, err := r.Reconcile(req)
-					Expect(res).To(Equal(reconcile.Result{}))
-					Expect(err).To(BeNil())
-
-					installedRelease, err = ac.Get(obj.GetName())
-					Expect(err).To(BeNil())
-				})
-				When("action client getter is not working", func() {
-					It("returns an error getting the action client", func() {
-						acgErr := errors.New("broken action client getter: error getting action client")
-
-						By("creating a reconciler with a broken action client getter", func() {
-							r.actionClientGetter = helmclient.ActionClientGetterFunc(func(helmclient.Object) (helmclient.ActionInterface, error) {
-								return nil, acgErr
-							})
+					When("installation fails and the retries are exhausted", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								return nil, driver.ErrReleaseNotFound
+							}
+							ac.HandleInstall = func() (*release.Release, error) {
+								return nil, errors.New("install failed: foobar")
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							retries := 0
+							r.remediation = Remediation{Retries: &retries}
 						})
+						It("stops retrying until the custom resource changes", func() {
+							By("failing the first installation", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(MatchError(ContainSubstring("install failed: foobar")))
+								Expect(ac.Installs).To(HaveLen(1))
+							})
 
-						By("reconciling unsuccessfully", func() {
-							res, err := r.Reconcile(req)
+							By("skipping the installation", func() {
+								res, err := r.Reconcile(req)
+								Expect(res).To(Equal(reconcile.Result{}))
+								Expect(err).To(BeNil())
+								Expect(ac.Installs).To(HaveLen(1))
+							})
+
+							By("retrying the installation after the custom resource changes", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								obj.SetAnnotations(map[string]string{"retry": "1"})
+								Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+								Eventually(func() error {
+									_, err := r.Reconcile(req)
+									return err
+								}).Should(HaveOccurred())
+								Expect(len(ac.Installs)).To(BeNumerically(">", 1))
+							})
+						})
+					})
+					When("installation fails with a template error", func() {
+						BeforeEach(func() {
+							ac := helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								return nil, driver.ErrReleaseNotFound
+							}
+							ac.HandleInstall = func() (*release.Release, error) {
+								return nil, errors.New(`parse error at (test-chart/templates/service.yaml:4): function "foo" not defined`)
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+						})
+						It("parks the CR with the RenderError reason", func() {
+							By("reconciling without retrying the permanent error", func() {
+								res, err := r.Reconcile(req)
+								Expect(res).To(Equal(reconcile.Result{}))
+								Expect(err).To(BeNil())
+							})
+
+							By("ensuring the correct conditions are set on the CR", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeIrreconcilable)
+								Expect(c).NotTo(BeNil())
+								Expect(c.Reason).To(Equal(conditions.ReasonRenderError))
+								Expect(c.Message).To(ContainSubstring(`function "foo" not defined`))
+
+								c = objStat.Status.Conditions.GetCondition(conditions.TypeReleaseFailed)
+								Expect(c).NotTo(BeNil())
+								Expect(c.Reason).To(Equal(conditions.ReasonInstallError))
+
+								Expect(objStat.Status.PermanentError).NotTo(BeNil())
+								Expect(objStat.Status.PermanentError.Reason).To(Equal(string(conditions.ReasonRenderError)))
+							})
+						})
+					})
+					When("the resources of atomic installations never become ready", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							installed := false
+							rel := &release.Release{Name: obj.GetName(), Namespace: obj.GetNamespace(), Version: 1, Manifest: unreadyManifest}
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								if !installed {
+									return nil, driver.ErrReleaseNotFound
+								}
+								return rel, nil
+							}
+							ac.HandleInstall = func() (*release.Release, error) {
+								installed = true
+								return rel, nil
+							}
+							ac.HandleUninstall = func() (*release.UninstallReleaseResponse, error) {
+								installed = false
+								return &release.UninstallReleaseResponse{}, nil
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.wait = true
+							r.atomic = true
+							// The deadline is truncated to seconds, so the wait
+							// times out right after the installation.
+							r.waitTimeout = time.Nanosecond
+							retries := 1
+							r.remediation = Remediation{Retries: &retries}
+						})
+						It("stops installing the release once the retries are exhausted", func() {
+							By("uninstalling the release after each timed out wait", func() {
+								for i := 1; i <= 2; i++ {
+									_, err := r.Reconcile(req)
+									Expect(err).To(MatchError(ContainSubstring("timed out waiting")))
+									Expect(ac.Installs).To(HaveLen(i))
+									Expect(ac.Uninstalls).To(HaveLen(i))
+
+									// The next reconciliation reads the CR from the cache.
+									Eventually(func() (int, error) {
+										objStat := &objStatus{}
+										if err := mgr.GetClient().Get(context.TODO(), objKey, obj); err != nil {
+											return 0, err
+										}
+										err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)
+										return objStat.Status.Failures, err
+									}).Should(Equal(i))
+								}
+							})
+
+							By("not installing the release a third time", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(ac.Installs).To(HaveLen(2))
+							})
+						})
+					})
+					When("the release name and namespace are templated", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								return nil, driver.ErrReleaseNotFound
+							}
+							ac.HandleInstall = func() (*release.Release, error) {
+								return &release.Release{
+									Name:      "test-2",
+									Namespace: "default-apps",
+									Version:   1,
+									Info:      &release.Info{Status: release.StatusDeployed},
+								}, nil
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.releaseNameFunc = templateMapper("{{ .metadata.name }}-{{ .spec.replicas }}")
+							r.targetNamespaceFunc = templateMapper("{{ .metadata.namespace }}-apps")
+						})
+						It("installs the release under the templated name into the templated namespace", func() {
+							By("successfully reconciling a request", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+							})
+
+							By("verifying the installation", func() {
+								Expect(ac.Installs).To(HaveLen(1))
+								Expect(ac.Installs[0].Name).To(Equal("test-2"))
+								Expect(ac.Installs[0].Namespace).To(Equal("default-apps"))
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								Expect(objStat.Status.Conditions.IsTrueFor(conditions.TypeDeployed)).To(BeTrue())
+								Expect(objStat.Status.DeployedRelease.Name).To(Equal("test-2"))
+							})
+						})
+						It("fails without installing a release when a template fails", func() {
+							r.targetNamespaceFunc = templateMapper("{{ .spec.missing }}")
+
+							By("returning an error", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(MatchError(ContainSubstring("get release namespace")))
+								Expect(ac.Installs).To(BeEmpty())
+							})
+
+							By("ensuring the correct conditions are set on the CR", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeIrreconcilable)
+								Expect(c).NotTo(BeNil())
+								Expect(c.Status).To(Equal(v1.ConditionTrue))
+								Expect(c.Reason).To(Equal(conditions.ReasonErrorGettingReleaseTarget))
+								Expect(c.Message).To(ContainSubstring("missing"))
+							})
+
+							By("ensuring the uninstall finalizer is not present on the CR", func() {
+								Expect(controllerutil.ContainsFinalizer(obj, uninstallFinalizer)).To(BeFalse())
+							})
+						})
+					})
+					When("installation succeeds", func() {
+						It("installs the release", func() {
+							var (
+								rel *release.Release
+								err error
+							)
+							By("successfully reconciling a request", func() {
+								res, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(res).To(Equal(reconcile.Result{}))
+							})
+
+							By("getting the release and CR", func() {
+								rel, err = ac.Get(obj.GetName())
+								Expect(err).To(BeNil())
+								Expect(rel).NotTo(BeNil())
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							})
+
+							By("verifying the release", func() {
+								Expect(rel.Version).To(Equal(1))
+								verifyRelease(mgr.GetClient(), obj.GetNamespace(), rel)
+							})
+
+							By("verifying override event", func() {
+								verifyEvent(mgr.GetAPIReader(), obj,
+									"Warning",
+									"ValueOverridden",
+									`Chart value "image.repository" overridden to "custom-nginx" by operator`)
+							})
+
+							By("ensuring the uninstall finalizer is present", func() {
+								Expect(obj.GetFinalizers()).To(ContainElement(uninstallFinalizer))
+							})
+
+							By("verifying the CR status", func() {
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								Expect(objStat.Status.Conditions.IsTrueFor(conditions.TypeInitialized)).To(BeTrue())
+								Expect(objStat.Status.Conditions.IsFalseFor(conditions.TypeIrreconcilable)).To(BeTrue())
+								Expect(objStat.Status.Conditions.IsTrueFor(conditions.TypeDeployed)).To(BeTrue())
+								Expect(objStat.Status.Conditions.IsFalseFor(conditions.TypeReleaseFailed)).To(BeTrue())
+								Expect(objStat.Status.DeployedRelease.Name).To(Equal(obj.GetName()))
+								Expect(objStat.Status.DeployedRelease.Manifest).To(Equal(rel.Manifest))
+								Expect(objStat.Status.DeployedRelease.Revision).To(Equal(1))
+								Expect(objStat.Status.DeployedRelease.Status).To(Equal("deployed"))
+								Expect(objStat.Status.DeployedRelease.ChartName).To(Equal(rel.Chart.Metadata.Name))
+								Expect(objStat.Status.DeployedRelease.ValuesHash).NotTo(BeEmpty())
+								Expect(objStat.Status.ObservedGeneration).To(Equal(obj.GetGeneration()))
+								for _, c := range objStat.Status.Conditions {
+									Expect(c.ObservedGeneration).To(Equal(obj.GetGeneration()), string(c.Type))
+								}
+
+								// The chart's templates do not set namespaces, so the
+								// inventory defaults them to the release namespace.
+								Expect(objStat.Status.Resources).NotTo(BeEmpty())
+								Expect(objStat.Status.ResourceCount).To(Equal(len(objStat.Status.Resources)))
+								for _, res := range objStat.Status.Resources {
+									Expect(res.Namespace).To(Equal(obj.GetNamespace()), res.Kind)
+								}
+
+								// There is no deployment controller in the test environment,
+								// so the release's deployment never becomes ready.
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeReady)
+								Expect(c).NotTo(BeNil())
+								Expect(c.Status).To(Equal(v1.ConditionFalse))
+								Expect(c.Reason).To(Equal(conditions.ReasonResourcesProgressing))
+							})
+						})
+						It("calls pre and post hooks", func() {
+							verifyHooksCalled(r, req)
+						})
+					})
+				})
+			})
+			When("requested CR release is installed", func() {
+				var (
+					installedRelease *release.Release
+				)
+				BeforeEach(func() {
+					// Reconcile once to get the release installed and finalizers added
+					var err error
+					res, err := r.Reconcile(req)
+					Expect(res).To(Equal(reconcile.Result{}))
+					Expect(err).To(BeNil())
+
+					installedRelease, err = ac.Get(obj.GetName())
+					Expect(err).To(BeNil())
+				})
+				When("action client getter is not working", func() {
+					It("returns an error getting the action client", func() {
+						acgErr := errors.New("broken action client getter: error getting action client")
+
+						By("creating a reconciler with a broken action client getter", func() {
+							r.actionClientGetter = helmclient.ActionClientGetterFunc(func(helmclient.Object) (helmclient.ActionInterface, error) {
+								return nil, acgErr
+							})
+						})
+
+						By("reconciling unsuccessfully", func() {
+							res, err := r.Reconcile(req)
 							Expect(res).To(Equal(reconcile.Result{}))
 							Expect(err).To(MatchError(acgErr))
 						})
@@ -928,8 +1617,8 @@ var _ = Describe("Reconciler", func() {
 							ac.HandleUpgrade = func() (*release.Release, error) {
 								return &release.Release{Name: "test", Version: 1, Manifest: "version: 1"}, nil
 							}
-							ac.HandleReconcile = func() error {
-								return errors.New("reconciliation failed: foobar")
+							ac.HandleReconcile = func() ([]helmclient.Drift, error) {
+								return nil, errors.New("reconciliation failed: foobar")
 							}
 							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
 						})
@@ -1028,6 +1717,415 @@ var _ = Describe("Reconciler", func() {
 								Expect(objStat.Status.Conditions.IsFalseFor(conditions.TypeReleaseFailed)).To(BeTrue())
 								Expect(objStat.Status.DeployedRelease.Name).To(Equal(rel.Name))
 								Expect(objStat.Status.DeployedRelease.Manifest).To(Equal(rel.Manifest))
+
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeDrifted)
+								Expect(c).NotTo(BeNil())
+								Expect(c.IsTrue()).To(BeTrue())
+								Expect(c.Reason).To(Equal(conditions.ReasonDriftCorrected))
+								Expect(c.Message).To(ContainSubstring("metadata.labels.app.kubernetes.io/managed-by"))
+							})
+						})
+					})
+					When("drift is only reported", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								return &release.Release{Name: "test", Version: 1, Manifest: "version: 1"}, nil
+							}
+							ac.HandleUpgrade = func() (*release.Release, error) {
+								return &release.Release{Name: "test", Version: 1, Manifest: "version: 1"}, nil
+							}
+							ac.HandleReconcile = func() ([]helmclient.Drift, error) {
+								return []helmclient.Drift{{
+									GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+									Namespace:        "default",
+									Name:             "test",
+									Fields:           []string{"spec.replicas"},
+								}}, nil
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.driftMode = DriftModeReportOnly
+						})
+						It("reports the drift without correcting it", func() {
+							By("successfully reconciling a request", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+							})
+
+							By("verifying the reconcile was a dry run", func() {
+								Expect(ac.Reconciles).To(HaveLen(1))
+								opts := &helmclient.ReconcileOptions{}
+								for _, o := range ac.Reconciles[0].Opts {
+									Expect(o(opts)).To(Succeed())
+								}
+								Expect(opts.DryRun).To(BeTrue())
+							})
+
+							By("verifying the Drifted condition", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeDrifted)
+								Expect(c).NotTo(BeNil())
+								Expect(c.IsTrue()).To(BeTrue())
+								Expect(c.Reason).To(Equal(conditions.ReasonDriftDetected))
+								Expect(c.Message).To(Equal("Deployment default/test: spec.replicas"))
+							})
+						})
+					})
+					When("drift is corrected with server-side apply", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								return &release.Release{Name: "test", Version: 1, Manifest: "version: 1"}, nil
+							}
+							ac.HandleUpgrade = func() (*release.Release, error) {
+								return &release.Release{Name: "test", Version: 1, Manifest: "version: 1"}, nil
+							}
+							ac.HandleReconcile = func() ([]helmclient.Drift, error) {
+								return []helmclient.Drift{{
+									GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+									Namespace:        "default",
+									Name:             "test",
+									Fields:           []string{"spec.replicas"},
+									Conflicts:        []string{`spec.replicas: conflict with "kube-controller-manager" using apps/v1`},
+								}}, nil
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.driftStrategy = DriftStrategyApply
+							r.fieldManager = "my-operator"
+						})
+						It("reports conflicts with other field managers", func() {
+							By("successfully reconciling a request", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+							})
+
+							By("verifying the reconcile used server-side apply", func() {
+								Expect(ac.Reconciles).To(HaveLen(1))
+								opts := &helmclient.ReconcileOptions{}
+								for _, o := range ac.Reconciles[0].Opts {
+									Expect(o(opts)).To(Succeed())
+								}
+								Expect(opts.DryRun).To(BeFalse())
+								Expect(opts.ServerSideApply).To(BeTrue())
+								Expect(opts.FieldManager).To(Equal("my-operator"))
+							})
+
+							By("verifying the Drifted condition", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeDrifted)
+								Expect(c).NotTo(BeNil())
+								Expect(c.IsTrue()).To(BeTrue())
+								Expect(c.Reason).To(Equal(conditions.ReasonDriftConflict))
+								Expect(c.Message).To(Equal(`Deployment default/test: spec.replicas (conflicts: spec.replicas: conflict with "kube-controller-manager" using apps/v1)`))
+							})
+						})
+					})
+					When("reconciliation is paused", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) {
+								return &release.Release{Name: "test", Version: 1, Manifest: "version: 1"}, nil
+							}
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.pauseAnnotation = annotation.Paused{}
+
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							obj.SetAnnotations(map[string]string{annotation.DefaultPausedName: "true"})
+							Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+						})
+						It("only refreshes the status", func() {
+							By("successfully reconciling a request", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+							})
+
+							By("verifying the release was not changed", func() {
+								Expect(ac.Upgrades).To(BeEmpty())
+								Expect(ac.Reconciles).To(BeEmpty())
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								Expect(objStat.Status.Conditions.IsTrueFor(conditions.TypePaused)).To(BeTrue())
+								Expect(objStat.Status.DeployedRelease.Manifest).To(Equal("version: 1"))
+							})
+						})
+						It("removes the finalizer without uninstalling if configured", func() {
+							r.skipUninstallPaused = true
+
+							By("deleting the CR", func() {
+								Expect(mgr.GetClient().Delete(context.TODO(), obj)).To(Succeed())
+							})
+
+							By("successfully reconciling a request", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(ac.Uninstalls).To(BeEmpty())
+							})
+
+							By("ensuring the finalizer is removed and the CR is deleted", func() {
+								err := mgr.GetAPIReader().Get(context.TODO(), objKey, obj)
+								Expect(apierrors.IsNotFound(err)).To(BeTrue())
+							})
+						})
+					})
+					When("release history is pruned", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							ac = helmfake.NewActionClient()
+							ac.HandlePrune = func() (int, error) { return 3, nil }
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.maxHistory = 5
+						})
+						It("uses the max history of the reconciler", func() {
+							r.pruneHistory(context.TODO())
+							Expect(ac.Prunes).To(Equal([]helmfake.PruneHistoryCall{{Name: obj.GetName(), Max: 5}}))
+							Expect(promtestutil.ToFloat64(r.historyMetric.WithLabelValues(obj.GetNamespace(), obj.GetName()))).To(Equal(3.0))
+						})
+						It("uses the max history of the CR annotation", func() {
+							Expect(WithUpgradeAnnotations(annotation.UpgradeMaxHistory{})(r)).To(Succeed())
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							obj.SetAnnotations(map[string]string{annotation.DefaultUpgradeMaxHistoryName: "2"})
+							Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+							Eventually(func() (map[string]string, error) {
+								err := mgr.GetClient().Get(context.TODO(), objKey, obj)
+								return obj.GetAnnotations(), err
+							}).Should(HaveKey(annotation.DefaultUpgradeMaxHistoryName))
+
+							r.pruneHistory(context.TODO())
+							Expect(ac.Prunes).To(Equal([]helmfake.PruneHistoryCall{{Name: obj.GetName(), Max: 2}}))
+						})
+						It("skips paused CRs", func() {
+							r.paused = true
+							r.pruneHistory(context.TODO())
+							Expect(ac.Prunes).To(BeEmpty())
+						})
+						It("skips CRs without a max history", func() {
+							r.maxHistory = 0
+							r.pruneHistory(context.TODO())
+							Expect(ac.Prunes).To(BeEmpty())
+						})
+					})
+					When("a rollback is requested", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							current := &release.Release{Name: "test", Version: 2, Manifest: "version: 2"}
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) { return current, nil }
+							ac.HandleUpgrade = func() (*release.Release, error) {
+								return &release.Release{Name: "test", Version: 3, Manifest: "version: 3"}, nil
+							}
+							ac.HandleHistory = func() ([]*release.Release, error) {
+								return []*release.Release{{Name: "test", Version: 1}, {Name: "test", Version: 2}}, nil
+							}
+							ac.HandleRollback = func() error {
+								current = &release.Release{Name: "test", Version: 3, Manifest: "version: 1"}
+								return nil
+							}
+							ac.HandleReconcile = func() ([]helmclient.Drift, error) { return nil, nil }
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.rollbackAnnotation = annotation.RollbackToRevision{}
+
+							Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+							obj.SetAnnotations(map[string]string{annotation.DefaultRollbackToRevisionName: "1"})
+							Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+						})
+						It("rolls back once and does not upgrade", func() {
+							By("reconciling twice", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								_, err = r.Reconcile(req)
+								Expect(err).To(BeNil())
+							})
+
+							By("verifying the release was rolled back once and not upgraded", func() {
+								Expect(ac.Rollbacks).To(HaveLen(1))
+								for _, u := range ac.Upgrades {
+									opts := &action.Upgrade{}
+									for _, o := range u.Opts {
+										Expect(o(opts)).To(Succeed())
+									}
+									Expect(opts.DryRun).To(BeTrue())
+								}
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								Expect(objStat.Status.Rollback).NotTo(BeNil())
+								Expect(objStat.Status.Rollback.Revision).To(Equal(1))
+								Expect(objStat.Status.Rollback.ReleaseVersion).To(Equal(3))
+								Expect(objStat.Status.Rollback.Succeeded).To(BeTrue())
+								Expect(objStat.Status.DeployedRelease.Manifest).To(Equal("version: 1"))
+							})
+						})
+						It("records a rollback to an unknown revision as failed", func() {
+							By("requesting an unknown revision", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								obj.SetAnnotations(map[string]string{annotation.DefaultRollbackToRevisionName: "5"})
+								Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+							})
+
+							By("successfully reconciling a request", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(ac.Rollbacks).To(BeEmpty())
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								Expect(objStat.Status.Rollback).NotTo(BeNil())
+								Expect(objStat.Status.Rollback.Succeeded).To(BeFalse())
+								Expect(objStat.Status.Rollback.Message).To(ContainSubstring("revision 5 not found"))
+							})
+						})
+					})
+					When("the render cache is enabled", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							rel := &release.Release{Name: "test", Version: 2}
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) { return rel, nil }
+							ac.HandleUpgrade = func() (*release.Release, error) { return rel, nil }
+							ac.HandleReconcile = func() ([]helmclient.Drift, error) { return nil, nil }
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.renderCache = rendercache.New(time.Hour, nil)
+						})
+						It("skips the dry-run upgrade of unchanged releases", func() {
+							By("rendering the release", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(ac.Upgrades).To(HaveLen(1))
+							})
+
+							By("skipping the render of the unchanged release", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(ac.Upgrades).To(HaveLen(1))
+							})
+
+							By("rendering the release after the custom resource changes", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								obj.SetAnnotations(map[string]string{"touched": "true"})
+								Expect(mgr.GetClient().Update(context.TODO(), obj)).To(Succeed())
+								Eventually(func() int {
+									_, err := r.Reconcile(req)
+									Expect(err).To(BeNil())
+									return len(ac.Upgrades)
+								}).Should(Equal(2))
+							})
+						})
+					})
+					When("waiting for release resources", func() {
+						var ac helmfake.ActionClient
+						BeforeEach(func() {
+							rel := &release.Release{Name: "test", Version: 2, Manifest: unreadyManifest}
+							ac = helmfake.NewActionClient()
+							ac.HandleGet = func() (*release.Release, error) { return rel, nil }
+							ac.HandleUpgrade = func() (*release.Release, error) { return rel, nil }
+							ac.HandleReconcile = func() ([]helmclient.Drift, error) { return nil, nil }
+							ac.HandleRollback = func() error { return nil }
+							r.actionClientGetter = helmfake.NewActionClientGetter(&ac, nil)
+							r.wait = true
+						})
+						It("requeues until the wait deadline", func() {
+							setPendingWait(mgr, objKey, 2, false, time.Now().Add(time.Minute))
+
+							By("successfully reconciling a request", func() {
+								res, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(res.RequeueAfter).To(BeNumerically(">", 0))
+								Expect(res.RequeueAfter).To(BeNumerically("<=", waitPollInterval))
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								Expect(objStat.Status.Conditions.IsFalseFor(conditions.TypeReleaseFailed)).To(BeTrue())
+								Expect(objStat.Status.PendingWait).NotTo(BeNil())
+								Expect(objStat.Status.PendingWait.ReleaseVersion).To(Equal(2))
+							})
+						})
+						It("marks the release failed when the wait times out", func() {
+							setPendingWait(mgr, objKey, 2, false, time.Now().Add(-time.Minute))
+
+							By("successfully reconciling a request", func() {
+								_, err := r.Reconcile(req)
+								Expect(err).To(BeNil())
+								Expect(ac.Rollbacks).To(BeEmpty())
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeReleaseFailed)
+								Expect(c).NotTo(BeNil())
+								Expect(c.IsTrue()).To(BeTrue())
+								Expect(c.Reason).To(Equal(conditions.ReasonUpgradeError))
+								Expect(c.Message).To(ContainSubstring("timed out waiting"))
+								Expect(objStat.Status.PendingWait).NotTo(BeNil())
+							})
+						})
+						It("rolls back an upgrade from version 1 when an atomic wait times out", func() {
+							r.atomic = true
+							setPendingWait(mgr, objKey, 2, false, time.Now().Add(-time.Minute))
+
+							By("returning an error", func() {
+								res, err := r.Reconcile(req)
+								Expect(res).To(Equal(reconcile.Result{}))
+								Expect(err).To(MatchError(ContainSubstring("timed out waiting")))
+								Expect(ac.Rollbacks).To(HaveLen(1))
+								Expect(ac.Uninstalls).To(BeEmpty())
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeReleaseFailed)
+								Expect(c).NotTo(BeNil())
+								Expect(c.IsTrue()).To(BeTrue())
+								Expect(c.Reason).To(Equal(conditions.ReasonUpgradeError))
+								Expect(objStat.Status.PendingWait).To(BeNil())
+							})
+						})
+						It("uninstalls a release that replaced a failed install when an atomic wait times out", func() {
+							r.atomic = true
+							ac.HandleUninstall = func() (*release.UninstallReleaseResponse, error) {
+								return &release.UninstallReleaseResponse{}, nil
+							}
+							setPendingWait(mgr, objKey, 2, true, time.Now().Add(-time.Minute))
+
+							By("returning an error", func() {
+								res, err := r.Reconcile(req)
+								Expect(res).To(Equal(reconcile.Result{}))
+								Expect(err).To(MatchError(ContainSubstring("timed out waiting")))
+								Expect(ac.Uninstalls).To(HaveLen(1))
+								Expect(ac.Rollbacks).To(BeEmpty())
+							})
+
+							By("verifying the CR status", func() {
+								Expect(mgr.GetAPIReader().Get(context.TODO(), objKey, obj)).To(Succeed())
+								objStat := &objStatus{}
+								Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, objStat)).To(Succeed())
+								c := objStat.Status.Conditions.GetCondition(conditions.TypeReleaseFailed)
+								Expect(c).NotTo(BeNil())
+								Expect(c.Reason).To(Equal(conditions.ReasonInstallError))
+								Expect(objStat.Status.PendingWait).To(BeNil())
 							})
 						})
 					})
@@ -1111,9 +2209,169 @@ var _ = Describe("Reconciler", func() {
 	})
 })
 
+var _ = Describe("checkResourceHealth", func() {
+	var (
+		r      *Reconciler
+		deploy schema.GroupVersionKind
+	)
+
+	newDeployment := func(name string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"namespace": "ns", "name": name},
+			"spec":     map[string]interface{}{"replicas": int64(0)},
+		}}
+		u.SetGroupVersionKind(deploy)
+		return u
+	}
+
+	BeforeEach(func() {
+		deploy = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+		r = &Reconciler{
+			healthCheckers: health.DefaultCheckers(),
+			apiReader:      fake.NewFakeClientWithScheme(scheme.Scheme, newDeployment("live")),
+			dependentCache: &testDependentCache{gvk: deploy, objs: []*unstructured.Unstructured{newDeployment("cached")}},
+		}
+	})
+
+	It("should read resources of watched kinds from the dependent cache", func() {
+		res, err := r.checkResourceHealth(context.TODO(), inventory.Object{GroupVersionKind: deploy, Namespace: "ns", Name: "cached"})
+		Expect(err).To(BeNil())
+		Expect(res.Status).To(Equal(health.StatusHealthy))
+	})
+
+	It("should read resources that are missing from the dependent cache from the API server", func() {
+		res, err := r.checkResourceHealth(context.TODO(), inventory.Object{GroupVersionKind: deploy, Namespace: "ns", Name: "live"})
+		Expect(err).To(BeNil())
+		Expect(res.Status).To(Equal(health.StatusHealthy))
+
+		res, err = r.checkResourceHealth(context.TODO(), inventory.Object{GroupVersionKind: deploy, Namespace: "ns", Name: "missing"})
+		Expect(err).To(BeNil())
+		Expect(res).To(Equal(health.Progressing("resource not found")))
+	})
+
+	It("should report missing resources of kinds without a checker as not ready", func() {
+		cm := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+		res, err := r.checkResourceHealth(context.TODO(), inventory.Object{GroupVersionKind: cm, Namespace: "ns", Name: "missing"})
+		Expect(err).To(BeNil())
+		Expect(res).To(Equal(health.Progressing("resource not found")))
+	})
+
+	It("should read resources of unwatched kinds from the API server", func() {
+		r.dependentCache = &testDependentCache{}
+		res, err := r.checkResourceHealth(context.TODO(), inventory.Object{GroupVersionKind: deploy, Namespace: "ns", Name: "live"})
+		Expect(err).To(BeNil())
+		Expect(res.Status).To(Equal(health.StatusHealthy))
+
+		res, err = r.checkResourceHealth(context.TODO(), inventory.Object{GroupVersionKind: deploy, Namespace: "ns", Name: "cached"})
+		Expect(err).To(BeNil())
+		Expect(res).To(Equal(health.Progressing("resource not found")))
+	})
+})
+
+var _ = Describe("release history pruning", func() {
+	var (
+		r   *Reconciler
+		ac  helmfake.ActionClient
+		u   *updater.Updater
+		obj *unstructured.Unstructured
+		rel *release.Release
+	)
+
+	BeforeEach(func() {
+		r = &Reconciler{
+			historyMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_release_history_records"}, []string{"namespace", "name"}),
+		}
+		r.setupAnnotationMaps()
+
+		rel = &release.Release{Name: "test", Namespace: "ns", Version: 2}
+		ac = helmfake.NewActionClient()
+		ac.HandleInstall = func() (*release.Release, error) { return rel, nil }
+		ac.HandleUpgrade = func() (*release.Release, error) { return rel, nil }
+		ac.HandlePrune = func() (int, error) { return 3, nil }
+
+		cl := fake.NewFakeClientWithScheme(scheme.Scheme)
+		nu := updater.New(cl)
+		u = &nu
+		obj = &unstructured.Unstructured{}
+		obj.SetNamespace("ns")
+		obj.SetName("test")
+	})
+
+	It("should not register the pruner unless the history can be limited", func() {
+		Expect(r.canPruneHistory()).To(BeFalse())
+
+		Expect(WithUpgradeAnnotations(annotation.UpgradeMaxHistory{})(r)).To(Succeed())
+		Expect(r.canPruneHistory()).To(BeTrue())
+
+		r.setupAnnotationMaps()
+		r.maxHistory = 5
+		Expect(r.canPruneHistory()).To(BeTrue())
+	})
+
+	It("should prune the history after an installation", func() {
+		r.maxHistory = 5
+		_, err := r.doInstall(&ac, u, obj, nil, "test", "ns", nil, attempt{}, testing.NullLogger{})
+		Expect(err).To(BeNil())
+		Expect(ac.Prunes).To(Equal([]helmfake.PruneHistoryCall{{Name: "test", Max: 5}}))
+		Expect(promtestutil.ToFloat64(r.historyMetric.WithLabelValues("ns", "test"))).To(Equal(3.0))
+	})
+
+	It("should prune the history after an upgrade", func() {
+		r.maxHistory = 5
+		_, err := r.doUpgrade(&ac, u, obj, nil, "test", "ns", nil, attempt{}, testing.NullLogger{})
+		Expect(err).To(BeNil())
+		Expect(ac.Prunes).To(Equal([]helmfake.PruneHistoryCall{{Name: "test", Max: 5}}))
+	})
+
+	It("should not prune the history of releases without a max history", func() {
+		_, err := r.doUpgrade(&ac, u, obj, nil, "test", "ns", nil, attempt{}, testing.NullLogger{})
+		Expect(err).To(BeNil())
+		Expect(ac.Prunes).To(BeEmpty())
+	})
+})
+
+// templateMapper returns a mapper that executes text with the unstructured
+// content of an object, like the release name and namespace templates of
+// watches files.
+func templateMapper(text string) helmclient.ObjectToStringMapper {
+	tmpl := template.Must(template.New("mapper").Option("missingkey=error").Parse(text))
+	return func(obj helmclient.Object) (string, error) {
+		data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+}
+
+// testDependentCache is a dependent cache of the objects objs of kind gvk.
+type testDependentCache struct {
+	gvk  schema.GroupVersionKind
+	objs []*unstructured.Unstructured
+}
+
+func (c *testDependentCache) Get(_ context.Context, key types.NamespacedName, obj runtime.Object) error {
+	for _, o := range c.objs {
+		if o.GetNamespace() == key.Namespace && o.GetName() == key.Name {
+			o.DeepCopyInto(obj.(*unstructured.Unstructured))
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Group: c.gvk.Group, Resource: c.gvk.Kind}, key.Name)
+}
+
+func (c *testDependentCache) Caches(gvk schema.GroupVersionKind) bool {
+	return !c.gvk.Empty() && gvk == c.gvk
+}
+
 func getManagerOrFail() manager.Manager {
 	mgr, err := manager.New(cfg, manager.Options{
 		MetricsBindAddress: "0",
+		NewCache:           helmmanager.NewMetadataCacheFunc(cache.New),
 	})
 	Expect(err).To(BeNil())
 	return mgr
@@ -1121,14 +2379,69 @@ func getManagerOrFail() manager.Manager {
 
 type objStatus struct {
 	Status struct {
-		Conditions      status.Conditions `json:"conditions"`
-		DeployedRelease *struct {
-			Name     string `json:"name"`
-			Manifest string `json:"manifest"`
+		Conditions         status.Conditions `json:"conditions"`
+		ObservedGeneration int64             `json:"observedGeneration"`
+		DeployedRelease    *struct {
+			Name       string `json:"name"`
+			Revision   int    `json:"revision"`
+			Status     string `json:"status"`
+			ChartName  string `json:"chartName"`
+			ValuesHash string `json:"valuesHash"`
+			Manifest   string `json:"manifest"`
 		} `json:"deployedRelease"`
+		ChartVersion string `json:"chartVersion"`
+		Resources    []struct {
+			Kind      string `json:"kind"`
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+		} `json:"resources"`
+		ResourceCount int `json:"resourceCount"`
+		PendingWait   *struct {
+			ReleaseVersion int         `json:"releaseVersion"`
+			Deadline       metav1.Time `json:"deadline"`
+		} `json:"pendingWait"`
+		PermanentError *struct {
+			Reason     string `json:"reason"`
+			Generation int64  `json:"generation"`
+		} `json:"permanentError"`
+		Rollback *struct {
+			Revision       int    `json:"revision"`
+			ReleaseVersion int    `json:"releaseVersion"`
+			Succeeded      bool   `json:"succeeded"`
+			Message        string `json:"message"`
+		} `json:"rollback"`
+		Diagnostics *struct {
+			ReleaseVersion int `json:"releaseVersion"`
+			Hooks          []struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			} `json:"hooks"`
+		} `json:"diagnostics"`
+		Failures int `json:"failures"`
 	} `json:"status"`
 }
 
+// unreadyManifest is a release manifest whose only resource does not exist,
+// so it is never ready.
+const unreadyManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: does-not-exist
+  namespace: default
+`
+
+func setPendingWait(mgr manager.Manager, key types.NamespacedName, version int, install bool, deadline time.Time) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	Expect(mgr.GetAPIReader().Get(context.TODO(), key, u)).To(Succeed())
+	Expect(unstructured.SetNestedField(u.Object, map[string]interface{}{
+		"releaseVersion": int64(version),
+		"deadline":       deadline.UTC().Format(time.RFC3339),
+		"install":        install,
+	}, "status", "pendingWait")).To(Succeed())
+	Expect(mgr.GetClient().Status().Update(context.TODO(), u)).To(Succeed())
+}
+
 func manifestToObjects(manifest string) []runtime.Object {
 	objs := []runtime.Object{}
 	for _, m := range releaseutil.SplitManifests(manifest) {
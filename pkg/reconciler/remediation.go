@@ -0,0 +1,235 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/conditions"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/updater"
+)
+
+// RemediationAction is what is done with a failed release once the retries
+// of its installation or upgrade are exhausted.
+type RemediationAction string
+
+const (
+	// RemediationActionNone leaves the failed release in place.
+	RemediationActionNone RemediationAction = "none"
+
+	// RemediationActionUninstall uninstalls the release.
+	RemediationActionUninstall RemediationAction = "uninstall"
+
+	// RemediationActionRollback uninstalls a failed installation and rolls
+	// back a failed upgrade to the previous release version.
+	RemediationActionRollback RemediationAction = "rollback"
+)
+
+// Remediation configures how failed release installations and upgrades are
+// remediated. Unset fields have their default values.
+type Remediation struct {
+	// Retries is how many times a failed installation or upgrade is retried.
+	// Failures are counted per revision, i.e. per chart version, generation,
+	// annotations, and values of the custom resource, and recorded in
+	// `status.failures`. Once the retries are exhausted, the revision is not
+	// attempted again. Only failed releases, and, when configured with
+	// WithAtomic, releases whose resources did not become ready in time, are
+	// counted, not errors that occur before a release is recorded, such as
+	// API server timeouts. Failures are reset once a release succeeds. If
+	// unset, which is the default, or negative, failures are retried
+	// indefinitely.
+	Retries *int `json:"retries,omitempty"`
+
+	// KeepFailed is whether a failed installation is kept instead of being
+	// uninstalled. A kept installation is replaced when it is retried.
+	KeepFailed *bool `json:"keepFailed,omitempty"`
+
+	// Rollback is whether a failed upgrade is rolled back to the previous
+	// release version. It defaults to true.
+	Rollback *bool `json:"rollback,omitempty"`
+
+	// OnExhausted is what is done with the failed release once the retries
+	// are exhausted. It defaults to "none".
+	OnExhausted RemediationAction `json:"onExhausted,omitempty"`
+}
+
+func (rem Remediation) validate() error {
+	switch rem.OnExhausted {
+	case "", RemediationActionNone, RemediationActionUninstall, RemediationActionRollback:
+		return nil
+	}
+	return fmt.Errorf("unsupported remediation action %q", rem.OnExhausted)
+}
+
+// merge returns rem with the fields that are set in override replaced.
+func (rem Remediation) merge(override Remediation) Remediation {
+	if override.Retries != nil {
+		rem.Retries = override.Retries
+	}
+	if override.KeepFailed != nil {
+		rem.KeepFailed = override.KeepFailed
+	}
+	if override.Rollback != nil {
+		rem.Rollback = override.Rollback
+	}
+	if override.OnExhausted != "" {
+		rem.OnExhausted = override.OnExhausted
+	}
+	return rem
+}
+
+// exhausted returns true if no retries are left after failures failed
+// attempts.
+func (rem Remediation) exhausted(failures int) bool {
+	return rem.Retries != nil && *rem.Retries >= 0 && failures > *rem.Retries
+}
+
+// action returns what is done with a failed installation (install is true) or
+// upgrade after failures failed attempts.
+func (rem Remediation) action(install bool, failures int) RemediationAction {
+	if rem.exhausted(failures) && rem.OnExhausted != "" && rem.OnExhausted != RemediationActionNone {
+		return rem.OnExhausted
+	}
+	if install && (rem.KeepFailed == nil || !*rem.KeepFailed) {
+		return RemediationActionUninstall
+	}
+	if !install && (rem.Rollback == nil || *rem.Rollback) {
+		return RemediationActionRollback
+	}
+	return RemediationActionNone
+}
+
+// remediationFor returns the remediation of obj: the remediation configured
+// with WithRemediation, overridden by the fields set in the spec field
+// configured with WithRemediationField.
+func (r *Reconciler) remediationFor(obj *unstructured.Unstructured) (Remediation, error) {
+	if r.remediationField == "" {
+		return r.remediation, nil
+	}
+	v, ok, err := unstructured.NestedFieldNoCopy(obj.Object, "spec", r.remediationField)
+	if err != nil || !ok || v == nil {
+		return r.remediation, err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Remediation{}, err
+	}
+	var override Remediation
+	if err := json.Unmarshal(data, &override); err != nil {
+		return Remediation{}, fmt.Errorf("field spec.%s: %w", r.remediationField, err)
+	}
+	if err := override.validate(); err != nil {
+		return Remediation{}, fmt.Errorf("field spec.%s: %w", r.remediationField, err)
+	}
+	return r.remediation.merge(override), nil
+}
+
+// attempt is an installation or upgrade of a release.
+type attempt struct {
+	revision    *updater.Revision
+	remediation Remediation
+
+	// replace is whether a failed installation that was kept is replaced.
+	replace bool
+}
+
+// handleFailure counts the failed installation (install is true) or upgrade
+// a in the status and remediates the failed release of err according to the
+// remediation of a. Errors without a failed release are neither counted nor
+// remediated, since they are usually transient. It returns err, annotated
+// with the error of a failed remediation.
+func (r *Reconciler) handleFailure(actionClient helmclient.ActionInterface, u *updater.Updater, obj *unstructured.Unstructured, a attempt, install bool, err error, log logr.Logger) error {
+	var failedErr *helmclient.FailedReleaseError
+	if !errors.As(err, &failedErr) || failedErr.Release == nil {
+		return err
+	}
+	rel := failedErr.Release
+
+	failures := countFailure(u, obj, a)
+	remAction := a.remediation.action(install, failures)
+	if a.remediation.exhausted(failures) {
+		log.Info("Retries of failed release exhausted", "name", rel.Name, "version", rel.Version, "failures", failures, "action", remAction)
+	}
+
+	switch {
+	case remAction == RemediationActionUninstall, remAction == RemediationActionRollback && install:
+		// Releases that failed to render are not recorded, so there may be
+		// nothing to uninstall.
+		_, uninstallErr := actionClient.Uninstall(rel.Name)
+		if uninstallErr != nil && !errors.Is(uninstallErr, driver.ErrReleaseNotFound) {
+			return fmt.Errorf("uninstall failed: %v: original error: %w", uninstallErr, err)
+		}
+		if !install {
+			u.UpdateStatus(
+				updater.EnsureCondition(conditions.Deployed(corev1.ConditionFalse, conditions.ReasonUninstallSuccessful, "")),
+				updater.RemoveDeployedRelease(),
+			)
+		}
+	case remAction == RemediationActionRollback:
+		if rollbackErr := actionClient.Rollback(rel.Name, func(rb *action.Rollback) error {
+			rb.Force = true
+			rb.CleanupOnFail = r.cleanupOnFail
+			return nil
+		}); rollbackErr != nil {
+			return fmt.Errorf("rollback failed: %v: original error: %w", rollbackErr, err)
+		}
+	default:
+		log.Info("Keeping failed release", "name", rel.Name, "version", rel.Version)
+	}
+	return err
+}
+
+// countFailure counts the failed attempt a in the status and returns the
+// number of consecutive failed attempts of its revision.
+func countFailure(u *updater.Updater, obj *unstructured.Unstructured, a attempt) int {
+	failures, last := updater.LastFailures(obj)
+	if !reflect.DeepEqual(last, a.revision) {
+		failures = 0
+	}
+	failures++
+	u.UpdateStatus(updater.EnsureFailures(failures, a.revision))
+	return failures
+}
+
+// RemediationSchema returns the OpenAPI schema of the remediation field
+// configured with WithRemediationField.
+func RemediationSchema() apiextv1.JSONSchemaProps {
+	return apiextv1.JSONSchemaProps{
+		Type:        "object",
+		Description: "Remediation of failed installations and upgrades of the release.",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"retries":    {Type: "integer"},
+			"keepFailed": {Type: "boolean"},
+			"rollback":   {Type: "boolean"},
+			"onExhausted": {
+				Type: "string",
+				Enum: []apiextv1.JSON{{Raw: []byte(`"none"`)}, {Raw: []byte(`"uninstall"`)}, {Raw: []byte(`"rollback"`)}},
+			},
+		},
+	}
+}
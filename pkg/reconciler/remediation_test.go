@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	helmfake "github.com/joelanford/helm-operator/pkg/reconciler/internal/fake"
+	"github.com/joelanford/helm-operator/pkg/reconciler/internal/updater"
+)
+
+var _ = Describe("Remediation", func() {
+	intPtr := func(i int) *int { return &i }
+	boolPtr := func(b bool) *bool { return &b }
+
+	It("should uninstall failed installs and roll back failed upgrades by default", func() {
+		rem := Remediation{}
+		Expect(rem.action(true, 1)).To(Equal(RemediationActionUninstall))
+		Expect(rem.action(false, 1)).To(Equal(RemediationActionRollback))
+		Expect(rem.exhausted(100)).To(BeFalse())
+	})
+
+	It("should keep failed releases", func() {
+		rem := Remediation{KeepFailed: boolPtr(true), Rollback: boolPtr(false)}
+		Expect(rem.action(true, 1)).To(Equal(RemediationActionNone))
+		Expect(rem.action(false, 1)).To(Equal(RemediationActionNone))
+	})
+
+	It("should apply the exhausted action once the retries are exhausted", func() {
+		rem := Remediation{Retries: intPtr(1), KeepFailed: boolPtr(true), OnExhausted: RemediationActionRollback}
+		Expect(rem.exhausted(1)).To(BeFalse())
+		Expect(rem.action(true, 1)).To(Equal(RemediationActionNone))
+		Expect(rem.exhausted(2)).To(BeTrue())
+		Expect(rem.action(true, 2)).To(Equal(RemediationActionRollback))
+	})
+
+	It("should merge overrides", func() {
+		rem := Remediation{Retries: intPtr(3), Rollback: boolPtr(false)}.merge(Remediation{Retries: intPtr(1), OnExhausted: RemediationActionUninstall})
+		Expect(rem).To(Equal(Remediation{Retries: intPtr(1), Rollback: boolPtr(false), OnExhausted: RemediationActionUninstall}))
+	})
+
+	It("should reject unsupported actions", func() {
+		Expect(Remediation{OnExhausted: "delete"}.validate()).To(MatchError(ContainSubstring(`"delete"`)))
+	})
+})
+
+var _ = Describe("remediationFor", func() {
+	var r *Reconciler
+
+	BeforeEach(func() {
+		retries := 3
+		r = &Reconciler{remediation: Remediation{Retries: &retries}, remediationField: "remediation"}
+	})
+
+	It("should override the remediation with the spec field", func() {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"remediation": map[string]interface{}{"keepFailed": true}},
+		}}
+		rem, err := r.remediationFor(obj)
+		Expect(err).To(BeNil())
+		Expect(*rem.Retries).To(Equal(3))
+		Expect(*rem.KeepFailed).To(BeTrue())
+	})
+
+	It("should return the remediation without the spec field", func() {
+		rem, err := r.remediationFor(&unstructured.Unstructured{Object: map[string]interface{}{}})
+		Expect(err).To(BeNil())
+		Expect(rem).To(Equal(r.remediation))
+	})
+
+	It("should fail for invalid spec fields", func() {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"remediation": map[string]interface{}{"onExhausted": "delete"}},
+		}}
+		_, err := r.remediationFor(obj)
+		Expect(err).To(MatchError(ContainSubstring("spec.remediation")))
+	})
+})
+
+var _ = Describe("handleFailure", func() {
+	var (
+		r   *Reconciler
+		ac  helmfake.ActionClient
+		cl  client.Client
+		u   updater.Updater
+		obj *unstructured.Unstructured
+		a   attempt
+		err error
+	)
+
+	BeforeEach(func() {
+		r = &Reconciler{}
+		ac = helmfake.NewActionClient()
+		ac.HandleUninstall = func() (*release.UninstallReleaseResponse, error) { return nil, driver.ErrReleaseNotFound }
+		ac.HandleRollback = func() error { return nil }
+		cl = fake.NewFakeClientWithScheme(scheme.Scheme)
+		u = updater.New(cl)
+		obj = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "test", "namespace": "test"},
+		}}
+		Expect(cl.Create(context.TODO(), obj)).To(Succeed())
+		a = attempt{revision: &updater.Revision{ChartVersion: "0.1.0", Generation: 1, Checksum: "sha256:0"}}
+		err = &helmclient.FailedReleaseError{Release: &release.Release{Name: "test", Version: 2}, Err: errors.New("upgrade failed")}
+	})
+
+	It("should uninstall failed installs", func() {
+		Expect(r.handleFailure(&ac, &u, obj, a, true, err, log.Log)).To(Equal(err))
+		Expect(ac.Uninstalls).To(HaveLen(1))
+		Expect(ac.Rollbacks).To(BeEmpty())
+	})
+
+	It("should roll back failed upgrades", func() {
+		Expect(r.handleFailure(&ac, &u, obj, a, false, err, log.Log)).To(Equal(err))
+		Expect(ac.Uninstalls).To(BeEmpty())
+		Expect(ac.Rollbacks).To(HaveLen(1))
+	})
+
+	It("should keep failed releases", func() {
+		keep, rollback := true, false
+		a.remediation = Remediation{KeepFailed: &keep, Rollback: &rollback}
+		Expect(r.handleFailure(&ac, &u, obj, a, false, err, log.Log)).To(Equal(err))
+		Expect(ac.Uninstalls).To(BeEmpty())
+		Expect(ac.Rollbacks).To(BeEmpty())
+	})
+
+	It("should uninstall failed upgrades once the retries are exhausted", func() {
+		retries, rollback := 1, false
+		a.remediation = Remediation{Retries: &retries, Rollback: &rollback, OnExhausted: RemediationActionUninstall}
+		obj.Object["status"] = map[string]interface{}{
+			"failures": int64(1),
+			"lastAttemptedRevision": map[string]interface{}{
+				"chartVersion": "0.1.0",
+				"generation":   int64(1),
+				"checksum":     "sha256:0",
+			},
+		}
+		Expect(r.handleFailure(&ac, &u, obj, a, false, err, log.Log)).To(Equal(err))
+		Expect(ac.Uninstalls).To(HaveLen(1))
+		Expect(ac.Rollbacks).To(BeEmpty())
+	})
+
+	It("should report failed rollbacks", func() {
+		ac.HandleRollback = func() error { return errors.New("rollback error") }
+		Expect(r.handleFailure(&ac, &u, obj, a, false, err, log.Log)).To(MatchError("rollback failed: rollback error: original error: upgrade failed"))
+	})
+
+	It("should count failed releases", func() {
+		Expect(r.handleFailure(&ac, &u, obj, a, false, err, log.Log)).To(Equal(err))
+		Expect(u.Apply(context.TODO(), obj)).To(Succeed())
+		failures, revision := updater.LastFailures(obj)
+		Expect(failures).To(Equal(1))
+		Expect(revision).To(Equal(a.revision))
+	})
+
+	It("should neither count nor remediate errors without a failed release", func() {
+		err = errors.New("install failed")
+		Expect(r.handleFailure(&ac, &u, obj, a, true, err, log.Log)).To(Equal(err))
+		Expect(ac.Uninstalls).To(BeEmpty())
+		Expect(u.Apply(context.TODO(), obj)).To(Succeed())
+		failures, _ := updater.LastFailures(obj)
+		Expect(failures).To(BeZero())
+	})
+})
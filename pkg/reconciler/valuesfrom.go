@@ -0,0 +1,234 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ValuesFromKindSecret references values in a Secret.
+	ValuesFromKindSecret = "Secret"
+
+	// ValuesFromKindConfigMap references values in a ConfigMap.
+	ValuesFromKindConfigMap = "ConfigMap"
+
+	// DefaultValuesKey is the key of a values reference without a key.
+	DefaultValuesKey = "values.yaml"
+)
+
+// ValuesReference references chart values in a Secret or ConfigMap in the
+// namespace of the custom resource.
+type ValuesReference struct {
+	// Kind is either "Secret" or "ConfigMap".
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+
+	// Key is the key of the values in the object. It defaults to
+	// "values.yaml".
+	Key string `json:"key,omitempty"`
+
+	// TargetPath is a dot-separated path, e.g. "database.password", at which
+	// the value of the key is set as a string. If it is empty, the value of
+	// the key is parsed as a YAML values file.
+	TargetPath string `json:"targetPath,omitempty"`
+
+	// Optional references are ignored if their object or key does not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// valuesReferences returns the values references of obj.
+func (r *Reconciler) valuesReferences(obj *unstructured.Unstructured) ([]ValuesReference, error) {
+	if r.valuesFromField == "" {
+		return nil, nil
+	}
+	v, ok, err := unstructured.NestedFieldNoCopy(obj.Object, "spec", r.valuesFromField)
+	if err != nil || !ok || v == nil {
+		return nil, err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var refs []ValuesReference
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("field spec.%s: %w", r.valuesFromField, err)
+	}
+	for i, ref := range refs {
+		if ref.Kind != ValuesFromKindSecret && ref.Kind != ValuesFromKindConfigMap {
+			return nil, fmt.Errorf("field spec.%s[%d]: unsupported kind %q", r.valuesFromField, i, ref.Kind)
+		}
+		if ref.Name == "" {
+			return nil, fmt.Errorf("field spec.%s[%d]: name must not be empty", r.valuesFromField, i)
+		}
+	}
+	return refs, nil
+}
+
+// getValuesFrom returns the values referenced by obj. Values of later
+// references take precedence over values of earlier references.
+func (r *Reconciler) getValuesFrom(ctx context.Context, obj *unstructured.Unstructured) (map[string]interface{}, error) {
+	refs, err := r.valuesReferences(obj)
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+	if obj.GetNamespace() == "" {
+		return nil, errors.New("values can only be referenced by namespaced custom resources")
+	}
+
+	vals := map[string]interface{}{}
+	for _, ref := range refs {
+		refVals, err := r.getReferencedValues(ctx, obj.GetNamespace(), ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s/%s: %w", ref.Kind, obj.GetNamespace(), ref.Name, err)
+		}
+		vals = chartutil.CoalesceTables(refVals, vals)
+	}
+	return vals, nil
+}
+
+func (r *Reconciler) getReferencedValues(ctx context.Context, namespace string, ref ValuesReference) (map[string]interface{}, error) {
+	key := ref.Key
+	if key == "" {
+		key = DefaultValuesKey
+	}
+
+	var (
+		data  []byte
+		found bool
+		err   error
+	)
+	objKey := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+	switch ref.Kind {
+	case ValuesFromKindSecret:
+		secret := &corev1.Secret{}
+		if err = r.client.Get(ctx, objKey, secret); err == nil {
+			data, found = secret.Data[key]
+		}
+	case ValuesFromKindConfigMap:
+		cm := &corev1.ConfigMap{}
+		if err = r.client.Get(ctx, objKey, cm); err == nil {
+			var s string
+			if s, found = cm.Data[key]; found {
+				data = []byte(s)
+			} else {
+				data, found = cm.BinaryData[key]
+			}
+		}
+	}
+	if apierrors.IsNotFound(err) && ref.Optional {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if ref.Optional {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+
+	if ref.TargetPath == "" {
+		vals, err := chartutil.ReadValues(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key, err)
+		}
+		return vals, nil
+	}
+	path := strings.Split(ref.TargetPath, ".")
+	for _, p := range path {
+		if p == "" {
+			return nil, fmt.Errorf("invalid target path %q", ref.TargetPath)
+		}
+	}
+	vals := map[string]interface{}{}
+	if err := unstructured.SetNestedField(vals, string(data), path...); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// valuesFromRequests returns a handler.ToRequestsFunc that maps a Secret or
+// ConfigMap to requests for the custom resources that reference its values.
+func (r *Reconciler) valuesFromRequests(kind string) handler.ToRequestsFunc {
+	return func(o handler.MapObject) []reconcile.Request {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(r.gvk.GroupVersion().WithKind(r.gvk.Kind + "List"))
+		if err := r.client.List(context.TODO(), list, client.InNamespace(o.Meta.GetNamespace())); err != nil {
+			r.log.Error(err, "Failed to list custom resources referencing values", "kind", kind, "name", o.Meta.GetName())
+			return nil
+		}
+
+		var reqs []reconcile.Request
+		for i := range list.Items {
+			obj := &list.Items[i]
+			refs, err := r.valuesReferences(obj)
+			if err != nil {
+				continue
+			}
+			for _, ref := range refs {
+				if ref.Kind == kind && ref.Name == o.Meta.GetName() {
+					reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{
+						Namespace: obj.GetNamespace(),
+						Name:      obj.GetName(),
+					}})
+					break
+				}
+			}
+		}
+		return reqs
+	}
+}
+
+// ValuesReferencesSchema returns the OpenAPI schema of the values from field
+// configured with WithValuesFromField.
+func ValuesReferencesSchema() apiextv1.JSONSchemaProps {
+	str := apiextv1.JSONSchemaProps{Type: "string"}
+	return apiextv1.JSONSchemaProps{
+		Type:        "array",
+		Description: "References to chart values in Secrets and ConfigMaps.",
+		Items: &apiextv1.JSONSchemaPropsOrArray{Schema: &apiextv1.JSONSchemaProps{
+			Type:     "object",
+			Required: []string{"kind", "name"},
+			Properties: map[string]apiextv1.JSONSchemaProps{
+				"kind": {
+					Type: "string",
+					Enum: []apiextv1.JSON{{Raw: []byte(`"Secret"`)}, {Raw: []byte(`"ConfigMap"`)}},
+				},
+				"name":       str,
+				"key":        str,
+				"targetPath": str,
+				"optional":   {Type: "boolean"},
+			},
+		}},
+	}
+}
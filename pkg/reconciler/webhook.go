@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/joelanford/helm-operator/pkg/values"
+)
+
+// WebhookPath returns the path at which the validating admission webhook for
+// CRs of gvk is served, e.g. "/validate-example-com-v1-myapp".
+func WebhookPath(gvk schema.GroupVersionKind) string {
+	return fmt.Sprintf("/validate-%s-%s-%s", strings.Replace(gvk.Group, ".", "-", -1), gvk.Version, strings.ToLower(gvk.Kind))
+}
+
+// valuesValidator is a validating admission webhook that rejects CRs whose
+// values do not match the values schema of their chart.
+type valuesValidator struct {
+	r *Reconciler
+}
+
+var _ admission.Handler = &valuesValidator{}
+
+func (v *valuesValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(req.Object.Raw); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if obj.GetDeletionTimestamp() != nil {
+		return admission.Allowed("")
+	}
+
+	chrt, err := v.r.selectChart(obj)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	vals, err := v.r.getValues(ctx, obj, chrt)
+	if err != nil {
+		// Referenced values may not exist yet. Errors getting values are
+		// reported in the status of the CR instead.
+		return admission.Allowed("")
+	}
+	if err := values.ValidateSchema(chrt, vals); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
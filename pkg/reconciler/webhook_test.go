@@ -0,0 +1,97 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/joelanford/helm-operator/pkg/internal/testutil"
+	internalvalues "github.com/joelanford/helm-operator/pkg/reconciler/internal/values"
+)
+
+var _ = Describe("WebhookPath", func() {
+	It("should return the path for a GVK", func() {
+		gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "MyApp"}
+		Expect(WebhookPath(gvk)).To(Equal("/validate-example-com-v1-myapp"))
+	})
+})
+
+var _ = Describe("valuesValidator", func() {
+	var (
+		v   *valuesValidator
+		obj *unstructured.Unstructured
+	)
+
+	BeforeEach(func() {
+		gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "MyApp"}
+		v = &valuesValidator{r: &Reconciler{
+			gvk: &gvk,
+			chrt: &chart.Chart{
+				Metadata: &chart.Metadata{Name: "test-chart", Version: "0.1.0"},
+				Schema:   []byte(`{"type": "object", "properties": {"replicas": {"type": "integer", "minimum": 1}}}`),
+			},
+			valueMapper: internalvalues.DefaultMapper,
+		}}
+		obj = testutil.BuildTestCR(gvk)
+	})
+
+	handle := func() admission.Response {
+		data, err := json.Marshal(obj.Object)
+		Expect(err).To(BeNil())
+		return v.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Operation: admissionv1beta1.Create,
+			Object:    runtime.RawExtension{Raw: data},
+		}})
+	}
+
+	It("should allow valid values", func() {
+		Expect(handle().Allowed).To(BeTrue())
+	})
+
+	It("should deny values that do not match the schema", func() {
+		Expect(unstructured.SetNestedField(obj.Object, int64(0), "spec", "replicas")).To(Succeed())
+		resp := handle()
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(string(resp.Result.Reason)).To(ContainSubstring("replicas: "))
+	})
+
+	It("should deny chart versions that are not allowed", func() {
+		v.r.chartVersionField = "chartVersion"
+		Expect(unstructured.SetNestedField(obj.Object, "~0.2", "spec", "chartVersion")).To(Succeed())
+		resp := handle()
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(string(resp.Result.Reason)).To(ContainSubstring("no allowed chart version"))
+	})
+
+	It("should fail with an invalid object", func() {
+		resp := v.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: []byte("{")},
+		}})
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Code).To(BeEquivalentTo(400))
+	})
+})
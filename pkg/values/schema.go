@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/copystructure"
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// rootPath is the path gojsonschema uses for the root of the values.
+const rootPath = "(root)"
+
+// SchemaError is a value that does not match a chart's values schema.
+type SchemaError struct {
+	// Path is the dot-separated path of the value, e.g. "image.tag", or
+	// "(root)" for the top-level values.
+	Path        string
+	Description string
+}
+
+// SchemaErrors are the values that do not match a chart's values schema.
+type SchemaErrors []SchemaError
+
+func (e SchemaErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, se := range e {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", se.Path, se.Description))
+	}
+	return "values do not match the chart's schema: " + strings.Join(msgs, "; ")
+}
+
+// ValidateSchema validates vals against the values schema
+// (values.schema.json) of chrt and the schemas of its dependencies. vals must
+// be coalesced with the chart's default values, as Helm does before
+// rendering. Like Helm, ValidateSchema skips dependencies that are disabled by
+// their condition or tags in vals. chrt is not modified. If any values do not
+// match, ValidateSchema returns SchemaErrors.
+func ValidateSchema(chrt *chart.Chart, vals map[string]interface{}) error {
+	processed, err := copyChart(chrt)
+	if err != nil {
+		return err
+	}
+	if err := chartutil.ProcessDependencies(processed, vals); err != nil {
+		return fmt.Errorf("chart %q: process dependencies: %w", chrt.Name(), err)
+	}
+	errs, err := validateSchema(processed, vals, "")
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateSchema(chrt *chart.Chart, vals map[string]interface{}, prefix string) (SchemaErrors, error) {
+	var errs SchemaErrors
+	if len(chrt.Schema) > 0 {
+		if vals == nil {
+			vals = map[string]interface{}{}
+		}
+		data, err := json.Marshal(vals)
+		if err != nil {
+			return nil, err
+		}
+		result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(chrt.Schema), gojsonschema.NewBytesLoader(data))
+		if err != nil {
+			return nil, fmt.Errorf("chart %q: invalid values schema: %w", chrt.Name(), err)
+		}
+		for _, re := range result.Errors() {
+			errs = append(errs, SchemaError{Path: joinPath(prefix, re.Field()), Description: re.Description()})
+		}
+	}
+
+	for _, dep := range chrt.Dependencies() {
+		depVals, _ := vals[dep.Name()].(map[string]interface{})
+		depErrs, err := validateSchema(dep, depVals, joinPath(prefix, dep.Name()))
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, depErrs...)
+	}
+	return errs, nil
+}
+
+// copyChart returns a copy of chrt and its dependencies that
+// chartutil.ProcessDependencies can modify without changing chrt.
+func copyChart(chrt *chart.Chart) (*chart.Chart, error) {
+	out := *chrt
+	md := *chrt.Metadata
+	md.Dependencies = nil
+	for _, d := range chrt.Metadata.Dependencies {
+		dep := *d
+		md.Dependencies = append(md.Dependencies, &dep)
+	}
+	out.Metadata = &md
+
+	vals, err := copystructure.Copy(chrt.Values)
+	if err != nil {
+		return nil, err
+	}
+	out.Values, _ = vals.(map[string]interface{})
+
+	var deps []*chart.Chart
+	for _, d := range chrt.Dependencies() {
+		dep, err := copyChart(d)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	out.SetDependencies(deps...)
+	return &out, nil
+}
+
+func joinPath(prefix, field string) string {
+	switch {
+	case prefix == "":
+		return field
+	case field == rootPath:
+		return prefix
+	default:
+		return prefix + "." + field
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+
+	. "github.com/joelanford/helm-operator/pkg/values"
+)
+
+var _ = Describe("ValidateSchema", func() {
+	var chrt *chart.Chart
+
+	BeforeEach(func() {
+		chrt = &chart.Chart{
+			Metadata: &chart.Metadata{Name: "parent"},
+			Schema: []byte(`{
+				"type": "object",
+				"required": ["image"],
+				"properties": {
+					"replicas": {"type": "integer", "minimum": 1},
+					"image": {
+						"type": "object",
+						"properties": {"tag": {"type": "string"}}
+					}
+				}
+			}`),
+		}
+		sub := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "sub"},
+			Schema:   []byte(`{"type": "object", "required": ["enabled"]}`),
+		}
+		chrt.SetDependencies(sub)
+	})
+
+	It("should succeed with valid values", func() {
+		vals := map[string]interface{}{
+			"replicas": 2,
+			"image":    map[string]interface{}{"tag": "1.0.0"},
+			"sub":      map[string]interface{}{"enabled": true},
+		}
+		Expect(ValidateSchema(chrt, vals)).To(Succeed())
+	})
+
+	It("should succeed without a schema", func() {
+		Expect(ValidateSchema(&chart.Chart{Metadata: &chart.Metadata{Name: "test"}}, nil)).To(Succeed())
+	})
+
+	It("should report each invalid path", func() {
+		vals := map[string]interface{}{
+			"replicas": 0,
+			"image":    map[string]interface{}{"tag": 1},
+		}
+		err := ValidateSchema(chrt, vals)
+		Expect(err).To(HaveOccurred())
+
+		errs, ok := err.(SchemaErrors)
+		Expect(ok).To(BeTrue())
+		paths := []string{}
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		Expect(paths).To(ConsistOf("replicas", "image.tag", "sub"))
+		Expect(err.Error()).To(ContainSubstring("image.tag: "))
+	})
+
+	It("should report missing required values", func() {
+		vals := map[string]interface{}{"sub": map[string]interface{}{"enabled": false}}
+		err := ValidateSchema(chrt, vals)
+		Expect(err).To(Equal(SchemaErrors{{Path: "(root)", Description: "image is required"}}))
+	})
+
+	It("should skip dependencies that are disabled by their condition", func() {
+		chrt.Metadata.Dependencies = []*chart.Dependency{{Name: "sub", Condition: "sub.enabled"}}
+		vals := map[string]interface{}{
+			"image": map[string]interface{}{},
+			"sub":   map[string]interface{}{"enabled": false},
+		}
+		chrt.Dependencies()[0].Schema = []byte(`{"type": "object", "required": ["password"]}`)
+		Expect(ValidateSchema(chrt, vals)).To(Succeed())
+		Expect(chrt.Dependencies()).To(HaveLen(1))
+		Expect(chrt.Metadata.Dependencies).To(HaveLen(1))
+
+		vals["sub"] = map[string]interface{}{"enabled": true}
+		Expect(ValidateSchema(chrt, vals)).To(Equal(SchemaErrors{{Path: "sub", Description: "password is required"}}))
+	})
+
+	It("should skip dependencies that are disabled by tags", func() {
+		chrt.Metadata.Dependencies = []*chart.Dependency{{Name: "sub", Tags: []string{"extras"}}}
+		vals := map[string]interface{}{
+			"image": map[string]interface{}{},
+			"tags":  map[string]interface{}{"extras": false},
+		}
+		Expect(ValidateSchema(chrt, vals)).To(Succeed())
+	})
+
+	It("should fail with an invalid schema", func() {
+		chrt.Schema = []byte(`{`)
+		err := ValidateSchema(chrt, map[string]interface{}{})
+		Expect(err).To(HaveOccurred())
+		_, ok := err.(SchemaErrors)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -15,35 +15,225 @@
 package watches
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"text/template"
 
+	"github.com/Masterminds/sprig/v3"
 	"helm.sh/helm/v3/pkg/chart"
-	"helm.sh/helm/v3/pkg/chart/loader"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
+
+	"github.com/joelanford/helm-operator/pkg/chartloader"
+	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/diff"
 )
 
 type Watch struct {
 	schema.GroupVersionKind `json:",inline"`
-	ChartPath               string `json:"chart"`
+	ChartSource             `json:",inline"`
+
+	// AdditionalCharts are other versions of the chart. A custom resource
+	// can select the version of its chart with a semver constraint such as
+	// "~1.2" in the chart version annotation or, if ChartVersionField is
+	// set, in that spec field. Only the versions of the chart and the
+	// additional charts are allowed; the chart is used by default.
+	AdditionalCharts  []ChartSource `json:"additionalCharts,omitempty"`
+	ChartVersionField string        `json:"chartVersionField,omitempty"`
 
 	WatchDependentResources *bool             `json:"watchDependentResources,omitempty"`
 	OverrideValues          map[string]string `json:"overrideValues,omitempty"`
-	ReconcilePeriod         *metav1.Duration  `json:"reconcilePeriod,omitempty"`
-	MaxConcurrentReconciles *int              `json:"maxConcurrentReconciles,omitempty"`
 
-	Chart *chart.Chart `json:"-"`
+	// ValuesFromField is a spec field in which custom resources reference
+	// chart values in Secrets and ConfigMaps, e.g. "valuesFrom". See
+	// reconciler.ValuesReference.
+	ValuesFromField string `json:"valuesFromField,omitempty"`
+
+	// ValidatingWebhook is whether the operator serves a validating admission
+	// webhook that rejects custom resources whose values do not match the
+	// chart's values schema. See reconciler.WithValidatingWebhook.
+	ValidatingWebhook *bool `json:"validatingWebhook,omitempty"`
+
+	ReconcilePeriod         *metav1.Duration `json:"reconcilePeriod,omitempty"`
+	MaxConcurrentReconciles *int             `json:"maxConcurrentReconciles,omitempty"`
+
+	// MaxHistory is the maximum number of release records kept for each
+	// release. 0 means no limit.
+	MaxHistory *int `json:"maxHistory,omitempty"`
+
+	// RenderResyncInterval is the interval after which unchanged releases
+	// are rendered again even though their render fingerprint is unchanged.
+	// 0 disables the render cache. See reconciler.WithRenderResyncInterval.
+	RenderResyncInterval *metav1.Duration `json:"renderResyncInterval,omitempty"`
+
+	// ManifestMode is how the manifest of the deployed release is recorded
+	// in the status: "full" (the default), "omit", or "compress".
+	ManifestMode string `json:"manifestMode,omitempty"`
+
+	// DriftMode is how release resources that have drifted from the release
+	// manifest are handled: "correct" (the default), "report-only", or
+	// "ignore".
+	DriftMode string `json:"driftMode,omitempty"`
+
+	// DriftStrategy is how drift is corrected: "patch" (the default) or
+	// "apply" for server-side apply with FieldManager, which defaults to
+	// "helm-operator". See reconciler.WithDriftStrategy.
+	DriftStrategy string `json:"driftStrategy,omitempty"`
+	FieldManager  string `json:"fieldManager,omitempty"`
+
+	// IgnoreDifferences are rules for fields of release resources that may
+	// differ from the release manifest. See reconciler.WithIgnoreDifferences.
+	IgnoreDifferences diff.IgnoreRules `json:"ignoreDifferences,omitempty"`
+
+	// Wait, WaitTimeout, Atomic and CleanupOnFail correspond to the Helm
+	// flags of the same names. Waits do not block the reconciler; see
+	// reconciler.WithWait. WaitTimeout must be positive if Wait or Atomic is
+	// set, and is ignored otherwise.
+	Wait          *bool            `json:"wait,omitempty"`
+	WaitTimeout   *metav1.Duration `json:"waitTimeout,omitempty"`
+	Atomic        *bool            `json:"atomic,omitempty"`
+	CleanupOnFail *bool            `json:"cleanupOnFail,omitempty"`
+
+	// Remediation configures how failed installations and upgrades are
+	// remediated. Custom resources can override its fields in the spec field
+	// RemediationField. See reconciler.WithRemediation.
+	Remediation      *Remediation `json:"remediation,omitempty"`
+	RemediationField string       `json:"remediationField,omitempty"`
+
+	// Paused is whether reconciliation is paused for custom resources that
+	// do not set the paused annotation. UninstallWhilePaused is whether the
+	// releases of paused custom resources are uninstalled when they are
+	// deleted; it defaults to true.
+	Paused               *bool `json:"paused,omitempty"`
+	UninstallWhilePaused *bool `json:"uninstallWhilePaused,omitempty"`
+
+	// ReleaseName and TargetNamespace are Go templates that are executed
+	// with the custom resource (e.g. `{{ .metadata.name }}-{{ .spec.tier }}`)
+	// to determine the name and namespace of its release. Sprig functions are
+	// available in both templates.
+	ReleaseName     string `json:"releaseName,omitempty"`
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// Storage configures where the records of releases are stored. By
+	// default, they are stored in Secrets in the release namespace.
+	Storage *Storage `json:"storage,omitempty"`
+
+	Chart               *chart.Chart                    `json:"-"`
+	ChartVersions       []*chart.Chart                  `json:"-"`
+	ReleaseNameFunc     helmclient.ObjectToStringMapper `json:"-"`
+	TargetNamespaceFunc helmclient.ObjectToStringMapper `json:"-"`
+	StorageDriverGetter helmclient.StorageDriverGetter  `json:"-"`
+}
+
+// ChartSource references a chart.
+type ChartSource struct {
+	// ChartPath is the path of a local chart, or an OCI reference such as
+	// `oci://registry.example.com/charts/nginx:1.2.3`.
+	ChartPath string `json:"chart"`
+
+	// Repo references a chart in an HTTP chart repository, and is used
+	// instead of ChartPath.
+	Repo *ChartRepo `json:"repo,omitempty"`
+
+	// ChartDigest pins the "sha256:<hex>" digest of a remote chart archive.
+	ChartDigest string `json:"chartDigest,omitempty"`
+
+	// ChartCredentialsSecret references a Secret with the "username" and
+	// "password" used to authenticate with the chart's registry or
+	// repository.
+	ChartCredentialsSecret *SecretReference `json:"chartCredentialsSecret,omitempty"`
+}
+
+// ChartRepo references a chart in an HTTP chart repository.
+type ChartRepo struct {
+	// URL is the URL of the repository, which serves its index.yaml.
+	URL string `json:"url"`
+
+	// Name is the name of the chart.
+	Name string `json:"name"`
+
+	// Version is a semver constraint of the chart version. The latest
+	// matching version is used.
+	Version string `json:"version,omitempty"`
+}
+
+// SecretReference references a Secret.
+type SecretReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// LoadOption configures how watches are loaded.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	chartLoader  *chartloader.Loader
+	secretReader client.Reader
+}
+
+// WithChartLoader configures the loader of the charts of watches. By default,
+// a chartloader.Loader with default options is used.
+func WithChartLoader(l *chartloader.Loader) LoadOption {
+	return func(o *loadOptions) {
+		o.chartLoader = l
+	}
+}
+
+// WithSecretReader configures the reader of the chart credentials Secrets
+// of watches. It is required if a watch references a credentials Secret.
+func WithSecretReader(r client.Reader) LoadOption {
+	return func(o *loadOptions) {
+		o.secretReader = r
+	}
+}
+
+// Storage configures the Helm storage driver of a watch.
+type Storage struct {
+	// Driver is the name of the storage driver: "secret" (the default),
+	// "configmap", "memory", or "sql". Like Helm's HELM_DRIVER, plural
+	// names are also accepted.
+	Driver string `json:"driver,omitempty"`
+
+	// ConnectionString is the PostgreSQL connection string used by the "sql"
+	// driver. Environment variables in it are expanded, so that credentials
+	// do not need to be written to the watches file.
+	ConnectionString string `json:"connectionString,omitempty"`
+}
+
+// Remediation configures how failed installations and upgrades of a watch are
+// remediated. See reconciler.Remediation.
+type Remediation struct {
+	Retries     *int   `json:"retries,omitempty"`
+	KeepFailed  *bool  `json:"keepFailed,omitempty"`
+	Rollback    *bool  `json:"rollback,omitempty"`
+	OnExhausted string `json:"onExhausted,omitempty"`
 }
 
 // Load loads a slice of Watches from the watch file at `path`. For each entry
-// in the watches file, it verifies the configuration. If an error is
-// encountered loading the file or verifying the configuration, it will be
-// returned.
-func Load(path string) ([]Watch, error) {
+// in the watches file, it verifies the configuration and loads the chart,
+// downloading it if it is in a registry or repository. If an error is
+// encountered loading the file, verifying the configuration, or loading a
+// chart, it will be returned.
+func Load(path string, opts ...LoadOption) ([]Watch, error) {
+	o := &loadOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.chartLoader == nil {
+		o.chartLoader = chartloader.New()
+	}
+
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -61,12 +251,43 @@ func Load(path string) ([]Watch, error) {
 			return nil, fmt.Errorf("invalid GVK: %s: %w", w.GroupVersionKind, err)
 		}
 
-		cl, err := loader.Load(w.ChartPath)
-		if err != nil {
-			return nil, fmt.Errorf("invalid chart %s: %w", w.ChartPath, err)
+		if w.Chart, err = loadChart(w.ChartSource, o); err != nil {
+			return nil, fmt.Errorf("invalid chart for GVK %s: %w", w.GroupVersionKind, err)
+		}
+		w.ChartVersions = nil
+		versions := map[string]struct{}{w.Chart.Metadata.Version: {}}
+		for _, src := range w.AdditionalCharts {
+			cl, err := loadChart(src, o)
+			if err != nil {
+				return nil, fmt.Errorf("invalid additional chart for GVK %s: %w", w.GroupVersionKind, err)
+			}
+			if _, ok := versions[cl.Metadata.Version]; ok {
+				return nil, fmt.Errorf("duplicate chart version %s for GVK %s", cl.Metadata.Version, w.GroupVersionKind)
+			}
+			versions[cl.Metadata.Version] = struct{}{}
+			w.ChartVersions = append(w.ChartVersions, cl)
 		}
-		w.Chart = cl
 		w.OverrideValues = expandOverrideEnvs(w.OverrideValues)
+		if w.ReleaseName != "" {
+			if w.ReleaseNameFunc, err = templateMapper("releaseName", w.ReleaseName); err != nil {
+				return nil, fmt.Errorf("invalid release name template for GVK %s: %w", w.GroupVersionKind, err)
+			}
+		}
+		if w.TargetNamespace != "" {
+			if w.TargetNamespaceFunc, err = templateMapper("targetNamespace", w.TargetNamespace); err != nil {
+				return nil, fmt.Errorf("invalid target namespace template for GVK %s: %w", w.GroupVersionKind, err)
+			}
+		}
+		if w.StorageDriverGetter, err = storageDriverGetter(w.Storage); err != nil {
+			return nil, fmt.Errorf("invalid storage for GVK %s: %w", w.GroupVersionKind, err)
+		}
+		if err := w.IgnoreDifferences.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid ignoreDifferences for GVK %s: %w", w.GroupVersionKind, err)
+		}
+		waits := (w.Wait != nil && *w.Wait) || (w.Atomic != nil && *w.Atomic)
+		if waits && w.WaitTimeout != nil && w.WaitTimeout.Duration <= 0 {
+			return nil, fmt.Errorf("invalid waitTimeout for GVK %s: must be positive, got %s", w.GroupVersionKind, w.WaitTimeout.Duration)
+		}
 		if w.WatchDependentResources == nil {
 			trueVal := true
 			w.WatchDependentResources = &trueVal
@@ -93,6 +314,98 @@ func expandOverrideEnvs(in map[string]string) map[string]string {
 	return out
 }
 
+// templateMapper parses text as a template and returns a mapper that
+// executes it with the unstructured content of an object.
+func templateMapper(name, text string) (helmclient.ObjectToStringMapper, error) {
+	tmpl, err := template.New(name).Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(obj helmclient.Object) (string, error) {
+		data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}, nil
+}
+
+// loadChart loads the chart referenced by src.
+func loadChart(src ChartSource, o *loadOptions) (*chart.Chart, error) {
+	ref, err := chartReference(src, o.secretReader)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := o.chartLoader.Load(ref)
+	if err != nil {
+		return nil, fmt.Errorf("load chart %s: %w", ref, err)
+	}
+	return cl, nil
+}
+
+// chartReference returns the reference of the chart of src. Chart
+// credentials are read from their Secret with r.
+func chartReference(src ChartSource, r client.Reader) (chartloader.Reference, error) {
+	ref := chartloader.Reference{Path: src.ChartPath, Digest: src.ChartDigest}
+	if src.Repo != nil {
+		if src.ChartPath != "" {
+			return ref, errors.New("chart and repo are mutually exclusive")
+		}
+		if src.Repo.URL == "" || src.Repo.Name == "" {
+			return ref, errors.New("repo url and name must not be empty")
+		}
+		ref.Repo, ref.Name, ref.Version = src.Repo.URL, src.Repo.Name, src.Repo.Version
+	}
+	if src.ChartCredentialsSecret == nil {
+		return ref, nil
+	}
+	if !ref.IsRemote() {
+		return ref, errors.New("credentials can only be used for remote charts")
+	}
+	if r == nil {
+		return ref, errors.New("chart credentials secret cannot be read without a secret reader")
+	}
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: src.ChartCredentialsSecret.Namespace, Name: src.ChartCredentialsSecret.Name}
+	if err := r.Get(context.TODO(), key, secret); err != nil {
+		return ref, fmt.Errorf("get chart credentials secret %s: %w", key, err)
+	}
+	ref.Username, ref.Password = string(secret.Data["username"]), string(secret.Data["password"])
+	return ref, nil
+}
+
+// storageDriverGetter returns the StorageDriverGetter for the driver
+// configured by s.
+func storageDriverGetter(s *Storage) (helmclient.StorageDriverGetter, error) {
+	if s == nil {
+		return helmclient.SecretsStorageDriver(), nil
+	}
+	switch strings.ToLower(s.Driver) {
+	case "", "secret", "secrets":
+		return helmclient.SecretsStorageDriver(), nil
+	case "configmap", "configmaps":
+		return helmclient.ConfigMapsStorageDriver(), nil
+	case "memory":
+		return helmclient.MemoryStorageDriver(), nil
+	case "sql":
+		conn := os.ExpandEnv(s.ConnectionString)
+		if conn == "" {
+			return nil, errors.New("sql driver requires a connection string")
+		}
+		db, err := sql.Open(string(helmclient.SQLDialectPostgres), conn)
+		if err != nil {
+			return nil, err
+		}
+		return helmclient.SQLStorageDriver(db, helmclient.SQLDialectPostgres), nil
+	default:
+		return nil, fmt.Errorf("unknown driver %q", s.Driver)
+	}
+}
+
 func verifyGVK(gvk schema.GroupVersionKind) error {
 	// A GVK without a group is valid. Certain scenarios may cause a GVK
 	// without a group to fail in other ways later in the initialization
@@ -15,10 +15,25 @@
 package watches
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/joelanford/helm-operator/pkg/chartloader"
 )
 
 type testCase struct {
@@ -42,6 +57,7 @@ func TestLoadWatches(t *testing.T) {
   chart: ../../testdata/test-chart-0.1.0.tgz
   watchDependentResources: false
   reconcilePeriod: 10s
+  renderResyncInterval: 30m
   overrideValues:
     key: value
 `,
@@ -65,6 +81,81 @@ func TestLoadWatches(t *testing.T) {
 			expectErr:       false,
 			expectOverrides: []map[string]string{{"key": "value"}},
 		},
+		{
+			name: "valid ignore differences",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  ignoreDifferences:
+  - group: apps
+    kind: Deployment
+    name: "*-web"
+    jsonPointers:
+    - /spec/replicas
+    jsonPaths:
+    - .spec.template.spec.containers[*].image
+`,
+			expectLen: 1,
+			expectErr: false,
+		},
+		{
+			name: "invalid ignore differences",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  ignoreDifferences:
+  - group: apps
+    kind: Deployment
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "zero wait timeout without wait",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  waitTimeout: 0s
+`,
+			expectLen: 1,
+			expectErr: false,
+		},
+		{
+			name: "zero wait timeout with wait",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  wait: true
+  waitTimeout: 0s
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "valid remediation",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  remediationField: remediation
+  remediation:
+    retries: 3
+    keepFailed: true
+    rollback: false
+    onExhausted: uninstall
+`,
+			expectLen: 1,
+			expectErr: false,
+		},
 		{
 			name: "multiple gvk",
 			data: `---
@@ -136,6 +227,138 @@ func TestLoadWatches(t *testing.T) {
   overrideValues:
     key1:
 		key2: value
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "invalid release name template",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  releaseName: "{{ .metadata.name"
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "invalid target namespace template",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  targetNamespace: "{{ .metadata.namespace }"
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "valid storage driver",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  storage:
+    driver: configmaps
+`,
+			expectLen: 1,
+			expectErr: false,
+		},
+		{
+			name: "valid sql storage driver",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  storage:
+    driver: sql
+    connectionString: $MY_CONNECTION_STRING
+`,
+			env:       map[string]string{"MY_CONNECTION_STRING": "postgres://localhost/helm"},
+			expectLen: 1,
+			expectErr: false,
+		},
+		{
+			name: "sql storage driver without connection string",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  storage:
+    driver: sql
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "unknown storage driver",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  storage:
+    driver: etcd
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "chart and repo",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  repo:
+    url: https://charts.example.com
+    name: test-chart
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "credentials for local chart",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  chartCredentialsSecret:
+    name: credentials
+    namespace: default
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "duplicate additional chart version",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  additionalCharts:
+  - chart: ../../testdata/test-chart-0.1.0.tgz
+`,
+			expectLen: 0,
+			expectErr: true,
+		},
+		{
+			name: "bad additional chart path",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  additionalCharts:
+  - chart: nonexistent/path/to/chart
 `,
 			expectLen: 0,
 			expectErr: true,
@@ -207,3 +430,188 @@ func doTest(t *testing.T, tc testCase, watchesFile string) {
 		}
 	}
 }
+
+func TestLoadWatchesReleaseTemplates(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "watches.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temporary watches.yaml file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.WriteString(`---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  releaseName: "{{ .metadata.name }}-{{ .kind | lower }}"
+  targetNamespace: "{{ .spec.targetNamespace }}"
+`); err != nil {
+		t.Fatalf("Failed to write data to temporary watches.yaml file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		t.Fatalf("Failed to close temporary watches.yaml file: %v", err)
+	}
+
+	watches, err := Load(tmp.Name())
+	if err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+	if len(watches) != 1 {
+		t.Fatalf("Expected 1 watch; got %d", len(watches))
+	}
+	w := watches[0]
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"targetNamespace": "target"},
+	}}
+	obj.SetGroupVersionKind(w.GroupVersionKind)
+	obj.SetName("test")
+
+	name, err := w.ReleaseNameFunc(obj)
+	if err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+	if name != "test-mykind" {
+		t.Fatalf("Expected release name %q; got %q", "test-mykind", name)
+	}
+
+	ns, err := w.TargetNamespaceFunc(obj)
+	if err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+	if ns != "target" {
+		t.Fatalf("Expected target namespace %q; got %q", "target", ns)
+	}
+
+	delete(obj.Object, "spec")
+	if _, err := w.TargetNamespaceFunc(obj); err == nil {
+		t.Fatalf("Expected error for missing key; got no error")
+	}
+}
+
+func TestLoadWatchesRepoChart(t *testing.T) {
+	archive, err := ioutil.ReadFile("../../testdata/test-chart-0.1.0.tgz")
+	if err != nil {
+		t.Fatalf("Failed to read chart archive: %v", err)
+	}
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	idx := repo.NewIndexFile()
+	idx.Add(&chart.Metadata{APIVersion: "v2", Name: "test-chart", Version: "0.1.0"}, "test-chart-0.1.0.tgz", srv.URL, "")
+	index, err := yaml.Marshal(idx)
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %v", err)
+	}
+	auth := func(body []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write(body)
+		}
+	}
+	mux.Handle("/index.yaml", auth(index))
+	mux.Handle("/test-chart-0.1.0.tgz", auth(archive))
+
+	cacheDir, err := ioutil.TempDir("", "watches-charts-")
+	if err != nil {
+		t.Fatalf("Failed to create cache directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(cacheDir) }()
+
+	tmp, err := ioutil.TempFile("", "watches.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temporary watches.yaml file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.WriteString(fmt.Sprintf(`---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  repo:
+    url: %s
+    name: test-chart
+    version: ">=0.1.0"
+  chartCredentialsSecret:
+    name: credentials
+    namespace: default
+`, srv.URL)); err != nil {
+		t.Fatalf("Failed to write data to temporary watches.yaml file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		t.Fatalf("Failed to close temporary watches.yaml file: %v", err)
+	}
+
+	loader := WithChartLoader(chartloader.New(chartloader.WithCacheDir(cacheDir)))
+	if _, err := Load(tmp.Name(), loader); err == nil {
+		t.Fatalf("Expected error without secret reader; got no error")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default"},
+		Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pass")},
+	}
+	reader := fake.NewFakeClientWithScheme(scheme.Scheme, secret)
+	watches, err := Load(tmp.Name(), loader, WithSecretReader(reader))
+	if err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+	if len(watches) != 1 {
+		t.Fatalf("Expected 1 watch; got %d", len(watches))
+	}
+	if v := watches[0].Chart.Metadata.Version; v != "0.1.0" {
+		t.Fatalf("Expected chart version %q; got %q", "0.1.0", v)
+	}
+}
+
+func TestLoadWatchesAdditionalCharts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watches-charts-")
+	if err != nil {
+		t.Fatalf("Failed to create chart directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	chartYAML := "apiVersion: v2\nname: test-chart\nversion: 0.2.0\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(chartYAML), 0644); err != nil {
+		t.Fatalf("Failed to write Chart.yaml: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile("", "watches.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temporary watches.yaml file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.WriteString(fmt.Sprintf(`---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../testdata/test-chart-0.1.0.tgz
+  additionalCharts:
+  - chart: %s
+  chartVersionField: chartVersion
+`, dir)); err != nil {
+		t.Fatalf("Failed to write data to temporary watches.yaml file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		t.Fatalf("Failed to close temporary watches.yaml file: %v", err)
+	}
+
+	watches, err := Load(tmp.Name())
+	if err != nil {
+		t.Fatalf("Expected no error; got error: %v", err)
+	}
+	if len(watches) != 1 {
+		t.Fatalf("Expected 1 watch; got %d", len(watches))
+	}
+	w := watches[0]
+	if v := w.Chart.Metadata.Version; v != "0.1.0" {
+		t.Fatalf("Expected chart version %q; got %q", "0.1.0", v)
+	}
+	if len(w.ChartVersions) != 1 || w.ChartVersions[0].Metadata.Version != "0.2.0" {
+		t.Fatalf("Expected additional chart version %q; got %v", "0.2.0", w.ChartVersions)
+	}
+	if w.ChartVersionField != "chartVersion" {
+		t.Fatalf("Expected chart version field %q; got %q", "chartVersion", w.ChartVersionField)
+	}
+}